@@ -0,0 +1,167 @@
+package cache
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"sync"
+)
+
+type (
+	// Compressor is implemented by each compression method used by the stores.
+	// The name is saved with each compressed item so it can be decompressed
+	// later, even if the store has since been configured with another compressor.
+	Compressor interface {
+		Name() string
+		Compress(data []byte) ([]byte, error)
+		Decompress(data []byte) ([]byte, error)
+	}
+
+	// Compression sets a stores compression at rest options.
+	Compression struct {
+		// Compressor is used to compress new items.
+		// If nil items are saved uncompressed.
+		Compressor Compressor
+
+		// Threshold is the minimum size in bytes an item must be to be compressed.
+		// Smaller items are saved uncompressed as they rarely benefit from it.
+		Threshold int
+	}
+
+	// gzipCompressor implements Compressor using compress/gzip
+	gzipCompressor struct {
+		level int
+	}
+
+	// flateCompressor implements Compressor using compress/flate
+	flateCompressor struct {
+		level int
+	}
+)
+
+var (
+	// compressors holds every registered compressor by name so items can be decompressed.
+	compressors   = make(map[string]Compressor)
+	compressorMtx sync.RWMutex
+)
+
+func init() {
+	RegisterCompressor(Gzip(gzip.DefaultCompression))
+	RegisterCompressor(Flate(flate.DefaultCompression))
+}
+
+// RegisterCompressor makes a compressor available for decompressing items by its name.
+// Gzip and Flate are registered by default. A custom compressor only needs to be registered
+// to read items it compressed once a store has been configured with another compressor.
+// Registering a compressor with the same name as an existing one replaces it.
+func RegisterCompressor(c Compressor) {
+	compressorMtx.Lock()
+	defer compressorMtx.Unlock()
+	compressors[c.Name()] = c
+}
+
+// Decompress decompresses data using the registered compressor with the given name.
+func Decompress(name string, data []byte) ([]byte, error) {
+	compressorMtx.RLock()
+	c, ok := compressors[name]
+	compressorMtx.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("compressor %s has not been registered", name)
+	}
+	return c.Decompress(data)
+}
+
+// Decompress decompresses data compressed by the compressor with the given name.
+// The configured Compressor is used if it has that name, so a custom compressor
+// does not need to be registered, otherwise the registered compressor is used.
+func (c *Compression) Decompress(name string, data []byte) ([]byte, error) {
+	if c != nil && c.Compressor != nil && c.Compressor.Name() == name {
+		return c.Compressor.Decompress(data)
+	}
+	return Decompress(name, data)
+}
+
+// Compress compresses data if it is at least Threshold bytes.
+// It returns the data to save and the name of the compressor used.
+// The name is empty if the data was not compressed, which also happens
+// if compressing would not make the data smaller.
+func (c *Compression) Compress(data []byte) ([]byte, string, error) {
+	if c == nil || c.Compressor == nil || len(data) < c.Threshold {
+		return data, "", nil
+	}
+
+	compressed, err := c.Compressor.Compress(data)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(compressed) >= len(data) {
+		return data, "", nil
+	}
+	return compressed, c.Compressor.Name(), nil
+}
+
+// Gzip returns a Compressor using compress/gzip at the given compression level.
+func Gzip(level int) Compressor {
+	return &gzipCompressor{level: level}
+}
+
+func (g *gzipCompressor) Name() string {
+	return "gzip"
+}
+
+func (g *gzipCompressor) Compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := gzip.NewWriterLevel(&buf, g.level)
+	if err != nil {
+		return nil, err
+	}
+	return compress(&buf, w, data)
+}
+
+func (g *gzipCompressor) Decompress(data []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// Flate returns a Compressor using compress/flate at the given compression level.
+func Flate(level int) Compressor {
+	return &flateCompressor{level: level}
+}
+
+func (f *flateCompressor) Name() string {
+	return "flate"
+}
+
+func (f *flateCompressor) Compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := flate.NewWriter(&buf, f.level)
+	if err != nil {
+		return nil, err
+	}
+	return compress(&buf, w, data)
+}
+
+func (f *flateCompressor) Decompress(data []byte) ([]byte, error) {
+	r := flate.NewReader(bytes.NewReader(data))
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// compress writes data to w and returns the compressed bytes written to buf.
+func compress(buf *bytes.Buffer, w io.WriteCloser, data []byte) ([]byte, error) {
+	_, err := w.Write(data)
+	if err != nil {
+		return nil, err
+	}
+	err = w.Close()
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,125 @@
+package cache_test
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"crypto/rand"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tmstorm/cache"
+	"github.com/tmstorm/cache/stores/mem"
+)
+
+// reverse is a test compressor registered under a custom name
+type reverse struct{}
+
+func (reverse) Name() string { return "reverse" }
+
+func (reverse) Compress(data []byte) ([]byte, error) {
+	out := bytes.Clone(data)
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	// Pretend the data got smaller so it is used
+	return out[:len(out)-1], nil
+}
+
+func (reverse) Decompress(data []byte) ([]byte, error) {
+	return []byte("decompressed"), nil
+}
+
+// unregistered is a custom compressor that is never registered
+type unregistered struct {
+	cache.Compressor
+}
+
+func (unregistered) Name() string { return "unregistered" }
+
+func TestCompression(t *testing.T) {
+	a := assert.New(t)
+
+	data := []byte(strings.Repeat("<p>cached html</p>", 200))
+
+	// Each built in compressor round trips through the registry
+	for _, c := range []cache.Compressor{cache.Gzip(gzip.BestSpeed), cache.Flate(flate.DefaultCompression)} {
+		compression := &cache.Compression{Compressor: c, Threshold: 10}
+		out, name, err := compression.Compress(data)
+		a.NoError(err)
+		a.Equal(c.Name(), name)
+		a.Less(len(out), len(data))
+
+		in, err := cache.Decompress(name, out)
+		a.NoError(err)
+		a.Equal(data, in)
+	}
+
+	// Data under the threshold is not compressed
+	compression := &cache.Compression{Compressor: cache.Gzip(gzip.DefaultCompression), Threshold: len(data) + 1}
+	out, name, err := compression.Compress(data)
+	a.NoError(err)
+	a.Empty(name)
+	a.Equal(data, out)
+
+	// Data that does not get smaller is not compressed
+	random := make([]byte, 1024)
+	_, _ = rand.Read(random)
+	compression.Threshold = 0
+	out, name, err = compression.Compress(random)
+	a.NoError(err)
+	a.Empty(name)
+	a.Equal(random, out)
+
+	// A nil compression leaves data as is
+	var none *cache.Compression
+	out, name, err = none.Compress(data)
+	a.NoError(err)
+	a.Empty(name)
+	a.Equal(data, out)
+
+	// Custom compressors need to be registered to decompress through the registry
+	_, err = cache.Decompress("reverse", []byte("data"))
+	a.Error(err)
+	cache.RegisterCompressor(reverse{})
+	compression = &cache.Compression{Compressor: reverse{}}
+	out, name, err = compression.Compress(data)
+	a.NoError(err)
+	a.Equal("reverse", name)
+	in, err := cache.Decompress(name, out)
+	a.NoError(err)
+	a.Equal("decompressed", string(in))
+
+	// The configured compressor decompresses its own data without being registered
+	compression = &cache.Compression{Compressor: unregistered{cache.Gzip(gzip.BestSpeed)}}
+	out, name, err = compression.Compress(data)
+	a.NoError(err)
+	a.Equal("unregistered", name)
+	_, err = cache.Decompress(name, out)
+	a.Error(err)
+	in, err = compression.Decompress(name, out)
+	a.NoError(err)
+	a.Equal(data, in)
+
+	// Data compressed by another compressor is decompressed through the registry
+	out, name, err = (&cache.Compression{Compressor: cache.Flate(flate.BestSpeed)}).Compress(data)
+	a.NoError(err)
+	in, err = compression.Decompress(name, out)
+	a.NoError(err)
+	a.Equal(data, in)
+}
+
+// TestCustomCompressor tests stores read back values compressed by an unregistered compressor
+func TestCustomCompressor(t *testing.T) {
+	a := assert.New(t)
+
+	data := []byte(strings.Repeat("<p>cached html</p>", 200))
+	compression := &cache.Compression{Compressor: unregistered{cache.Gzip(gzip.BestSpeed)}}
+
+	store := mem.New(&mem.Store{Compression: compression})
+	a.NoError(mem.Get(store).Write("page", data, false))
+	b, err := mem.Get(store).Read("page")
+	a.NoError(err)
+	a.Equal(data, b)
+}
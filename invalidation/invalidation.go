@@ -0,0 +1,211 @@
+// Package invalidation broadcasts the keys removed or overwritten in a store to the other replicas of a cache,
+// so they drop their stale copies instead of serving them until they are trimmed.
+package invalidation
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"sync"
+
+	"github.com/tmstorm/cache"
+)
+
+// kind is the change that made a key stale
+type kind uint8
+
+const (
+	kindRemove kind = iota + 1
+	kindOverwrite
+)
+
+// ErrMessage is returned when a message received from the transport cannot be decoded
+var ErrMessage = errors.New("invalid invalidation message")
+
+type (
+	// Transport delivers messages between the replicas of a cache.
+	// Messages may be lost or delivered to the node that sent them.
+	Transport interface {
+		// Send delivers msg to every other node
+		Send(msg []byte) error
+
+		// Receive blocks until a message arrives or the transport is closed
+		Receive() ([]byte, error)
+
+		// Close stops the transport and unblocks Receive
+		Close() error
+	}
+
+	// Bus publishes the keys removed or overwritten in its stores and removes the keys published by other nodes.
+	Bus struct {
+		mtx    sync.RWMutex
+		stores map[string]cache.KVStore
+
+		// Transport carries the messages between nodes
+		Transport Transport
+
+		// Node identifies this replica. Messages it sent are ignored when they are received.
+		// Defaults to a random ID.
+		Node string
+	}
+
+	// event is the message published for each invalidated key
+	event struct {
+		kind  kind
+		node  string
+		store string
+		key   string
+	}
+
+	// busStore publishes the keys removed or overwritten through it
+	busStore struct {
+		cache.KVStore
+		b *Bus
+	}
+)
+
+// NewBus initializes an invalidation bus.
+// If Node is not provided a random ID will be used.
+func NewBus(b *Bus) *Bus {
+	if b.Transport == nil {
+		fmt.Println("cache: invalidation bus requires a transport")
+		return nil
+	}
+	if b.Node == "" {
+		var id [8]byte
+		_, _ = rand.Read(id[:])
+		b.Node = hex.EncodeToString(id[:])
+	}
+	b.stores = make(map[string]cache.KVStore)
+	return b
+}
+
+// Middleware returns middleware publishing the keys removed or overwritten in the store it wraps.
+// Keys published by other nodes are removed from their store of the same Type.
+// Keys too long to fit in a 64KB message are not published.
+func (b *Bus) Middleware() cache.Middleware {
+	return func(s cache.KVStore) cache.KVStore {
+		b.mtx.Lock()
+		b.stores[s.Type()] = s
+		b.mtx.Unlock()
+		return &busStore{KVStore: s, b: b}
+	}
+}
+
+func (s *busStore) Write(key string, value []byte, overwrite bool) error {
+	err := s.KVStore.Write(key, value, overwrite)
+	if err != nil || !overwrite {
+		return err
+	}
+	s.b.publish(event{kind: kindOverwrite, store: s.Type(), key: key})
+	return nil
+}
+
+func (s *busStore) Remove(key string) error {
+	err := s.KVStore.Remove(key)
+	if err != nil {
+		return err
+	}
+	s.b.publish(event{kind: kindRemove, store: s.Type(), key: key})
+	return nil
+}
+
+// publish sends e to the other nodes.
+// The local change has already been made, so a failure is only logged.
+func (b *Bus) publish(e event) {
+	e.node = b.Node
+	msg := e.encode()
+	if len(msg) > maxMessage {
+		log.Printf("invalidation in %s store not published: key of %d bytes is too long", e.store, len(e.key))
+		return
+	}
+	err := b.Transport.Send(msg)
+	if err != nil {
+		log.Printf("invalidation of %s in %s store not published: %v", e.key, e.store, err)
+	}
+}
+
+// Run removes the keys published by other nodes until ctx is cancelled.
+// The transport is closed when Run returns.
+func (b *Bus) Run(ctx context.Context) error {
+	stop := context.AfterFunc(ctx, func() { b.Transport.Close() })
+	defer stop()
+	defer b.Transport.Close()
+
+	for {
+		msg, err := b.Transport.Receive()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+
+		e, err := decode(msg)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		err = b.apply(e)
+		if err != nil {
+			log.Printf("invalidation of %s in %s store failed: %v", e.key, e.store, err)
+		}
+	}
+}
+
+// apply removes the key of an event published by another node.
+func (b *Bus) apply(e event) error {
+	if e.node == b.Node {
+		return nil
+	}
+
+	b.mtx.RLock()
+	s, ok := b.stores[e.store]
+	b.mtx.RUnlock()
+	if !ok {
+		return nil
+	}
+
+	// The inner store is used so the removal is not published again
+	err := s.Remove(e.key)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// encode returns the wire format of e: kind, then the node and store each prefixed with their length, then the key.
+func (e event) encode() []byte {
+	msg := []byte{byte(e.kind)}
+	msg = binary.AppendUvarint(msg, uint64(len(e.node)))
+	msg = append(msg, e.node...)
+	msg = binary.AppendUvarint(msg, uint64(len(e.store)))
+	msg = append(msg, e.store...)
+	return append(msg, e.key...)
+}
+
+// decode parses a message created by encode.
+func decode(msg []byte) (event, error) {
+	if len(msg) == 0 || (kind(msg[0]) != kindRemove && kind(msg[0]) != kindOverwrite) {
+		return event{}, ErrMessage
+	}
+	e := event{kind: kind(msg[0])}
+	msg = msg[1:]
+
+	var fields [2]string
+	for i := range fields {
+		n, size := binary.Uvarint(msg)
+		if size <= 0 || n > uint64(len(msg)-size) {
+			return event{}, ErrMessage
+		}
+		fields[i] = string(msg[size : size+int(n)])
+		msg = msg[size+int(n):]
+	}
+	e.node, e.store, e.key = fields[0], fields[1], string(msg)
+	return e, nil
+}
@@ -0,0 +1,189 @@
+package invalidation_test
+
+import (
+	"context"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tmstorm/cache"
+	"github.com/tmstorm/cache/invalidation"
+	"github.com/tmstorm/cache/stores/disk"
+	"github.com/tmstorm/cache/stores/mem"
+)
+
+// node is a replica with a mem and disk store joined by an invalidation bus
+type node struct {
+	bus  *invalidation.Bus
+	mem  cache.KVStore
+	disk cache.KVStore
+}
+
+func newNode(t *testing.T, transport invalidation.Transport) *node {
+	bus := invalidation.NewBus(&invalidation.Bus{Transport: transport})
+	memStore := mem.New(&mem.Store{})
+	diskStore := disk.New(&disk.Store{RootDir: t.TempDir()})
+	n := &node{
+		bus:  bus,
+		mem:  cache.Chain(cache.NewKVStore(memStore, mem.Get(memStore)), bus.Middleware()),
+		disk: cache.Chain(cache.NewKVStore(diskStore, disk.GetKeyed(diskStore)), bus.Middleware()),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		bus.Run(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return n
+}
+
+func unixTransport(t *testing.T, dir string) *invalidation.Unix {
+	u, err := invalidation.NewUnix(dir)
+	assert.NoError(t, err)
+	return u
+}
+
+// removed waits for key to be removed from s
+func removed(t *testing.T, s cache.KVStore, key string) bool {
+	return assert.Eventually(t, func() bool {
+		_, err := s.Read(key)
+		return err != nil
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+// TestBus tests removes and overwrites on one node invalidate the copies on the others
+func TestBus(t *testing.T) {
+	a := assert.New(t)
+	dir := filepath.Join(t.TempDir(), "bus")
+	nodes := []*node{
+		newNode(t, unixTransport(t, dir)),
+		newNode(t, unixTransport(t, dir)),
+		newNode(t, unixTransport(t, dir)),
+	}
+	a.NotEqual(nodes[0].bus.Node, nodes[1].bus.Node)
+
+	for _, n := range nodes {
+		a.NoError(n.mem.Write("user", []byte("v1"), false))
+		a.NoError(n.mem.Write("session", []byte("v1"), false))
+		a.NoError(n.disk.Write("report", []byte("v1"), false))
+	}
+
+	// A remove is applied everywhere
+	a.NoError(nodes[0].mem.Remove("user"))
+	for _, n := range nodes[1:] {
+		removed(t, n.mem, "user")
+	}
+
+	// An overwrite drops the stale copies but keeps the new value on the writer
+	a.NoError(nodes[1].disk.Write("report", []byte("v2"), true))
+	removed(t, nodes[0].disk, "report")
+	removed(t, nodes[2].disk, "report")
+	v, err := nodes[1].disk.Read("report")
+	a.NoError(err)
+	a.Equal([]byte("v2"), v)
+
+	// Keys are only removed from the store of the same type
+	_, err = nodes[2].mem.Read("session")
+	a.NoError(err)
+	a.NoError(nodes[2].disk.Write("session", []byte("v2"), true))
+	time.Sleep(100 * time.Millisecond)
+	for _, n := range nodes {
+		_, err = n.mem.Read("session")
+		a.NoError(err)
+	}
+}
+
+// loopback is a transport delivering every message to the node that sent it
+type loopback struct {
+	msgs   chan []byte
+	closed chan struct{}
+	once   sync.Once
+}
+
+func newLoopback() *loopback {
+	return &loopback{msgs: make(chan []byte, 16), closed: make(chan struct{})}
+}
+
+func (l *loopback) Send(msg []byte) error {
+	l.msgs <- msg
+	return nil
+}
+
+func (l *loopback) Receive() ([]byte, error) {
+	select {
+	case msg := <-l.msgs:
+		return msg, nil
+	case <-l.closed:
+		return nil, os.ErrClosed
+	}
+}
+
+func (l *loopback) Close() error {
+	l.once.Do(func() { close(l.closed) })
+	return nil
+}
+
+// TestBusOwnMessages tests a node ignores its own messages and survives invalid ones
+func TestBusOwnMessages(t *testing.T) {
+	a := assert.New(t)
+	transport := newLoopback()
+	n := newNode(t, transport)
+
+	// The overwrite comes back to the node that made it and must not remove the new value
+	a.NoError(n.mem.Write("key", []byte("v1"), false))
+	a.NoError(n.mem.Write("key", []byte("v2"), true))
+
+	a.NoError(transport.Send([]byte{0xff}))
+	a.NoError(transport.Send(nil))
+
+	// Messages are applied in order, so once another node's remove is applied the others have been too
+	a.NoError(n.mem.Write("marker", []byte("v1"), false))
+	other := invalidation.NewBus(&invalidation.Bus{Transport: transport, Node: "other"})
+	otherStore := mem.New(&mem.Store{})
+	a.NoError(cache.Chain(cache.NewKVStore(otherStore, mem.Get(otherStore)), other.Middleware()).Remove("marker"))
+	removed(t, n.mem, "marker")
+
+	v, err := n.mem.Read("key")
+	a.NoError(err)
+	a.Equal([]byte("v2"), v)
+}
+
+// TestBusLongKey tests keys too long for a message are not published
+func TestBusLongKey(t *testing.T) {
+	a := assert.New(t)
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(io.Discard)
+
+	transport := newLoopback()
+	bus := invalidation.NewBus(&invalidation.Bus{Transport: transport})
+	memStore := mem.New(&mem.Store{})
+	kv := cache.Chain(cache.NewKVStore(memStore, mem.Get(memStore)), bus.Middleware())
+
+	a.NoError(kv.Write(strings.Repeat("k", 70000), []byte("value"), false))
+	a.NoError(kv.Remove(strings.Repeat("k", 70000)))
+	a.Empty(transport.msgs)
+	a.NoError(kv.Remove("short"))
+	a.Len(transport.msgs, 1)
+}
+
+// TestNewBus tests a bus requires a transport and gets a node ID
+func TestNewBus(t *testing.T) {
+	a := assert.New(t)
+	a.Nil(invalidation.NewBus(&invalidation.Bus{}))
+
+	bus := invalidation.NewBus(&invalidation.Bus{Transport: newLoopback()})
+	a.Len(bus.Node, 16)
+	bus = invalidation.NewBus(&invalidation.Bus{Transport: newLoopback(), Node: "replica-1"})
+	a.Equal("replica-1", bus.Node)
+}
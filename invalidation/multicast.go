@@ -0,0 +1,61 @@
+package invalidation
+
+import (
+	"net"
+)
+
+// maxMessage is the largest message a transport sends or receives, the largest UDP payload over IPv4.
+const maxMessage = 65507
+
+// Multicast is a transport sending messages to a UDP multicast group on the local network.
+type Multicast struct {
+	recv *net.UDPConn
+	send *net.UDPConn
+}
+
+// NewMulticast joins the multicast group at addr, such as "239.0.0.1:7946", on the network interface ifi.
+// If ifi is nil the system chooses the interface. Messages are sent through the system's default multicast route.
+func NewMulticast(addr string, ifi *net.Interface) (*Multicast, error) {
+	group, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	recv, err := net.ListenMulticastUDP("udp", ifi, group)
+	if err != nil {
+		return nil, err
+	}
+	send, err := net.DialUDP("udp", nil, group)
+	if err != nil {
+		recv.Close()
+		return nil, err
+	}
+	return &Multicast{recv: recv, send: send}, nil
+}
+
+// Send delivers msg to the multicast group.
+func (m *Multicast) Send(msg []byte) error {
+	_, err := m.send.Write(msg)
+	return err
+}
+
+// Receive returns the next message sent to the multicast group.
+// Messages longer than maxMessage are dropped rather than returned cut off.
+func (m *Multicast) Receive() ([]byte, error) {
+	buf := make([]byte, maxMessage+1)
+	for {
+		n, _, err := m.recv.ReadFromUDP(buf)
+		if err != nil {
+			return nil, err
+		}
+		if n <= maxMessage {
+			return buf[:n], nil
+		}
+	}
+}
+
+// Close leaves the multicast group.
+func (m *Multicast) Close() error {
+	m.send.Close()
+	return m.recv.Close()
+}
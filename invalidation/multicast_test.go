@@ -0,0 +1,43 @@
+package invalidation_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tmstorm/cache/invalidation"
+)
+
+// TestMulticast tests messages sent to the group are received by its members
+func TestMulticast(t *testing.T) {
+	a := assert.New(t)
+
+	m, err := invalidation.NewMulticast("239.0.0.1:7946", nil)
+	if err != nil {
+		t.Skipf("multicast not available: %v", err)
+	}
+
+	received := make(chan []byte, 1)
+	go func() {
+		msg, err := m.Receive()
+		if err == nil {
+			received <- msg
+		}
+	}()
+
+	err = m.Send([]byte("hello"))
+	if err != nil {
+		m.Close()
+		t.Skipf("multicast not routable: %v", err)
+	}
+	select {
+	case msg := <-received:
+		a.Equal([]byte("hello"), msg)
+	case <-time.After(time.Second):
+		t.Log("multicast loopback is disabled")
+	}
+
+	a.NoError(m.Close())
+	_, err = m.Receive()
+	a.Error(err)
+}
@@ -0,0 +1,103 @@
+package invalidation
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// sendTimeout is how long Send waits for a node that is not reading its messages.
+const sendTimeout = 50 * time.Millisecond
+
+// Unix is a transport for nodes on one host.
+// Each node binds a datagram socket in a shared directory and sends messages to every other socket in it.
+type Unix struct {
+	dir  string
+	path string
+	conn *net.UnixConn
+}
+
+// NewUnix binds a socket for this node in dir, creating dir if it does not exist.
+func NewUnix(dir string) (*Unix, error) {
+	err := os.MkdirAll(dir, 0o700)
+	if err != nil {
+		return nil, err
+	}
+
+	var id [8]byte
+	_, _ = rand.Read(id[:])
+	path := filepath.Join(dir, hex.EncodeToString(id[:])+".sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		return nil, err
+	}
+	return &Unix{dir: dir, path: path, conn: conn}, nil
+}
+
+// Send delivers msg to every other socket in the directory.
+// Messages to a node that is not reading them are dropped after a short wait so one node cannot stall the others.
+// Sockets left behind by nodes that exited without closing are removed.
+func (u *Unix) Send(msg []byte) error {
+	entries, err := os.ReadDir(u.dir)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, entry := range entries {
+		path := filepath.Join(u.dir, entry.Name())
+		if !strings.HasSuffix(entry.Name(), ".sock") || path == u.path {
+			continue
+		}
+
+		err = u.conn.SetWriteDeadline(time.Now().Add(sendTimeout))
+		if err != nil {
+			return err
+		}
+		_, err = u.conn.WriteToUnix(msg, &net.UnixAddr{Name: path, Net: "unixgram"})
+		if errors.Is(err, syscall.ECONNREFUSED) {
+			os.Remove(path)
+			continue
+		}
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			errs = append(errs, fmt.Errorf("dropped message to %s: %w", entry.Name(), err))
+			continue
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Receive returns the next message sent to this node.
+// Messages longer than maxMessage are dropped rather than returned cut off.
+func (u *Unix) Receive() ([]byte, error) {
+	buf := make([]byte, maxMessage+1)
+	for {
+		n, _, err := u.conn.ReadFromUnix(buf)
+		if err != nil {
+			return nil, err
+		}
+		if n <= maxMessage {
+			return buf[:n], nil
+		}
+	}
+}
+
+// Close closes the socket and removes it from the directory.
+func (u *Unix) Close() error {
+	err := u.conn.Close()
+	if errors.Is(err, net.ErrClosed) {
+		return nil
+	}
+	os.Remove(u.path)
+	return err
+}
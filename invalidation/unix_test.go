@@ -0,0 +1,112 @@
+package invalidation_test
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tmstorm/cache/invalidation"
+)
+
+// TestUnix tests messages reach every other socket in the directory
+func TestUnix(t *testing.T) {
+	a := assert.New(t)
+	dir := t.TempDir()
+
+	first, err := invalidation.NewUnix(dir)
+	a.NoError(err)
+	defer first.Close()
+	second, err := invalidation.NewUnix(dir)
+	a.NoError(err)
+	defer second.Close()
+	third, err := invalidation.NewUnix(dir)
+	a.NoError(err)
+
+	a.NoError(first.Send([]byte("hello")))
+	for _, u := range []*invalidation.Unix{second, third} {
+		msg, err := u.Receive()
+		a.NoError(err)
+		a.Equal([]byte("hello"), msg)
+	}
+
+	// Closing removes the socket
+	a.NoError(third.Close())
+	_, err = third.Receive()
+	a.Error(err)
+	entries, err := os.ReadDir(dir)
+	a.NoError(err)
+	a.Len(entries, 2)
+}
+
+// TestUnixStaleSocket tests sockets of nodes that exited without closing are removed
+func TestUnixStaleSocket(t *testing.T) {
+	a := assert.New(t)
+	dir := t.TempDir()
+
+	// A socket file nobody is reading from
+	stale := filepath.Join(dir, "stale.sock")
+	l, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: stale, Net: "unixgram"})
+	a.NoError(err)
+	a.NoError(l.Close())
+	a.FileExists(stale)
+
+	u, err := invalidation.NewUnix(dir)
+	a.NoError(err)
+	defer u.Close()
+	a.NoError(u.Send([]byte("hello")))
+	a.NoFileExists(stale)
+}
+
+// TestUnixStalledNode tests a node not reading its messages does not stall the others
+func TestUnixStalledNode(t *testing.T) {
+	a := assert.New(t)
+	dir := t.TempDir()
+
+	// A node that binds its socket but never receives
+	stalled, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: filepath.Join(dir, "stalled.sock"), Net: "unixgram"})
+	a.NoError(err)
+	defer stalled.Close()
+
+	u, err := invalidation.NewUnix(dir)
+	a.NoError(err)
+	defer u.Close()
+
+	msg := make([]byte, 1024)
+	for i := 0; i < 100000; i++ {
+		start := time.Now()
+		err = u.Send(msg)
+		if err != nil {
+			a.Less(time.Since(start), time.Second)
+			break
+		}
+	}
+	a.ErrorIs(err, os.ErrDeadlineExceeded)
+}
+
+// TestUnixOversized tests messages too long to receive whole are dropped
+func TestUnixOversized(t *testing.T) {
+	a := assert.New(t)
+	dir := t.TempDir()
+
+	u, err := invalidation.NewUnix(dir)
+	a.NoError(err)
+	defer u.Close()
+	sockets, err := filepath.Glob(filepath.Join(dir, "*.sock"))
+	a.NoError(err)
+	a.Len(sockets, 1)
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: sockets[0], Net: "unixgram"})
+	a.NoError(err)
+	defer conn.Close()
+	_, err = conn.Write(make([]byte, 70000))
+	a.NoError(err)
+	_, err = conn.Write([]byte("hello"))
+	a.NoError(err)
+
+	msg, err := u.Receive()
+	a.NoError(err)
+	a.Equal([]byte("hello"), msg)
+}
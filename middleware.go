@@ -0,0 +1,246 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+type (
+	// KeyReadWriter is implemented by the writers of stores saving values by key,
+	// such as the writers returned by mem.Get and logkv.Get.
+	KeyReadWriter interface {
+		Write(key string, value []byte, overwrite bool) error
+		Read(key string) ([]byte, error)
+		Remove(key string) error
+	}
+
+	// KVStore is a Store that also reads and writes values by key.
+	// It is what middleware wraps and returns, and can be added to a cache with MakeStores.
+	KVStore interface {
+		Store
+		KeyReadWriter
+	}
+
+	// Middleware wraps a store to add behaviour to it.
+	// The returned store should keep the Type of the store it wraps so the cache can find it.
+	Middleware func(KVStore) KVStore
+
+	// kvStore joins a store and its writer
+	kvStore struct {
+		Store
+		KeyReadWriter
+	}
+
+	// Metrics counts the operations passing through WithMetrics.
+	// Counters may be read while the store is in use.
+	Metrics struct {
+		Reads   atomic.Int64
+		Misses  atomic.Int64
+		Writes  atomic.Int64
+		Removes atomic.Int64
+		Trims   atomic.Int64
+		Errors  atomic.Int64
+
+		// ReadTime and WriteTime are the total nanoseconds spent reading and writing
+		ReadTime  atomic.Int64
+		WriteTime atomic.Int64
+	}
+
+	loggingStore struct {
+		KVStore
+		logger *log.Logger
+	}
+
+	metricsStore struct {
+		KVStore
+		metrics *Metrics
+	}
+
+	compressionStore struct {
+		KVStore
+		compression *Compression
+	}
+
+	prefixStore struct {
+		KVStore
+		prefix string
+	}
+)
+
+// NewKVStore joins a store and its writer so middleware can wrap them.
+//
+//	kv := cache.NewKVStore(store, mem.Get(store))
+func NewKVStore(s Store, rw KeyReadWriter) KVStore {
+	return &kvStore{Store: s, KeyReadWriter: rw}
+}
+
+// Chain wraps a store in middleware. The first middleware is the outermost,
+// so it sees each call first and each result last.
+func Chain(s KVStore, middleware ...Middleware) KVStore {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		s = middleware[i](s)
+	}
+	return s
+}
+
+// WithLogging logs every call with its key, duration, and error.
+// A nil logger uses the standard logger.
+func WithLogging(logger *log.Logger) Middleware {
+	if logger == nil {
+		logger = log.Default()
+	}
+	return func(s KVStore) KVStore {
+		return &loggingStore{KVStore: s, logger: logger}
+	}
+}
+
+func (s *loggingStore) Write(key string, value []byte, overwrite bool) error {
+	start := time.Now()
+	err := s.KVStore.Write(key, value, overwrite)
+	s.logger.Printf("%s store write %s (%d bytes) in %v: %v", s.Type(), key, len(value), time.Since(start), errString(err))
+	return err
+}
+
+func (s *loggingStore) Read(key string) ([]byte, error) {
+	start := time.Now()
+	value, err := s.KVStore.Read(key)
+	s.logger.Printf("%s store read %s (%d bytes) in %v: %v", s.Type(), key, len(value), time.Since(start), errString(err))
+	return value, err
+}
+
+func (s *loggingStore) Remove(key string) error {
+	start := time.Now()
+	err := s.KVStore.Remove(key)
+	s.logger.Printf("%s store remove %s in %v: %v", s.Type(), key, time.Since(start), errString(err))
+	return err
+}
+
+func (s *loggingStore) Trim() {
+	start := time.Now()
+	s.KVStore.Trim()
+	s.logger.Printf("%s store trim in %v", s.Type(), time.Since(start))
+}
+
+func (s *loggingStore) Purge() error {
+	start := time.Now()
+	err := s.KVStore.Purge()
+	s.logger.Printf("%s store purge in %v: %v", s.Type(), time.Since(start), errString(err))
+	return err
+}
+
+// errString returns ok for a nil error so log lines read naturally.
+func errString(err error) string {
+	if err == nil {
+		return "ok"
+	}
+	return err.Error()
+}
+
+// WithMetrics counts calls and the time spent in them in m.
+// A failed read counts as a miss rather than an error, as stores do not tell the two apart.
+func WithMetrics(m *Metrics) Middleware {
+	return func(s KVStore) KVStore {
+		return &metricsStore{KVStore: s, metrics: m}
+	}
+}
+
+func (s *metricsStore) Write(key string, value []byte, overwrite bool) error {
+	start := time.Now()
+	err := s.KVStore.Write(key, value, overwrite)
+	s.metrics.WriteTime.Add(int64(time.Since(start)))
+	s.metrics.Writes.Add(1)
+	if err != nil {
+		s.metrics.Errors.Add(1)
+	}
+	return err
+}
+
+func (s *metricsStore) Read(key string) ([]byte, error) {
+	start := time.Now()
+	value, err := s.KVStore.Read(key)
+	s.metrics.ReadTime.Add(int64(time.Since(start)))
+	s.metrics.Reads.Add(1)
+	if err != nil {
+		s.metrics.Misses.Add(1)
+	}
+	return value, err
+}
+
+func (s *metricsStore) Remove(key string) error {
+	err := s.KVStore.Remove(key)
+	s.metrics.Removes.Add(1)
+	if err != nil {
+		s.metrics.Errors.Add(1)
+	}
+	return err
+}
+
+func (s *metricsStore) Trim() {
+	s.KVStore.Trim()
+	s.metrics.Trims.Add(1)
+}
+
+// WithCompression compresses values before they reach the store.
+// Each value is saved with the name of the compressor used, so values written
+// through the middleware can only be read through it.
+// Values compressed by another compressor than c's can be read if it is registered.
+func WithCompression(c *Compression) Middleware {
+	return func(s KVStore) KVStore {
+		return &compressionStore{KVStore: s, compression: c}
+	}
+}
+
+func (s *compressionStore) Write(key string, value []byte, overwrite bool) error {
+	data, codec, err := s.compression.Compress(value)
+	if err != nil {
+		return err
+	}
+	if len(codec) > 255 {
+		return fmt.Errorf("compressor name is longer than 255 bytes: %s", codec)
+	}
+
+	framed := make([]byte, 0, 1+len(codec)+len(data))
+	framed = append(framed, byte(len(codec)))
+	framed = append(framed, codec...)
+	framed = append(framed, data...)
+	return s.KVStore.Write(key, framed, overwrite)
+}
+
+func (s *compressionStore) Read(key string) ([]byte, error) {
+	framed, err := s.KVStore.Read(key)
+	if err != nil {
+		return framed, err
+	}
+	if len(framed) == 0 || len(framed) < 1+int(framed[0]) {
+		return []byte{}, errors.New("value was not written by the compression middleware: " + key)
+	}
+
+	codec, data := string(framed[1:1+framed[0]]), framed[1+framed[0]:]
+	if codec == "" {
+		return data, nil
+	}
+	return s.compression.Decompress(codec, data)
+}
+
+// WithPrefix adds prefix to every key, so several users of one store keep their keys apart.
+// Trim and Purge are passed on unchanged and apply to the whole store.
+func WithPrefix(prefix string) Middleware {
+	return func(s KVStore) KVStore {
+		return &prefixStore{KVStore: s, prefix: prefix}
+	}
+}
+
+func (s *prefixStore) Write(key string, value []byte, overwrite bool) error {
+	return s.KVStore.Write(s.prefix+key, value, overwrite)
+}
+
+func (s *prefixStore) Read(key string) ([]byte, error) {
+	return s.KVStore.Read(s.prefix + key)
+}
+
+func (s *prefixStore) Remove(key string) error {
+	return s.KVStore.Remove(s.prefix + key)
+}
@@ -0,0 +1,122 @@
+package cache_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"log"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tmstorm/cache"
+	"github.com/tmstorm/cache/stores/mem"
+)
+
+// TestChain tests middleware is applied in order and the chain can be used as a cache store
+func TestChain(t *testing.T) {
+	a := assert.New(t)
+
+	store := mem.New(&mem.Store{})
+	var order []string
+	trace := func(name string) cache.Middleware {
+		return func(s cache.KVStore) cache.KVStore {
+			return &tracer{KVStore: s, name: name, order: &order}
+		}
+	}
+
+	kv := cache.Chain(cache.NewKVStore(store, mem.Get(store)), trace("outer"), trace("inner"))
+	a.Equal("mem", kv.Type())
+	a.NoError(kv.Write("key", []byte("value"), false))
+	a.Equal([]string{"outer", "inner"}, order)
+
+	stores := cache.MakeStores(kv)
+	a.Equal(kv, stores["mem"])
+	a.NoError(stores["mem"].Purge())
+}
+
+// tracer records the order writes pass through it
+type tracer struct {
+	cache.KVStore
+	name  string
+	order *[]string
+}
+
+func (t *tracer) Write(key string, value []byte, overwrite bool) error {
+	*t.order = append(*t.order, t.name)
+	return t.KVStore.Write(key, value, overwrite)
+}
+
+// TestMiddleware tests each built in middleware
+func TestMiddleware(t *testing.T) {
+	a := assert.New(t)
+
+	store := mem.New(&mem.Store{})
+	var logs bytes.Buffer
+	var metrics cache.Metrics
+	kv := cache.Chain(cache.NewKVStore(store, mem.Get(store)),
+		cache.WithLogging(log.New(&logs, "", 0)),
+		cache.WithMetrics(&metrics),
+		cache.WithCompression(&cache.Compression{Compressor: cache.Gzip(gzip.BestSpeed), Threshold: 100}),
+		cache.WithPrefix("tenant/"),
+	)
+
+	large := []byte(strings.Repeat("compress me ", 100))
+	a.NoError(kv.Write("large", large, false))
+	a.NoError(kv.Write("small", []byte("value"), false))
+	a.Error(kv.Write("small", []byte("value"), false))
+
+	v, err := kv.Read("large")
+	a.NoError(err)
+	a.Equal(large, v)
+	v, err = kv.Read("small")
+	a.NoError(err)
+	a.Equal([]byte("value"), v)
+	_, err = kv.Read("missing")
+	a.Error(err)
+
+	// Keys are prefixed and large values compressed in the store
+	raw, err := mem.Get(store).Read("tenant/large")
+	a.NoError(err)
+	a.Less(len(raw), len(large))
+	_, err = mem.Get(store).Read("large")
+	a.Error(err)
+
+	a.NoError(kv.Remove("large"))
+	kv.Trim()
+
+	a.Equal(int64(3), metrics.Writes.Load())
+	a.Equal(int64(1), metrics.Errors.Load())
+	a.Equal(int64(3), metrics.Reads.Load())
+	a.Equal(int64(1), metrics.Misses.Load())
+	a.Equal(int64(1), metrics.Removes.Load())
+	a.Equal(int64(1), metrics.Trims.Load())
+	a.Positive(metrics.ReadTime.Load())
+
+	a.Contains(logs.String(), "mem store write large")
+	a.Contains(logs.String(), "mem store read missing")
+	a.Contains(logs.String(), "mem store trim")
+
+	// Values not written through the compression middleware cannot be read through it
+	a.NoError(mem.Get(store).Write("tenant/plain", nil, false))
+	_, err = kv.Read("plain")
+	a.Error(err)
+}
+
+// TestCompressionMiddlewareCustom tests values compressed by an unregistered compressor are read back
+func TestCompressionMiddlewareCustom(t *testing.T) {
+	a := assert.New(t)
+
+	store := mem.New(&mem.Store{})
+	kv := cache.Chain(cache.NewKVStore(store, mem.Get(store)),
+		cache.WithCompression(&cache.Compression{Compressor: unregistered{cache.Gzip(gzip.BestSpeed)}}))
+
+	data := []byte(strings.Repeat("compress me ", 100))
+	a.NoError(kv.Write("large", data, false))
+	raw, err := mem.Get(store).Read("large")
+	a.NoError(err)
+	a.Less(len(raw), len(data))
+
+	b, err := kv.Read("large")
+	a.NoError(err)
+	a.Equal(data, b)
+}
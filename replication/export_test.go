@@ -0,0 +1,12 @@
+package replication
+
+// Keys returns the number of keys kept by p for snapshots.
+func Keys(p *Primary) int {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	n := 0
+	for _, set := range p.keys {
+		n += len(set)
+	}
+	return n
+}
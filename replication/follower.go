@@ -0,0 +1,221 @@
+package replication
+
+import (
+	"bufio"
+	"context"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/tmstorm/cache"
+)
+
+type (
+	// Follower applies the changes streamed by a primary to its own stores.
+	Follower struct {
+		stores map[string]cache.KVStore
+
+		mtx   sync.Mutex
+		epoch uint64
+		seq   uint64
+		head  uint64
+		last  time.Time
+
+		// Addr is the address of the primary
+		Addr string
+
+		// Stores receive the changes made to the primary's stores of the same Type.
+		// They are purged when a snapshot is received.
+		Stores []cache.KVStore
+
+		// Retry is how long to wait before reconnecting to the primary. Defaults to 1 second.
+		Retry time.Duration
+
+		// Timeout is how long to wait for the primary to connect or send a message.
+		// Defaults to 10 seconds and must be longer than the primary's Heartbeat.
+		Timeout time.Duration
+	}
+)
+
+// NewFollower initializes a follower.
+// If Retry or Timeout are not provided they will be set to their default values.
+func NewFollower(f *Follower) *Follower {
+	if f.Addr == "" {
+		fmt.Println("cache: replication follower requires the address of a primary")
+		return nil
+	}
+	if f.Retry == 0 {
+		f.Retry = time.Second
+	}
+	if f.Timeout == 0 {
+		f.Timeout = 10 * time.Second
+	}
+
+	f.stores = make(map[string]cache.KVStore, len(f.Stores))
+	for _, s := range f.Stores {
+		f.stores[s.Type()] = s
+	}
+	return f
+}
+
+// Run follows the primary until ctx is cancelled, reconnecting whenever the connection is lost.
+func (f *Follower) Run(ctx context.Context) error {
+	for {
+		err := f.follow(ctx)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		log.Printf("replication from %s interrupted: %v", f.Addr, err)
+
+		select {
+		case <-time.After(f.Retry):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Seq returns the sequence number of the last change applied.
+func (f *Follower) Seq() uint64 {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	return f.seq
+}
+
+// Lag returns how far the follower is behind the primary.
+func (f *Follower) Lag() Lag {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if f.head <= f.seq {
+		return Lag{}
+	}
+	lag := Lag{Changes: f.head - f.seq}
+	if !f.last.IsZero() {
+		lag.Delay = time.Since(f.last)
+	}
+	return lag
+}
+
+// follow applies changes from one connection to the primary until it fails.
+func (f *Follower) follow(ctx context.Context) error {
+	d := net.Dialer{Timeout: f.Timeout}
+	conn, err := d.DialContext(ctx, "tcp", f.Addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	w := bufio.NewWriter(conn)
+	enc := gob.NewEncoder(w)
+	f.mtx.Lock()
+	req := request{Epoch: f.epoch, Seq: f.seq}
+	f.mtx.Unlock()
+	err = enc.Encode(req)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err != nil {
+		return err
+	}
+
+	dec := gob.NewDecoder(bufio.NewReader(conn))
+	for {
+		conn.SetReadDeadline(time.Now().Add(f.Timeout))
+		var m message
+		err = dec.Decode(&m)
+		if err != nil {
+			return err
+		}
+
+		err = f.apply(m)
+		if err != nil {
+			return err
+		}
+		if m.Kind == kindHeartbeat || m.Kind == kindSnapshotBegin || m.Seq == 0 {
+			continue
+		}
+
+		f.mtx.Lock()
+		ack := request{Epoch: f.epoch, Seq: f.seq}
+		f.mtx.Unlock()
+		err = enc.Encode(ack)
+		if err == nil {
+			err = w.Flush()
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// apply applies one message from the primary.
+// The stores are changed without holding the lock so the lag can be read meanwhile.
+func (f *Follower) apply(m message) error {
+	f.mtx.Lock()
+	f.head = max(f.head, m.Head)
+	epoch := f.epoch
+	f.mtx.Unlock()
+
+	switch m.Kind {
+	case kindSnapshotBegin:
+		// Until the snapshot ends the follower has no consistent state to resume from
+		f.mtx.Lock()
+		f.epoch, f.seq, f.head, f.last = 0, 0, m.Head, time.Time{}
+		f.mtx.Unlock()
+		for _, s := range f.stores {
+			err := s.Purge()
+			if err != nil {
+				return err
+			}
+		}
+	case kindSnapshotEnd:
+		f.mtx.Lock()
+		f.epoch, f.seq, f.last = m.Epoch, m.Seq, m.Time
+		f.mtx.Unlock()
+	case kindWrite, kindRemove, kindPurge:
+		err := f.change(m)
+		if err != nil {
+			log.Printf("replication failed to apply change %d to %s store: %v", m.Seq, m.Store, err)
+		}
+		// Entries in a snapshot have no sequence number
+		if m.Seq != 0 {
+			f.mtx.Lock()
+			f.seq, f.last = m.Seq, m.Time
+			f.mtx.Unlock()
+		}
+	case kindHeartbeat:
+		if epoch != 0 && m.Epoch != epoch {
+			return errors.New("primary history changed")
+		}
+	}
+	return nil
+}
+
+// change applies a write, remove, or purge to the store of the same type.
+func (f *Follower) change(m message) error {
+	s, ok := f.stores[m.Store]
+	if !ok {
+		return nil
+	}
+	switch m.Kind {
+	case kindWrite:
+		return s.Write(m.Key, m.Value, true)
+	case kindPurge:
+		return s.Purge()
+	}
+	// The entry may have been trimmed on the follower already
+	err := s.Remove(m.Key)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
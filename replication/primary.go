@@ -0,0 +1,407 @@
+package replication
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"hash/fnv"
+	"log"
+	"net"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/tmstorm/cache"
+)
+
+type (
+	// Primary records the changes made to its stores and streams them to followers.
+	Primary struct {
+		epoch uint64
+
+		// stripes serialise changes to the same key so they are recorded in the order they were made
+		stripes [64]sync.Mutex
+
+		mtx     sync.Mutex
+		seq     uint64
+		backlog []message
+		changed chan struct{}
+		stores  map[string]cache.KVStore
+		keys    map[string]map[string]uint64
+		conns   map[net.Conn]*FollowerStatus
+		closed  bool
+		done    chan struct{}
+
+		// Backlog is the number of changes kept for followers catching up. Defaults to 10000.
+		// Followers further behind are sent a snapshot instead.
+		Backlog int
+
+		// Heartbeat is how often an idle follower is told the primary's sequence number. Defaults to 1 second.
+		Heartbeat time.Duration
+	}
+
+	// FollowerStatus describes a connected follower
+	FollowerStatus struct {
+		// Addr is the remote address of the follower
+		Addr string
+
+		// Acked is the sequence number of the last change the follower has applied
+		Acked uint64
+
+		// Behind is the number of changes the follower has not applied yet
+		Behind uint64
+	}
+
+	// primaryStore records the changes made through it on the primary
+	primaryStore struct {
+		cache.KVStore
+		p *Primary
+	}
+)
+
+// NewPrimary initializes a primary.
+// If Backlog or Heartbeat are not provided they will be set to their default values.
+func NewPrimary(p *Primary) *Primary {
+	if p.Backlog == 0 {
+		p.Backlog = 10000
+	}
+	if p.Heartbeat == 0 {
+		p.Heartbeat = time.Second
+	}
+
+	var b [8]byte
+	_, _ = rand.Read(b[:])
+	p.epoch = binary.BigEndian.Uint64(b[:]) | 1
+	p.changed = make(chan struct{})
+	p.stores = make(map[string]cache.KVStore)
+	p.keys = make(map[string]map[string]uint64)
+	p.conns = make(map[net.Conn]*FollowerStatus)
+	p.done = make(chan struct{})
+	return p
+}
+
+// Middleware returns middleware replicating the changes made to the store it wraps.
+// Followers apply them to their store of the same Type.
+// Only entries written through the middleware are replicated. Purges are replicated as well,
+// while trims are left to each follower's own store.
+func (p *Primary) Middleware() cache.Middleware {
+	return func(s cache.KVStore) cache.KVStore {
+		p.mtx.Lock()
+		p.stores[s.Type()] = s
+		if p.keys[s.Type()] == nil {
+			p.keys[s.Type()] = make(map[string]uint64)
+		}
+		p.mtx.Unlock()
+		return &primaryStore{KVStore: s, p: p}
+	}
+}
+
+func (s *primaryStore) Write(key string, value []byte, overwrite bool) error {
+	stripe := s.p.stripe(key)
+	stripe.Lock()
+	defer stripe.Unlock()
+
+	err := s.KVStore.Write(key, value, overwrite)
+	if err != nil {
+		return err
+	}
+	s.p.record(message{Kind: kindWrite, Store: s.Type(), Key: key, Value: slices.Clone(value)})
+	return nil
+}
+
+// Purge empties the store and the stores of the followers.
+func (s *primaryStore) Purge() error {
+	for i := range s.p.stripes {
+		s.p.stripes[i].Lock()
+		defer s.p.stripes[i].Unlock()
+	}
+
+	err := s.KVStore.Purge()
+	if err != nil {
+		return err
+	}
+	s.p.record(message{Kind: kindPurge, Store: s.Type()})
+	return nil
+}
+
+func (s *primaryStore) Remove(key string) error {
+	stripe := s.p.stripe(key)
+	stripe.Lock()
+	defer stripe.Unlock()
+
+	err := s.KVStore.Remove(key)
+	if err != nil {
+		return err
+	}
+	s.p.record(message{Kind: kindRemove, Store: s.Type(), Key: key})
+	return nil
+}
+
+// stripe returns the lock for key.
+func (p *Primary) stripe(key string) *sync.Mutex {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return &p.stripes[h.Sum32()%uint32(len(p.stripes))]
+}
+
+// record adds a change to the backlog and wakes the followers.
+func (p *Primary) record(m message) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	p.seq++
+	m.Seq = p.seq
+	m.Time = time.Now()
+	p.backlog = append(p.backlog, m)
+	if len(p.backlog) > p.Backlog {
+		p.backlog = slices.Delete(p.backlog, 0, len(p.backlog)-p.Backlog)
+	}
+
+	// Only the keys of the entries that may exist are kept for snapshots
+	switch m.Kind {
+	case kindWrite:
+		p.keys[m.Store][m.Key] = m.Seq
+	case kindRemove:
+		delete(p.keys[m.Store], m.Key)
+	case kindPurge:
+		p.keys[m.Store] = make(map[string]uint64)
+	}
+
+	close(p.changed)
+	p.changed = make(chan struct{})
+}
+
+// Seq returns the sequence number of the last change.
+func (p *Primary) Seq() uint64 {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	return p.seq
+}
+
+// Followers returns the status of every connected follower.
+func (p *Primary) Followers() []FollowerStatus {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	var followers []FollowerStatus
+	for _, status := range p.conns {
+		f := *status
+		f.Behind = p.seq - min(f.Acked, p.seq)
+		followers = append(followers, f)
+	}
+	return followers
+}
+
+// Serve accepts followers on l until Close is called.
+func (p *Primary) Serve(l net.Listener) error {
+	go func() {
+		<-p.done
+		l.Close()
+	}()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			select {
+			case <-p.done:
+				return nil
+			default:
+			}
+			return err
+		}
+		go p.serve(conn)
+	}
+}
+
+// Close stops serving and disconnects every follower.
+func (p *Primary) Close() error {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	if p.closed {
+		return nil
+	}
+	p.closed = true
+	close(p.done)
+	for conn := range p.conns {
+		conn.Close()
+	}
+	return nil
+}
+
+// serve streams changes to one follower until it disconnects.
+func (p *Primary) serve(conn net.Conn) {
+	defer conn.Close()
+
+	dec := gob.NewDecoder(conn)
+	var req request
+	err := dec.Decode(&req)
+	if err != nil {
+		return
+	}
+
+	status := &FollowerStatus{Addr: conn.RemoteAddr().String(), Acked: req.Seq}
+	p.mtx.Lock()
+	if p.closed {
+		p.mtx.Unlock()
+		return
+	}
+	p.conns[conn] = status
+	p.mtx.Unlock()
+	defer func() {
+		p.mtx.Lock()
+		delete(p.conns, conn)
+		p.mtx.Unlock()
+	}()
+
+	// Acknowledgements are read while changes are sent
+	go func() {
+		for {
+			var ack request
+			if dec.Decode(&ack) != nil {
+				conn.Close()
+				return
+			}
+			p.mtx.Lock()
+			status.Acked = ack.Seq
+			p.mtx.Unlock()
+		}
+	}()
+
+	w := bufio.NewWriter(conn)
+	enc := gob.NewEncoder(w)
+	err = p.stream(enc, w, req)
+	if err != nil && !errors.Is(err, net.ErrClosed) {
+		log.Printf("replication to %s stopped: %v", status.Addr, err)
+	}
+}
+
+// stream sends a snapshot if the follower cannot catch up from the backlog, then every change as it is made.
+func (p *Primary) stream(enc *gob.Encoder, w *bufio.Writer, req request) error {
+	sent := req.Seq
+	if !p.canResume(req) {
+		var err error
+		sent, err = p.snapshot(enc)
+		if err != nil {
+			return err
+		}
+	}
+
+	heartbeat := time.NewTicker(p.Heartbeat)
+	defer heartbeat.Stop()
+	for {
+		p.mtx.Lock()
+		changed := p.changed
+		head := p.seq
+		var pending []message
+		if len(p.backlog) > 0 && p.backlog[0].Seq > sent+1 {
+			p.mtx.Unlock()
+			return errors.New("follower fell behind the backlog")
+		}
+		for _, m := range p.backlog {
+			if m.Seq > sent {
+				pending = append(pending, m)
+			}
+		}
+		p.mtx.Unlock()
+
+		for _, m := range pending {
+			m.Head = head
+			m.Epoch = p.epoch
+			err := enc.Encode(m)
+			if err != nil {
+				return err
+			}
+			sent = m.Seq
+		}
+		err := w.Flush()
+		if err != nil {
+			return err
+		}
+
+		select {
+		case <-changed:
+		case <-heartbeat.C:
+			err = enc.Encode(message{Kind: kindHeartbeat, Seq: sent, Head: head, Epoch: p.epoch})
+			if err == nil {
+				err = w.Flush()
+			}
+			if err != nil {
+				return err
+			}
+		case <-p.done:
+			return nil
+		}
+	}
+}
+
+// canResume reports whether every change after the followers last one is still in the backlog.
+func (p *Primary) canResume(req request) bool {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	if req.Epoch != p.epoch || req.Seq > p.seq {
+		return false
+	}
+	return req.Seq == p.seq || (len(p.backlog) > 0 && p.backlog[0].Seq <= req.Seq+1)
+}
+
+// snapshot sends every replicated entry and returns the sequence number it is current to.
+// Entries are read after the sequence number is taken, so they may include later changes.
+// The follower applies those changes again once the snapshot ends, which leaves it with the same values.
+func (p *Primary) snapshot(enc *gob.Encoder) (uint64, error) {
+	p.mtx.Lock()
+	seq := p.seq
+	keys := make(map[string][]string)
+	for store, set := range p.keys {
+		for key := range set {
+			keys[store] = append(keys[store], key)
+		}
+	}
+	stores := make(map[string]cache.KVStore, len(p.stores))
+	for name, store := range p.stores {
+		stores[name] = store
+	}
+	p.mtx.Unlock()
+
+	err := enc.Encode(message{Kind: kindSnapshotBegin, Seq: seq, Head: seq, Epoch: p.epoch})
+	if err != nil {
+		return 0, err
+	}
+	gone := make(map[string][]string)
+	for name, list := range keys {
+		for _, key := range list {
+			// Entries trimmed since they were written are left out
+			value, err := stores[name].Read(key)
+			if err != nil {
+				gone[name] = append(gone[name], key)
+				continue
+			}
+			err = enc.Encode(message{Kind: kindWrite, Store: name, Key: key, Value: value, Epoch: p.epoch})
+			if err != nil {
+				return 0, err
+			}
+		}
+	}
+	p.forget(gone, seq)
+
+	err = enc.Encode(message{Kind: kindSnapshotEnd, Seq: seq, Head: seq, Epoch: p.epoch, Time: time.Now()})
+	return seq, err
+}
+
+// forget drops the keys of entries that could not be read from the keys kept for snapshots,
+// unless they were written again after seq.
+func (p *Primary) forget(gone map[string][]string, seq uint64) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	for name, list := range gone {
+		set := p.keys[name]
+		for _, key := range list {
+			if written, ok := set[key]; ok && written <= seq {
+				delete(set, key)
+			}
+		}
+	}
+}
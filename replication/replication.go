@@ -0,0 +1,66 @@
+// Package replication streams the writes and removes made to the stores of a primary cache
+// to followers over TCP, so a warm secondary can take over if the primary fails.
+//
+// Changes are captured by wrapping the primary's stores with the middleware from Primary.Middleware.
+// Each change gets a sequence number. A follower connecting for the first time, or after falling
+// further behind than the primary's backlog, is sent a snapshot of every replicated entry before
+// the changes that followed it. A follower reconnecting within the backlog only receives the
+// changes it missed.
+package replication
+
+import (
+	"time"
+)
+
+// kind is the type of a message sent by the primary
+type kind uint8
+
+const (
+	kindWrite kind = iota + 1
+	kindRemove
+	kindPurge
+	kindSnapshotBegin
+	kindSnapshotEnd
+	kindHeartbeat
+)
+
+type (
+	// message is sent by the primary to a follower
+	message struct {
+		Kind kind
+
+		// Seq is the sequence number of a change, or of the last change included in a snapshot
+		Seq uint64
+
+		// Head is the sequence number of the last change on the primary when the message was sent
+		Head uint64
+
+		// Epoch identifies the primary's history and changes when the primary restarts
+		Epoch uint64
+
+		Store string
+		Key   string
+		Value []byte
+
+		// Time is when the change was made on the primary
+		Time time.Time
+	}
+
+	// request is sent by a follower when it connects and then to acknowledge applied changes
+	request struct {
+		// Epoch and Seq are the primary history and last change the follower has applied.
+		// A zero Epoch asks for a snapshot.
+		Epoch uint64
+		Seq   uint64
+	}
+
+	// Lag is how far a follower is behind the primary
+	Lag struct {
+		// Changes is the number of changes made on the primary the follower has not applied yet
+		Changes uint64
+
+		// Delay is how long ago the last change applied by the follower was made on the primary.
+		// It is zero once the follower has caught up. It relies on the clocks of both hosts agreeing.
+		Delay time.Duration
+	}
+)
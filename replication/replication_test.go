@@ -0,0 +1,297 @@
+package replication_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tmstorm/cache"
+	"github.com/tmstorm/cache/replication"
+	"github.com/tmstorm/cache/stores/disk"
+	"github.com/tmstorm/cache/stores/mem"
+)
+
+// serve starts a primary on a loopback listener and returns its address
+func serve(t *testing.T, p *replication.Primary) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	go p.Serve(l)
+	t.Cleanup(func() { p.Close() })
+	return l.Addr().String()
+}
+
+// follow runs a follower until the returned function is called
+func follow(f *replication.Follower) func() {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		f.Run(ctx)
+		close(done)
+	}()
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+// caughtUp waits for the follower to apply every change made on the primary
+func caughtUp(t *testing.T, p *replication.Primary, f *replication.Follower) {
+	assert.Eventually(t, func() bool {
+		return f.Seq() == p.Seq() && f.Lag() == replication.Lag{}
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func memKV() (*mem.Store, cache.KVStore) {
+	s := mem.New(&mem.Store{})
+	return s, cache.NewKVStore(s, mem.Get(s))
+}
+
+// TestReplication tests a follower receives a snapshot and then every later change
+func TestReplication(t *testing.T) {
+	a := assert.New(t)
+
+	primary := replication.NewPrimary(&replication.Primary{Heartbeat: 50 * time.Millisecond})
+	_, primaryMem := memKV()
+	primaryMem = cache.Chain(primaryMem, primary.Middleware())
+	diskStore := disk.New(&disk.Store{RootDir: t.TempDir()})
+	primaryDisk := cache.Chain(cache.NewKVStore(diskStore, disk.GetKeyed(diskStore)), primary.Middleware())
+
+	a.NoError(primaryMem.Write("before", []byte("snapshot"), false))
+	a.NoError(primaryMem.Write("removed", []byte("gone"), false))
+	a.NoError(primaryMem.Remove("removed"))
+	a.NoError(primaryDisk.Write("file", []byte("on disk"), false))
+	a.Equal(uint64(4), primary.Seq())
+
+	_, followerMem := memKV()
+	followerDiskStore := disk.New(&disk.Store{RootDir: t.TempDir()})
+	followerDisk := cache.NewKVStore(followerDiskStore, disk.GetKeyed(followerDiskStore))
+	// Entries only the follower has are dropped by the snapshot
+	a.NoError(followerMem.Write("stale", []byte("value"), false))
+
+	follower := replication.NewFollower(&replication.Follower{
+		Addr:   serve(t, primary),
+		Stores: []cache.KVStore{followerMem, followerDisk},
+		Retry:  10 * time.Millisecond,
+	})
+	defer follow(follower)()
+	caughtUp(t, primary, follower)
+
+	v, err := followerMem.Read("before")
+	a.NoError(err)
+	a.Equal([]byte("snapshot"), v)
+	v, err = followerDisk.Read("file")
+	a.NoError(err)
+	a.Equal([]byte("on disk"), v)
+	_, err = followerMem.Read("removed")
+	a.Error(err)
+	_, err = followerMem.Read("stale")
+	a.Error(err)
+
+	// Later changes are streamed
+	a.NoError(primaryMem.Write("after", []byte("incremental"), false))
+	a.NoError(primaryMem.Write("before", []byte("overwritten"), true))
+	a.NoError(primaryDisk.Remove("file"))
+	caughtUp(t, primary, follower)
+
+	v, err = followerMem.Read("after")
+	a.NoError(err)
+	a.Equal([]byte("incremental"), v)
+	v, err = followerMem.Read("before")
+	a.NoError(err)
+	a.Equal([]byte("overwritten"), v)
+	_, err = followerDisk.Read("file")
+	a.Error(err)
+
+	a.Eventually(func() bool {
+		followers := primary.Followers()
+		return len(followers) == 1 && followers[0].Acked == primary.Seq() && followers[0].Behind == 0
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+// TestReplicationCatchUp tests a reconnecting follower only receives the changes it missed
+// unless it fell further behind than the backlog
+func TestReplicationCatchUp(t *testing.T) {
+	a := assert.New(t)
+
+	primary := replication.NewPrimary(&replication.Primary{Backlog: 3, Heartbeat: 50 * time.Millisecond})
+	_, primaryMem := memKV()
+	primaryMem = cache.Chain(primaryMem, primary.Middleware())
+	a.NoError(primaryMem.Write("a", []byte("1"), false))
+
+	_, followerMem := memKV()
+	follower := replication.NewFollower(&replication.Follower{
+		Addr:   serve(t, primary),
+		Stores: []cache.KVStore{followerMem},
+		Retry:  10 * time.Millisecond,
+	})
+	stop := follow(follower)
+	caughtUp(t, primary, follower)
+	stop()
+
+	// Within the backlog the follower keeps its entries and catches up
+	a.NoError(followerMem.Write("local", []byte("kept"), false))
+	a.NoError(primaryMem.Write("b", []byte("2"), false))
+	a.NoError(primaryMem.Write("c", []byte("3"), false))
+	stop = follow(follower)
+	caughtUp(t, primary, follower)
+	stop()
+
+	_, err := followerMem.Read("local")
+	a.NoError(err)
+	v, err := followerMem.Read("c")
+	a.NoError(err)
+	a.Equal([]byte("3"), v)
+
+	// Beyond the backlog the follower is sent a snapshot
+	for _, key := range []string{"d", "e", "f", "g"} {
+		a.NoError(primaryMem.Write(key, []byte(key), false))
+	}
+	stop = follow(follower)
+	defer stop()
+	caughtUp(t, primary, follower)
+
+	_, err = followerMem.Read("local")
+	a.Error(err)
+	for _, key := range []string{"a", "b", "c", "d", "e", "f", "g"} {
+		_, err = followerMem.Read(key)
+		a.NoError(err, key)
+	}
+}
+
+// TestReplicationPrimaryRestart tests a follower resyncs from a restarted primary
+func TestReplicationPrimaryRestart(t *testing.T) {
+	a := assert.New(t)
+
+	first := replication.NewPrimary(&replication.Primary{Heartbeat: 50 * time.Millisecond})
+	_, firstMem := memKV()
+	firstMem = cache.Chain(firstMem, first.Middleware())
+	a.NoError(firstMem.Write("old", []byte("value"), false))
+	addr := serve(t, first)
+
+	_, followerMem := memKV()
+	follower := replication.NewFollower(&replication.Follower{
+		Addr:   addr,
+		Stores: []cache.KVStore{followerMem},
+		Retry:  10 * time.Millisecond,
+	})
+	defer follow(follower)()
+	caughtUp(t, first, follower)
+
+	// A new primary on the same address has its own history even with matching sequence numbers
+	first.Close()
+	second := replication.NewPrimary(&replication.Primary{Heartbeat: 50 * time.Millisecond})
+	_, secondMem := memKV()
+	secondMem = cache.Chain(secondMem, second.Middleware())
+	a.NoError(secondMem.Write("new", []byte("value"), false))
+
+	var l net.Listener
+	a.Eventually(func() bool {
+		var err error
+		l, err = net.Listen("tcp", addr)
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+	go second.Serve(l)
+	defer second.Close()
+
+	a.Eventually(func() bool {
+		_, err := followerMem.Read("new")
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+	_, err := followerMem.Read("old")
+	a.Error(err)
+	caughtUp(t, second, follower)
+}
+
+// TestNewFollower tests a follower requires the address of a primary
+func TestNewFollower(t *testing.T) {
+	a := assert.New(t)
+	a.Nil(replication.NewFollower(&replication.Follower{}))
+
+	f := replication.NewFollower(&replication.Follower{Addr: "127.0.0.1:0"})
+	a.Equal(time.Second, f.Retry)
+	a.Equal(10*time.Second, f.Timeout)
+	a.Equal(replication.Lag{}, f.Lag())
+}
+
+// blockingStore waits for a signal before each write
+type blockingStore struct {
+	cache.KVStore
+	release chan struct{}
+}
+
+func (s *blockingStore) Write(key string, value []byte, overwrite bool) error {
+	<-s.release
+	return s.KVStore.Write(key, value, overwrite)
+}
+
+// TestReplicationLag tests the lag of a follower applying changes slowly
+func TestReplicationLag(t *testing.T) {
+	a := assert.New(t)
+
+	primary := replication.NewPrimary(&replication.Primary{Heartbeat: 50 * time.Millisecond})
+	_, primaryMem := memKV()
+	primaryMem = cache.Chain(primaryMem, primary.Middleware())
+
+	_, followerMem := memKV()
+	slow := &blockingStore{KVStore: followerMem, release: make(chan struct{})}
+	follower := replication.NewFollower(&replication.Follower{
+		Addr:   serve(t, primary),
+		Stores: []cache.KVStore{slow},
+		Retry:  10 * time.Millisecond,
+	})
+	defer follow(follower)()
+	caughtUp(t, primary, follower)
+
+	for _, key := range []string{"a", "b", "c"} {
+		a.NoError(primaryMem.Write(key, []byte(key), false))
+	}
+	a.Eventually(func() bool {
+		return follower.Lag().Changes > 0
+	}, 5*time.Second, 10*time.Millisecond)
+	a.Equal(uint64(0), follower.Seq())
+	a.Positive(follower.Lag().Delay)
+
+	close(slow.release)
+	caughtUp(t, primary, follower)
+	a.Equal(uint64(3), follower.Seq())
+}
+
+// TestReplicationPrune tests trimmed and purged entries are no longer kept for snapshots
+func TestReplicationPrune(t *testing.T) {
+	a := assert.New(t)
+
+	primary := replication.NewPrimary(&replication.Primary{Backlog: 1, Heartbeat: 50 * time.Millisecond})
+	memStore := mem.New(&mem.Store{MaxAge: 1})
+	primaryMem := cache.Chain(cache.NewKVStore(memStore, mem.Get(memStore)), primary.Middleware())
+	for _, key := range []string{"a", "b", "c"} {
+		a.NoError(primaryMem.Write(key, []byte(key), false))
+	}
+	time.Sleep(1100 * time.Millisecond)
+	primaryMem.Trim()
+	a.NoError(primaryMem.Write("d", []byte("d"), false))
+	a.Equal(4, replication.Keys(primary))
+
+	// The snapshot skips and forgets the trimmed entries
+	_, followerMem := memKV()
+	follower := replication.NewFollower(&replication.Follower{
+		Addr:   serve(t, primary),
+		Stores: []cache.KVStore{followerMem},
+		Retry:  10 * time.Millisecond,
+	})
+	defer follow(follower)()
+	caughtUp(t, primary, follower)
+	a.Equal(1, replication.Keys(primary))
+	_, err := followerMem.Read("a")
+	a.Error(err)
+	_, err = followerMem.Read("d")
+	a.NoError(err)
+
+	// A purge empties the followers too
+	a.NoError(primaryMem.Purge())
+	a.Zero(replication.Keys(primary))
+	caughtUp(t, primary, follower)
+	_, err = followerMem.Read("d")
+	a.Error(err)
+}
@@ -19,6 +19,19 @@ type (
 	// Stores are used to access all the available stores
 	Stores map[string]Store
 
+	// TrimBudget limits how much work a store does in a single call to Trim.
+	// Stores supporting it resume the next call where the previous one stopped,
+	// so a short TrimTime with a small budget spreads trimming evenly over time.
+	TrimBudget struct {
+		// MaxDuration is the longest a single call to Trim may run, e.g. 100 * time.Millisecond.
+		// Zero does not limit the duration.
+		MaxDuration time.Duration
+
+		// MaxEntries is the most entries a single call to Trim may check.
+		// Zero does not limit the number of entries.
+		MaxEntries int
+	}
+
 	// MaxAge Defines the maximum allowed age of items stored in the cache measured in seconds.
 	// MaxAge = 1800 would set the MaxAge for all files to 30 minutes.
 	// This needs to be implemented for each store and used with the store specific Purge() method
@@ -38,6 +51,19 @@ func MakeStores(stores ...Store) Stores {
 	return s
 }
 
+// Exhausted reports whether a call to Trim that started at start and
+// has checked the given number of entries has used up its budget.
+// A nil budget is never exhausted.
+func (b *TrimBudget) Exhausted(start time.Time, entries int) bool {
+	if b == nil {
+		return false
+	}
+	if b.MaxEntries > 0 && entries >= b.MaxEntries {
+		return true
+	}
+	return b.MaxDuration > 0 && time.Since(start) >= b.MaxDuration
+}
+
 // getStore is an internal method that should return a specific store for use
 func getStore(sType string, Stores Stores) (Store, error) {
 	store := Stores[sType]
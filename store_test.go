@@ -2,6 +2,7 @@ package cache_test
 
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/tmstorm/cache"
@@ -21,3 +22,20 @@ func TestMakeStores(t *testing.T) {
 	c = cache.New(&cache.Options{})
 	a.Nil(c.Stores)
 }
+
+func TestTrimBudget(t *testing.T) {
+	a := assert.New(t)
+	start := time.Now()
+
+	var unlimited *cache.TrimBudget
+	a.False(unlimited.Exhausted(start.Add(-time.Hour), 1000000))
+	a.False((&cache.TrimBudget{}).Exhausted(start.Add(-time.Hour), 1000000))
+
+	entries := &cache.TrimBudget{MaxEntries: 10}
+	a.False(entries.Exhausted(start, 9))
+	a.True(entries.Exhausted(start, 10))
+
+	duration := &cache.TrimBudget{MaxDuration: time.Minute}
+	a.False(duration.Exhausted(start, 1000000))
+	a.True(duration.Exhausted(start.Add(-time.Minute), 0))
+}
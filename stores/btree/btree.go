@@ -0,0 +1,456 @@
+// Package btree implements an embedded store keeping entries sorted in a B+tree within a single paged file.
+// Changes are copy-on-write so the file always holds a complete tree, and entries can be iterated in key order.
+package btree
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/tmstorm/cache"
+)
+
+type (
+	// Store implements cache.Store
+	// Only one Store may open a file at a time.
+	Store struct {
+		storeType string
+
+		// mtx is held shared by reads and iteration and exclusively by changes
+		mtx  sync.RWMutex
+		f    *os.File
+		meta meta
+
+		// free holds the sorted ids of pages not used by the committed tree.
+		// It is rebuilt from the tree when the file is opened.
+		free []pgid
+
+		// Path is the file holding the tree
+		Path string
+
+		// MaxAge is the implementation of cache.MaxAge for use during trimming old entries
+		MaxAge cache.MaxAge
+
+		// NoSync skips flushing each change to disk.
+		// A crash may then lose recent changes, though the file is never left inconsistent
+		// unless the operating system writes pages out of order.
+		NoSync bool
+	}
+
+	// writer is used to read, write, remove, and iterate entries
+	writer struct {
+		Store *Store
+	}
+)
+
+// New opens the tree in Path, creating the file if it does not exist.
+// If MaxAge or Path are not provided they will be set to their default values.
+func New(s *Store) *Store {
+	s.storeType = "btree"
+
+	if s.Path == "" {
+		fmt.Println("cache: a file for the btree store has not been provided. It will now be set to cache-btree/btree.db in the applications root directory.")
+		s.Path = filepath.Clean("./cache-btree/btree.db")
+	}
+	if s.MaxAge == 0 {
+		s.MaxAge = cache.DefaultMaxAge
+	}
+
+	err := os.MkdirAll(filepath.Dir(s.Path), 0o750)
+	if err != nil {
+		fmt.Printf("cannot make btree directory: %v\n", err)
+		return nil
+	}
+	err = s.open()
+	if err != nil {
+		fmt.Printf("cannot open btree store: %v\n", err)
+		return nil
+	}
+	return s
+}
+
+// open loads the latest intact meta page and rebuilds the free pages from the tree.
+func (s *Store) open() error {
+	f, err := os.OpenFile(s.Path, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	s.f = f
+
+	stat, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if stat.Size() == 0 {
+		err = s.init()
+		if err != nil {
+			f.Close()
+		}
+		return err
+	}
+
+	found := false
+	for i := int64(0); i < 2; i++ {
+		b := make([]byte, metaSize)
+		_, err = f.ReadAt(b, i*pageSize)
+		if err != nil {
+			continue
+		}
+		// A meta with more pages than the file predates a shrink
+		m, ok := decodeMeta(b)
+		ok = ok && int64(m.pages)*pageSize <= stat.Size()
+		if ok && (!found || m.txid > s.meta.txid) {
+			s.meta = m
+			found = true
+		}
+	}
+	if !found {
+		f.Close()
+		return fmt.Errorf("btree: %s is not a btree file or both meta pages are corrupt", s.Path)
+	}
+
+	// Drop pages written by a tx that was never committed
+	if stat.Size() > int64(s.meta.pages)*pageSize {
+		err = f.Truncate(int64(s.meta.pages) * pageSize)
+		if err != nil {
+			f.Close()
+			return err
+		}
+	}
+
+	err = s.rebuildFree()
+	if err != nil {
+		f.Close()
+	}
+	return err
+}
+
+// init writes an empty tree to a new file.
+func (s *Store) init() error {
+	s.meta = meta{root: firstPage, pages: firstPage + 1}
+	s.free = nil
+	err := s.f.Truncate(0)
+	if err != nil {
+		return err
+	}
+	_, err = s.f.WriteAt((&node{leaf: true}).encode(), firstPage*pageSize)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < 2; i++ {
+		_, err = s.f.WriteAt(s.meta.encode(), int64(i)*pageSize)
+		if err != nil {
+			return err
+		}
+		s.meta.txid++
+	}
+	s.meta.txid--
+	return s.f.Sync()
+}
+
+// rebuildFree marks every page reachable from the root and frees the rest.
+func (s *Store) rebuildFree() error {
+	used := make([]bool, s.meta.pages)
+	var walk func(id pgid) error
+	walk = func(id pgid) error {
+		if id < firstPage || id >= s.meta.pages || used[id] {
+			return errCorrupt
+		}
+		n, count, err := s.read(id, s.meta.pages)
+		if err != nil {
+			return err
+		}
+		for i := 0; i < count; i++ {
+			used[id+pgid(i)] = true
+		}
+		for _, child := range n.children {
+			err = walk(child)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	err := walk(s.meta.root)
+	if err != nil {
+		return err
+	}
+
+	s.free = nil
+	for id := pgid(firstPage); id < s.meta.pages; id++ {
+		if !used[id] {
+			s.free = append(s.free, id)
+		}
+	}
+	return nil
+}
+
+// read returns the node at id and the number of pages it takes.
+func (s *Store) read(id, pages pgid) (*node, int, error) {
+	if id < firstPage || id >= pages {
+		return nil, 0, errCorrupt
+	}
+	b := make([]byte, pageSize)
+	_, err := s.f.ReadAt(b, int64(id)*pageSize)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	count := overflow(b) + 1
+	if count > 1 {
+		if id+pgid(count) > pages {
+			return nil, 0, errCorrupt
+		}
+		b = make([]byte, count*pageSize)
+		_, err = s.f.ReadAt(b, int64(id)*pageSize)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+	n, err := decodeNode(b)
+	return n, count, err
+}
+
+// Type returns the stores type as a string
+func (s *Store) Type() string {
+	return s.storeType
+}
+
+// Get returns a writer for the current btree store
+func Get(s *Store) *writer {
+	var w writer
+	w.Store = s
+	return &w
+}
+
+// Write saves the value under key.
+// If overwrite = true the value will be overwriten if it already exists
+func (w *writer) Write(key string, value []byte, overwrite bool) error {
+	if len(key) > maxKeySize {
+		return fmt.Errorf("key is longer than %d bytes", maxKeySize)
+	}
+
+	s := w.Store
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	t := s.begin()
+	err := t.put(key, value, time.Now().UnixNano(), overwrite)
+	if errors.Is(err, errExists) {
+		return fmt.Errorf("key already exists in btree store: %s", key)
+	}
+	if err != nil {
+		return err
+	}
+	return t.commit()
+}
+
+// Read returns the value saved under key.
+func (w *writer) Read(key string) ([]byte, error) {
+	s := w.Store
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	id := s.meta.root
+	for {
+		n, _, err := s.read(id, s.meta.pages)
+		if err != nil {
+			return []byte{}, err
+		}
+		i := n.search(key)
+		if !n.leaf {
+			id = n.children[i]
+			continue
+		}
+		if i == len(n.keys) || n.keys[i] != key {
+			return []byte{}, fmt.Errorf("key not found in btree store: %s", key)
+		}
+		return n.values[i], nil
+	}
+}
+
+// Remove deletes the entry saved under key.
+// Removing a key that does not exist is not an error.
+func (w *writer) Remove(key string) error {
+	s := w.Store
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	t := s.begin()
+	found, err := t.delete(key)
+	if err != nil || !found {
+		return err
+	}
+	return t.commit()
+}
+
+// Range calls fn for each entry with a key from start up to but not including end, in key order.
+// An empty end iterates to the last key. Iteration stops when fn returns false.
+// fn must not write to the store.
+func (w *writer) Range(start, end string, fn func(key string, value []byte) bool) error {
+	s := w.Store
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	_, err := s.ascend(s.meta.root, start, end, fn)
+	return err
+}
+
+// Prefix calls fn for each entry with a key starting with prefix, in key order.
+// Iteration stops when fn returns false. fn must not write to the store.
+func (w *writer) Prefix(prefix string, fn func(key string, value []byte) bool) error {
+	// The first key after every key with the prefix is the prefix
+	// with its last byte below 0xff incremented
+	end := []byte(prefix)
+	for len(end) > 0 && end[len(end)-1] == 0xff {
+		end = end[:len(end)-1]
+	}
+	if len(end) > 0 {
+		end[len(end)-1]++
+	}
+	return w.Range(prefix, string(end), fn)
+}
+
+// ascend calls fn for the entries under the node at id within the range.
+// It returns false once iteration should stop.
+func (s *Store) ascend(id pgid, start, end string, fn func(key string, value []byte) bool) (bool, error) {
+	n, _, err := s.read(id, s.meta.pages)
+	if err != nil {
+		return false, err
+	}
+
+	for i := n.search(start); i < len(n.keys); i++ {
+		if end != "" && n.keys[i] >= end {
+			return false, nil
+		}
+		if n.leaf {
+			if !fn(n.keys[i], n.values[i]) {
+				return false, nil
+			}
+			continue
+		}
+		more, err := s.ascend(n.children[i], start, end, fn)
+		if err != nil || !more {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// Trim removes entries older than MaxAge in a single change
+// and then shrinks the file by the free pages at its end.
+// It is called by the caches trim worker.
+// This can be called directly if needed.
+func (s *Store) Trim() {
+	log.Println("Starting btree store trimming...")
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	cutoff := time.Now().Add(-time.Second * time.Duration(s.MaxAge)).UnixNano()
+	var expired []string
+	err := s.walkLeaves(s.meta.root, func(n *node) {
+		for i, stamp := range n.stamps {
+			if stamp < cutoff {
+				expired = append(expired, n.keys[i])
+			}
+		}
+	})
+	if err != nil {
+		log.Printf("unable to read btree store: %v", err)
+		return
+	}
+
+	if len(expired) > 0 {
+		t := s.begin()
+		for _, key := range expired {
+			_, err = t.delete(key)
+			if err != nil {
+				log.Printf("unable to remove %s from btree store: %v", key, err)
+				return
+			}
+		}
+		err = t.commit()
+		if err != nil {
+			log.Printf("unable to commit btree store trim: %v", err)
+			return
+		}
+	}
+
+	err = s.shrink()
+	if err != nil {
+		log.Printf("unable to shrink btree store: %v", err)
+		return
+	}
+	log.Println("Btree store trimming complete")
+}
+
+// walkLeaves calls fn for every leaf under the node at id.
+func (s *Store) walkLeaves(id pgid, fn func(n *node)) error {
+	n, _, err := s.read(id, s.meta.pages)
+	if err != nil {
+		return err
+	}
+	if n.leaf {
+		fn(n)
+		return nil
+	}
+	for _, child := range n.children {
+		err = s.walkLeaves(child, fn)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// shrink commits a smaller page count when the last pages of the file are free and truncates them.
+// Pages in the free list are not used by either meta page, so they can be dropped once the commit is durable.
+func (s *Store) shrink() error {
+	pages := s.meta.pages
+	free := s.free
+	for len(free) > 0 && free[len(free)-1] == pages-1 {
+		free = free[:len(free)-1]
+		pages--
+	}
+	if pages == s.meta.pages {
+		return nil
+	}
+
+	t := s.begin()
+	t.meta.pages = pages
+	t.free = free
+	err := t.commit()
+	if err != nil {
+		return err
+	}
+	return s.f.Truncate(int64(pages) * pageSize)
+}
+
+// Purge removes every entry and starts the file over.
+// This function should only be used when stopping the service.
+// If you need to flush the store without stopping it you can
+// call this method directly.
+func (s *Store) Purge() error {
+	log.Println("Btree store is being purged...")
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	err := s.init()
+	if err != nil {
+		return err
+	}
+	log.Println("Btree store purge complete")
+	return nil
+}
+
+// Close closes the file. The store cannot be used once it is closed.
+func (s *Store) Close() error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.f.Close()
+}
@@ -0,0 +1,110 @@
+package btree_test
+
+import (
+	"fmt"
+	"math/rand"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tmstorm/cache/stores/btree"
+)
+
+// TestBtreeStore tests writing, reading, and removing entries
+func TestBtreeStore(t *testing.T) {
+	a := assert.New(t)
+
+	s := btree.New(&btree.Store{Path: filepath.Join(t.TempDir(), "btree.db")})
+	a.NotNil(s)
+	defer s.Close()
+	a.Equal("btree", s.Type())
+
+	w := btree.Get(s)
+	a.NoError(w.Write("key", []byte("value"), false))
+	a.Error(w.Write("key", []byte("other"), false))
+
+	v, err := w.Read("key")
+	a.NoError(err)
+	a.Equal([]byte("value"), v)
+
+	a.NoError(w.Write("key", []byte("other"), true))
+	v, err = w.Read("key")
+	a.NoError(err)
+	a.Equal([]byte("other"), v)
+
+	a.NoError(w.Remove("key"))
+	a.NoError(w.Remove("key"))
+	_, err = w.Read("key")
+	a.Error(err)
+
+	// Values larger than a page spill into overflow pages
+	large := []byte(strings.Repeat("x", 3*btree.PageSize))
+	a.NoError(w.Write("large", large, false))
+	v, err = w.Read("large")
+	a.NoError(err)
+	a.Equal(large, v)
+
+	a.NoError(s.Purge())
+	_, err = w.Read("large")
+	a.Error(err)
+}
+
+// TestBtreeOrder tests entries written in random order are iterated sorted and survive reopening
+func TestBtreeOrder(t *testing.T) {
+	a := assert.New(t)
+	path := filepath.Join(t.TempDir(), "btree.db")
+
+	s := btree.New(&btree.Store{Path: path, NoSync: true})
+	a.NotNil(s)
+	w := btree.Get(s)
+
+	var keys []string
+	for i := 0; i < 3000; i++ {
+		keys = append(keys, fmt.Sprintf("%s/%05d", []string{"a", "b", "c"}[i%3], i))
+	}
+	for _, i := range rand.Perm(len(keys)) {
+		a.NoError(w.Write(keys[i], []byte(keys[i]), false))
+	}
+	a.NoError(s.Close())
+	sort.Strings(keys)
+
+	s = btree.New(&btree.Store{Path: path, NoSync: true})
+	a.NotNil(s)
+	defer s.Close()
+	w = btree.Get(s)
+
+	var got []string
+	a.NoError(w.Range("", "", func(key string, value []byte) bool {
+		a.Equal(key, string(value))
+		got = append(got, key)
+		return true
+	}))
+	a.Equal(keys, got)
+
+	got = nil
+	a.NoError(w.Range("a/00100", "a/00200", func(key string, _ []byte) bool {
+		got = append(got, key)
+		return true
+	}))
+	a.Len(got, 33)
+	a.Equal("a/00102", got[0])
+	a.Equal("a/00198", got[len(got)-1])
+
+	got = nil
+	a.NoError(w.Prefix("b/", func(key string, _ []byte) bool {
+		got = append(got, key)
+		return len(got) < 10
+	}))
+	a.Len(got, 10)
+	a.Equal("b/00001", got[0])
+
+	count := 0
+	a.NoError(w.Prefix("c/", func(key string, _ []byte) bool {
+		a.True(strings.HasPrefix(key, "c/"))
+		count++
+		return true
+	}))
+	a.Equal(1000, count)
+}
@@ -0,0 +1,11 @@
+package btree
+
+// PageSize is the size of each page in the file
+const PageSize = pageSize
+
+// Pages returns the number of pages in the file and how many of them are free.
+func Pages(s *Store) (pages, free int) {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+	return int(s.meta.pages), len(s.free)
+}
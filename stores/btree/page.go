@@ -0,0 +1,257 @@
+package btree
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+)
+
+// File layout
+//
+// The file is a sequence of fixed size pages. Pages 0 and 1 hold the two meta pages,
+// the rest hold the nodes of the tree. A node that does not fit in one page spills
+// into the pages following it, recorded as its overflow count.
+//
+// meta:   magic u32 | version u32 | pageSize u32 | reserved u32 | txid u64 | root u64 | pages u64 | crc u32
+// node:   kind u8 | reserved u8 | count u16 | overflow u32 | elements
+// leaf:   klen u16 | vlen u32 | timestamp i64 | key | value
+// branch: klen u16 | page u64 | key
+const (
+	pageSize = 4096
+
+	magic   = 0x42545245 // "BTRE"
+	version = 1
+
+	metaSize       = 44
+	nodeHeaderSize = 8
+	leafElemSize   = 14
+	branchElemSize = 10
+
+	kindLeaf   = 1
+	kindBranch = 2
+
+	// firstPage is the first page after the meta pages
+	firstPage = 2
+
+	// maxKeySize is the longest key that fits in a node element
+	maxKeySize = 1<<16 - 1
+)
+
+// errCorrupt is returned when a page cannot be decoded
+var errCorrupt = errors.New("btree: corrupt page")
+
+// pgid is the index of a page in the file
+type pgid uint64
+
+type (
+	// meta is the root of a committed version of the tree.
+	// Commits alternate between the two meta pages so the previous version
+	// is still intact if a crash interrupts writing the next one.
+	meta struct {
+		txid  uint64
+		root  pgid
+		pages pgid
+	}
+
+	// node is a decoded leaf or branch page.
+	// In a branch keys[i] is the smallest key found under children[i].
+	node struct {
+		leaf     bool
+		keys     []string
+		values   [][]byte
+		stamps   []int64
+		children []pgid
+	}
+)
+
+// encode returns the meta as a page.
+func (m meta) encode() []byte {
+	b := make([]byte, pageSize)
+	binary.BigEndian.PutUint32(b[0:], magic)
+	binary.BigEndian.PutUint32(b[4:], version)
+	binary.BigEndian.PutUint32(b[8:], pageSize)
+	binary.BigEndian.PutUint64(b[16:], m.txid)
+	binary.BigEndian.PutUint64(b[24:], uint64(m.root))
+	binary.BigEndian.PutUint64(b[32:], uint64(m.pages))
+	binary.BigEndian.PutUint32(b[metaSize-4:], crc32.ChecksumIEEE(b[:metaSize-4]))
+	return b
+}
+
+// decodeMeta reads a meta page, returning false if it is not valid.
+func decodeMeta(b []byte) (meta, bool) {
+	if len(b) < metaSize ||
+		binary.BigEndian.Uint32(b[0:]) != magic ||
+		binary.BigEndian.Uint32(b[4:]) != version ||
+		binary.BigEndian.Uint32(b[8:]) != pageSize ||
+		binary.BigEndian.Uint32(b[metaSize-4:]) != crc32.ChecksumIEEE(b[:metaSize-4]) {
+		return meta{}, false
+	}
+	return meta{
+		txid:  binary.BigEndian.Uint64(b[16:]),
+		root:  pgid(binary.BigEndian.Uint64(b[24:])),
+		pages: pgid(binary.BigEndian.Uint64(b[32:])),
+	}, true
+}
+
+// size returns the number of bytes the encoded node takes.
+func (n *node) size() int {
+	size := nodeHeaderSize
+	for i, key := range n.keys {
+		size += n.elemSize(i, key)
+	}
+	return size
+}
+
+// elemSize returns the encoded size of element i.
+func (n *node) elemSize(i int, key string) int {
+	if n.leaf {
+		return leafElemSize + len(key) + len(n.values[i])
+	}
+	return branchElemSize + len(key)
+}
+
+// pageCount returns the number of pages the encoded node takes.
+func (n *node) pageCount() int {
+	return (n.size() + pageSize - 1) / pageSize
+}
+
+// encode returns the node as one or more pages.
+func (n *node) encode() []byte {
+	count := n.pageCount()
+	b := make([]byte, count*pageSize)
+	if n.leaf {
+		b[0] = kindLeaf
+	} else {
+		b[0] = kindBranch
+	}
+	binary.BigEndian.PutUint16(b[2:], uint16(len(n.keys)))
+	binary.BigEndian.PutUint32(b[4:], uint32(count-1))
+
+	off := nodeHeaderSize
+	for i, key := range n.keys {
+		binary.BigEndian.PutUint16(b[off:], uint16(len(key)))
+		if n.leaf {
+			binary.BigEndian.PutUint32(b[off+2:], uint32(len(n.values[i])))
+			binary.BigEndian.PutUint64(b[off+6:], uint64(n.stamps[i]))
+			off += leafElemSize
+			off += copy(b[off:], key)
+			off += copy(b[off:], n.values[i])
+		} else {
+			binary.BigEndian.PutUint64(b[off+2:], uint64(n.children[i]))
+			off += branchElemSize
+			off += copy(b[off:], key)
+		}
+	}
+	return b
+}
+
+// overflow returns the number of extra pages of the node starting in b.
+func overflow(b []byte) int {
+	return int(binary.BigEndian.Uint32(b[4:]))
+}
+
+// decodeNode reads a node from its pages.
+func decodeNode(b []byte) (*node, error) {
+	if len(b) < nodeHeaderSize {
+		return nil, errCorrupt
+	}
+	n := &node{}
+	switch b[0] {
+	case kindLeaf:
+		n.leaf = true
+	case kindBranch:
+	default:
+		return nil, errCorrupt
+	}
+
+	count := int(binary.BigEndian.Uint16(b[2:]))
+	off := nodeHeaderSize
+	for i := 0; i < count; i++ {
+		if n.leaf {
+			if off+leafElemSize > len(b) {
+				return nil, errCorrupt
+			}
+			klen := int(binary.BigEndian.Uint16(b[off:]))
+			vlen := int(binary.BigEndian.Uint32(b[off+2:]))
+			stamp := int64(binary.BigEndian.Uint64(b[off+6:]))
+			off += leafElemSize
+			if off+klen+vlen > len(b) {
+				return nil, errCorrupt
+			}
+			n.keys = append(n.keys, string(b[off:off+klen]))
+			n.values = append(n.values, append([]byte(nil), b[off+klen:off+klen+vlen]...))
+			n.stamps = append(n.stamps, stamp)
+			off += klen + vlen
+		} else {
+			if off+branchElemSize > len(b) {
+				return nil, errCorrupt
+			}
+			klen := int(binary.BigEndian.Uint16(b[off:]))
+			child := pgid(binary.BigEndian.Uint64(b[off+2:]))
+			off += branchElemSize
+			if off+klen > len(b) || child < firstPage {
+				return nil, errCorrupt
+			}
+			n.keys = append(n.keys, string(b[off:off+klen]))
+			n.children = append(n.children, child)
+			off += klen
+		}
+	}
+	if !n.leaf && count == 0 {
+		return nil, errCorrupt
+	}
+	return n, nil
+}
+
+// search returns the index of the first key >= key in a leaf,
+// or the index of the child that may hold key in a branch.
+func (n *node) search(key string) int {
+	lo, hi := 0, len(n.keys)
+	for lo < hi {
+		mid := int(uint(lo+hi) >> 1)
+		if n.keys[mid] < key {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+	if n.leaf {
+		return lo
+	}
+	if lo < len(n.keys) && n.keys[lo] == key {
+		return lo
+	}
+	if lo > 0 {
+		lo--
+	}
+	return lo
+}
+
+// split divides a node into nodes that each fit in a page,
+// unless a single element is larger than a page.
+func (n *node) split() []*node {
+	if n.size() <= pageSize {
+		return []*node{n}
+	}
+
+	var nodes []*node
+	cur := &node{leaf: n.leaf}
+	size := nodeHeaderSize
+	for i, key := range n.keys {
+		elem := n.elemSize(i, key)
+		if len(cur.keys) > 0 && size+elem > pageSize {
+			nodes = append(nodes, cur)
+			cur = &node{leaf: n.leaf}
+			size = nodeHeaderSize
+		}
+		cur.keys = append(cur.keys, key)
+		if n.leaf {
+			cur.values = append(cur.values, n.values[i])
+			cur.stamps = append(cur.stamps, n.stamps[i])
+		} else {
+			cur.children = append(cur.children, n.children[i])
+		}
+		size += elem
+	}
+	return append(nodes, cur)
+}
@@ -0,0 +1,312 @@
+package btree
+
+import (
+	"errors"
+	"sort"
+)
+
+// errExists is returned by put when the key exists and overwrite is false
+var errExists = errors.New("btree: key exists")
+
+type (
+	// tx is a single copy-on-write change to the tree.
+	// Every node on the path to a change is written to a free page and the
+	// change becomes visible once commit writes the meta page pointing at the new root.
+	// Pages of the committed tree are never written, so a crash leaves the previous version intact.
+	tx struct {
+		s    *Store
+		meta meta
+
+		// free is the working copy of the stores free pages
+		free []pgid
+
+		// allocated holds the pages written by this tx and their page counts.
+		// They are not part of any committed tree and can be reused as soon as they are released.
+		allocated map[pgid]int
+
+		// pending holds released pages of the committed tree.
+		// They become free once the commit replacing the tree is durable.
+		pending []pgid
+	}
+
+	// ref points at a written node
+	ref struct {
+		key  string
+		id   pgid
+		size int
+	}
+)
+
+// begin starts a tx on the committed tree.
+// The caller must hold the write lock until the tx is committed or dropped.
+func (s *Store) begin() *tx {
+	return &tx{
+		s:         s,
+		meta:      s.meta,
+		free:      append([]pgid(nil), s.free...),
+		allocated: make(map[pgid]int),
+	}
+}
+
+// commit makes the tx durable and the new tree visible.
+// Dropping a tx without committing leaves the store unchanged.
+func (t *tx) commit() error {
+	s := t.s
+	if !s.NoSync {
+		err := s.f.Sync()
+		if err != nil {
+			return err
+		}
+	}
+
+	t.meta.txid++
+	_, err := s.f.WriteAt(t.meta.encode(), int64(t.meta.txid%2)*pageSize)
+	if err != nil {
+		return err
+	}
+	if !s.NoSync {
+		err = s.f.Sync()
+		if err != nil {
+			return err
+		}
+	}
+
+	s.meta = t.meta
+	s.free = append(t.free, t.pending...)
+	sort.Slice(s.free, func(i, j int) bool { return s.free[i] < s.free[j] })
+	return nil
+}
+
+// alloc returns the first run of count free pages, growing the file if there is none.
+func (t *tx) alloc(count int) pgid {
+	start := 0
+	for i := range t.free {
+		if i > 0 && t.free[i] != t.free[i-1]+1 {
+			start = i
+		}
+		if i-start+1 == count {
+			id := t.free[start]
+			t.free = append(t.free[:start], t.free[i+1:]...)
+			t.allocated[id] = count
+			return id
+		}
+	}
+
+	id := t.meta.pages
+	t.meta.pages += pgid(count)
+	t.allocated[id] = count
+	return id
+}
+
+// release gives back the pages of a node that is no longer part of the tree.
+func (t *tx) release(id pgid, count int) {
+	var pages []pgid
+	for i := 0; i < count; i++ {
+		pages = append(pages, id+pgid(i))
+	}
+
+	if _, ok := t.allocated[id]; ok {
+		delete(t.allocated, id)
+		t.free = append(t.free, pages...)
+		sort.Slice(t.free, func(i, j int) bool { return t.free[i] < t.free[j] })
+		return
+	}
+	t.pending = append(t.pending, pages...)
+}
+
+// read returns a node of the tree being changed.
+func (t *tx) read(id pgid) (*node, int, error) {
+	return t.s.read(id, t.meta.pages)
+}
+
+// write saves a node to newly allocated pages.
+func (t *tx) write(n *node) (ref, error) {
+	id := t.alloc(n.pageCount())
+	_, err := t.s.f.WriteAt(n.encode(), int64(id)*pageSize)
+	if err != nil {
+		return ref{}, err
+	}
+	r := ref{id: id, size: n.size()}
+	if len(n.keys) > 0 {
+		r.key = n.keys[0]
+	}
+	return r, nil
+}
+
+// writeSplit writes a node, splitting it first if it does not fit in a page.
+func (t *tx) writeSplit(n *node) ([]ref, error) {
+	var refs []ref
+	for _, part := range n.split() {
+		r, err := t.write(part)
+		if err != nil {
+			return nil, err
+		}
+		refs = append(refs, r)
+	}
+	return refs, nil
+}
+
+// replace swaps count children of a branch starting at i for refs.
+func (n *node) replace(i, count int, refs []ref) {
+	keys := make([]string, 0, len(n.keys)-count+len(refs))
+	children := make([]pgid, 0, cap(keys))
+	keys = append(keys, n.keys[:i]...)
+	children = append(children, n.children[:i]...)
+	for _, r := range refs {
+		keys = append(keys, r.key)
+		children = append(children, r.id)
+	}
+	n.keys = append(keys, n.keys[i+count:]...)
+	n.children = append(children, n.children[i+count:]...)
+}
+
+// put sets key in the tree and updates the root.
+func (t *tx) put(key string, value []byte, stamp int64, overwrite bool) error {
+	refs, err := t.putNode(t.meta.root, key, value, stamp, overwrite)
+	if err != nil {
+		return err
+	}
+
+	// Grow the tree while the root is split
+	for len(refs) > 1 {
+		root := &node{}
+		root.replace(0, 0, refs)
+		refs, err = t.writeSplit(root)
+		if err != nil {
+			return err
+		}
+	}
+	t.meta.root = refs[0].id
+	return nil
+}
+
+// putNode sets key under the node at id and returns the nodes replacing it.
+func (t *tx) putNode(id pgid, key string, value []byte, stamp int64, overwrite bool) ([]ref, error) {
+	n, count, err := t.read(id)
+	if err != nil {
+		return nil, err
+	}
+
+	i := n.search(key)
+	if n.leaf {
+		if i < len(n.keys) && n.keys[i] == key {
+			if !overwrite {
+				return nil, errExists
+			}
+			n.values[i] = value
+			n.stamps[i] = stamp
+		} else {
+			n.keys = append(n.keys[:i], append([]string{key}, n.keys[i:]...)...)
+			n.values = append(n.values[:i], append([][]byte{value}, n.values[i:]...)...)
+			n.stamps = append(n.stamps[:i], append([]int64{stamp}, n.stamps[i:]...)...)
+		}
+	} else {
+		refs, err := t.putNode(n.children[i], key, value, stamp, overwrite)
+		if err != nil {
+			return nil, err
+		}
+		n.replace(i, 1, refs)
+	}
+
+	t.release(id, count)
+	return t.writeSplit(n)
+}
+
+// delete removes key from the tree and updates the root.
+// It reports whether the key was found.
+func (t *tx) delete(key string) (bool, error) {
+	refs, found, err := t.deleteNode(t.meta.root, key)
+	if err != nil || !found {
+		return found, err
+	}
+
+	if len(refs) == 0 {
+		r, err := t.write(&node{leaf: true})
+		if err != nil {
+			return false, err
+		}
+		refs = []ref{r}
+	}
+	t.meta.root = refs[0].id
+
+	// Shrink the tree while the root has a single child
+	for {
+		n, count, err := t.read(t.meta.root)
+		if err != nil {
+			return false, err
+		}
+		if n.leaf || len(n.children) > 1 {
+			return true, nil
+		}
+		t.release(t.meta.root, count)
+		t.meta.root = n.children[0]
+	}
+}
+
+// deleteNode removes key under the node at id and returns the nodes replacing it.
+// Nodes left less than a quarter full are merged with a sibling.
+func (t *tx) deleteNode(id pgid, key string) ([]ref, bool, error) {
+	n, count, err := t.read(id)
+	if err != nil {
+		return nil, false, err
+	}
+
+	i := n.search(key)
+	if n.leaf {
+		if i == len(n.keys) || n.keys[i] != key {
+			return nil, false, nil
+		}
+		n.keys = append(n.keys[:i], n.keys[i+1:]...)
+		n.values = append(n.values[:i], n.values[i+1:]...)
+		n.stamps = append(n.stamps[:i], n.stamps[i+1:]...)
+	} else {
+		refs, found, err := t.deleteNode(n.children[i], key)
+		if err != nil || !found {
+			return nil, found, err
+		}
+		n.replace(i, 1, refs)
+
+		if len(refs) == 1 && refs[0].size < pageSize/4 && len(n.children) > 1 {
+			err = t.merge(n, i)
+			if err != nil {
+				return nil, false, err
+			}
+		}
+	}
+
+	t.release(id, count)
+	if len(n.keys) == 0 {
+		return nil, true, nil
+	}
+	refs, err := t.writeSplit(n)
+	return refs, true, err
+}
+
+// merge joins child i of a branch with its neighbour.
+func (t *tx) merge(n *node, i int) error {
+	lo := i
+	if i == len(n.children)-1 {
+		lo = i - 1
+	}
+
+	merged := &node{}
+	for j := lo; j < lo+2; j++ {
+		child, count, err := t.read(n.children[j])
+		if err != nil {
+			return err
+		}
+		merged.leaf = child.leaf
+		merged.keys = append(merged.keys, child.keys...)
+		merged.values = append(merged.values, child.values...)
+		merged.stamps = append(merged.stamps, child.stamps...)
+		merged.children = append(merged.children, child.children...)
+		t.release(n.children[j], count)
+	}
+
+	refs, err := t.writeSplit(merged)
+	if err != nil {
+		return err
+	}
+	n.replace(lo, 2, refs)
+	return nil
+}
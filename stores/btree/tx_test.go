@@ -0,0 +1,128 @@
+package btree_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tmstorm/cache/stores/btree"
+)
+
+// TestBtreeTornCommit tests the previous version of the tree is used when the last meta page is corrupt
+func TestBtreeTornCommit(t *testing.T) {
+	a := assert.New(t)
+	path := filepath.Join(t.TempDir(), "btree.db")
+
+	s := btree.New(&btree.Store{Path: path})
+	a.NotNil(s)
+	w := btree.Get(s)
+	a.NoError(w.Write("first", []byte("value"), false))
+	a.NoError(w.Write("second", []byte("value"), false))
+	a.NoError(s.Close())
+
+	// The second write committed the meta page in slot 1, pages written after it were never committed
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	a.NoError(err)
+	_, err = f.WriteAt([]byte{0xff, 0xff}, btree.PageSize+20)
+	a.NoError(err)
+	info, err := f.Stat()
+	a.NoError(err)
+	_, err = f.WriteAt(make([]byte, btree.PageSize), info.Size())
+	a.NoError(err)
+	a.NoError(f.Close())
+
+	s = btree.New(&btree.Store{Path: path})
+	a.NotNil(s)
+	defer s.Close()
+	w = btree.Get(s)
+
+	_, err = w.Read("first")
+	a.NoError(err)
+	_, err = w.Read("second")
+	a.Error(err)
+
+	info, err = os.Stat(path)
+	a.NoError(err)
+	pages, _ := btree.Pages(s)
+	a.Equal(int64(pages*btree.PageSize), info.Size())
+
+	a.NoError(w.Write("second", []byte("value"), false))
+	_, err = w.Read("second")
+	a.NoError(err)
+}
+
+// TestBtreeTrim tests Trim removes expired entries and reclaims their pages
+func TestBtreeTrim(t *testing.T) {
+	a := assert.New(t)
+	path := filepath.Join(t.TempDir(), "btree.db")
+
+	s := btree.New(&btree.Store{Path: path, MaxAge: 1, NoSync: true})
+	a.NotNil(s)
+	defer s.Close()
+	w := btree.Get(s)
+
+	for i := 0; i < 2000; i++ {
+		a.NoError(w.Write(fmt.Sprintf("old/%04d", i), make([]byte, 100), false))
+	}
+	time.Sleep(1100 * time.Millisecond)
+	a.NoError(w.Write("new", []byte("value"), false))
+
+	before, err := os.Stat(path)
+	a.NoError(err)
+	s.Trim()
+	after, err := os.Stat(path)
+	a.NoError(err)
+	a.Less(after.Size(), before.Size()/4)
+
+	v, err := w.Read("new")
+	a.NoError(err)
+	a.Equal([]byte("value"), v)
+	count := 0
+	a.NoError(w.Range("", "", func(string, []byte) bool {
+		count++
+		return true
+	}))
+	a.Equal(1, count)
+
+	// Every page below the end of the file is either in the tree or free
+	pages, free := btree.Pages(s)
+	a.Equal(int64(pages*btree.PageSize), after.Size())
+	a.Less(free, pages)
+}
+
+// TestBtreeRemoveMany tests the tree stays ordered and complete as removals merge its nodes
+func TestBtreeRemoveMany(t *testing.T) {
+	a := assert.New(t)
+	path := filepath.Join(t.TempDir(), "btree.db")
+
+	s := btree.New(&btree.Store{Path: path, NoSync: true})
+	a.NotNil(s)
+	w := btree.Get(s)
+	for i := 0; i < 2000; i++ {
+		a.NoError(w.Write(fmt.Sprintf("%04d", i), make([]byte, 50), false))
+	}
+	for i := 0; i < 2000; i++ {
+		if i%7 != 0 {
+			a.NoError(w.Remove(fmt.Sprintf("%04d", i)))
+		}
+	}
+	a.NoError(s.Close())
+
+	s = btree.New(&btree.Store{Path: path, NoSync: true})
+	a.NotNil(s)
+	defer s.Close()
+	w = btree.Get(s)
+
+	var want, got []string
+	for i := 0; i < 2000; i += 7 {
+		want = append(want, fmt.Sprintf("%04d", i))
+	}
+	a.NoError(w.Range("", "", func(key string, _ []byte) bool {
+		got = append(got, key)
+		return true
+	}))
+	a.Equal(want, got)
+}
@@ -0,0 +1,161 @@
+package disk
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// blobDir is the directory under RootDir that holds content-addressed payloads
+// when Dedup is enabled. Each blob has a refs file next to it counting the
+// entries that reference it.
+const blobDir = ".blobs"
+
+// refsExt is the extension of the file holding a blob's reference count.
+const refsExt = ".refs"
+
+// blobPath returns the directory and full path relative to RootDir of the blob with the given digest.
+func blobPath(digest [sha256.Size]byte) (string, string) {
+	name := hex.EncodeToString(digest[:])
+	dir := filepath.Join(blobDir, name[:2])
+	return dir, filepath.Join(dir, name)
+}
+
+// retain adds a reference to the blob holding data, writing the blob if this is the first reference.
+// The refs file is locked while the count is updated so processes sharing the
+// RootDir do not lose references.
+func (s *Store) retain(root *os.Root, digest [sha256.Size]byte, data []byte) error {
+	dir, path := blobPath(digest)
+	stripe := s.blobStripe(path)
+	stripe.Lock()
+	defer stripe.Unlock()
+	s.touch(filepath.Base(path))
+
+	err := mkdirAll(root, dir)
+	if err != nil {
+		return err
+	}
+
+	refs, err := openLocked(root, path+refsExt, os.O_RDWR|os.O_CREATE, true)
+	if err != nil {
+		return err
+	}
+	defer refs.Close()
+
+	count, err := readRefs(refs)
+	if err != nil {
+		return err
+	}
+
+	// Write the blob if it is new or was lost
+	_, err = root.Stat(path)
+	if count == 0 || os.IsNotExist(err) {
+		blob, err := root.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+		if err != nil {
+			return err
+		}
+		_, err = blob.Write(data)
+		if closeErr := blob.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return writeRefs(refs, count+1)
+}
+
+// release removes a reference to the blob with the given digest.
+// The blob is deleted once it is no longer referenced.
+func (s *Store) release(root *os.Root, digest [sha256.Size]byte) error {
+	_, path := blobPath(digest)
+	stripe := s.blobStripe(path)
+	stripe.Lock()
+	defer stripe.Unlock()
+	s.touch(filepath.Base(path))
+
+	refs, err := openLocked(root, path+refsExt, os.O_RDWR, true)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer refs.Close()
+
+	count, err := readRefs(refs)
+	if err != nil {
+		return err
+	}
+
+	if count > 1 {
+		return writeRefs(refs, count-1)
+	}
+
+	err = root.Remove(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return root.Remove(path + refsExt)
+}
+
+// touch records that the references of the named blob are changing while Recover counts them.
+func (s *Store) touch(name string) {
+	s.touchMtx.Lock()
+	defer s.touchMtx.Unlock()
+	if s.touched != nil {
+		s.touched[name] = true
+	}
+}
+
+// readBlob returns the contents of the blob with the given digest.
+// A CorruptError is returned if the blob is missing or does not match its digest.
+func (s *Store) readBlob(root *os.Root, digest [sha256.Size]byte) ([]byte, error) {
+	_, path := blobPath(digest)
+	stripe := s.blobStripe(path)
+	stripe.RLock()
+	defer stripe.RUnlock()
+
+	blob, err := root.Open(path)
+	if os.IsNotExist(err) {
+		return nil, &CorruptError{Path: path, Err: fmt.Errorf("referenced blob is missing: %w", err)}
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer blob.Close()
+
+	data, err := io.ReadAll(blob)
+	if err != nil {
+		return nil, err
+	}
+	return data, checkBlob(path, digest, data)
+}
+
+// readRefs reads the reference count from a locked refs file.
+func readRefs(refs *os.File) (int, error) {
+	b, err := io.ReadAll(refs)
+	if err != nil {
+		return 0, err
+	}
+	if len(b) == 0 {
+		return 0, nil
+	}
+	return strconv.Atoi(strings.TrimSpace(string(b)))
+}
+
+// writeRefs replaces the reference count in a locked refs file.
+func writeRefs(refs *os.File, count int) error {
+	err := refs.Truncate(0)
+	if err != nil {
+		return err
+	}
+	_, err = refs.WriteAt([]byte(strconv.Itoa(count)), 0)
+	return err
+}
@@ -0,0 +1,127 @@
+package disk_test
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tmstorm/cache/stores/disk"
+)
+
+// blobs returns the blob files in the store and their reference counts.
+func blobs(t *testing.T, rootDir string) map[string]string {
+	t.Helper()
+	found := make(map[string]string)
+	err := filepath.WalkDir(filepath.Join(rootDir, ".blobs"), func(path string, d fs.DirEntry, err error) error {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil || d.IsDir() || strings.HasSuffix(path, ".refs") {
+			return err
+		}
+		refs, err := os.ReadFile(path + ".refs")
+		found[filepath.Base(path)] = string(refs)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return found
+}
+
+// Test content-addressed deduplicated storage
+func TestDedupStore(t *testing.T) {
+	a := assert.New(t)
+
+	diskStore := disk.New(&disk.Store{
+		RootDir: t.TempDir(),
+		MaxAge:  20,
+		Dedup:   true,
+	})
+	a.NotNil(diskStore)
+	d := disk.Get(diskStore)
+
+	shared := []byte("the same asset for every tenant")
+	for _, tenant := range []string{"tenant-a", "tenant-b", "tenant-c"} {
+		a.NoError(d.Write(tenant, "logo.svg", shared, false))
+	}
+	a.NoError(disk.GetKeyed(diskStore).Write("logo", shared, false))
+
+	// The payload is only stored once
+	found := blobs(t, diskStore.RootDir)
+	a.Len(found, 1)
+	for _, refs := range found {
+		a.Equal("4", refs)
+	}
+
+	for _, tenant := range []string{"tenant-a", "tenant-b", "tenant-c"} {
+		b, err := d.Read(tenant, "logo.svg")
+		a.NoError(err)
+		a.Equal(shared, b)
+	}
+	b, err := disk.GetKeyed(diskStore).Read("logo")
+	a.NoError(err)
+	a.Equal(shared, b)
+
+	// Removing an entry keeps the blob for the others
+	a.NoError(d.Remove("tenant-a", "logo.svg"))
+	a.NoError(disk.GetKeyed(diskStore).Remove("logo"))
+	found = blobs(t, diskStore.RootDir)
+	a.Len(found, 1)
+	for _, refs := range found {
+		a.Equal("2", refs)
+	}
+
+	// Overwriting an entry references a new blob and releases the old one
+	a.NoError(d.Write("tenant-b", "logo.svg", []byte("a custom logo"), true))
+	b, err = d.Read("tenant-b", "logo.svg")
+	a.NoError(err)
+	a.Equal("a custom logo", string(b))
+	found = blobs(t, diskStore.RootDir)
+	a.Len(found, 2)
+	for _, refs := range found {
+		a.Equal("1", refs)
+	}
+
+	// Overwriting with the same data keeps a single reference
+	a.NoError(d.Write("tenant-b", "logo.svg", []byte("a custom logo"), true))
+	found = blobs(t, diskStore.RootDir)
+	a.Len(found, 2)
+	for _, refs := range found {
+		a.Equal("1", refs)
+	}
+
+	// Blobs are hidden from the FS view
+	_, err = fs.Stat(diskStore.FS(), ".blobs")
+	a.ErrorIs(err, fs.ErrNotExist)
+
+	// Trimming expired entries releases their blobs
+	old := time.Now().Add(-time.Hour)
+	a.NoError(os.Chtimes(filepath.Join(diskStore.RootDir, "tenant-c", "logo.svg"), old, old))
+	diskStore.Trim()
+	found = blobs(t, diskStore.RootDir)
+	a.Len(found, 1)
+
+	// Blobs are not trimmed by age while they are referenced
+	err = filepath.WalkDir(filepath.Join(diskStore.RootDir, ".blobs"), func(path string, _ fs.DirEntry, err error) error {
+		if err == nil {
+			err = os.Chtimes(path, old, old)
+		}
+		return err
+	})
+	a.NoError(err)
+	diskStore.Trim()
+	b, err = d.Read("tenant-b", "logo.svg")
+	a.NoError(err)
+	a.Equal("a custom logo", string(b))
+
+	// Removing the last reference removes the blob
+	a.NoError(d.Remove("tenant-b", "logo.svg"))
+	a.Empty(blobs(t, diskStore.RootDir))
+
+	a.NoError(diskStore.Purge())
+}
@@ -0,0 +1,453 @@
+package disk
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// nonceSize is the size of the AES-GCM nonce saved with each encrypted entry.
+const nonceSize = 12
+
+// nameDir is the directory under RootDir that holds path addressed entries
+// when file names are hidden.
+const nameDir = ".names"
+
+type (
+	// KeyProvider supplies the keys used to encrypt entries at rest.
+	// Keys must be 16, 24, or 32 bytes to select AES-128, AES-192, or AES-256.
+	KeyProvider interface {
+		// CurrentKey returns the ID and key used to encrypt new entries.
+		CurrentKey() (id string, key []byte, err error)
+
+		// Key returns the key with the given ID to decrypt existing entries.
+		// Keys should be kept available until every entry encrypted with them
+		// has been re-encrypted or has expired.
+		Key(id string) ([]byte, error)
+	}
+
+	// Encryption sets the disk stores encryption at rest options.
+	Encryption struct {
+		// Keys provides the keys used to encrypt and decrypt entries.
+		// The key ID is saved with each entry so keys can be rotated.
+		Keys KeyProvider
+
+		// NameKey hides the names of entries on disk if set.
+		// Paths and keys are replaced with an HMAC-SHA256 of the name using a key derived from NameKey
+		// and the original name is saved encrypted with the entry.
+		// Unlike Keys this key cannot be rotated without losing access to existing entries.
+		NameKey []byte
+	}
+
+	// StaticKeys is a KeyProvider holding a fixed set of keys.
+	StaticKeys struct {
+		// CurrentID is the ID of the key used to encrypt new entries.
+		CurrentID string
+
+		// Keys holds every available key by its ID.
+		Keys map[string][]byte
+	}
+)
+
+// ErrKeyNotFound is returned when the key an entry was encrypted with is not available.
+var ErrKeyNotFound = errors.New("encryption key not found")
+
+// CurrentKey returns the key with CurrentID.
+func (k *StaticKeys) CurrentKey() (string, []byte, error) {
+	key, err := k.Key(k.CurrentID)
+	return k.CurrentID, key, err
+}
+
+// Key returns the key with the given ID.
+func (k *StaticKeys) Key(id string) ([]byte, error) {
+	key, ok := k.Keys[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrKeyNotFound, id)
+	}
+	return key, nil
+}
+
+// hidesNames reports whether entry names are hidden on disk.
+func (s *Store) hidesNames() bool {
+	return s.Encryption != nil && len(s.Encryption.NameKey) > 0
+}
+
+// nameHash returns the hex encoded hash a key or path name is stored under.
+// Names are hashed with SHA-256 or with HMAC-SHA256 if names are hidden.
+func (s *Store) nameHash(name string) string {
+	if s.hidesNames() {
+		mac := hmac.New(sha256.New, deriveKey(s.Encryption.NameKey, "names"))
+		_, _ = mac.Write([]byte(name))
+		return hex.EncodeToString(mac.Sum(nil))
+	}
+	sum := sha256.Sum256([]byte(name))
+	return hex.EncodeToString(sum[:])
+}
+
+// deriveKey returns a key for the given purpose derived from key with HKDF-SHA256,
+// so the same key bytes are never used by two algorithms.
+func deriveKey(key []byte, purpose string) []byte {
+	derived, err := hkdf.Key(sha256.New, key, nil, "cache disk store "+purpose, sha256.Size)
+	if err != nil {
+		// Only returned for lengths HKDF-SHA256 cannot produce
+		panic(err)
+	}
+	return derived
+}
+
+// entryAAD returns the additional data authenticated with data encrypted with the key id.
+// It binds the data to the path of its entry relative to RootDir so entries cannot be swapped on disk.
+// Version 1 entries and blobs, which may be shared by several entries, are only bound to the key ID.
+func entryAAD(version byte, id string, path string) []byte {
+	if version == 1 || path == "" {
+		return []byte(id)
+	}
+	path = filepath.ToSlash(path)
+	b := make([]byte, 0, 1+len(id)+len(path))
+	b = append(b, byte(len(id)))
+	b = append(b, id...)
+	return append(b, path...)
+}
+
+// fanOut returns the directory and full path of a hashed name under dir.
+// The first two byte pairs of the hash are used as directories, e.g. dir/ab/cd/abcd...
+func fanOut(dir string, hash string) (string, string) {
+	dir = filepath.Join(dir, hash[:2], hash[2:4])
+	return dir, filepath.Join(dir, hash)
+}
+
+// newAEAD returns AES-GCM using the given key.
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encrypt encrypts data for the entry at path with the current key if encryption is enabled.
+// The header is updated with the key ID and nonce used.
+// If names are hidden the key held in the header is encrypted as well.
+func (s *Store) encrypt(h *header, data []byte, path string) ([]byte, error) {
+	if s.Encryption == nil {
+		return data, nil
+	}
+
+	id, key, err := s.Encryption.Keys.CurrentKey()
+	if err != nil {
+		return nil, err
+	}
+	if len(id) > math.MaxUint8 {
+		return nil, fmt.Errorf("encryption key ID is too long: %s", id)
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	// With Dedup the nonce is derived from the data so the same data
+	// encrypts to the same blob, which is not bound to the path of any one entry.
+	// Otherwise a random nonce is used.
+	bound := path
+	if s.Dedup {
+		mac := hmac.New(sha256.New, deriveKey(key, "nonces"))
+		_, _ = mac.Write(data)
+		copy(h.nonce[:], mac.Sum(nil))
+		bound = ""
+	} else if _, err := rand.Read(h.nonce[:]); err != nil {
+		return nil, err
+	}
+	h.flags |= flagEncrypted
+	h.keyID = id
+	sealed := aead.Seal(nil, h.nonce[:], data, entryAAD(entryVersion, id, bound))
+
+	if s.hidesNames() && h.flags&flagKey != 0 {
+		nonce := make([]byte, nonceSize, nonceSize+len(h.key)+aead.Overhead())
+		if _, err := rand.Read(nonce); err != nil {
+			return nil, err
+		}
+		h.sealed = aead.Seal(nonce, nonce, []byte(h.key), entryAAD(entryVersion, id, path))
+		if len(h.sealed) > math.MaxUint16 {
+			return nil, fmt.Errorf("name is too long to encrypt: %d bytes", len(h.key))
+		}
+		h.flags = h.flags&^flagKey | flagSealedName
+		h.key = ""
+	}
+
+	return sealed, nil
+}
+
+// decrypt decrypts data of the entry at path using the key the entry was encrypted with.
+// If the entry's name is encrypted it is decrypted into the header's key.
+func (s *Store) decrypt(h *header, data []byte, path string) ([]byte, error) {
+	if h.flags&(flagEncrypted|flagSealedName) == 0 {
+		return data, nil
+	}
+
+	aead, err := s.entryAEAD(h)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.openName(h, path)
+	if err != nil {
+		return nil, err
+	}
+
+	bound := path
+	if h.flags&flagRef != 0 {
+		bound = ""
+	}
+	data, err = aead.Open(nil, h.nonce[:], data, entryAAD(h.version, h.keyID, bound))
+	if err != nil {
+		return nil, fmt.Errorf("cannot decrypt entry: %w", err)
+	}
+	return data, nil
+}
+
+// openName decrypts the name of the entry at path into the header's key.
+func (s *Store) openName(h *header, path string) error {
+	if h.flags&flagSealedName == 0 || h.key != "" {
+		return nil
+	}
+
+	aead, err := s.entryAEAD(h)
+	if err != nil {
+		return err
+	}
+	if len(h.sealed) < nonceSize {
+		return errBadHeader
+	}
+	key, err := aead.Open(nil, h.sealed[:nonceSize], h.sealed[nonceSize:], entryAAD(h.version, h.keyID, path))
+	if err != nil {
+		return fmt.Errorf("cannot decrypt entry name: %w", err)
+	}
+	h.key = string(key)
+	return nil
+}
+
+// entryAEAD returns AES-GCM using the key an entry was encrypted with.
+func (s *Store) entryAEAD(h *header) (cipher.AEAD, error) {
+	if s.Encryption == nil {
+		return nil, errors.New("entry is encrypted but the store has no encryption keys")
+	}
+	key, err := s.Encryption.Keys.Key(h.keyID)
+	if err != nil {
+		return nil, err
+	}
+	return newAEAD(key)
+}
+
+// ReEncrypt rewrites every entry that is not encrypted with the current key.
+// Entries whose names are hidden differently than the current options ask for, e.g. after NameKey is first set,
+// are moved to where they are now looked up. Plain entries are moved under the store's hidden names,
+// so entries written through a namespace should be re-encrypted through the namespace first.
+// Entries keep their age so MaxAge is not extended.
+// It is intended to be run in the background after rotating keys, e.g. go s.ReEncrypt(ctx),
+// and stops early if the context is canceled.
+// The number of rewritten entries is returned.
+func (s *Store) ReEncrypt(ctx context.Context) (int, error) {
+	return s.reencryptDir(ctx, ".", nameDir)
+}
+
+// reencryptDir rewrites the entries under dir like ReEncrypt, hiding the names of plain entries under hiddenDir.
+func (s *Store) reencryptDir(ctx context.Context, dir string, hiddenDir string) (int, error) {
+	if s.Encryption == nil {
+		return 0, errors.New("encryption is not enabled for the store")
+	}
+	currentID, _, err := s.Encryption.Keys.CurrentKey()
+	if err != nil {
+		return 0, err
+	}
+
+	// Moving an entry locks both its old and new path, which is only safe
+	// while no other re-encryption is locking two entries
+	s.reencryptMtx.Lock()
+	defer s.reencryptMtx.Unlock()
+
+	root, err := s.freshRoot()
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	err = fs.WalkDir(root.FS(), dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == dir && errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		if internal(path) {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		rewritten, err := s.reencrypt(path, currentID, hiddenDir)
+		if err != nil {
+			log.Printf("unable to re-encrypt %s: %v", path, err)
+			return nil
+		}
+		if rewritten {
+			count++
+		}
+		return nil
+	})
+	return count, err
+}
+
+// reencrypt rewrites the entry at path if it is not encrypted with the key currentID,
+// or moves it if it is not saved where the current options look it up.
+// Plain entries are hidden under hiddenDir if names are hidden.
+func (s *Store) reencrypt(path string, currentID string, hiddenDir string) (bool, error) {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+	stripe := s.stripe(path)
+	stripe.Lock()
+	defer stripe.Unlock()
+
+	root, err := s.openRoot()
+	if err != nil {
+		return false, err
+	}
+
+	file, err := openLocked(root, path, os.O_RDONLY, true)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return false, err
+	}
+
+	raw, err := io.ReadAll(file)
+	if err != nil {
+		return false, err
+	}
+
+	// A corrupt entry is quarantined rather than rewritten with a fresh checksum
+	err = s.verify(root, path, raw)
+	if errors.Is(err, ErrCorrupt) {
+		s.quarantineLocked(root, path, raw, err)
+		return false, err
+	}
+	if err != nil {
+		return false, err
+	}
+	old, payload, err := splitEntry(raw)
+	if err != nil {
+		return false, err
+	}
+
+	named := old
+	err = s.openName(&named, path)
+	if err != nil {
+		return false, err
+	}
+	saveDir, target, h := s.entryLocation(path, named.key, hiddenDir)
+	current := old.flags&flagEncrypted != 0 && old.keyID == currentID && old.version != 1
+	if current && target == path {
+		return false, nil
+	}
+
+	_, data, err := s.decode(root, path, old, payload)
+	if err != nil {
+		return false, err
+	}
+
+	// Keep the age of the entry
+	if target == path {
+		err = s.commit(root, path, old, h, data, stat.ModTime())
+	} else {
+		err = s.move(root, path, old, saveDir, target, h, data, stat.ModTime())
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// entryLocation returns the directory and path the entry at path named key is saved at
+// with the store's current options, along with the header it is written with.
+// Plain entries are hidden under hiddenDir if names are hidden.
+func (s *Store) entryLocation(path string, key string, hiddenDir string) (string, string, header) {
+	elems := strings.Split(filepath.ToSlash(path), "/")
+	if elems[0] == keyDir {
+		if key == "" {
+			return filepath.Dir(path), path, header{}
+		}
+		saveDir, fullPath := s.keyPath(key)
+		return saveDir, fullPath, header{flags: flagKey, key: key}
+	}
+	if key == "" {
+		return s.entryIn(hiddenDir, path)
+	}
+
+	// Entries with hidden names stay in the names directory they were hidden in
+	for i, elem := range elems {
+		if elem == nameDir {
+			return s.entryIn(filepath.Join(elems[:i+1]...), key)
+		}
+	}
+	return filepath.Dir(path), path, header{flags: flagKey, key: key}
+}
+
+// move saves the data of the entry at path as a new entry at target and removes the entry at path.
+// An entry already at target was written after the entry at path so it is kept instead.
+// The caller must hold the lock of the entry at path and s.reencryptMtx.
+func (s *Store) move(root *os.Root, path string, old header, saveDir string, target string, h header, data []byte, modTime time.Time) error {
+	if stripe := s.stripe(target); stripe != s.stripe(path) {
+		stripe.Lock()
+		defer stripe.Unlock()
+	}
+
+	_, err := root.Lstat(target)
+	if os.IsNotExist(err) {
+		err = mkdirAll(root, saveDir)
+		if err == nil {
+			err = s.commit(root, target, header{}, h, data, modTime)
+		}
+	}
+	if err != nil {
+		return checkEscape(root, target, err)
+	}
+
+	err = root.Remove(path)
+	if err != nil {
+		return checkEscape(root, path, err)
+	}
+
+	// Release the blob the entry referenced
+	if old.flags&flagRef != 0 {
+		return s.release(root, old.digest)
+	}
+	return nil
+}
@@ -0,0 +1,325 @@
+package disk_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tmstorm/cache/stores/disk"
+)
+
+// onDisk returns the names and contents of every file under dir.
+func onDisk(t *testing.T, dir string) map[string][]byte {
+	t.Helper()
+	files := make(map[string][]byte)
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		b, err := os.ReadFile(path)
+		files[path] = b
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+// Test encryption at rest and key rotation
+func TestEncryption(t *testing.T) {
+	a := assert.New(t)
+
+	keys := &disk.StaticKeys{
+		CurrentID: "2024-01",
+		Keys: map[string][]byte{
+			"2024-01": bytes.Repeat([]byte{1}, 32),
+		},
+	}
+	diskStore := disk.New(&disk.Store{
+		RootDir: t.TempDir(),
+		MaxAge:  20,
+	})
+	a.NotNil(diskStore)
+	d := disk.Get(diskStore)
+
+	// An entry written before encryption was enabled
+	a.NoError(d.Write("docs", "plain.txt", []byte("plain customer document"), false))
+	diskStore.Encryption = &disk.Encryption{Keys: keys}
+
+	secret := []byte("confidential customer document")
+	a.NoError(d.Write("docs", "secret.txt", secret, false))
+	a.NoError(disk.GetKeyed(diskStore).Write("customer", secret, false))
+
+	// The payload is not saved as plaintext
+	for path, b := range onDisk(t, diskStore.RootDir) {
+		a.False(bytes.Contains(b, secret), path)
+	}
+
+	b, err := d.Read("docs", "secret.txt")
+	a.NoError(err)
+	a.Equal(secret, b)
+	b, err = d.Read("docs", "plain.txt")
+	a.NoError(err)
+	a.Equal("plain customer document", string(b))
+
+	// Rotate the key. Entries encrypted with the old key can still be read
+	keys.Keys["2024-02"] = bytes.Repeat([]byte{2}, 32)
+	keys.CurrentID = "2024-02"
+	a.NoError(d.Write("docs", "new.txt", secret, false))
+	b, err = d.Read("docs", "secret.txt")
+	a.NoError(err)
+	a.Equal(secret, b)
+
+	// Re-encrypt the old and plain entries while keeping their age
+	old := time.Now().Add(-5 * time.Second).Truncate(time.Second)
+	entry := filepath.Join(diskStore.RootDir, "docs", "secret.txt")
+	a.NoError(os.Chtimes(entry, old, old))
+	n, err := diskStore.ReEncrypt(context.Background())
+	a.NoError(err)
+	a.Equal(3, n)
+	if runtime.GOOS == "linux" {
+		stat, err := os.Stat(entry)
+		a.NoError(err)
+		a.True(stat.ModTime().Equal(old))
+	}
+
+	// Nothing is left to re-encrypt
+	n, err = diskStore.ReEncrypt(context.Background())
+	a.NoError(err)
+	a.Zero(n)
+
+	// The old key is no longer needed
+	delete(keys.Keys, "2024-01")
+	for _, name := range []string{"secret.txt", "new.txt"} {
+		b, err = d.Read("docs", name)
+		a.NoError(err)
+		a.Equal(secret, b)
+	}
+	b, err = d.Read("docs", "plain.txt")
+	a.NoError(err)
+	a.Equal("plain customer document", string(b))
+	b, err = disk.GetKeyed(diskStore).Read("customer")
+	a.NoError(err)
+	a.Equal(secret, b)
+
+	// Entries cannot be read without their key
+	delete(keys.Keys, "2024-02")
+	_, err = d.Read("docs", "secret.txt")
+	a.ErrorIs(err, disk.ErrKeyNotFound)
+
+	a.NoError(diskStore.Purge())
+}
+
+// Test re-encrypting quarantines corrupt entries instead of rewriting them
+func TestReEncryptCorrupt(t *testing.T) {
+	a := assert.New(t)
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(io.Discard)
+
+	keys := &disk.StaticKeys{
+		CurrentID: "2024-01",
+		Keys: map[string][]byte{
+			"2024-01": bytes.Repeat([]byte{1}, 32),
+		},
+	}
+	diskStore := disk.New(&disk.Store{
+		RootDir:    t.TempDir(),
+		MaxAge:     20,
+		Quarantine: true,
+		Encryption: &disk.Encryption{Keys: keys},
+	})
+	a.NotNil(diskStore)
+	d := disk.Get(diskStore)
+
+	a.NoError(d.Write("docs", "good.txt", []byte("intact document"), false))
+	a.NoError(d.Write("docs", "bad.txt", []byte("damaged document"), false))
+	flipLastByte(t, filepath.Join(diskStore.RootDir, "docs", "bad.txt"))
+
+	keys.Keys["2024-02"] = bytes.Repeat([]byte{2}, 32)
+	keys.CurrentID = "2024-02"
+	n, err := diskStore.ReEncrypt(context.Background())
+	a.NoError(err)
+	a.Equal(1, n)
+
+	a.NoFileExists(filepath.Join(diskStore.RootDir, "docs", "bad.txt"))
+	a.FileExists(filepath.Join(diskStore.RootDir, ".quarantine", "docs", "bad.txt"))
+	_, err = d.Read("docs", "bad.txt")
+	a.Error(err)
+	b, err := d.Read("docs", "good.txt")
+	a.NoError(err)
+	a.Equal("intact document", string(b))
+
+	a.NoError(diskStore.Purge())
+}
+
+// Test hiding file names on disk
+func TestEncryptedNames(t *testing.T) {
+	a := assert.New(t)
+
+	diskStore := disk.New(&disk.Store{
+		RootDir: t.TempDir(),
+		MaxAge:  20,
+		Dedup:   true,
+		Encryption: &disk.Encryption{
+			Keys: &disk.StaticKeys{
+				CurrentID: "k1",
+				Keys:      map[string][]byte{"k1": bytes.Repeat([]byte{1}, 16)},
+			},
+			NameKey: bytes.Repeat([]byte{9}, 32),
+		},
+	})
+	a.NotNil(diskStore)
+	d := disk.Get(diskStore)
+	k := disk.GetKeyed(diskStore)
+
+	data := []byte("quarterly report")
+	a.NoError(d.Write("acme-corp/finance", "q3-report.pdf", data, false))
+	a.NoError(d.Write("globex/finance", "q3-report.pdf", data, false))
+	a.NoError(k.Write("acme-corp/session", data, false))
+
+	// Neither names nor data are visible on disk
+	for path, b := range onDisk(t, diskStore.RootDir) {
+		for _, name := range []string{"acme-corp", "globex", "finance", "report", "session"} {
+			a.NotContains(path, name)
+			a.False(bytes.Contains(b, []byte(name)), path)
+		}
+	}
+
+	// Identical data is still deduplicated
+	blobs := 0
+	for path := range onDisk(t, filepath.Join(diskStore.RootDir, ".blobs")) {
+		if !strings.HasSuffix(path, ".refs") {
+			blobs++
+		}
+	}
+	a.Equal(1, blobs)
+
+	b, err := d.Read("acme-corp/finance", "q3-report.pdf")
+	a.NoError(err)
+	a.Equal(data, b)
+	b, err = k.Read("acme-corp/session")
+	a.NoError(err)
+	a.Equal(data, b)
+
+	keys, err := k.Keys()
+	a.NoError(err)
+	sort.Strings(keys)
+	a.Equal([]string{"acme-corp/session"}, keys)
+
+	a.NoError(d.Remove("acme-corp/finance", "q3-report.pdf"))
+	_, err = d.Read("acme-corp/finance", "q3-report.pdf")
+	a.Error(err)
+	b, err = d.Read("globex/finance", "q3-report.pdf")
+	a.NoError(err)
+	a.Equal(data, b)
+
+	// A key provider is required
+	a.Nil(disk.New(&disk.Store{RootDir: t.TempDir(), Encryption: &disk.Encryption{}}))
+
+	a.NoError(diskStore.Purge())
+}
+
+// Test encrypted entries cannot be swapped on disk
+func TestEncryptedEntriesBound(t *testing.T) {
+	a := assert.New(t)
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(io.Discard)
+
+	diskStore := disk.New(&disk.Store{
+		RootDir: t.TempDir(),
+		MaxAge:  20,
+		Encryption: &disk.Encryption{
+			Keys: &disk.StaticKeys{
+				CurrentID: "k1",
+				Keys:      map[string][]byte{"k1": bytes.Repeat([]byte{1}, 32)},
+			},
+		},
+	})
+	a.NotNil(diskStore)
+	d := disk.Get(diskStore)
+
+	a.NoError(d.Write("accounts", "alice", []byte("balance 10"), false))
+	a.NoError(d.Write("accounts", "bob", []byte("balance 1000000"), false))
+
+	// Copying one entry over another does not pass it off as the other
+	b, err := os.ReadFile(filepath.Join(diskStore.RootDir, "accounts", "bob"))
+	a.NoError(err)
+	a.NoError(os.WriteFile(filepath.Join(diskStore.RootDir, "accounts", "alice"), b, 0o600))
+	_, err = d.Read("accounts", "alice")
+	a.Error(err)
+
+	b, err = d.Read("accounts", "bob")
+	a.NoError(err)
+	a.Equal("balance 1000000", string(b))
+}
+
+// Test re-encrypting moves entries once names are hidden
+func TestReEncryptHidesNames(t *testing.T) {
+	a := assert.New(t)
+
+	diskStore := disk.New(&disk.Store{
+		RootDir: t.TempDir(),
+		MaxAge:  20,
+		Encryption: &disk.Encryption{
+			Keys: &disk.StaticKeys{
+				CurrentID: "k1",
+				Keys:      map[string][]byte{"k1": bytes.Repeat([]byte{1}, 32)},
+			},
+		},
+	})
+	a.NotNil(diskStore)
+	d := disk.Get(diskStore)
+	k := disk.GetKeyed(diskStore)
+	ns, err := disk.GetNamespace(diskStore, "tenant")
+	a.NoError(err)
+
+	data := []byte("quarterly report")
+	a.NoError(d.Write("acme-corp/finance", "q3-report.pdf", data, false))
+	a.NoError(k.Write("acme-corp/session", data, false))
+	a.NoError(ns.Write("globex/finance", "q3-report.pdf", data, false))
+
+	diskStore.Encryption.NameKey = bytes.Repeat([]byte{9}, 32)
+	n, err := ns.ReEncrypt(context.Background())
+	a.NoError(err)
+	a.Equal(1, n)
+	n, err = diskStore.ReEncrypt(context.Background())
+	a.NoError(err)
+	a.Equal(2, n)
+	diskStore.Trim()
+
+	// Names are no longer visible on disk
+	for path := range onDisk(t, diskStore.RootDir) {
+		for _, name := range []string{"acme-corp", "globex", "finance", "report", "session"} {
+			a.NotContains(path, name)
+		}
+	}
+
+	b, err := d.Read("acme-corp/finance", "q3-report.pdf")
+	a.NoError(err)
+	a.Equal(data, b)
+	b, err = k.Read("acme-corp/session")
+	a.NoError(err)
+	a.Equal(data, b)
+	b, err = ns.Read("globex/finance", "q3-report.pdf")
+	a.NoError(err)
+	a.Equal(data, b)
+	keys, err := k.Keys()
+	a.NoError(err)
+	a.Equal([]string{"acme-corp/session"}, keys)
+
+	// Nothing is left to move
+	n, err = diskStore.ReEncrypt(context.Background())
+	a.NoError(err)
+	a.Zero(n)
+}
@@ -0,0 +1,121 @@
+package disk
+
+import (
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// trimCursorFile is the file under RootDir holding the path a budgeted trim stopped at.
+// It is shared by every process using the RootDir so a pass continues where any of them stopped.
+const trimCursorFile = ".trim"
+
+// trimPass walks the store for a single call to Trim.
+// With a TrimBudget it skips the paths checked by the previous call and
+// stops once the budget is used up.
+type trimPass struct {
+	s     *Store
+	start time.Time
+
+	// resume is the path the previous call stopped at, or empty to start a new pass
+	resume string
+
+	// last is the last file checked and checked counts the files checked
+	last    string
+	checked int
+
+	// stopped is set if the budget was used up before the pass completed
+	stopped bool
+}
+
+// walk is used for fs.WalkDirFunc and calls the stores walk for each path after the cursor.
+func (p *trimPass) walk(path string, d fs.DirEntry, err error) error {
+	if err != nil {
+		return err
+	}
+
+	if p.resume != "" && path != "." {
+		// Directories holding the cursor are walked to reach it
+		if d.IsDir() && strings.HasPrefix(p.resume, path+"/") {
+			return p.s.walk(path, d, nil)
+		}
+		if !walkedAfter(path, p.resume) {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		p.resume = ""
+	}
+
+	err = p.s.walk(path, d, nil)
+	if err != nil || d.IsDir() || metaFile(path) {
+		return err
+	}
+
+	p.last = path
+	p.checked++
+	if p.s.TrimBudget.Exhausted(p.start, p.checked) {
+		p.stopped = true
+		return fs.SkipAll
+	}
+	return nil
+}
+
+// walkedAfter reports whether fs.WalkDir visits path after cursor.
+// Paths are compared by element as WalkDir visits each directory in lexical order.
+func walkedAfter(path string, cursor string) bool {
+	a := strings.Split(path, "/")
+	b := strings.Split(cursor, "/")
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			return a[i] > b[i]
+		}
+	}
+	return len(a) > len(b)
+}
+
+// readCursor returns the path the last budgeted trim stopped at, or empty if it completed.
+func readCursor(root *os.Root) string {
+	f, err := root.Open(trimCursorFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("unable to read trim cursor: %v", err)
+		}
+		return ""
+	}
+	defer f.Close()
+
+	b, err := io.ReadAll(f)
+	if err != nil {
+		log.Printf("unable to read trim cursor: %v", err)
+		return ""
+	}
+	return string(b)
+}
+
+// saveCursor saves where a budgeted trim stopped so the next call can continue from it.
+// The cursor is removed once a pass completes.
+func (p *trimPass) saveCursor(root *os.Root) {
+	if !p.stopped {
+		err := root.Remove(trimCursorFile)
+		if err != nil && !os.IsNotExist(err) {
+			log.Printf("unable to remove trim cursor: %v", err)
+		}
+		return
+	}
+
+	f, err := root.OpenFile(trimCursorFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err == nil {
+		_, err = f.WriteString(p.last)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		log.Printf("unable to save trim cursor: %v", err)
+	}
+}
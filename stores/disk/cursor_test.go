@@ -0,0 +1,86 @@
+package disk_test
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tmstorm/cache"
+	"github.com/tmstorm/cache/stores/disk"
+)
+
+// Test trimming is spread over several calls and resumes from the saved cursor
+func TestTrimBudget(t *testing.T) {
+	a := assert.New(t)
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(io.Discard)
+
+	rootDir := t.TempDir()
+	diskStore := disk.New(&disk.Store{
+		RootDir:    rootDir,
+		MaxAge:     20,
+		TrimBudget: &cache.TrimBudget{MaxEntries: 4},
+	})
+	a.NotNil(diskStore)
+	d := disk.Get(diskStore)
+
+	// "a-c" sorts before "a/..." as a string but is walked after the "a" directory
+	old := time.Now().Add(-time.Hour)
+	var paths []string
+	for _, dir := range []string{"a", "a-c", "b"} {
+		for i := range 4 {
+			name := fmt.Sprintf("%d.txt", i)
+			a.NoError(d.Write(dir, name, []byte("expired"), false))
+			path := filepath.Join(rootDir, dir, name)
+			a.NoError(os.Chtimes(path, old, old))
+			paths = append(paths, path)
+		}
+	}
+
+	// remaining counts the entries that have not been trimmed
+	remaining := func() int {
+		n := 0
+		for _, path := range paths {
+			if _, err := os.Stat(path); err == nil {
+				n++
+			}
+		}
+		return n
+	}
+
+	diskStore.Trim()
+	a.Equal(8, remaining())
+	a.FileExists(filepath.Join(rootDir, ".trim"))
+
+	// A new store sharing the RootDir continues from the saved cursor
+	diskStore = disk.New(&disk.Store{
+		RootDir:    rootDir,
+		MaxAge:     20,
+		TrimBudget: &cache.TrimBudget{MaxEntries: 4},
+	})
+	diskStore.Trim()
+	a.Equal(4, remaining())
+	for _, name := range []string{"0.txt", "1.txt", "2.txt", "3.txt"} {
+		a.FileExists(filepath.Join(rootDir, "b", name))
+	}
+
+	// The cursor is removed once a pass completes
+	diskStore.Trim()
+	a.Equal(0, remaining())
+	diskStore.Trim()
+	a.NoFileExists(filepath.Join(rootDir, ".trim"))
+
+	// The cursor is hidden from the FS view
+	a.NoError(d.Write("c", "fresh.txt", []byte("fresh"), false))
+	diskStore.TrimBudget.MaxEntries = 1
+	diskStore.Trim()
+	_, err := diskStore.FS().Open(".trim")
+	a.Error(err)
+
+	a.NoError(diskStore.Purge())
+}
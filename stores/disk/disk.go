@@ -2,10 +2,15 @@ package disk
 
 import (
 	"bufio"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"log"
+	"math"
 	"os"
 	"path/filepath"
 	"strings"
@@ -19,19 +24,96 @@ type (
 	// Store implements cache.Store
 	Store struct {
 		storeType string
-		mtx       sync.RWMutex
+
+		// mtx is held shared by every operation and exclusively by Purge
+		mtx sync.RWMutex
+
+		// stripes lock individual entries and blobStripes lock content-addressed blobs
+		stripes     stripes
+		blobStripes stripes
+
+		// reencryptMtx allows one ReEncrypt at a time
+		reencryptMtx sync.Mutex
+
+		// touched records the blobs retained or released while Recover counts references.
+		// It is nil when no count is running.
+		touched  map[string]bool
+		touchMtx sync.Mutex
+
+		// root is used for all file operations so paths cannot escape RootDir.
+		// stale holds roots dropped after another process removed RootDir,
+		// which are closed once no operation can still be using them.
+		root    *os.Root
+		stale   []*os.Root
+		rootMtx sync.Mutex
 
 		// RootDir defines the root file path of the cache
 		RootDir string
 
 		// MaxAge is the implementation of cache.MaxAge for use during trimming old files
 		MaxAge cache.MaxAge
+
+		// Dedup enables content-addressed storage.
+		// Payloads are stored once by their SHA-256 digest and entries reference the stored blob.
+		// Blobs are reference counted and removed once no entry references them.
+		Dedup bool
+
+		// Compression enables compression at rest for entries at or above the compression threshold.
+		// Entries are saved with the name of the compressor used so Read can decompress them,
+		// and entries saved without compression can still be read.
+		Compression *cache.Compression
+
+		// Encryption enables AES-GCM encryption at rest and optionally hides file names.
+		// Entries saved without encryption can still be read.
+		Encryption *Encryption
+
+		// Quarantine moves corrupt entries to RootDir/.quarantine instead of deleting them,
+		// so they can be inspected. Quarantined entries are trimmed once they reach MaxAge.
+		Quarantine bool
+
+		// Scrub verifies the checksum of every entry while trimming
+		// so corrupt entries are found before they are read.
+		Scrub bool
+
+		// FreeSpace evicts the oldest entries during Trim, regardless of MaxAge,
+		// when the filesystem holding RootDir is running out of space.
+		// Free space is only measured on Linux.
+		FreeSpace *Watermarks
+
+		// TrimBudget limits the work done by each call to Trim.
+		// The path a call stops at is saved in RootDir and the next call continues from it.
+		// If nil the whole store is checked on each call.
+		TrimBudget *cache.TrimBudget
+
+		// Recovery runs a scan of RootDir in New to clean up after a crash or an unclean shutdown.
+		// If nil no scan is run.
+		Recovery *Recovery
 	}
 
 	// writer is used to implement the store for read, write, and remove
 	writer struct {
 		Store *Store
 	}
+
+	// checkMode sets how checkFile checks a file.
+	// evict removes the file regardless of its age, scrub verifies kept files,
+	// and partial removes empty files left by an interrupted write.
+	checkMode struct {
+		evict   bool
+		scrub   bool
+		partial bool
+	}
+
+	// fileCheck is what checkFile did with a file
+	fileCheck int
+)
+
+const (
+	fileKept fileCheck = iota
+	fileSkipped
+	fileExpired
+	filePartial
+	fileCorrupt
 )
 
 // New initializes a new instance of the disk store to be added to the current cache.
@@ -53,16 +135,25 @@ func New(s *Store) *Store {
 		s.MaxAge = cache.DefaultMaxAge
 	}
 
-	// create the cache root directory
-	s.mtx.Lock()
-	defer s.mtx.Unlock()
-	_, err := os.Stat(s.RootDir)
+	if s.Encryption != nil && s.Encryption.Keys == nil {
+		fmt.Println("cache: encryption for the disk store requires a key provider.")
+		return nil
+	}
+
+	if s.FreeSpace != nil && s.FreeSpace.High < s.FreeSpace.Low {
+		fmt.Println("cache: the high free space watermark of the disk store must not be lower than the low watermark.")
+		return nil
+	}
+
+	// create and open the cache root directory
+	_, err := s.openRoot()
 	if err != nil {
-		err = os.MkdirAll(s.RootDir, 0o750)
-		if err != nil {
-			fmt.Printf("cannot make root directory: %v", err)
-			return nil
-		}
+		fmt.Printf("cannot make root directory: %v", err)
+		return nil
+	}
+
+	if s.Recovery != nil {
+		s.recoverStore()
 	}
 	return s
 }
@@ -83,108 +174,339 @@ func Get(s *Store) *writer {
 // The given directory is joined with the RootDir path set when the store was created.
 // If overwrite = true the file will be overwriten if it already exists
 func (w *writer) Write(path string, fileName string, data []byte, overwrite bool) error {
-	w.Store.mtx.Lock()
-	defer w.Store.mtx.Unlock()
+	saveDir, fullPath, h, err := w.Store.entryPath(path, fileName)
+	if err != nil {
+		return err
+	}
+	return w.Store.write(saveDir, fullPath, h, data, overwrite)
+}
+
+// Remove deletes the file passed in at the given path from the store.
+func (w *writer) Remove(path string, fileName string) error {
+	_, fullPath, _, err := w.Store.entryPath(path, fileName)
+	if err != nil {
+		return err
+	}
+	return w.Store.remove(fullPath)
+}
+
+// Read reads the file passed in from the store in the given path,
+// and return it as a byte slice.
+func (w *writer) Read(path string, fileName string) ([]byte, error) {
+	_, fullPath, want, err := w.Store.entryPath(path, fileName)
+	if err != nil {
+		return []byte{}, err
+	}
+	return w.Store.readPath(fullPath, want)
+}
+
+// readPath is an internal method used to read the entry at fullPath written with the header want.
+func (s *Store) readPath(fullPath string, want header) ([]byte, error) {
+	h, data, _, err := s.read(fullPath)
+	if err != nil {
+		return []byte{}, err
+	}
+	// With hidden names make sure the entry is the one that was asked for
+	if want.flags&flagKey != 0 && h.key != want.key {
+		return []byte{}, fmt.Errorf("file not found in store: %s", fullPath)
+	}
+	return data, nil
+}
+
+// entryPath is an internal method used to build the paths relative to RootDir
+// an entry is saved at along with the header it is written with.
+// If names are hidden the path is hashed and the original path is kept in the header.
+func (s *Store) entryPath(elem ...string) (string, string, header, error) {
+	fullPath, err := s.buildPath(elem...)
+	if err != nil {
+		return "", "", header{}, err
+	}
+
+	saveDir, path, h := s.entryIn(nameDir, fullPath)
+	return saveDir, path, h, nil
+}
+
+// entryIn returns the directory and path an entry for fullPath is saved at along with its header.
+// If names are hidden the path is hashed into hiddenDir.
+func (s *Store) entryIn(hiddenDir string, fullPath string) (string, string, header) {
+	if s.hidesNames() {
+		saveDir, hashed := fanOut(hiddenDir, s.nameHash(fullPath))
+		return saveDir, hashed, header{flags: flagKey, key: fullPath}
+	}
+	return filepath.Dir(fullPath), fullPath, header{}
+}
 
+// write is an internal method used to save an entry at fullPath in saveDir.
+// Both paths must be relative to RootDir and built with buildPath.
+// The header is only written if it has any flags set.
+func (s *Store) write(saveDir string, fullPath string, h header, data []byte, overwrite bool) error {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+	stripe := s.stripe(fullPath)
+	stripe.Lock()
+	defer stripe.Unlock()
+
+	return s.withRoot(func(root *os.Root) error {
+		return s.writeIn(root, saveDir, fullPath, h, data, overwrite)
+	})
+}
+
+// writeIn is an internal method used by write to save the entry in root while it is locked.
+func (s *Store) writeIn(root *os.Root, saveDir string, fullPath string, h header, data []byte, overwrite bool) error {
 	// Check if the save directory exists.
 	// If not create it.
-	saveDir := w.Store.buildPath(path)
-	_, err := os.Stat(saveDir)
-	if os.IsNotExist(err) {
-		err = os.MkdirAll(saveDir, 0o750)
-		if err != nil {
-			return err
-		}
+	err := mkdirAll(root, saveDir)
+	if err != nil {
+		return err
 	}
 
-	fullPath := w.Store.buildPath(saveDir, fileName)
+	// Lock the current entry so other processes do not write or remove it meanwhile.
+	// A new entry has nothing to lock, so two processes writing it at once both succeed and the last one is kept.
+	var old header
+	file, err := openLocked(root, fullPath, os.O_RDONLY, true)
+	switch {
+	case err == nil:
+		defer file.Close()
+		if !overwrite {
+			return fmt.Errorf("file already exists in store: %v", fullPath)
+		}
+		// Keep the blob referenced by an overwritten entry so it can be released
+		old, _, _ = readHeader(bufio.NewReader(file))
+	case !os.IsNotExist(err):
+		return err
+	}
 
-	// Check if ok to overwrite an already existing file.
-	if !overwrite {
-		_, err = os.Stat(fullPath)
+	err = s.commit(root, fullPath, old, h, data, time.Time{})
+	if os.IsNotExist(err) {
+		// The directory may have been trimmed by another process after it was created
+		err = mkdirAll(root, saveDir)
 		if err == nil {
-			return fmt.Errorf("file already exists in store: %v", err)
+			err = s.commit(root, fullPath, old, h, data, time.Time{})
 		}
 	}
+	return err
+}
 
-	file, err := os.OpenFile(fullPath, os.O_WRONLY|os.O_CREATE, 0o600) //#nosec G304
+// commit is an internal method used to encode data and save it as the entry at fullPath.
+// old is the header the entry held before so any blob it referenced can be released.
+// If modTime is not zero the entry keeps it as its modification time.
+func (s *Store) commit(root *os.Root, fullPath string, old header, h header, data []byte, modTime time.Time) error {
+	h, data, err := s.encode(root, fullPath, h, data)
 	if err != nil {
 		return err
 	}
 
-	defer file.Close()
-
-	_, err = file.Write(data)
+	h.flags |= flagChecksum
+	err = replaceFile(root, fullPath, h.entry(data), modTime)
 	if err != nil {
+		if h.flags&flagRef != 0 {
+			_ = s.release(root, h.digest)
+		}
 		return err
 	}
+
+	if old.flags&flagRef != 0 {
+		return s.release(root, old.digest)
+	}
 	return nil
 }
 
-// Remove deletes the file passed in at the given path from the store.
-func (w *writer) Remove(path string, fileName string) error {
-	w.Store.mtx.Lock()
-	defer w.Store.mtx.Unlock()
+// tempExt is the extension of the temporary files entries are written to before they replace the entry.
+const tempExt = ".tmp"
+
+// tempFile reports whether path is a temporary file an entry is being written to.
+func tempFile(path string) bool {
+	base := filepath.Base(path)
+	return strings.HasPrefix(base, ".") && strings.HasSuffix(base, tempExt)
+}
 
-	fullPath := w.Store.buildPath(path, fileName)
-	file, err := os.Open(fullPath) //#nosec G304
+// replaceFile atomically replaces the file at path with b.
+// b is written and synced to a temporary file in the same directory which is then renamed over path,
+// so readers in any process see either the old or the new contents.
+// The temporary file is locked so trims and recovery leave it alone while it is written.
+func replaceFile(root *os.Root, path string, b []byte, modTime time.Time) error {
+	var id [8]byte
+	_, _ = rand.Read(id[:])
+	dir, name := filepath.Split(path)
+	tmpName := "." + name + "." + hex.EncodeToString(id[:]) + tempExt
+	tmp := filepath.Join(dir, tmpName)
+
+	f, err := root.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
 	if err != nil {
-		return err
+		return checkEscape(root, tmp, err)
 	}
-	defer file.Close()
+	defer f.Close()
 
-	err = os.Remove(fullPath)
+	err = lockFile(f, true)
+	if err == nil {
+		_, err = f.Write(b)
+	}
+	if err == nil && !modTime.IsZero() {
+		err = setModTime(f, modTime)
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if err == nil {
+		err = renameIn(root, filepath.Clean(dir), tmpName, name)
+	}
 	if err != nil {
-		return err
+		_ = root.Remove(tmp)
+		return checkEscape(root, path, err)
 	}
 	return nil
 }
 
-// Read reads the file passed in from the store in the given path,
-// and return it as a byte slice.
-func (w *writer) Read(path string, fileName string) ([]byte, error) {
-	w.Store.mtx.Lock()
-	defer w.Store.mtx.Unlock()
+// encode is an internal method used to prepare data to be saved in the entry at fullPath.
+// Depending on the store's options the data is compressed, encrypted, and saved as a blob.
+// The returned header describes how the data was stored.
+func (s *Store) encode(root *os.Root, fullPath string, h header, data []byte) (header, []byte, error) {
+	data, codec, err := s.Compression.Compress(data)
+	if err != nil {
+		return h, nil, err
+	}
+	if codec != "" {
+		if len(codec) > math.MaxUint8 {
+			return h, nil, fmt.Errorf("compressor name is too long: %s", codec)
+		}
+		h.flags |= flagCompressed
+		h.codec = codec
+	}
 
-	fullPath := w.Store.buildPath(path, fileName)
-	file, err := os.Open(fullPath) //#nosec G304
+	data, err = s.encrypt(&h, data, fullPath)
 	if err != nil {
-		return []byte{}, err
+		return h, nil, err
 	}
-	defer file.Close()
 
-	stat, err := file.Stat()
+	// The digest is of the data as it is stored so entries saved
+	// with different compression or keys do not share a blob
+	if s.Dedup {
+		h.flags |= flagRef
+		h.digest = sha256.Sum256(data)
+		err = s.retain(root, h.digest, data)
+		if err != nil {
+			return h, nil, err
+		}
+		data = nil
+	}
+	return h, data, nil
+}
+
+// decode is an internal method used to return the original data of the entry at fullPath from its stored payload.
+// The returned header has the entry's key decrypted if it was encrypted.
+func (s *Store) decode(root *os.Root, fullPath string, h header, data []byte) (header, []byte, error) {
+	var err error
+
+	// Load the payload from the blob the entry references
+	if h.flags&flagRef != 0 {
+		data, err = s.readBlob(root, h.digest)
+		if err != nil {
+			return h, nil, err
+		}
+	}
+
+	data, err = s.decrypt(&h, data, fullPath)
 	if err != nil {
-		return []byte{}, err
+		return h, nil, err
 	}
 
-	b := make([]byte, stat.Size())
-	_, err = bufio.NewReader(file).Read(b)
-	if err != nil && err != io.EOF {
-		return []byte{}, nil
+	if h.flags&flagCompressed != 0 {
+		data, err = s.Compression.Decompress(h.codec, data)
+		if err != nil {
+			return h, nil, err
+		}
 	}
+	return h, data, nil
+}
+
+// remove is an internal method used to delete the entry at fullPath.
+// The path must be relative to RootDir and built with buildPath.
+func (s *Store) remove(fullPath string) error {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+	stripe := s.stripe(fullPath)
+	stripe.Lock()
+	defer stripe.Unlock()
+
+	return s.withRoot(func(root *os.Root) error {
+		// Wait for other processes reading or writing the file
+		file, err := openLocked(root, fullPath, os.O_RDONLY, true)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
 
-	return b, nil
+		h, _, _ := readHeader(bufio.NewReader(file))
+
+		err = root.Remove(fullPath)
+		if err != nil {
+			return checkEscape(root, fullPath, err)
+		}
+
+		// Release the blob the entry referenced
+		if h.flags&flagRef != 0 {
+			return s.release(root, h.digest)
+		}
+		return nil
+	})
 }
 
-// buildPath is an internal method used for building a complete cleaned file path
-// It will join the RootDir if it is not already present.
-func (s *Store) buildPath(elem ...string) string {
-	// Return empty string if there a no elements
-	if len(elem) == 0 {
-		return ""
+// read is an internal method used to read the entry at fullPath.
+// The path must be relative to RootDir and built with buildPath.
+// It returns the entry's header and payload along with the file info of the entry.
+// Corrupt entries are removed and a CorruptError is returned.
+func (s *Store) read(fullPath string) (header, []byte, os.FileInfo, error) {
+	h, data, stat, err := s.readEntry(fullPath)
+	if errors.Is(err, ErrCorrupt) {
+		s.quarantine(fullPath)
 	}
+	return h, data, stat, err
+}
+
+// readEntry is an internal method used by read to read and decode an entry while it is locked.
+func (s *Store) readEntry(fullPath string) (header, []byte, os.FileInfo, error) {
+	// Reads only share the locks so they can run alongside each other
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+	stripe := s.stripe(fullPath)
+	stripe.RLock()
+	defer stripe.RUnlock()
+
+	var (
+		h    header
+		data []byte
+		stat os.FileInfo
+	)
+	err := s.withRoot(func(root *os.Root) error {
+		// Share the lock with other readers so the file is not written or removed mid-read
+		file, err := openLocked(root, fullPath, os.O_RDONLY, false)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
 
-	// Join all elements then join with RootDir
-	path := filepath.Join(elem...)
+		stat, err = file.Stat()
+		if err != nil {
+			return err
+		}
 
-	// If the path doesn't include the RootDir add it
-	if !strings.HasPrefix(path, s.RootDir) {
-		fullPath := filepath.Join(s.RootDir, path)
-		return fullPath
-	}
+		b, err := io.ReadAll(file)
+		if err != nil {
+			return err
+		}
 
-	return path
+		h, data, err = splitEntry(b)
+		if err != nil {
+			return &CorruptError{Path: fullPath, Err: err}
+		}
+
+		h, data, err = s.decode(root, fullPath, h, data)
+		return err
+	})
+	if err != nil {
+		return header{}, nil, nil, err
+	}
+	return h, data, stat, nil
 }
 
 // Purge will clear the entire cache and remove the RootDir.
@@ -196,7 +518,24 @@ func (s *Store) Purge() error {
 	s.mtx.Lock()
 	defer s.mtx.Unlock()
 
-	err := os.RemoveAll(s.RootDir)
+	// Wait for any other process trimming the store
+	var lock *os.File
+	err := s.withRoot(func(root *os.Root) error {
+		var err error
+		lock, _, err = lockStore(root, true)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+
+	err = s.closeRoot()
+	if err != nil {
+		return err
+	}
+
+	err = os.RemoveAll(s.RootDir)
 	if err != nil {
 		return err
 	}
@@ -208,57 +547,73 @@ func (s *Store) Purge() error {
 // Trim is used for trimming files older then the MaxAge.
 // It is called by the caches trim worker.
 // This can be called directly if needed.
+// No store wide lock is held while trimming, each entry is only
+// locked while it is checked so reads and writes are not stalled.
 func (s *Store) Trim() {
 	log.Println("Starting file store trimming...")
-	s.mtx.Lock()
-	defer s.mtx.Unlock()
 
-	err := filepath.Walk(s.RootDir, s.walk)
+	root, err := s.freshRoot()
+	if err != nil {
+		log.Printf("unable to open root directory: %v", err)
+		return
+	}
+
+	// Only one process sharing the RootDir needs to trim at a time
+	lock, ok, err := lockStore(root, false)
+	if err != nil {
+		log.Printf("unable to lock store: %v", err)
+		return
+	}
+	if !ok {
+		log.Println("File store is being trimmed by another process")
+		return
+	}
+	defer lock.Close()
+
+	pass := &trimPass{s: s, start: time.Now()}
+	if s.TrimBudget != nil {
+		pass.resume = readCursor(root)
+	}
+	err = fs.WalkDir(root.FS(), ".", pass.walk)
 	if err != nil {
 		log.Printf("unable to read path: %v", err)
 	}
+	if s.TrimBudget != nil && err == nil {
+		pass.saveCursor(root)
+	}
+
+	// Evict entries that have not expired yet if the filesystem is running out of space
+	s.evict(root)
 
 	log.Println("File store trimming complete")
 }
 
-// walk is an internal method used for filepath.WalkFunc
+// walk is an internal method used for fs.WalkDirFunc
 // to check a files MaxAge and remove it if to old.
 // It will also check for empty directories and remove them.
-func (s *Store) walk(path string, info os.FileInfo, err error) error {
+// Paths are relative to RootDir and are removed through the stores os.Root.
+func (s *Store) walk(path string, d fs.DirEntry, err error) error {
 	if err != nil {
 		return err
 	}
 
-	cleanPath := filepath.Clean(path)
-	f, err := os.Open(cleanPath)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-
-	switch info.IsDir() {
+	switch d.IsDir() {
 	// If the path is not a directory check if it has reached the MaxAge.
 	// If so delete the file.
 	case false:
-		stat, _ := os.Stat(cleanPath)
-		age := stat.ModTime().Add(time.Second * time.Duration(s.MaxAge))
-		if time.Now().Local().After(age) {
-			err := os.RemoveAll(cleanPath)
-			if err != nil {
-				return err
-			}
+		if metaFile(path) {
+			return nil
 		}
+		return s.trimFile(path, false)
 	// If the path is a directory check if it is empty.
 	// If so remove the empty directory.
 	case true:
-		if path != s.RootDir {
-			_, err := f.Readdirnames(1)
-			if err == io.EOF {
-				err = os.Remove(cleanPath)
-				if err != nil {
-					return err
-				}
-			}
+		// Blobs are removed once they are no longer referenced rather than by age
+		if path == blobDir {
+			return fs.SkipDir
+		}
+		if path != "." {
+			return s.trimDir(path)
 		}
 	default:
 		return errors.New("unknown file type")
@@ -266,3 +621,138 @@ func (s *Store) walk(path string, info os.FileInfo, err error) error {
 
 	return nil
 }
+
+// trimFile removes the file at path if it is older than MaxAge or evict is true.
+// If Scrub is enabled files that are kept are verified and removed if corrupt.
+// Files locked by another reader or writer are skipped and will be
+// checked again on the next trim.
+func (s *Store) trimFile(path string, evict bool) error {
+	_, _, err := s.checkFile(path, checkMode{evict: evict, scrub: s.Scrub})
+	return err
+}
+
+// checkFile removes the file at path if it has expired, or if it is corrupt when checked by mode.
+// It returns what was done with the file and the size of a kept file.
+func (s *Store) checkFile(path string, mode checkMode) (fileCheck, int64, error) {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+	stripe := s.stripe(path)
+	stripe.Lock()
+	defer stripe.Unlock()
+
+	root, err := s.openRoot()
+	if err != nil {
+		return fileSkipped, 0, err
+	}
+
+	info, err := root.Lstat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fileSkipped, 0, nil
+		}
+		return fileSkipped, 0, err
+	}
+
+	// Symlinks are not opened as they may point out of the store
+	if info.Mode()&fs.ModeSymlink != 0 {
+		if mode.evict || s.expired(info) {
+			return fileExpired, 0, root.Remove(path)
+		}
+		return fileKept, 0, nil
+	}
+
+	f, err := root.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fileSkipped, 0, nil
+		}
+		return fileSkipped, 0, err
+	}
+	defer f.Close()
+
+	ok, err := tryLockFile(f)
+	if err != nil || !ok {
+		return fileSkipped, 0, err
+	}
+
+	// Check the locked file is still the one at path and has not been rewritten
+	stat, err := f.Stat()
+	if err != nil {
+		return fileSkipped, 0, err
+	}
+	current, err := root.Lstat(path)
+	if err != nil || !os.SameFile(stat, current) {
+		return fileSkipped, 0, nil
+	}
+
+	if mode.evict || s.expired(stat) {
+		h, _, _ := readHeader(bufio.NewReader(f))
+
+		err := root.Remove(path)
+		if err != nil {
+			return fileSkipped, 0, err
+		}
+
+		// Release the blob the entry referenced
+		if h.flags&flagRef != 0 && !quarantined(path) {
+			return fileExpired, 0, s.release(root, h.digest)
+		}
+		return fileExpired, 0, nil
+	}
+
+	// Every entry is written with a header, so an empty file was cut off mid-write
+	if mode.partial && stat.Size() == 0 {
+		return filePartial, 0, root.Remove(path)
+	}
+
+	// Quarantined entries are already known to be corrupt
+	if mode.scrub && !quarantined(path) {
+		raw, err := io.ReadAll(f)
+		if err != nil {
+			return fileSkipped, 0, err
+		}
+		err = s.verify(root, path, raw)
+		if errors.Is(err, ErrCorrupt) {
+			s.quarantineLocked(root, path, raw, err)
+			return fileCorrupt, 0, nil
+		}
+	}
+	return fileKept, stat.Size(), nil
+}
+
+// trimDir removes the directory at path if it is empty.
+// A write racing with the removal recreates the directory.
+func (s *Store) trimDir(path string) error {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	root, err := s.openRoot()
+	if err != nil {
+		return err
+	}
+
+	f, err := root.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fs.SkipDir
+		}
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.Readdirnames(1)
+	if err == io.EOF {
+		err = root.Remove(path)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return fs.SkipDir
+	}
+	return nil
+}
+
+// expired reports whether a file has reached the MaxAge of the store.
+func (s *Store) expired(info os.FileInfo) bool {
+	age := info.ModTime().Add(time.Second * time.Duration(s.MaxAge))
+	return time.Now().Local().After(age)
+}
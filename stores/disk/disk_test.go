@@ -1,7 +1,12 @@
 package disk_test
 
 import (
+	"compress/flate"
 	"encoding/json"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -80,3 +85,94 @@ func TestDiskStore(t *testing.T) {
 
 	cache.StopCacheInstance(c.CacheNum)
 }
+
+// Test that overwritten entries are replaced whole and leave no temporary files
+func TestDiskOverwriteAtomic(t *testing.T) {
+	a := assert.New(t)
+
+	dir := t.TempDir()
+	d := disk.Get(disk.New(&disk.Store{RootDir: dir, MaxAge: 20}))
+	other := disk.Get(disk.New(&disk.Store{RootDir: dir, MaxAge: 20}))
+
+	values := [][]byte{[]byte("short"), []byte(strings.Repeat("long value ", 100))}
+	a.NoError(d.Write(path, file, values[0], false))
+
+	done := make(chan bool)
+	go func() {
+		defer close(done)
+		for i := 0; i < 2000; i++ {
+			if err := d.Write(path, file, values[i%2], true); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+
+	// Neither a store nor a reader ignoring the locks sees a partly written entry
+	for {
+		select {
+		case <-done:
+			entries, err := os.ReadDir(filepath.Join(dir, path))
+			a.NoError(err)
+			a.Len(entries, 1)
+			return
+		default:
+		}
+
+		b, err := other.Read(path, file)
+		a.NoError(err)
+		a.Contains(values, b)
+
+		raw, err := os.ReadFile(filepath.Join(dir, path, file))
+		a.NoError(err)
+		a.NotEmpty(raw)
+	}
+}
+
+// Test compressed entries in the on disk store
+func TestDiskCompression(t *testing.T) {
+	a := assert.New(t)
+
+	diskStore := disk.New(&disk.Store{
+		RootDir: t.TempDir(),
+		MaxAge:  20,
+	})
+	a.NotNil(diskStore)
+	d := disk.Get(diskStore)
+
+	// Entries saved before compression was enabled
+	small := []byte("small value")
+	large := []byte(strings.Repeat(`{"FirstName":"John","LastName":"Doe"}`, 100))
+	a.NoError(d.Write(path, "before.json", large, false))
+
+	diskStore.Compression = &cache.Compression{
+		Compressor: cache.Flate(flate.BestSpeed),
+		Threshold:  64,
+	}
+	a.NoError(d.Write(path, "small.json", small, false))
+	a.NoError(d.Write(path, "large.json", large, false))
+	a.NoError(disk.GetKeyed(diskStore).Write("large", large, false))
+
+	// Only the large entry is compressed on disk
+	before, err := os.Stat(filepath.Join(diskStore.RootDir, path, "before.json"))
+	a.NoError(err)
+	compressed, err := os.Stat(filepath.Join(diskStore.RootDir, path, "large.json"))
+	a.NoError(err)
+	a.Less(compressed.Size(), before.Size()/5)
+
+	for name, want := range map[string][]byte{"before.json": large, "small.json": small, "large.json": large} {
+		b, err := d.Read(path, name)
+		a.NoError(err)
+		a.Equal(want, b)
+	}
+	b, err := disk.GetKeyed(diskStore).Read("large")
+	a.NoError(err)
+	a.Equal(large, b)
+
+	// The FS view returns the decompressed entry
+	b, err = fs.ReadFile(diskStore.FS(), path+"/large.json")
+	a.NoError(err)
+	a.Equal(large, b)
+
+	a.NoError(diskStore.Purge())
+}
@@ -0,0 +1,255 @@
+package disk
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+)
+
+// Entries written by the store begin with a small header used to carry
+// metadata along with the payload. Files without the header are plain data
+// and are returned as-is, so files written before the header was added can
+// still be read. A file holding only the start of the magic, or nothing at all,
+// is an entry cut off mid-write and is treated as corrupt.
+//
+// The header layout is:
+//
+//	magic   [4]byte
+//	version uint8
+//	flags   uint8
+//	key     uint16 length + bytes (flagKey)
+//	digest  [32]byte SHA-256 of the payload stored as a blob (flagRef)
+//	codec   uint8 length + compressor name (flagCompressed)
+//	keyID   uint8 length + encryption key ID, followed by the [12]byte nonce (flagEncrypted)
+//	sealed  uint16 length + encrypted key or path name of the entry (flagSealedName)
+//	crc     uint32 CRC-32C of the header before it and the payload as stored after it (flagChecksum)
+//
+// Version 1 entries are still read. Their checksum only covers the payload.
+const entryVersion = 2
+
+// Header flags describing which optional fields follow the fixed part of the header.
+const (
+	// flagKey marks that the original key of a key-addressed entry is stored in the header.
+	flagKey byte = 1 << iota
+
+	// flagRef marks that the payload is stored once as a content-addressed blob
+	// and the entry only holds the blob's digest.
+	flagRef
+
+	// flagCompressed marks that the payload is compressed with the named compressor.
+	flagCompressed
+
+	// flagEncrypted marks that the payload is encrypted with the identified key.
+	flagEncrypted
+
+	// flagSealedName marks that the key or path name of the entry is stored encrypted.
+	// It is used instead of flagKey when file names are hidden.
+	flagSealedName
+
+	// flagChecksum marks that a checksum of the header and payload is stored to detect corruption.
+	flagChecksum
+)
+
+// entryMagic identifies files carrying an entry header.
+// The leading high byte keeps it from being confused with text payloads.
+var entryMagic = []byte{0x89, 'B', 'S', 'C'}
+
+var (
+	// errBadHeader is returned when a file starts with entryMagic
+	// but the rest of the header cannot be decoded.
+	errBadHeader = errors.New("malformed entry header")
+
+	// errChecksum is returned when the payload of an entry does not match its checksum.
+	errChecksum = errors.New("entry checksum mismatch")
+
+	// errTruncated is returned when a file was cut off before the end of its header.
+	errTruncated = errors.New("entry is truncated")
+
+	// castagnoli is the CRC-32C table used for entry checksums
+	castagnoli = crc32.MakeTable(crc32.Castagnoli)
+)
+
+// header is the decoded metadata stored in front of an entry's payload.
+type header struct {
+	version byte
+	flags   byte
+	key     string
+	digest  [sha256.Size]byte
+	codec   string
+	keyID   string
+	nonce   [nonceSize]byte
+	sealed  []byte
+	crc     uint32
+}
+
+// marshal encodes the header so it can be written in front of the payload.
+// The lengths of variable sized fields are checked before the header is built.
+func (h *header) marshal() []byte {
+	b := make([]byte, 0, 64+len(h.key)+len(h.sealed))
+	b = append(b, entryMagic...)
+	b = append(b, entryVersion, h.flags)
+	if h.flags&flagKey != 0 {
+		b = binary.BigEndian.AppendUint16(b, uint16(len(h.key))) //#nosec G115 -- key length is checked on write
+		b = append(b, h.key...)
+	}
+	if h.flags&flagRef != 0 {
+		b = append(b, h.digest[:]...)
+	}
+	if h.flags&flagCompressed != 0 {
+		b = append(b, byte(len(h.codec))) //#nosec G115 -- codec length is checked on write
+		b = append(b, h.codec...)
+	}
+	if h.flags&flagEncrypted != 0 {
+		b = append(b, byte(len(h.keyID))) //#nosec G115 -- key ID length is checked on write
+		b = append(b, h.keyID...)
+		b = append(b, h.nonce[:]...)
+	}
+	if h.flags&flagSealedName != 0 {
+		b = binary.BigEndian.AppendUint16(b, uint16(len(h.sealed))) //#nosec G115 -- key length is checked on write
+		b = append(b, h.sealed...)
+	}
+	if h.flags&flagChecksum != 0 {
+		b = binary.BigEndian.AppendUint32(b, h.crc)
+	}
+	return b
+}
+
+// entry returns the header followed by data as it is saved in a file.
+// With flagChecksum the checksum is computed over the header and data.
+func (h *header) entry(data []byte) []byte {
+	b := h.marshal()
+	if h.flags&flagChecksum != 0 {
+		n := len(b) - 4
+		h.crc = crc32.Update(crc32.Checksum(b[:n], castagnoli), castagnoli, data)
+		binary.BigEndian.PutUint32(b[n:], h.crc)
+	}
+	return append(b, data...)
+}
+
+// hasHeader reports whether b starts with an entry header.
+func hasHeader(b []byte) bool {
+	return bytes.HasPrefix(b, entryMagic)
+}
+
+// readHeader decodes an entry header from r.
+// ok is false if r does not start with entryMagic, in which case nothing is consumed.
+func readHeader(r *bufio.Reader) (h header, ok bool, err error) {
+	magic, err := r.Peek(len(entryMagic))
+	if err != nil || !bytes.Equal(magic, entryMagic) {
+		return h, false, nil
+	}
+	_, _ = r.Discard(len(entryMagic))
+
+	var fixed [2]byte
+	if _, err := io.ReadFull(r, fixed[:]); err != nil {
+		return h, true, errBadHeader
+	}
+	if fixed[0] != 1 && fixed[0] != entryVersion {
+		return h, true, errBadHeader
+	}
+	h.version, h.flags = fixed[0], fixed[1]
+
+	if h.flags&flagKey != 0 {
+		key, err := readField(r, 2)
+		if err != nil {
+			return h, true, err
+		}
+		h.key = string(key)
+	}
+
+	if h.flags&flagRef != 0 {
+		if _, err := io.ReadFull(r, h.digest[:]); err != nil {
+			return h, true, errBadHeader
+		}
+	}
+
+	if h.flags&flagCompressed != 0 {
+		codec, err := readField(r, 1)
+		if err != nil {
+			return h, true, err
+		}
+		h.codec = string(codec)
+	}
+
+	if h.flags&flagEncrypted != 0 {
+		keyID, err := readField(r, 1)
+		if err != nil {
+			return h, true, err
+		}
+		h.keyID = string(keyID)
+		if _, err := io.ReadFull(r, h.nonce[:]); err != nil {
+			return h, true, errBadHeader
+		}
+	}
+
+	if h.flags&flagSealedName != 0 {
+		h.sealed, err = readField(r, 2)
+		if err != nil {
+			return h, true, err
+		}
+	}
+
+	if h.flags&flagChecksum != 0 {
+		var crc [4]byte
+		if _, err := io.ReadFull(r, crc[:]); err != nil {
+			return h, true, errBadHeader
+		}
+		h.crc = binary.BigEndian.Uint32(crc[:])
+	}
+
+	return h, true, nil
+}
+
+// readField reads a variable sized header field prefixed with its length
+// stored in size bytes.
+func readField(r *bufio.Reader, size int) ([]byte, error) {
+	var n [2]byte
+	if _, err := io.ReadFull(r, n[2-size:]); err != nil {
+		return nil, errBadHeader
+	}
+	field := make([]byte, binary.BigEndian.Uint16(n[:]))
+	if _, err := io.ReadFull(r, field); err != nil {
+		return nil, errBadHeader
+	}
+	return field, nil
+}
+
+// splitEntry separates the header from the payload of a stored file.
+// Files without a header are returned unchanged with a zero header,
+// unless they are too short to tell apart from an entry cut off within its magic.
+// If the header holds a checksum the entry is verified against it.
+func splitEntry(b []byte) (header, []byte, error) {
+	if !hasHeader(b) {
+		if len(b) < len(entryMagic) && bytes.HasPrefix(entryMagic, b) {
+			return header{}, nil, errTruncated
+		}
+		return header{}, b, nil
+	}
+	r := bufio.NewReader(bytes.NewReader(b))
+	h, _, err := readHeader(r)
+	if err != nil {
+		return h, nil, err
+	}
+	payload, err := io.ReadAll(r)
+	if err != nil {
+		return h, nil, err
+	}
+	if h.flags&flagChecksum == 0 {
+		return h, payload, nil
+	}
+
+	crc := crc32.Checksum(payload, castagnoli)
+	if h.version != 1 {
+		// The checksum covers the header up to the checksum itself
+		head := b[:len(b)-len(payload)-4]
+		crc = crc32.Update(crc32.Checksum(head, castagnoli), castagnoli, payload)
+	}
+	if crc != h.crc {
+		return h, nil, errChecksum
+	}
+	return h, payload, nil
+}
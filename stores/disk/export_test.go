@@ -0,0 +1,12 @@
+package disk
+
+// SetFreeSpace replaces how the free space of RootDir is measured
+// and returns a function restoring the original.
+func SetFreeSpace(f func(dir string) (uint64, error)) (restore func()) {
+	original := statFreeSpace
+	statFreeSpace = f
+	return func() { statFreeSpace = original }
+}
+
+// FreeSpace exposes freeSpace for testing.
+var FreeSpace = freeSpace
@@ -0,0 +1,22 @@
+//go:build linux
+
+package disk_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tmstorm/cache/stores/disk"
+)
+
+// Test free space is read from the filesystem
+func TestFreeSpace(t *testing.T) {
+	a := assert.New(t)
+
+	free, err := disk.FreeSpace(t.TempDir())
+	a.NoError(err)
+	a.NotZero(free)
+
+	_, err = disk.FreeSpace("/does/not/exist")
+	a.Error(err)
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package disk
+
+import "errors"
+
+// Free space is only measured on Linux.
+// On other platforms FreeSpace watermarks are ignored.
+
+// freeSpace always returns errors.ErrUnsupported on this platform.
+func freeSpace(dir string) (uint64, error) {
+	return 0, errors.ErrUnsupported
+}
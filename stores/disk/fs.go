@@ -0,0 +1,259 @@
+package disk
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"time"
+)
+
+type (
+	// storeFS is a read only io/fs view of the store rooted at RootDir.
+	storeFS struct {
+		s *Store
+	}
+
+	// fileInfo describes an entry in the store.
+	// The size is the size of the entry's payload rather than the file on disk.
+	fileInfo struct {
+		name    string
+		size    int64
+		mode    fs.FileMode
+		modTime time.Time
+	}
+
+	// file is an open entry.
+	// The entry is read when opened so the file holds no locks on the store.
+	file struct {
+		*bytes.Reader
+		info *fileInfo
+	}
+
+	// dir is an open directory
+	dir struct {
+		info    *fileInfo
+		entries []fs.DirEntry
+		offset  int
+	}
+
+	// dirEntry is an entry returned when reading a directory
+	dirEntry struct {
+		fsys *storeFS
+		path string
+		name string
+		dir  bool
+	}
+)
+
+// FS returns a read only view of the store rooted at RootDir.
+// The view implements fs.StatFS, fs.ReadDirFS, and fs.ReadFileFS
+// and can be used with fs.WalkDir, template.ParseFS, and http.FS.
+// Entries older than MaxAge are treated as if they do not exist.
+func (s *Store) FS() fs.FS {
+	return &storeFS{s: s}
+}
+
+// HTTPFileSystem returns the FS view of the store as an http.FileSystem
+// for serving cached files with http.FileServer.
+func (s *Store) HTTPFileSystem() http.FileSystem {
+	return http.FS(s.FS())
+}
+
+// Open opens the named file or directory.
+func (f *storeFS) Open(name string) (fs.File, error) {
+	info, data, err := f.load(name, "open")
+	if err != nil {
+		return nil, err
+	}
+
+	if info.IsDir() {
+		entries, err := f.ReadDir(name)
+		if err != nil {
+			return nil, err
+		}
+		return &dir{info: info, entries: entries}, nil
+	}
+	return &file{Reader: bytes.NewReader(data), info: info}, nil
+}
+
+// Stat returns a FileInfo describing the named file or directory.
+func (f *storeFS) Stat(name string) (fs.FileInfo, error) {
+	info, _, err := f.load(name, "stat")
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// ReadFile reads the named file and returns its contents.
+func (f *storeFS) ReadFile(name string) ([]byte, error) {
+	info, data, err := f.load(name, "read")
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: errors.New("is a directory")}
+	}
+	return data, nil
+}
+
+// ReadDir reads the named directory and returns its entries sorted by file name.
+// Expired entries and files used internally by the store are left out.
+func (f *storeFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if !fs.ValidPath(name) || f.hidden(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+
+	f.s.mtx.RLock()
+	defer f.s.mtx.RUnlock()
+
+	root, err := f.s.freshRoot()
+	if err != nil {
+		return nil, err
+	}
+
+	osEntries, err := fs.ReadDir(root.FS(), name)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]fs.DirEntry, 0, len(osEntries))
+	for _, e := range osEntries {
+		p := path.Join(name, e.Name())
+		if f.hidden(p) {
+			continue
+		}
+		if !e.IsDir() {
+			if !e.Type().IsRegular() {
+				continue
+			}
+			info, err := e.Info()
+			if err != nil || f.s.expired(info) {
+				continue
+			}
+		}
+		entries = append(entries, &dirEntry{fsys: f, path: p, name: e.Name(), dir: e.IsDir()})
+	}
+	return entries, nil
+}
+
+// load reads the named entry returning its info and payload.
+// Directories are returned with a nil payload.
+func (f *storeFS) load(name string, op string) (*fileInfo, []byte, error) {
+	if !fs.ValidPath(name) {
+		return nil, nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	if f.hidden(name) {
+		return nil, nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+
+	stat, err := f.s.stat(name)
+	if err != nil {
+		return nil, nil, &fs.PathError{Op: op, Path: name, Err: unwrapPathError(err)}
+	}
+	if stat.IsDir() {
+		return &fileInfo{name: path.Base(name), mode: stat.Mode(), modTime: stat.ModTime()}, nil, nil
+	}
+	if !stat.Mode().IsRegular() || f.s.expired(stat) {
+		return nil, nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+
+	_, data, stat, err := f.s.read(name)
+	if err != nil {
+		return nil, nil, &fs.PathError{Op: op, Path: name, Err: unwrapPathError(err)}
+	}
+	// The entry may have expired or been rewritten while waiting for the lock
+	if f.s.expired(stat) {
+		return nil, nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+
+	info := &fileInfo{
+		name:    path.Base(name),
+		size:    int64(len(data)),
+		mode:    stat.Mode(),
+		modTime: stat.ModTime(),
+	}
+	return info, data, nil
+}
+
+// hidden reports whether name should not be visible through the FS.
+// Files used by the store for its own bookkeeping are hidden.
+func (f *storeFS) hidden(name string) bool {
+	return internal(name)
+}
+
+// stat is an internal method used to stat the file or directory at path relative to RootDir.
+// Symlinks are not followed.
+func (s *Store) stat(path string) (os.FileInfo, error) {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	var stat os.FileInfo
+	err := s.withRoot(func(root *os.Root) error {
+		var err error
+		stat, err = root.Lstat(path)
+		return checkEscape(root, path, err)
+	})
+	return stat, err
+}
+
+// unwrapPathError returns the error wrapped by an os.PathError so it
+// can be rewrapped with the name used in the FS.
+func unwrapPathError(err error) error {
+	var pathErr *os.PathError
+	if errors.As(err, &pathErr) {
+		return pathErr.Err
+	}
+	return err
+}
+
+func (i *fileInfo) Name() string       { return i.name }
+func (i *fileInfo) Size() int64        { return i.size }
+func (i *fileInfo) Mode() fs.FileMode  { return i.mode }
+func (i *fileInfo) ModTime() time.Time { return i.modTime }
+func (i *fileInfo) IsDir() bool        { return i.mode.IsDir() }
+func (i *fileInfo) Sys() any           { return nil }
+
+func (f *file) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *file) Close() error               { return nil }
+
+func (d *dir) Stat() (fs.FileInfo, error) { return d.info, nil }
+func (d *dir) Close() error               { return nil }
+
+func (d *dir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.name, Err: errors.New("is a directory")}
+}
+
+// ReadDir returns the next n entries of the directory.
+// If n <= 0 all remaining entries are returned.
+func (d *dir) ReadDir(n int) ([]fs.DirEntry, error) {
+	remaining := d.entries[d.offset:]
+	if n <= 0 {
+		d.offset = len(d.entries)
+		return remaining, nil
+	}
+	if len(remaining) == 0 {
+		return nil, io.EOF
+	}
+	n = min(n, len(remaining))
+	d.offset += n
+	return remaining[:n], nil
+}
+
+func (e *dirEntry) Name() string { return e.name }
+func (e *dirEntry) IsDir() bool  { return e.dir }
+
+func (e *dirEntry) Type() fs.FileMode {
+	if e.dir {
+		return fs.ModeDir
+	}
+	return 0
+}
+
+func (e *dirEntry) Info() (fs.FileInfo, error) {
+	return e.fsys.Stat(e.path)
+}
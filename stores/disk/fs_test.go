@@ -0,0 +1,101 @@
+package disk_test
+
+import (
+	"html/template"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tmstorm/cache/stores/disk"
+)
+
+// Test the io/fs view of the disk store
+func TestFS(t *testing.T) {
+	a := assert.New(t)
+
+	diskStore := disk.New(&disk.Store{
+		RootDir: t.TempDir(),
+		MaxAge:  20,
+	})
+	a.NotNil(diskStore)
+	d := disk.Get(diskStore)
+
+	a.NoError(d.Write("assets/css", "site.css", []byte("body { color: red; }"), false))
+	a.NoError(d.Write("assets", "app.js", []byte("console.log('hi')"), false))
+	a.NoError(d.Write("templates", "hello.tmpl", []byte("Hello {{.}}!"), false))
+	a.NoError(disk.GetKeyed(diskStore).Write("some key", []byte("keyed"), false))
+
+	// Make sure the store lock file exists so we can check it is hidden
+	diskStore.Trim()
+
+	// Expire one of the entries
+	a.NoError(d.Write("assets", "old.js", []byte("expired"), false))
+	old := time.Now().Add(-time.Hour)
+	a.NoError(os.Chtimes(filepath.Join(diskStore.RootDir, "assets", "old.js"), old, old))
+
+	fsys := diskStore.FS()
+	a.NoError(fstest.TestFS(fsys, "assets/css/site.css", "assets/app.js", "templates/hello.tmpl"))
+
+	// Expired entries and the lock file are absent
+	_, err := fs.Stat(fsys, "assets/old.js")
+	a.ErrorIs(err, fs.ErrNotExist)
+	_, err = fs.ReadFile(fsys, ".lock")
+	a.ErrorIs(err, fs.ErrNotExist)
+
+	// Paths outside the store are invalid
+	_, err = fsys.Open("../secret")
+	a.ErrorIs(err, fs.ErrInvalid)
+
+	// Keyed entries are read without their header
+	var keyed []byte
+	a.NoError(fs.WalkDir(fsys, ".keys", func(path string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			keyed, err = fs.ReadFile(fsys, path)
+		}
+		return err
+	}))
+	a.Equal("keyed", string(keyed))
+
+	// Walk the store
+	var files []string
+	a.NoError(fs.WalkDir(fsys, "assets", func(path string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			files = append(files, path)
+		}
+		return err
+	}))
+	a.Equal([]string{"assets/app.js", "assets/css/site.css"}, files)
+
+	// Parse templates from the store
+	tmpl, err := template.ParseFS(fsys, "templates/*.tmpl")
+	a.NoError(err)
+	var sb strings.Builder
+	a.NoError(tmpl.ExecuteTemplate(&sb, "hello.tmpl", "World"))
+	a.Equal("Hello World!", sb.String())
+
+	// Serve files from the store
+	srv := httptest.NewServer(http.FileServer(diskStore.HTTPFileSystem()))
+	defer srv.Close()
+
+	res, err := http.Get(srv.URL + "/assets/css/site.css")
+	a.NoError(err)
+	body, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	a.Equal(http.StatusOK, res.StatusCode)
+	a.Equal("body { color: red; }", string(body))
+
+	res, err = http.Get(srv.URL + "/assets/old.js")
+	a.NoError(err)
+	res.Body.Close()
+	a.Equal(http.StatusNotFound, res.StatusCode)
+
+	a.NoError(diskStore.Purge())
+}
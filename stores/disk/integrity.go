@@ -0,0 +1,179 @@
+package disk
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// quarantineDir is the directory under RootDir corrupt entries are moved to
+// when Quarantine is enabled. Quarantined entries are trimmed by MaxAge.
+const quarantineDir = ".quarantine"
+
+// quarantined reports whether path relative to RootDir is a quarantined copy of an entry.
+// Quarantined copies keep the header of the entry but the blob it referenced has already been released.
+func quarantined(path string) bool {
+	return strings.HasPrefix(path, quarantineDir+string(filepath.Separator))
+}
+
+// ErrCorrupt is matched by errors.Is when an entry fails its integrity check.
+var ErrCorrupt = errors.New("entry is corrupt")
+
+// CorruptError is returned when an entry or the blob it references has been
+// truncated or otherwise altered on disk. Corrupt entries are removed from
+// the store, or moved to the quarantine directory if Quarantine is enabled.
+type CorruptError struct {
+	// Path is the path of the corrupt file relative to RootDir
+	Path string
+
+	// Err describes why the entry is corrupt
+	Err error
+}
+
+func (e *CorruptError) Error() string {
+	return fmt.Sprintf("cache: %s: %v: %v", e.Path, ErrCorrupt, e.Err)
+}
+
+// Is allows errors.Is(err, ErrCorrupt) to match a CorruptError.
+func (e *CorruptError) Is(target error) bool {
+	return target == ErrCorrupt
+}
+
+func (e *CorruptError) Unwrap() error {
+	return e.Err
+}
+
+// checkBlob verifies the contents of a blob match its digest.
+func checkBlob(path string, digest [sha256.Size]byte, data []byte) error {
+	if sha256.Sum256(data) != digest {
+		return &CorruptError{Path: path, Err: errors.New("blob digest mismatch")}
+	}
+	return nil
+}
+
+// verify checks the integrity of the raw contents of an entry and the blob it references.
+func (s *Store) verify(root *os.Root, path string, raw []byte) error {
+	h, _, err := splitEntry(raw)
+	if err != nil {
+		return &CorruptError{Path: path, Err: err}
+	}
+	if h.flags&flagRef != 0 {
+		_, err = s.readBlob(root, h.digest)
+	}
+	return err
+}
+
+// quarantine removes the entry at path if it is still corrupt once it is locked.
+// The entry may have been rewritten since the corruption was found.
+func (s *Store) quarantine(path string) {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+	stripe := s.stripe(path)
+	stripe.Lock()
+	defer stripe.Unlock()
+
+	root, err := s.openRoot()
+	if err != nil {
+		return
+	}
+
+	file, err := openLocked(root, path, os.O_RDONLY, true)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	raw, err := io.ReadAll(file)
+	if err != nil {
+		return
+	}
+
+	err = s.verify(root, path, raw)
+	if errors.Is(err, ErrCorrupt) {
+		s.quarantineLocked(root, path, raw, err)
+	}
+}
+
+// quarantineLocked removes a corrupt entry that is already locked by the caller.
+// The entry is copied to the quarantine directory first if Quarantine is enabled.
+// A corrupt blob is removed as well so it is written again by the next entry saving the same data.
+func (s *Store) quarantineLocked(root *os.Root, path string, raw []byte, reason error) {
+	log.Printf("cache: removing corrupt entry %s: %v", path, reason)
+
+	if s.Quarantine {
+		err := s.copyToQuarantine(root, path, raw)
+		if err != nil {
+			log.Printf("cache: unable to quarantine %s: %v", path, err)
+		}
+	}
+
+	err := root.Remove(path)
+	if err != nil && !os.IsNotExist(err) {
+		log.Printf("cache: unable to remove corrupt entry %s: %v", path, err)
+		return
+	}
+
+	h, _, err := readHeader(bufio.NewReader(bytes.NewReader(raw)))
+	if err != nil || h.flags&flagRef == 0 {
+		return
+	}
+
+	var corrupt *CorruptError
+	if errors.As(reason, &corrupt) && corrupt.Path != path {
+		s.dropBlob(root, h.digest)
+	}
+	err = s.release(root, h.digest)
+	if err != nil {
+		log.Printf("cache: unable to release blob of %s: %v", path, err)
+	}
+}
+
+// copyToQuarantine saves the raw contents of an entry under the quarantine directory.
+func (s *Store) copyToQuarantine(root *os.Root, path string, raw []byte) error {
+	target := filepath.Join(quarantineDir, path)
+	err := mkdirAll(root, filepath.Dir(target))
+	if err != nil {
+		return err
+	}
+
+	f, err := root.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(raw)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// dropBlob removes the contents of a corrupt blob while keeping its reference count,
+// so the blob is rewritten the next time the same data is saved.
+func (s *Store) dropBlob(root *os.Root, digest [sha256.Size]byte) {
+	_, path := blobPath(digest)
+	stripe := s.blobStripe(path)
+	stripe.Lock()
+	defer stripe.Unlock()
+
+	blob, err := root.Open(path)
+	if err != nil {
+		return
+	}
+	b, err := io.ReadAll(blob)
+	blob.Close()
+	if err != nil || checkBlob(path, digest, b) == nil {
+		return
+	}
+
+	err = root.Remove(path)
+	if err != nil && !os.IsNotExist(err) {
+		log.Printf("cache: unable to remove corrupt blob %s: %v", path, err)
+	}
+}
@@ -0,0 +1,229 @@
+package disk_test
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tmstorm/cache/stores/disk"
+)
+
+// flipLastByte corrupts the file at path by flipping the bits of its last byte.
+func flipLastByte(t *testing.T, path string) {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b[len(b)-1] ^= 0xff
+	err = os.WriteFile(path, b, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Test corrupt entries are detected when read and removed
+func TestCorruptEntry(t *testing.T) {
+	a := assert.New(t)
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(io.Discard)
+
+	diskStore := disk.New(&disk.Store{
+		RootDir: t.TempDir(),
+		MaxAge:  20,
+	})
+	a.NotNil(diskStore)
+	d := disk.Get(diskStore)
+
+	// A bit flip is detected by the checksum
+	a.NoError(d.Write("", "flipped.txt", []byte("some cached data"), false))
+	flipLastByte(t, filepath.Join(diskStore.RootDir, "flipped.txt"))
+	_, err := d.Read("", "flipped.txt")
+	a.ErrorIs(err, disk.ErrCorrupt)
+	var corrupt *disk.CorruptError
+	a.True(errors.As(err, &corrupt))
+	a.Equal("flipped.txt", corrupt.Path)
+	a.NoFileExists(filepath.Join(diskStore.RootDir, "flipped.txt"))
+
+	// A truncated entry is detected as well
+	a.NoError(d.Write("", "truncated.txt", []byte("some cached data"), false))
+	a.NoError(os.Truncate(filepath.Join(diskStore.RootDir, "truncated.txt"), 10))
+	_, err = d.Read("", "truncated.txt")
+	a.ErrorIs(err, disk.ErrCorrupt)
+	a.NoFileExists(filepath.Join(diskStore.RootDir, "truncated.txt"))
+
+	// Entries cut off within the magic are not mistaken for plain data
+	for _, size := range []int64{0, 1, 2, 3} {
+		name := fmt.Sprint("cut", size, ".txt")
+		a.NoError(d.Write("", name, []byte("some cached data"), false))
+		a.NoError(os.Truncate(filepath.Join(diskStore.RootDir, name), size))
+		_, err = d.Read("", name)
+		a.ErrorIs(err, disk.ErrCorrupt, "truncated to %d bytes", size)
+		a.NoFileExists(filepath.Join(diskStore.RootDir, name))
+	}
+
+	// The checksum covers the header as well
+	k := disk.GetKeyed(diskStore)
+	a.NoError(k.Write("customer", []byte("some cached data"), false))
+	keys, err := k.Keys()
+	a.NoError(err)
+	a.Equal([]string{"customer"}, keys)
+	paths, err := filepath.Glob(filepath.Join(diskStore.RootDir, ".keys", "*", "*", "*"))
+	a.NoError(err)
+	a.Len(paths, 1)
+	b, err := os.ReadFile(paths[0])
+	a.NoError(err)
+	b[8] ^= 0x20
+	a.NoError(os.WriteFile(paths[0], b, 0o600))
+	_, err = k.Read("customer")
+	a.ErrorIs(err, disk.ErrCorrupt)
+
+	// The entry can be written again once removed
+	a.NoError(d.Write("", "flipped.txt", []byte("some cached data"), false))
+	b, err = d.Read("", "flipped.txt")
+	a.NoError(err)
+	a.Equal("some cached data", string(b))
+
+	// Files written without a header are still read as is
+	a.NoError(os.WriteFile(filepath.Join(diskStore.RootDir, "legacy.txt"), []byte("legacy data"), 0o600))
+	b, err = d.Read("", "legacy.txt")
+	a.NoError(err)
+	a.Equal("legacy data", string(b))
+
+	// Version 1 entries only checksum their payload
+	v1 := []byte{0x89, 'B', 'S', 'C', 1, 1 << 5}
+	v1 = binary.BigEndian.AppendUint32(v1, crc32.Checksum([]byte("version 1"), crc32.MakeTable(crc32.Castagnoli)))
+	v1 = append(v1, "version 1"...)
+	a.NoError(os.WriteFile(filepath.Join(diskStore.RootDir, "v1.txt"), v1, 0o600))
+	b, err = d.Read("", "v1.txt")
+	a.NoError(err)
+	a.Equal("version 1", string(b))
+
+	a.NoError(diskStore.Purge())
+}
+
+// Test corrupt entries are kept for inspection when Quarantine is enabled
+func TestQuarantine(t *testing.T) {
+	a := assert.New(t)
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(io.Discard)
+
+	diskStore := disk.New(&disk.Store{
+		RootDir:    t.TempDir(),
+		MaxAge:     20,
+		Quarantine: true,
+		Scrub:      true,
+	})
+	a.NotNil(diskStore)
+	d := disk.Get(diskStore)
+
+	a.NoError(d.Write("images", "logo.svg", []byte("<svg></svg>"), false))
+	a.NoError(d.Write("images", "icon.svg", []byte("<svg></svg>"), false))
+	flipLastByte(t, filepath.Join(diskStore.RootDir, "images", "logo.svg"))
+
+	// Scrubbing during trim finds the corrupt entry before it is read
+	diskStore.Trim()
+	a.NoFileExists(filepath.Join(diskStore.RootDir, "images", "logo.svg"))
+	a.FileExists(filepath.Join(diskStore.RootDir, ".quarantine", "images", "logo.svg"))
+	b, err := d.Read("images", "icon.svg")
+	a.NoError(err)
+	a.Equal("<svg></svg>", string(b))
+
+	// Quarantined entries are hidden from the FS view
+	_, err = diskStore.FS().Open(".quarantine/images/logo.svg")
+	a.Error(err)
+
+	a.NoError(diskStore.Purge())
+}
+
+// Test a corrupt blob is removed and written again by the next entry saving the same data
+func TestCorruptBlob(t *testing.T) {
+	a := assert.New(t)
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(io.Discard)
+
+	diskStore := disk.New(&disk.Store{
+		RootDir: t.TempDir(),
+		MaxAge:  20,
+		Dedup:   true,
+	})
+	a.NotNil(diskStore)
+	d := disk.Get(diskStore)
+
+	shared := []byte("the same asset for every tenant")
+	a.NoError(d.Write("tenant-a", "logo.svg", shared, false))
+	a.NoError(d.Write("tenant-b", "logo.svg", shared, false))
+	for digest := range blobs(t, diskStore.RootDir) {
+		flipLastByte(t, filepath.Join(diskStore.RootDir, ".blobs", digest[:2], digest))
+	}
+
+	_, err := d.Read("tenant-a", "logo.svg")
+	a.ErrorIs(err, disk.ErrCorrupt)
+	a.NoFileExists(filepath.Join(diskStore.RootDir, "tenant-a", "logo.svg"))
+	a.Empty(blobs(t, diskStore.RootDir))
+
+	// Saving the same data again restores the blob for the remaining entry
+	a.NoError(d.Write("tenant-a", "logo.svg", shared, false))
+	b, err := d.Read("tenant-b", "logo.svg")
+	a.NoError(err)
+	a.Equal(shared, b)
+
+	a.NoError(diskStore.Purge())
+}
+
+// Test trimming a quarantined entry does not release the blob it referenced a second time
+func TestQuarantineDedup(t *testing.T) {
+	a := assert.New(t)
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(io.Discard)
+
+	diskStore := disk.New(&disk.Store{
+		RootDir:    t.TempDir(),
+		MaxAge:     20,
+		Dedup:      true,
+		Quarantine: true,
+	})
+	a.NotNil(diskStore)
+	d := disk.Get(diskStore)
+
+	shared := []byte("the same asset for every tenant")
+	a.NoError(d.Write("a", "x", shared, false))
+	a.NoError(d.Write("b", "y", shared, false))
+	flipLastByte(t, filepath.Join(diskStore.RootDir, "a", "x"))
+
+	_, err := d.Read("a", "x")
+	a.ErrorIs(err, disk.ErrCorrupt)
+	quarantine := filepath.Join(diskStore.RootDir, ".quarantine", "a", "x")
+	a.FileExists(quarantine)
+	for _, refs := range blobs(t, diskStore.RootDir) {
+		a.Equal("1", refs)
+	}
+
+	// Recovery does not count the quarantined copy as a reference
+	report := diskStore.Recover()
+	a.NoError(report.Err)
+	a.Equal(0, report.RefsFixed)
+
+	old := time.Now().Add(-time.Hour)
+	a.NoError(os.Chtimes(quarantine, old, old))
+	diskStore.Trim()
+	a.NoFileExists(quarantine)
+
+	b, err := d.Read("b", "y")
+	a.NoError(err)
+	a.Equal(shared, b)
+
+	// Removing the last entry removes the blob
+	a.NoError(d.Remove("b", "y"))
+	a.Empty(blobs(t, diskStore.RootDir))
+
+	a.NoError(diskStore.Purge())
+}
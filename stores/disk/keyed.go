@@ -0,0 +1,117 @@
+package disk
+
+import (
+	"bufio"
+	"fmt"
+	"io/fs"
+	"math"
+	"os"
+)
+
+// keyDir is the directory under RootDir that holds key-addressed entries.
+const keyDir = ".keys"
+
+// keyWriter is used to read, write, and remove key-addressed entries.
+// Keys are hashed into a fan-out directory tree so any string can be used
+// as a key without creating very large directories.
+type keyWriter struct {
+	Store *Store
+}
+
+// GetKeyed returns a key-addressed writer for the current disk store.
+func GetKeyed(s *Store) *keyWriter {
+	var w keyWriter
+	w.Store = s
+	return &w
+}
+
+// keyPath returns the directory and full path relative to RootDir a key is stored under.
+// The key is hashed and the first two byte pairs of the hex digest are
+// used as directories, e.g. .keys/ab/cd/abcd...
+func (s *Store) keyPath(key string) (string, string) {
+	return fanOut(keyDir, s.nameHash(key))
+}
+
+// Write saves the data under the given key.
+// If overwrite = true the entry will be overwriten if it already exists
+func (w *keyWriter) Write(key string, data []byte, overwrite bool) error {
+	if len(key) > math.MaxUint16 {
+		return fmt.Errorf("key is too long for disk store: %d bytes", len(key))
+	}
+
+	saveDir, fullPath := w.Store.keyPath(key)
+	return w.Store.write(saveDir, fullPath, header{flags: flagKey, key: key}, data, overwrite)
+}
+
+// Read returns the data saved under the given key.
+func (w *keyWriter) Read(key string) ([]byte, error) {
+	_, fullPath := w.Store.keyPath(key)
+	h, data, _, err := w.Store.read(fullPath)
+	if err != nil {
+		return []byte{}, err
+	}
+	if h.key != key {
+		return []byte{}, fmt.Errorf("key not found in disk store: %s", key)
+	}
+	return data, nil
+}
+
+// Remove deletes the entry saved under the given key.
+func (w *keyWriter) Remove(key string) error {
+	_, fullPath := w.Store.keyPath(key)
+	return w.Store.remove(fullPath)
+}
+
+// Keys returns the original keys of all key-addressed entries in the store.
+// The order of the returned keys is not defined.
+func (w *keyWriter) Keys() ([]string, error) {
+	w.Store.mtx.RLock()
+	defer w.Store.mtx.RUnlock()
+
+	root, err := w.Store.freshRoot()
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []string
+	err = fs.WalkDir(root.FS(), keyDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() || tempFile(path) {
+			return nil
+		}
+
+		stripe := w.Store.stripe(path)
+		stripe.RLock()
+		defer stripe.RUnlock()
+
+		f, err := root.Open(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		defer f.Close()
+
+		h, ok, err := readHeader(bufio.NewReader(f))
+		if err != nil || !ok || h.flags&(flagKey|flagSealedName) == 0 {
+			// Skip anything that was not written by the key writer
+			return nil
+		}
+		err = w.Store.openName(&h, path)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, h.key)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
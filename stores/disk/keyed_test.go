@@ -0,0 +1,73 @@
+package disk_test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tmstorm/cache/stores/disk"
+)
+
+// Test writing, reading, listing, and removing key-addressed entries
+func TestKeyedStore(t *testing.T) {
+	a := assert.New(t)
+
+	diskStore := disk.New(&disk.Store{
+		RootDir: t.TempDir(),
+		MaxAge:  20,
+	})
+	a.NotNil(diskStore)
+
+	k := disk.GetKeyed(diskStore)
+	a.NotNil(k)
+
+	keys := []string{"users/1/profile", "../../etc/passwd", "plain", "with spaces and ünïcode"}
+	for _, key := range keys {
+		a.NoError(k.Write(key, []byte("value of "+key), false))
+	}
+
+	// Attempt to overwrite a key with overwrite = false
+	a.Error(k.Write("plain", []byte("new"), false))
+
+	// Overwrite with shorter data and make sure nothing is left over
+	a.NoError(k.Write("plain", []byte("new"), true))
+	b, err := k.Read("plain")
+	a.NoError(err)
+	a.Equal("new", string(b))
+
+	for _, key := range keys {
+		if key == "plain" {
+			continue
+		}
+		b, err := k.Read(key)
+		a.NoError(err)
+		a.Equal("value of "+key, string(b))
+	}
+
+	// Entries are stored in a hashed fan-out tree
+	sum := sha256.Sum256([]byte("users/1/profile"))
+	name := hex.EncodeToString(sum[:])
+	_, err = os.Stat(filepath.Join(diskStore.RootDir, ".keys", name[:2], name[2:4], name))
+	a.NoError(err)
+
+	// The original keys can be listed back
+	listed, err := k.Keys()
+	a.NoError(err)
+	sort.Strings(listed)
+	sort.Strings(keys)
+	a.Equal(keys, listed)
+
+	// Remove a key
+	a.NoError(k.Remove("plain"))
+	_, err = k.Read("plain")
+	a.Error(err)
+	a.Error(k.Remove("plain"))
+
+	// Reading a missing key is an error
+	_, err = k.Read("missing")
+	a.Error(err)
+}
@@ -0,0 +1,66 @@
+package disk
+
+import (
+	"os"
+)
+
+// storeLockFile is the file in RootDir used to coordinate Trim and Purge
+// between processes sharing the same RootDir.
+const storeLockFile = ".lock"
+
+// openLocked opens the entry at path relative to root and places a flock on it.
+// The lock is shared unless exclusive is true.
+// If the entry is removed or replaced by another process while waiting for the
+// lock it is opened again, so the returned file is always the current entry.
+func openLocked(root *os.Root, path string, flag int, exclusive bool) (*os.File, error) {
+	for {
+		f, err := root.OpenFile(path, flag, 0o600)
+		if err != nil {
+			return nil, checkEscape(root, path, err)
+		}
+
+		err = lockFile(f, exclusive)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+
+		locked, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		current, err := root.Stat(path)
+		if err == nil && os.SameFile(locked, current) {
+			return f, nil
+		}
+
+		// Closing the file releases the lock
+		f.Close()
+		if err != nil && (!os.IsNotExist(err) || flag&os.O_CREATE == 0) {
+			return nil, err
+		}
+	}
+}
+
+// lockStore places an exclusive flock on the store lock file.
+// If wait is false and another process holds the lock ok is false.
+// The returned file must be closed to release the lock.
+func lockStore(root *os.Root, wait bool) (f *os.File, ok bool, err error) {
+	f, err = root.OpenFile(storeLockFile, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if wait {
+		err = lockFile(f, true)
+		ok = err == nil
+	} else {
+		ok, err = tryLockFile(f)
+	}
+	if err != nil || !ok {
+		f.Close()
+		return nil, ok, err
+	}
+	return f, true, nil
+}
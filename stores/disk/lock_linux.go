@@ -0,0 +1,42 @@
+//go:build linux
+
+package disk
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// lockFile places an advisory flock on f, blocking until it is acquired.
+// The lock is shared unless exclusive is true.
+func lockFile(f *os.File, exclusive bool) error {
+	how := syscall.LOCK_SH
+	if exclusive {
+		how = syscall.LOCK_EX
+	}
+	return flock(f, how)
+}
+
+// tryLockFile attempts to place an exclusive flock on f without blocking.
+// It returns false if the lock is held by another open file.
+func tryLockFile(f *os.File) (bool, error) {
+	err := flock(f, syscall.LOCK_EX|syscall.LOCK_NB)
+	if errors.Is(err, syscall.EWOULDBLOCK) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// flock calls syscall.Flock retrying if interrupted by a signal.
+func flock(f *os.File, how int) error {
+	for {
+		err := syscall.Flock(int(f.Fd()), how) //#nosec G115 -- file descriptors fit in an int
+		if err != syscall.EINTR {
+			return err
+		}
+	}
+}
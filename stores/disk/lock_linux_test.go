@@ -0,0 +1,90 @@
+package disk_test
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tmstorm/cache/stores/disk"
+)
+
+// holdLock opens path separately and flocks it as another process would.
+// flock locks belong to the open file so this conflicts with the store's own locks.
+func holdLock(t *testing.T, path string, how int) *os.File {
+	t.Helper()
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := syscall.Flock(int(f.Fd()), how); err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+// Test that locks held by other processes are respected
+func TestCrossProcessLocking(t *testing.T) {
+	a := assert.New(t)
+
+	diskStore := disk.New(&disk.Store{
+		RootDir: t.TempDir(),
+		MaxAge:  1,
+	})
+	a.NotNil(diskStore)
+	d := disk.Get(diskStore)
+
+	a.NoError(d.Write("json", "file", []byte("data"), false))
+	entry := filepath.Join(diskStore.RootDir, "json", "file")
+	old := time.Now().Add(-time.Hour)
+	a.NoError(os.Chtimes(entry, old, old))
+
+	// Another process is reading the entry so trim must not remove it
+	reader := holdLock(t, entry, syscall.LOCK_SH)
+	diskStore.Trim()
+	_, err := os.Stat(entry)
+	a.NoError(err)
+
+	// Reads share the lock with the other reader
+	b, err := d.Read("json", "file")
+	a.NoError(err)
+	a.Equal("data", string(b))
+
+	// Writes wait for the other reader to finish
+	written := make(chan error)
+	go func() {
+		written <- d.Write("json", "file", []byte("new data"), true)
+	}()
+	select {
+	case <-written:
+		t.Fatal("write did not wait for the reader lock")
+	case <-time.After(100 * time.Millisecond):
+	}
+	reader.Close()
+	a.NoError(<-written)
+
+	b, err = d.Read("json", "file")
+	a.NoError(err)
+	a.Equal("new data", string(b))
+
+	// Another process is trimming so this trim is skipped
+	a.NoError(os.Chtimes(entry, old, old))
+	trimmer := holdLock(t, filepath.Join(diskStore.RootDir, ".lock"), syscall.LOCK_EX)
+	diskStore.Trim()
+	_, err = os.Stat(entry)
+	a.NoError(err)
+	trimmer.Close()
+
+	// Once nothing holds a lock the expired entry is trimmed
+	diskStore.Trim()
+	_, err = os.Stat(entry)
+	a.True(os.IsNotExist(err))
+
+	// The store lock file is never trimmed
+	_, err = os.Stat(filepath.Join(diskStore.RootDir, ".lock"))
+	a.NoError(err)
+
+	a.NoError(diskStore.Purge())
+}
@@ -0,0 +1,18 @@
+//go:build !linux
+
+package disk
+
+import "os"
+
+// File locking is only implemented on Linux.
+// On other platforms the store is only safe to share within one process.
+
+// lockFile is a no-op on this platform.
+func lockFile(f *os.File, exclusive bool) error {
+	return nil
+}
+
+// tryLockFile is a no-op on this platform and always succeeds.
+func tryLockFile(f *os.File) (bool, error) {
+	return true, nil
+}
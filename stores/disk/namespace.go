@@ -0,0 +1,165 @@
+package disk
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// namespaceWriter is used to read, write, and remove entries of one namespace.
+// Entries are saved in a directory under RootDir named after the namespace.
+type namespaceWriter struct {
+	Store *Store
+	name  string
+}
+
+// GetNamespace returns a writer limited to the entries under RootDir/name,
+// so several tenants can share a store without seeing each others entries.
+// The name must be a single path element and cannot start with a dot,
+// as those are used by the store for its own bookkeeping.
+func GetNamespace(s *Store, name string) (*namespaceWriter, error) {
+	if !filepath.IsLocal(name) || strings.ContainsAny(name, `/\`) || strings.HasPrefix(name, ".") {
+		return nil, errors.New("namespace names must be a single path element not starting with a dot")
+	}
+	return &namespaceWriter{Store: s, name: name}, nil
+}
+
+// Name returns the name of the namespace
+func (w *namespaceWriter) Name() string {
+	return w.name
+}
+
+// entryPath returns the paths of an entry within the namespace.
+// An EscapeError is returned if the path would resolve outside of the namespace.
+func (w *namespaceWriter) entryPath(path string, fileName string) (string, string, header, error) {
+	fullPath, err := w.Store.buildPath(w.name, path, fileName)
+	if err != nil {
+		return "", "", header{}, err
+	}
+	rel, ok := strings.CutPrefix(fullPath, w.name+string(filepath.Separator))
+	if !ok {
+		return "", "", header{}, &EscapeError{Path: filepath.Join(path, fileName)}
+	}
+	if reserved(rel) {
+		return "", "", header{}, fmt.Errorf("cache: %s: %w", filepath.Join(path, fileName), ErrReservedPath)
+	}
+
+	// Hidden names are kept within the namespace so it can be trimmed and purged alone
+	saveDir, entry, h := w.Store.entryIn(filepath.Join(w.name, nameDir), fullPath)
+	return saveDir, entry, h, nil
+}
+
+// Write saves the data passed with the fileName given to the given directory within the namespace.
+// If overwrite = true the file will be overwriten if it already exists
+func (w *namespaceWriter) Write(path string, fileName string, data []byte, overwrite bool) error {
+	saveDir, fullPath, h, err := w.entryPath(path, fileName)
+	if err != nil {
+		return err
+	}
+	return w.Store.write(saveDir, fullPath, h, data, overwrite)
+}
+
+// Read reads the file at the given path within the namespace.
+func (w *namespaceWriter) Read(path string, fileName string) ([]byte, error) {
+	_, fullPath, want, err := w.entryPath(path, fileName)
+	if err != nil {
+		return []byte{}, err
+	}
+	return w.Store.readPath(fullPath, want)
+}
+
+// Remove deletes the file at the given path within the namespace.
+func (w *namespaceWriter) Remove(path string, fileName string) error {
+	_, fullPath, _, err := w.entryPath(path, fileName)
+	if err != nil {
+		return err
+	}
+	return w.Store.remove(fullPath)
+}
+
+// ReEncrypt rewrites the entries of the namespace like the stores ReEncrypt,
+// moving entries saved before names were hidden under the namespace's hidden names.
+func (w *namespaceWriter) ReEncrypt(ctx context.Context) (int, error) {
+	return w.Store.reencryptDir(ctx, w.name, filepath.Join(w.name, nameDir))
+}
+
+// Trim removes the entries of the namespace older than the stores MaxAge
+// along with the directories left empty.
+func (w *namespaceWriter) Trim() {
+	log.Printf("Starting file namespace %s trimming...", w.name)
+	s := w.Store
+
+	root, err := s.openRoot()
+	if err != nil {
+		log.Printf("unable to open root directory: %v", err)
+		return
+	}
+
+	lock, ok, err := lockStore(root, false)
+	if err != nil {
+		log.Printf("unable to lock store: %v", err)
+		return
+	}
+	if !ok {
+		log.Println("File store is being trimmed by another process")
+		return
+	}
+	defer lock.Close()
+
+	err = fs.WalkDir(root.FS(), w.name, s.walk)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Printf("unable to read path: %v", err)
+	}
+	log.Printf("File namespace %s trimming complete", w.name)
+}
+
+// Purge removes every entry of the namespace and its directory.
+// Entries are removed one at a time so deduplicated blobs are released,
+// and the rest of the store is left as it is.
+func (w *namespaceWriter) Purge() error {
+	log.Printf("File namespace %s is being purged...", w.name)
+	s := w.Store
+
+	root, err := s.openRoot()
+	if err != nil {
+		return err
+	}
+
+	var dirs []string
+	err = fs.WalkDir(root.FS(), w.name, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			dirs = append(dirs, path)
+			return nil
+		}
+		err = s.remove(path)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// Remove directories deepest first, leaving any a concurrent write has filled again
+	for i := len(dirs) - 1; i >= 0; i-- {
+		err = s.trimDir(dirs[i])
+		if err != nil && err != fs.SkipDir {
+			return err
+		}
+	}
+
+	log.Printf("File namespace %s purge complete", w.name)
+	return nil
+}
@@ -0,0 +1,108 @@
+package disk_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tmstorm/cache/stores/disk"
+)
+
+// TestDiskNamespace tests namespaces keep their entries apart and can be trimmed and purged alone
+func TestDiskNamespace(t *testing.T) {
+	a := assert.New(t)
+	diskStore := disk.New(&disk.Store{
+		RootDir: t.TempDir(),
+		MaxAge:  20,
+		Dedup:   true,
+	})
+	a.NotNil(diskStore)
+
+	for _, name := range []string{"", ".", "..", ".blobs", "a/b", "../a"} {
+		_, err := disk.GetNamespace(diskStore, name)
+		a.Error(err, name)
+	}
+
+	tenantA, err := disk.GetNamespace(diskStore, "tenant-a")
+	a.NoError(err)
+	a.Equal("tenant-a", tenantA.Name())
+	tenantB, err := disk.GetNamespace(diskStore, "tenant-b")
+	a.NoError(err)
+
+	logo := []byte("<svg>shared logo</svg>")
+	a.NoError(tenantA.Write("img", "logo.svg", logo, false))
+	a.NoError(tenantA.Write("img", "old.svg", []byte("old"), false))
+	a.NoError(tenantB.Write("img", "logo.svg", logo, false))
+	a.NoError(tenantB.Write("img", "old.svg", []byte("old"), false))
+
+	v, err := tenantA.Read("img", "logo.svg")
+	a.NoError(err)
+	a.Equal(logo, v)
+	v, err = disk.Get(diskStore).Read("tenant-b/img", "logo.svg")
+	a.NoError(err)
+	a.Equal(logo, v)
+
+	// Paths cannot leave the namespace
+	_, err = tenantA.Read("../tenant-b/img", "logo.svg")
+	a.ErrorIs(err, disk.ErrPathEscape)
+	a.ErrorIs(tenantA.Write("..", "escaped", logo, false), disk.ErrPathEscape)
+
+	// Trimming one namespace leaves the others alone
+	old := time.Now().Add(-time.Minute)
+	for _, tenant := range []string{"tenant-a", "tenant-b"} {
+		a.NoError(os.Chtimes(filepath.Join(diskStore.RootDir, tenant, "img", "old.svg"), old, old))
+	}
+	tenantA.Trim()
+	_, err = tenantA.Read("img", "old.svg")
+	a.Error(err)
+	_, err = tenantB.Read("img", "old.svg")
+	a.NoError(err)
+
+	// Purging releases the shared blob once and removes the namespace directory
+	a.NoError(tenantA.Purge())
+	_, err = os.Stat(filepath.Join(diskStore.RootDir, "tenant-a"))
+	a.True(os.IsNotExist(err))
+	v, err = tenantB.Read("img", "logo.svg")
+	a.NoError(err)
+	a.Equal(logo, v)
+	a.NoError(tenantA.Purge())
+
+	a.NoError(tenantB.Remove("img", "logo.svg"))
+	a.NoError(diskStore.Purge())
+}
+
+// TestDiskNamespaceHiddenNames tests hashed names are kept within their namespace
+func TestDiskNamespaceHiddenNames(t *testing.T) {
+	a := assert.New(t)
+	diskStore := disk.New(&disk.Store{
+		RootDir: t.TempDir(),
+		Encryption: &disk.Encryption{
+			Keys: &disk.StaticKeys{
+				CurrentID: "k1",
+				Keys:      map[string][]byte{"k1": bytes.Repeat([]byte{1}, 16)},
+			},
+			NameKey: bytes.Repeat([]byte{9}, 32),
+		},
+	})
+	a.NotNil(diskStore)
+
+	tenant, err := disk.GetNamespace(diskStore, "tenant")
+	a.NoError(err)
+	a.NoError(tenant.Write("docs", "secret.txt", []byte("secret"), false))
+	v, err := tenant.Read("docs", "secret.txt")
+	a.NoError(err)
+	a.Equal([]byte("secret"), v)
+
+	entries, err := os.ReadDir(filepath.Join(diskStore.RootDir, "tenant"))
+	a.NoError(err)
+	a.Len(entries, 1)
+	a.Equal(".names", entries[0].Name())
+
+	a.NoError(tenant.Purge())
+	_, err = tenant.Read("docs", "secret.txt")
+	a.Error(err)
+	a.NoError(diskStore.Purge())
+}
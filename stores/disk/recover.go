@@ -0,0 +1,307 @@
+package disk
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type (
+	// Recovery sets the disk stores startup recovery options.
+	// When set New scans RootDir for state left behind by a crash or an unclean shutdown.
+	Recovery struct {
+		// Background runs the scan in a goroutine so New returns immediately.
+		// The store can be used while entries are checked, as each entry is only locked while it is checked.
+		// When Dedup blobs exist, reads and writes only wait while each blob's reference count is fixed.
+		Background bool
+
+		// Report is called with the results once the scan completes.
+		// If nil the results are logged.
+		Report func(RecoveryReport)
+	}
+
+	// RecoveryReport describes what a recovery scan found.
+	RecoveryReport struct {
+		// Entries and Bytes count the entries kept and their size on disk
+		Entries int
+		Bytes   int64
+
+		// Expired counts entries removed as they had reached MaxAge
+		Expired int
+
+		// Partial counts entries and temporary files removed as they were left by an interrupted write
+		Partial int
+
+		// Corrupt counts entries removed or quarantined as they failed their integrity check
+		Corrupt int
+
+		// EmptyDirs counts the empty directories removed
+		EmptyDirs int
+
+		// OrphanBlobs counts blobs and refs files removed as no entry references them
+		OrphanBlobs int
+
+		// RefsFixed counts blobs whose reference count was rebuilt
+		RefsFixed int
+
+		// Skipped counts entries that were in use and not checked
+		Skipped int
+
+		// Duration is how long the scan took
+		Duration time.Duration
+
+		// Err is set if the scan stopped early
+		Err error
+	}
+)
+
+// recoverStore runs the recovery scan configured for the store.
+func (s *Store) recoverStore() {
+	run := func() {
+		report := s.Recover()
+		if s.Recovery.Report != nil {
+			s.Recovery.Report(report)
+			return
+		}
+		log.Printf("cache: disk store recovery kept %d entries (%d bytes), removed %d expired, %d partial, %d corrupt, %d empty directories and %d orphaned blobs, fixed %d blob references in %v",
+			report.Entries, report.Bytes, report.Expired, report.Partial, report.Corrupt, report.EmptyDirs, report.OrphanBlobs, report.RefsFixed, report.Duration)
+		if report.Err != nil {
+			log.Printf("cache: disk store recovery stopped early: %v", report.Err)
+		}
+	}
+
+	if s.Recovery.Background {
+		go run()
+		return
+	}
+	run()
+}
+
+// Recover scans RootDir for state left behind by a crash or an unclean shutdown.
+// It removes expired entries, entries left empty by an interrupted write,
+// corrupt entries, and empty directories. Blob reference counts are rebuilt
+// from the entries on disk and blobs no entry references are removed.
+// Recover is run by New when Recovery is set but can also be called directly.
+// Blob references are counted without locking the store. Blobs whose references change
+// within this process while they are counted are left as they are, and no other process
+// should be writing to the RootDir while it runs.
+func (s *Store) Recover() (report RecoveryReport) {
+	start := time.Now()
+	defer func() { report.Duration = time.Since(start) }()
+
+	root, err := s.freshRoot()
+	if err != nil {
+		report.Err = err
+		return report
+	}
+
+	// Wait for any trim to finish and keep others from starting
+	lock, _, err := lockStore(root, true)
+	if err != nil {
+		report.Err = err
+		return report
+	}
+	defer lock.Close()
+
+	var dirs []string
+	err = fs.WalkDir(root.FS(), ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() {
+			if path != "." {
+				dirs = append(dirs, path)
+			}
+			return nil
+		}
+		if metaFile(path) || path == blobDir || strings.HasPrefix(path, blobDir+"/") {
+			return nil
+		}
+
+		// Temporary files left by an interrupted write are removed unless still being written
+		mode := checkMode{scrub: true, partial: true}
+		if tempFile(path) {
+			mode = checkMode{evict: true}
+		}
+
+		result, size, err := s.checkFile(path, mode)
+		if err != nil {
+			log.Printf("unable to recover %s: %v", path, err)
+			report.Skipped++
+			return nil
+		}
+		switch result {
+		case fileKept:
+			report.Entries++
+			report.Bytes += size
+		case fileSkipped:
+			report.Skipped++
+		case fileExpired:
+			if mode.evict {
+				report.Partial++
+			} else {
+				report.Expired++
+			}
+		case filePartial:
+			report.Partial++
+		case fileCorrupt:
+			report.Corrupt++
+		}
+		return nil
+	})
+	if err != nil {
+		report.Err = err
+		return report
+	}
+
+	err = s.recoverBlobs(root, &report)
+	if err != nil {
+		report.Err = err
+		return report
+	}
+
+	// Remove empty directories deepest first so emptied parents are removed too
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if s.trimDir(dirs[i]) == fs.SkipDir {
+			if _, err := root.Lstat(dirs[i]); os.IsNotExist(err) {
+				report.EmptyDirs++
+			}
+		}
+	}
+	return report
+}
+
+// recoverBlobs rebuilds blob reference counts from the entries on disk and removes orphaned blobs.
+// References are counted without the store lock, which is only held while each blob is fixed,
+// so blobs retained or released in the meantime are skipped.
+func (s *Store) recoverBlobs(root *os.Root, report *RecoveryReport) error {
+	_, err := root.Lstat(blobDir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	s.touchMtx.Lock()
+	s.touched = make(map[string]bool)
+	s.touchMtx.Unlock()
+	defer func() {
+		s.touchMtx.Lock()
+		s.touched = nil
+		s.touchMtx.Unlock()
+	}()
+
+	// Count the references held by every entry
+	refs := make(map[string]int)
+	err = fs.WalkDir(root.FS(), ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() {
+			// Quarantined copies no longer hold a reference
+			if path == blobDir || path == quarantineDir {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() || metaFile(path) {
+			return nil
+		}
+
+		f, err := root.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		h, _, err := readHeader(bufio.NewReader(f))
+		if err == nil && h.flags&flagRef != 0 {
+			refs[hex.EncodeToString(h.digest[:])]++
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Find every blob, including those that lost their blob or refs file
+	blobs := make(map[string]string)
+	err = fs.WalkDir(root.FS(), blobDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		name := strings.TrimSuffix(filepath.Base(path), refsExt)
+		digest, err := hex.DecodeString(name)
+		if err != nil || len(digest) != sha256.Size {
+			// Not written by the store
+			report.OrphanBlobs++
+			return root.Remove(path)
+		}
+		blobs[name] = strings.TrimSuffix(path, refsExt)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for name, path := range blobs {
+		err = s.recoverBlob(root, name, path, refs[name], report)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recoverBlob sets the reference count of a blob to count, removing it if count is zero.
+// The store is locked so no entry is written meanwhile, and blobs whose references
+// changed since counting started are left alone.
+func (s *Store) recoverBlob(root *os.Root, name, path string, count int, report *RecoveryReport) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.touchMtx.Lock()
+	touched := s.touched[name]
+	s.touchMtx.Unlock()
+	if touched {
+		return nil
+	}
+
+	if count > 0 {
+		return fixRefs(root, path, count, report)
+	}
+
+	report.OrphanBlobs++
+	for _, file := range []string{path, path + refsExt} {
+		err := root.Remove(file)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// fixRefs sets the reference count of the blob at path if it does not match count.
+func fixRefs(root *os.Root, path string, count int, report *RecoveryReport) error {
+	f, err := openLocked(root, path+refsExt, os.O_RDWR|os.O_CREATE, true)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	current, err := readRefs(f)
+	if err == nil && current == count {
+		return nil
+	}
+	report.RefsFixed++
+	return writeRefs(f, count)
+}
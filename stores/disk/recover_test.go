@@ -0,0 +1,189 @@
+package disk_test
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tmstorm/cache/stores/disk"
+)
+
+// crashedStore leaves the state of a crashed store in rootDir.
+func crashedStore(t *testing.T, rootDir string) {
+	t.Helper()
+	a := assert.New(t)
+
+	diskStore := disk.New(&disk.Store{
+		RootDir: rootDir,
+		MaxAge:  20,
+		Dedup:   true,
+	})
+	a.NotNil(diskStore)
+	d := disk.Get(diskStore)
+
+	shared := []byte("the same asset for every tenant")
+	a.NoError(d.Write("tenant-a", "logo.svg", shared, false))
+	a.NoError(d.Write("tenant-b", "logo.svg", shared, false))
+	a.NoError(d.Write("tenant-c", "logo.svg", []byte("a custom logo"), false))
+	a.NoError(d.Write("stale", "old.txt", []byte("stale data"), false))
+	a.NoError(d.Write("corrupt", "flipped.txt", []byte("some cached data"), false))
+
+	// An expired entry
+	old := time.Now().Add(-time.Hour)
+	a.NoError(os.Chtimes(filepath.Join(rootDir, "stale", "old.txt"), old, old))
+
+	// An entry cut off mid-write and a corrupt entry
+	a.NoError(os.WriteFile(filepath.Join(rootDir, "partial.txt"), nil, 0o600))
+	flipLastByte(t, filepath.Join(rootDir, "corrupt", "flipped.txt"))
+
+	// Empty directories
+	a.NoError(os.MkdirAll(filepath.Join(rootDir, "empty", "nested"), 0o750))
+
+	// An entry removed without releasing its blob, leaving the blob orphaned
+	a.NoError(os.Remove(filepath.Join(rootDir, "tenant-c", "logo.svg")))
+
+	// A lost reference
+	for digest, refs := range blobs(t, rootDir) {
+		if refs == "2" {
+			a.NoError(os.WriteFile(filepath.Join(rootDir, ".blobs", digest[:2], digest+".refs"), []byte("1"), 0o600))
+		}
+	}
+
+	// A stray file in the blob directory
+	a.NoError(os.WriteFile(filepath.Join(rootDir, ".blobs", "tmp"), []byte("stray"), 0o600))
+}
+
+// Test the recovery scan run by New
+func TestRecovery(t *testing.T) {
+	a := assert.New(t)
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(io.Discard)
+
+	rootDir := t.TempDir()
+	crashedStore(t, rootDir)
+
+	var report disk.RecoveryReport
+	diskStore := disk.New(&disk.Store{
+		RootDir: rootDir,
+		MaxAge:  20,
+		Dedup:   true,
+		Recovery: &disk.Recovery{
+			Report: func(r disk.RecoveryReport) { report = r },
+		},
+	})
+	a.NotNil(diskStore)
+
+	a.NoError(report.Err)
+	a.Equal(2, report.Entries)
+	a.Positive(report.Bytes)
+	a.Equal(1, report.Expired)
+	a.Equal(1, report.Partial)
+	a.Equal(1, report.Corrupt)
+	a.Equal(2, report.OrphanBlobs)
+	a.Equal(1, report.RefsFixed)
+	a.GreaterOrEqual(report.EmptyDirs, 5)
+	a.Positive(report.Duration)
+
+	a.NoFileExists(filepath.Join(rootDir, "partial.txt"))
+	a.NoDirExists(filepath.Join(rootDir, "stale"))
+	a.NoDirExists(filepath.Join(rootDir, "corrupt"))
+	a.NoDirExists(filepath.Join(rootDir, "empty"))
+	a.NoFileExists(filepath.Join(rootDir, ".blobs", "tmp"))
+	found := blobs(t, rootDir)
+	a.Len(found, 1)
+	for _, refs := range found {
+		a.Equal("2", refs)
+	}
+
+	// The remaining entries are intact
+	d := disk.Get(diskStore)
+	for _, tenant := range []string{"tenant-a", "tenant-b"} {
+		b, err := d.Read(tenant, "logo.svg")
+		a.NoError(err)
+		a.Equal("the same asset for every tenant", string(b))
+	}
+
+	a.NoError(diskStore.Purge())
+}
+
+// Test the recovery scan can run in the background
+func TestBackgroundRecovery(t *testing.T) {
+	a := assert.New(t)
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(io.Discard)
+
+	rootDir := t.TempDir()
+	crashedStore(t, rootDir)
+
+	done := make(chan disk.RecoveryReport, 1)
+	diskStore := disk.New(&disk.Store{
+		RootDir: rootDir,
+		MaxAge:  20,
+		Dedup:   true,
+		Recovery: &disk.Recovery{
+			Background: true,
+			Report:     func(r disk.RecoveryReport) { done <- r },
+		},
+	})
+	a.NotNil(diskStore)
+
+	select {
+	case report := <-done:
+		a.NoError(report.Err)
+		a.Equal(2, report.Entries)
+	case <-time.After(time.Second * 10):
+		t.Fatal("recovery did not complete")
+	}
+
+	a.NoError(diskStore.Purge())
+}
+
+// Test blob references stay correct when entries are written and removed during recovery
+func TestRecoveryConcurrentWrites(t *testing.T) {
+	a := assert.New(t)
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(io.Discard)
+
+	rootDir := t.TempDir()
+	diskStore := disk.New(&disk.Store{
+		RootDir: rootDir,
+		MaxAge:  20,
+		Dedup:   true,
+	})
+	a.NotNil(diskStore)
+	d := disk.Get(diskStore)
+
+	shared := []byte("the same asset for every tenant")
+	for i := 0; i < 200; i++ {
+		a.NoError(d.Write(fmt.Sprint("tenant-", i), "logo.svg", shared, false))
+	}
+
+	done := make(chan disk.RecoveryReport)
+	go func() { done <- diskStore.Recover() }()
+
+	// Writes and removes are not held up by the scan
+	var report disk.RecoveryReport
+	var i int
+	for ; ; i++ {
+		a.NoError(d.Write("busy", fmt.Sprint(i), shared, false))
+		a.NoError(d.Remove("busy", fmt.Sprint(i)))
+		select {
+		case report = <-done:
+		default:
+			continue
+		}
+		break
+	}
+	a.NoError(report.Err)
+	a.Positive(i)
+
+	for i := 0; i < 200; i++ {
+		a.NoError(d.Remove(fmt.Sprint("tenant-", i), "logo.svg"))
+	}
+	a.Empty(blobs(t, rootDir))
+}
@@ -0,0 +1,24 @@
+//go:build linux
+
+package disk
+
+import (
+	"os"
+	"syscall"
+)
+
+// renameIn renames oldName to newName in the directory dir relative to root.
+// The directory is opened through root so the rename cannot be redirected out of it.
+func renameIn(root *os.Root, dir string, oldName string, newName string) error {
+	d, err := root.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	err = syscall.Renameat(int(d.Fd()), oldName, int(d.Fd()), newName)
+	if err != nil {
+		return &os.LinkError{Op: "renameat", Old: oldName, New: newName, Err: err}
+	}
+	return nil
+}
@@ -0,0 +1,17 @@
+//go:build !linux
+
+package disk
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// Renaming relative to an open directory is only implemented on Linux.
+// On other platforms the paths are resolved again from RootDir.
+
+// renameIn renames oldName to newName in the directory dir relative to root.
+func renameIn(root *os.Root, dir string, oldName string, newName string) error {
+	dir = filepath.Join(root.Name(), dir)
+	return os.Rename(filepath.Join(dir, oldName), filepath.Join(dir, newName))
+}
@@ -0,0 +1,273 @@
+package disk
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// ErrPathEscape is matched by errors.Is when a path given to the store
+// would resolve outside of RootDir, or outside of the namespace it was given to.
+var ErrPathEscape = errors.New("path escapes the store root directory")
+
+// EscapeError is returned when a path is rejected because it would resolve
+// outside of RootDir, either through ".." elements, an absolute path that
+// is not under RootDir, or a symlink pointing out of the store.
+type EscapeError struct {
+	// Path is the path as it was given to the store
+	Path string
+
+	// Err is the underlying error if the escape was detected by the file system
+	Err error
+}
+
+func (e *EscapeError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("cache: %s: %v: %v", e.Path, ErrPathEscape, e.Err)
+	}
+	return fmt.Sprintf("cache: %s: %v", e.Path, ErrPathEscape)
+}
+
+// Is allows errors.Is(err, ErrPathEscape) to match an EscapeError.
+func (e *EscapeError) Is(target error) bool {
+	return target == ErrPathEscape
+}
+
+func (e *EscapeError) Unwrap() error {
+	return e.Err
+}
+
+// ErrReservedPath is returned when a path given to the store names
+// a file or directory the store uses for its own bookkeeping.
+var ErrReservedPath = errors.New("path is reserved for use by the store")
+
+// reservedNames are the names the store uses for its own files and directories in RootDir.
+// Namespaces keep their hidden names in a directory of the same name as well.
+var reservedNames = []string{keyDir, nameDir, blobDir, quarantineDir, storeLockFile, trimCursorFile}
+
+// reserved reports whether the entry path, relative to RootDir or a namespace,
+// starts with a name the store uses for itself or names a temporary file.
+func reserved(path string) bool {
+	first, _, _ := strings.Cut(path, string(filepath.Separator))
+	return slices.Contains(reservedNames, first) || tempFile(path)
+}
+
+// openRoot returns the os.Root used for all file operations in the store.
+// The RootDir is created and opened if it has not been yet or was removed by Purge.
+func (s *Store) openRoot() (*os.Root, error) {
+	s.rootMtx.Lock()
+	defer s.rootMtx.Unlock()
+
+	if s.root != nil {
+		return s.root, nil
+	}
+
+	err := os.MkdirAll(s.RootDir, 0o750)
+	if err != nil {
+		return nil, err
+	}
+	root, err := os.OpenRoot(s.RootDir)
+	if err != nil {
+		return nil, err
+	}
+	s.root = root
+	return s.root, nil
+}
+
+// closeRoot closes the os.Root of the store so it will be reopened on next use.
+// The caller must hold s.mtx exclusively.
+func (s *Store) closeRoot() error {
+	s.rootMtx.Lock()
+	defer s.rootMtx.Unlock()
+
+	for _, root := range s.stale {
+		root.Close()
+	}
+	s.stale = nil
+
+	if s.root == nil {
+		return nil
+	}
+	err := s.root.Close()
+	s.root = nil
+	return err
+}
+
+// staleRoot reports whether root is no longer the RootDir, as another process purged the store.
+// A stale root is dropped so openRoot opens the RootDir again.
+// It is kept open until closeRoot as other operations may still be using it.
+func (s *Store) staleRoot(root *os.Root) bool {
+	s.rootMtx.Lock()
+	defer s.rootMtx.Unlock()
+
+	// Another operation has already dropped it
+	if s.root != root {
+		return true
+	}
+
+	opened, err := root.Stat(".")
+	if err != nil {
+		return false
+	}
+	current, err := os.Stat(s.RootDir)
+	if err == nil && os.SameFile(opened, current) {
+		return false
+	}
+
+	s.stale = append(s.stale, root)
+	s.root = nil
+	return true
+}
+
+// freshRoot returns the os.Root of the store, reopening the RootDir first if another process removed it.
+// It is used by walks, which find nothing rather than fail in a removed RootDir.
+func (s *Store) freshRoot() (*os.Root, error) {
+	root, err := s.openRoot()
+	if err != nil || !s.staleRoot(root) {
+		return root, err
+	}
+	return s.openRoot()
+}
+
+// withRoot calls fn with the os.Root of the store.
+// If fn fails as a file does not exist because RootDir was removed by another process,
+// fn is called again with the RootDir reopened.
+func (s *Store) withRoot(fn func(root *os.Root) error) error {
+	root, err := s.openRoot()
+	if err != nil {
+		return err
+	}
+
+	err = fn(root)
+	if !errors.Is(err, fs.ErrNotExist) || !s.staleRoot(root) {
+		return err
+	}
+
+	root, err = s.openRoot()
+	if err != nil {
+		return err
+	}
+	return fn(root)
+}
+
+// buildPath is an internal method used for building a cleaned file path relative to RootDir.
+// Paths that already include the RootDir have it removed.
+// An EscapeError is returned if the path would resolve outside of RootDir,
+// and ErrReservedPath if it names a file or directory the store uses for itself.
+func (s *Store) buildPath(elem ...string) (string, error) {
+	path := filepath.Clean(filepath.Join(elem...))
+
+	// If the path includes the RootDir remove it
+	if path == s.RootDir {
+		return ".", nil
+	}
+	if rel, ok := strings.CutPrefix(path, s.RootDir+string(filepath.Separator)); ok {
+		path = rel
+	}
+
+	if !filepath.IsLocal(path) {
+		return "", &EscapeError{Path: filepath.Join(elem...)}
+	}
+	if reserved(path) {
+		return "", fmt.Errorf("cache: %s: %w", filepath.Join(elem...), ErrReservedPath)
+	}
+	return path, nil
+}
+
+// checkEscape converts errors returned by os.Root for paths resolving outside
+// of root into an EscapeError. Other errors are returned unchanged.
+func checkEscape(root *os.Root, path string, err error) error {
+	var pathErr *os.PathError
+	if !errors.As(err, &pathErr) || errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if !filepath.IsLocal(path) || symlinkEscapes(root.Name(), path) {
+		return &EscapeError{Path: path, Err: err}
+	}
+	return err
+}
+
+// symlinkEscapes reports whether a symlink along path relative to dir points outside of dir.
+// It only explains why os.Root refused path, the refusal itself is enforced by os.Root.
+func symlinkEscapes(dir string, path string) bool {
+	base, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return false
+	}
+
+	current := base
+	for _, elem := range strings.Split(path, string(filepath.Separator)) {
+		next := filepath.Join(current, elem)
+		resolved, err := filepath.EvalSymlinks(next)
+		if err != nil {
+			// A dangling symlink is judged by its target
+			target, linkErr := os.Readlink(next)
+			if linkErr != nil {
+				return false
+			}
+			if !filepath.IsAbs(target) {
+				target = filepath.Join(current, target)
+			}
+			resolved = target
+		}
+
+		rel, err := filepath.Rel(base, resolved)
+		if err != nil || !filepath.IsLocal(rel) {
+			return true
+		}
+		current = resolved
+	}
+	return false
+}
+
+// mkdirAll creates the directory dir relative to root along with any missing parents.
+func mkdirAll(root *os.Root, dir string) error {
+	if dir == "." {
+		return nil
+	}
+
+	info, err := root.Stat(dir)
+	if err == nil {
+		if !info.IsDir() {
+			return &os.PathError{Op: "mkdir", Path: dir, Err: errors.New("not a directory")}
+		}
+		return nil
+	}
+	if !os.IsNotExist(err) {
+		return checkEscape(root, dir, err)
+	}
+
+	err = mkdirAll(root, filepath.Dir(dir))
+	if err != nil {
+		return err
+	}
+
+	err = root.Mkdir(dir, 0o750)
+	if err != nil && !os.IsExist(err) {
+		return checkEscape(root, dir, err)
+	}
+	return nil
+}
+
+// metaFile reports whether path is a file the store keeps in RootDir for its own use.
+func metaFile(path string) bool {
+	return path == storeLockFile || path == trimCursorFile
+}
+
+// internal reports whether path relative to RootDir is used by the store
+// for its own bookkeeping rather than holding an entry.
+func internal(path string) bool {
+	if metaFile(path) || tempFile(path) {
+		return true
+	}
+	for _, dir := range []string{blobDir, quarantineDir} {
+		if path == dir || strings.HasPrefix(path, dir+"/") {
+			return true
+		}
+	}
+	return false
+}
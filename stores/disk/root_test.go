@@ -0,0 +1,157 @@
+package disk_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tmstorm/cache/stores/disk"
+)
+
+// Test that paths resolving outside of RootDir are refused
+func TestRootEscape(t *testing.T) {
+	a := assert.New(t)
+
+	dir := t.TempDir()
+	rootDir := filepath.Join(dir, "cache")
+	sibling := filepath.Join(dir, "cache2")
+	outside := filepath.Join(dir, "outside")
+	a.NoError(os.MkdirAll(sibling, 0o750))
+	a.NoError(os.MkdirAll(outside, 0o750))
+	a.NoError(os.WriteFile(filepath.Join(outside, "secret"), []byte("secret"), 0o600))
+
+	diskStore := disk.New(&disk.Store{
+		RootDir: rootDir,
+		MaxAge:  20,
+	})
+	a.NotNil(diskStore)
+	d := disk.Get(diskStore)
+
+	// A symlink inside the store pointing out of it
+	a.NoError(os.Symlink(outside, filepath.Join(rootDir, "link")))
+	// A symlink pointing to a missing directory out of it
+	a.NoError(os.Symlink(filepath.Join(outside, "missing"), filepath.Join(rootDir, "dangling")))
+
+	escapes := []struct {
+		path string
+		file string
+	}{
+		{"../../etc", "passwd"},
+		{"json/../../outside", "secret"},
+		{"../cache2", "file"},
+		// A sibling directory sharing RootDir as a string prefix
+		{sibling, "file"},
+		{"/etc", "passwd"},
+		{"link", "secret"},
+		{"link/nested", "file"},
+		{"dangling", "file"},
+	}
+	for _, e := range escapes {
+		err := d.Write(e.path, e.file, []byte("data"), true)
+		a.ErrorIs(err, disk.ErrPathEscape, "write %s/%s", e.path, e.file)
+
+		var escapeErr *disk.EscapeError
+		a.True(errors.As(err, &escapeErr))
+
+		_, err = d.Read(e.path, e.file)
+		a.ErrorIs(err, disk.ErrPathEscape, "read %s/%s", e.path, e.file)
+
+		err = d.Remove(e.path, e.file)
+		a.ErrorIs(err, disk.ErrPathEscape, "remove %s/%s", e.path, e.file)
+	}
+
+	// Nothing outside of the store was touched
+	b, err := os.ReadFile(filepath.Join(outside, "secret"))
+	a.NoError(err)
+	a.Equal("secret", string(b))
+	_, err = os.Stat(filepath.Join(sibling, "file"))
+	a.True(os.IsNotExist(err))
+
+	// Paths that stay inside the store are still allowed,
+	// including paths that already include the RootDir
+	a.NoError(d.Write("json/../other", "file", []byte("data"), true))
+	a.NoError(d.Write(filepath.Join(rootDir, "abs"), "file", []byte("data"), true))
+	b, err = d.Read("abs", "file")
+	a.NoError(err)
+	a.Equal("data", string(b))
+
+	diskStore.Trim()
+	a.NoError(diskStore.Purge())
+
+	// The store can still be used after being purged
+	a.NoError(d.Write("json", "file", []byte("data"), true))
+	a.NoError(diskStore.Purge())
+}
+
+// Test that a store keeps working after another store sharing its RootDir purges it
+func TestRootPurgedByOtherStore(t *testing.T) {
+	a := assert.New(t)
+
+	rootDir := filepath.Join(t.TempDir(), "cache")
+	first := disk.New(&disk.Store{RootDir: rootDir, MaxAge: 20})
+	second := disk.New(&disk.Store{RootDir: rootDir, MaxAge: 20})
+	a.NotNil(first)
+	a.NotNil(second)
+
+	a.NoError(disk.Get(second).Write("json", "before", []byte("before"), false))
+	a.NoError(first.Purge())
+
+	// Walks through the other store see the new RootDir
+	a.NoError(disk.GetKeyed(first).Write("key", []byte("value"), false))
+	keys, err := disk.GetKeyed(second).Keys()
+	a.NoError(err)
+	a.Equal([]string{"key"}, keys)
+
+	// Writes through the other store land in the new RootDir
+	a.NoError(disk.Get(second).Write("json", "after", []byte("after"), false))
+	b, err := os.ReadFile(filepath.Join(rootDir, "json", "after"))
+	a.NoError(err)
+	a.Contains(string(b), "after")
+
+	b, err = disk.Get(first).Read("json", "after")
+	a.NoError(err)
+	a.Equal([]byte("after"), b)
+	_, err = disk.Get(second).Read("json", "before")
+	a.Error(err)
+
+	// Entries written through the purging store are seen by the other one
+	a.NoError(disk.Get(first).Write("json", "first", []byte("first"), false))
+	b, err = disk.Get(second).Read("json", "first")
+	a.NoError(err)
+	a.Equal([]byte("first"), b)
+	a.NoError(disk.Get(second).Remove("json", "first"))
+	_, err = disk.Get(first).Read("json", "first")
+	a.Error(err)
+}
+
+// Test that paths naming the stores own files and directories are refused
+func TestReservedPaths(t *testing.T) {
+	a := assert.New(t)
+
+	diskStore := disk.New(&disk.Store{RootDir: t.TempDir(), MaxAge: 20, Dedup: true})
+	a.NotNil(diskStore)
+	d := disk.Get(diskStore)
+	ns, err := disk.GetNamespace(diskStore, "tenant")
+	a.NoError(err)
+	a.NoError(d.Write("json", "entry", []byte("data"), false))
+
+	for _, path := range []string{".blobs", ".names", ".keys", ".quarantine", ".lock", ".trim", ".blobs/ab", "json/.entry.0123456789abcdef.tmp"} {
+		dir, file := filepath.Split(path)
+		err := d.Write(dir, file, []byte("data"), true)
+		a.ErrorIs(err, disk.ErrReservedPath, path)
+		_, err = d.Read(dir, file)
+		a.ErrorIs(err, disk.ErrReservedPath, path)
+		a.ErrorIs(d.Remove(dir, file), disk.ErrReservedPath, path)
+
+		a.ErrorIs(ns.Write(dir, file, []byte("data"), true), disk.ErrReservedPath, path)
+	}
+
+	// Other names starting with a dot can be used
+	a.NoError(d.Write(".config", "settings", []byte("data"), false))
+	a.NoError(ns.Write("json", ".lock", []byte("data"), false))
+	b, err := d.Read("json", "entry")
+	a.NoError(err)
+	a.Equal([]byte("data"), b)
+}
@@ -0,0 +1,29 @@
+package disk
+
+import (
+	"hash/fnv"
+	"sync"
+)
+
+// stripeCount is the number of locks entries are spread across.
+// Entries whose paths hash to the same stripe share a lock.
+const stripeCount = 64
+
+// stripes guard individual entries within one process so operations on
+// different entries do not wait on each other.
+type stripes [stripeCount]sync.RWMutex
+
+// stripe returns the lock for the entry at path relative to RootDir.
+func (s *Store) stripe(path string) *sync.RWMutex {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(path))
+	return &s.stripes[h.Sum32()%stripeCount]
+}
+
+// blobStripe returns the lock for the blob at path relative to RootDir.
+// Blobs use their own stripes as they are always locked after an entry.
+func (s *Store) blobStripe(path string) *sync.RWMutex {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(path))
+	return &s.blobStripes[h.Sum32()%stripeCount]
+}
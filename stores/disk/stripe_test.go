@@ -0,0 +1,173 @@
+package disk_test
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tmstorm/cache/stores/disk"
+)
+
+// Test that reads are not stalled by a running trim
+func TestReadDuringTrim(t *testing.T) {
+	a := assert.New(t)
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(io.Discard)
+
+	diskStore := benchStore(t, 500)
+	d := disk.Get(diskStore)
+
+	// Block a trim part way through by holding up its free space check
+	diskStore.FreeSpace = &disk.Watermarks{Low: 1, High: 1}
+	blocked := make(chan bool)
+	release := make(chan bool)
+	restore := disk.SetFreeSpace(func(string) (uint64, error) {
+		blocked <- true
+		<-release
+		return 1, nil
+	})
+	trimmed := make(chan bool)
+	go func() {
+		diskStore.Trim()
+		close(trimmed)
+	}()
+	<-blocked
+
+	// Reads, writes, and removes finish while the trim is still running
+	a.NoError(d.Write("blocked", "entry", []byte("value"), true))
+	b, err := d.Read("dir-0", "file-0")
+	a.NoError(err)
+	a.Equal([]byte("some cached data"), b)
+	a.NoError(d.Remove("blocked", "entry"))
+	select {
+	case <-trimmed:
+		t.Fatal("trim finished before it was released")
+	default:
+	}
+	close(release)
+	<-trimmed
+	restore()
+	diskStore.FreeSpace = nil
+
+	stop := make(chan bool)
+	var trims atomic.Int64
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				diskStore.Trim()
+				trims.Add(1)
+			}
+		}
+	}()
+
+	// Concurrent writers, readers, and removers on different entries
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				name := fmt.Sprintf("worker-%d-%d", i, j)
+				if err := d.Write("concurrent", name, []byte(name), true); err != nil {
+					t.Error(err)
+					return
+				}
+				b, err := d.Read("concurrent", name)
+				if err != nil || string(b) != name {
+					t.Errorf("unexpected read of %s: %s %v", name, b, err)
+					return
+				}
+				if err := d.Remove("concurrent", name); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	close(stop)
+	wg.Wait()
+	a.NotZero(trims.Load())
+}
+
+// benchStore creates a disk store populated with n entries.
+func benchStore(tb testing.TB, n int) *disk.Store {
+	diskStore := disk.New(&disk.Store{
+		RootDir: tb.TempDir(),
+		MaxAge:  3600,
+	})
+	d := disk.Get(diskStore)
+	for i := 0; i < n; i++ {
+		err := d.Write(fmt.Sprintf("dir-%d", i%16), fmt.Sprintf("file-%d", i), []byte("some cached data"), true)
+		if err != nil {
+			tb.Fatal(err)
+		}
+	}
+	return diskStore
+}
+
+// benchmarkRead reads entries in parallel, optionally while the store is trimmed in a loop.
+func benchmarkRead(b *testing.B, trim bool) {
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(io.Discard)
+
+	const n = 2000
+	diskStore := benchStore(b, n)
+	d := disk.Get(diskStore)
+
+	stop := make(chan bool)
+	done := make(chan bool)
+	if trim {
+		go func() {
+			defer close(done)
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+					diskStore.Trim()
+				}
+			}
+		}()
+	} else {
+		close(done)
+	}
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			_, err := d.Read(fmt.Sprintf("dir-%d", i%16), fmt.Sprintf("file-%d", i%n))
+			if err != nil {
+				b.Error(err)
+				return
+			}
+			i++
+		}
+	})
+	b.StopTimer()
+
+	close(stop)
+	<-done
+}
+
+// BenchmarkRead measures read throughput with no trim running.
+func BenchmarkRead(b *testing.B) {
+	benchmarkRead(b, false)
+}
+
+// BenchmarkReadDuringTrim measures read throughput while a trim runs continuously.
+func BenchmarkReadDuringTrim(b *testing.B) {
+	benchmarkRead(b, true)
+}
@@ -0,0 +1,19 @@
+//go:build linux
+
+package disk
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+// setModTime sets the access and modification times of the open file f to t.
+func setModTime(f *os.File, t time.Time) error {
+	tv := syscall.NsecToTimeval(t.UnixNano())
+	err := syscall.Futimes(int(f.Fd()), []syscall.Timeval{tv, tv})
+	if err != nil {
+		return &os.PathError{Op: "futimes", Path: f.Name(), Err: err}
+	}
+	return nil
+}
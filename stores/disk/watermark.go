@@ -0,0 +1,115 @@
+package disk
+
+import (
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+	"sort"
+	"time"
+)
+
+// Watermarks sets the free space thresholds of the filesystem holding RootDir.
+// When free space drops below Low, Trim evicts the oldest entries regardless
+// of MaxAge until at least High bytes are free.
+type Watermarks struct {
+	// Low is the free space in bytes below which eviction starts
+	Low uint64
+
+	// High is the free space in bytes eviction stops at.
+	// It must not be lower than Low.
+	High uint64
+}
+
+// statFreeSpace measures the free space of a directory. It is replaced in tests.
+var statFreeSpace = freeSpace
+
+// evictedFile is a file that may be evicted to free space.
+type evictedFile struct {
+	path    string
+	modTime time.Time
+}
+
+// evict removes the oldest entries while the free space of the filesystem
+// holding RootDir is below the high watermark. It does nothing unless free
+// space has dropped below the low watermark.
+func (s *Store) evict(root *os.Root) {
+	if s.FreeSpace == nil {
+		return
+	}
+
+	free, err := statFreeSpace(s.RootDir)
+	if err != nil {
+		if !errors.Is(err, errors.ErrUnsupported) {
+			log.Printf("unable to read free space: %v", err)
+		}
+		return
+	}
+	if free >= s.FreeSpace.Low {
+		return
+	}
+	log.Printf("Free space of %d bytes is below the low watermark, evicting oldest entries...", free)
+
+	files, err := s.evictionOrder(root)
+	if err != nil {
+		log.Printf("unable to read path: %v", err)
+		return
+	}
+
+	// Entries locked by another reader or writer are skipped
+	for _, file := range files {
+		err := s.trimFile(file.path, true)
+		if err != nil {
+			log.Printf("unable to evict %s: %v", file.path, err)
+			continue
+		}
+
+		free, err = statFreeSpace(s.RootDir)
+		if err != nil {
+			log.Printf("unable to read free space: %v", err)
+			return
+		}
+		if free >= s.FreeSpace.High {
+			break
+		}
+	}
+	log.Printf("Eviction complete, %d bytes are free", free)
+}
+
+// evictionOrder returns every entry in the store sorted from oldest to newest.
+// Blobs are not included as they are removed once no entry references them.
+func (s *Store) evictionOrder(root *os.Root) ([]evictedFile, error) {
+	var files []evictedFile
+	err := fs.WalkDir(root.FS(), ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() {
+			if path == blobDir {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if metaFile(path) {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		files = append(files, evictedFile{path: path, modTime: info.ModTime()})
+		return nil
+	})
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].modTime.Before(files[j].modTime)
+	})
+	return files, err
+}
@@ -0,0 +1,90 @@
+package disk_test
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tmstorm/cache/stores/disk"
+)
+
+// Test the oldest entries are evicted when free space drops below the low watermark
+func TestFreeSpaceWatermarks(t *testing.T) {
+	a := assert.New(t)
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(io.Discard)
+
+	// Simulate a small filesystem holding only the store
+	const capacity = 16 * 1024
+	restore := disk.SetFreeSpace(func(dir string) (uint64, error) {
+		var used int64
+		err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return err
+			}
+			info, err := d.Info()
+			used += info.Size()
+			return err
+		})
+		return uint64(capacity - used), err
+	})
+	defer restore()
+
+	diskStore := disk.New(&disk.Store{
+		RootDir: t.TempDir(),
+		MaxAge:  3600,
+		FreeSpace: &disk.Watermarks{
+			Low:  4 * 1024,
+			High: 8 * 1024,
+		},
+	})
+	a.NotNil(diskStore)
+	d := disk.Get(diskStore)
+
+	// Fill the filesystem with entries of increasing age
+	data := make([]byte, 1024)
+	for i := range 14 {
+		name := fmt.Sprintf("%02d.bin", i)
+		a.NoError(d.Write("entries", name, data, false))
+		age := time.Now().Add(-time.Duration(i) * time.Minute)
+		a.NoError(os.Chtimes(filepath.Join(diskStore.RootDir, "entries", name), age, age))
+	}
+
+	diskStore.Trim()
+
+	// The oldest entries are evicted until the high watermark is reached
+	for i := range 14 {
+		_, err := d.Read("entries", fmt.Sprintf("%02d.bin", i))
+		if i < 7 {
+			a.NoError(err, i)
+		} else {
+			a.Error(err, i)
+		}
+	}
+
+	// Nothing is evicted while free space is above the low watermark
+	diskStore.Trim()
+	for i := range 7 {
+		_, err := d.Read("entries", fmt.Sprintf("%02d.bin", i))
+		a.NoError(err, i)
+	}
+
+	a.NoError(diskStore.Purge())
+}
+
+// Test the high watermark cannot be lower than the low watermark
+func TestInvalidWatermarks(t *testing.T) {
+	a := assert.New(t)
+
+	diskStore := disk.New(&disk.Store{
+		RootDir:   t.TempDir(),
+		FreeSpace: &disk.Watermarks{Low: 2048, High: 1024},
+	})
+	a.Nil(diskStore)
+}
@@ -0,0 +1,214 @@
+package logkv
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Trim drops entries older than MaxAge and compacts the segments.
+// Every segment holding records that were overwritten, removed, or expired
+// has its live records copied into new segments and is then deleted.
+func (s *Store) Trim() {
+	log.Println("Starting logkv store trimming...")
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	cutoff := time.Now().Add(-time.Second * time.Duration(s.MaxAge)).UnixNano()
+	for key, e := range s.keydir {
+		if e.timestamp < cutoff {
+			delete(s.keydir, key)
+		}
+	}
+
+	err := s.compact()
+	if err != nil {
+		log.Printf("unable to compact logkv store: %v", err)
+		return
+	}
+	log.Println("Logkv store trimming complete")
+}
+
+// compact rewrites every segment that holds dead records.
+//
+// The active segment is rolled first so only sealed segments are merged.
+// Merged segments get ids above every existing segment and a new active segment
+// is started above them, so a record found later on open is always the newer one.
+// If compaction fails the merged segments are discarded and the store is left as it was.
+// A crash before the old segments are deleted leaves both copies of the live records,
+// which resolve to the same values on open.
+func (s *Store) compact() error {
+	live := make(map[uint32]int64)
+	for _, e := range s.keydir {
+		live[e.segment] += int64(e.size)
+	}
+
+	merge := make(map[uint32]bool)
+	for id, seg := range s.segments {
+		if seg.size > live[id] {
+			merge[id] = true
+		}
+	}
+	if len(merge) == 0 {
+		return nil
+	}
+
+	// Seal the active segment so its records can be merged or kept as they are
+	if s.active.size > 0 {
+		err := s.roll()
+		if err != nil {
+			return err
+		}
+	}
+	empty := s.active
+
+	out, moved, err := s.merge(merge)
+	if err == nil {
+		// Later writes go to a segment above the merged ones
+		err = s.newActive(s.maxID(out) + 1)
+	}
+	if err != nil {
+		s.discard(out)
+		return err
+	}
+
+	for _, seg := range out {
+		s.segments[seg.id] = seg
+	}
+	for key, e := range moved {
+		s.keydir[key] = e
+	}
+
+	// Drop the oldest segments first, so a crash never leaves a live value
+	// that a newer dropped segment had overwritten or removed
+	ids := make([]uint32, 0, len(merge))
+	for id := range merge {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	for _, id := range ids {
+		err = s.dropSegment(s.segments[id])
+		if err != nil {
+			return err
+		}
+	}
+	return s.dropSegment(empty)
+}
+
+// merge copies the live records of the given segments into new segments
+// and makes them durable. It returns the new segments, which are not yet in use,
+// and where each copied record now is.
+func (s *Store) merge(merge map[uint32]bool) ([]*segment, map[string]entry, error) {
+	// Copy live records in the order they were written
+	var keys []string
+	for key, e := range s.keydir {
+		if merge[e.segment] {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := s.keydir[keys[i]], s.keydir[keys[j]]
+		if a.segment != b.segment {
+			return a.segment < b.segment
+		}
+		return a.offset < b.offset
+	})
+
+	next := s.maxID(nil) + 1
+	var out []*segment
+	var cur *segment
+	moved := make(map[string]entry, len(keys))
+	for _, key := range keys {
+		e := s.keydir[key]
+		seg := s.segments[e.segment]
+		rec, size, err := readRecord(seg.f, e.offset, seg.size)
+		if err != nil {
+			return out, nil, err
+		}
+
+		if cur == nil || (cur.size > 0 && cur.size+int64(size) > s.SegmentSize) {
+			f, err := os.OpenFile(filepath.Join(s.Dir, segmentName(next, segmentExt+tmpExt)), os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o600)
+			if err != nil {
+				return out, nil, err
+			}
+			cur = &segment{id: next, f: f}
+			out = append(out, cur)
+			next++
+		}
+
+		_, err = cur.f.WriteAt(rec.encode(), cur.size)
+		if err != nil {
+			return out, nil, err
+		}
+		h := hint{timestamp: rec.timestamp, key: key, size: size, offset: cur.size}
+		cur.hints = append(cur.hints, h)
+		moved[key] = entry{segment: cur.id, offset: cur.size, size: size, timestamp: rec.timestamp}
+		cur.size += int64(size)
+	}
+
+	// Make the merged segments durable before the old ones are deleted
+	for _, seg := range out {
+		err := seg.f.Sync()
+		if err != nil {
+			return out, nil, err
+		}
+		path := filepath.Join(s.Dir, segmentName(seg.id, segmentExt))
+		err = os.Rename(path+tmpExt, path)
+		if err != nil {
+			return out, nil, err
+		}
+		err = s.writeHints(seg)
+		if err != nil {
+			return out, nil, err
+		}
+		seg.hints = nil
+	}
+	return out, moved, syncDir(s.Dir)
+}
+
+// discard closes and removes segments written by a compaction that failed.
+func (s *Store) discard(out []*segment) {
+	for _, seg := range out {
+		seg.f.Close()
+		for _, ext := range []string{segmentExt + tmpExt, segmentExt, hintExt + tmpExt, hintExt} {
+			err := os.Remove(filepath.Join(s.Dir, segmentName(seg.id, ext)))
+			if err != nil && !os.IsNotExist(err) {
+				log.Printf("logkv: unable to remove merged segment %d: %v", seg.id, err)
+			}
+		}
+	}
+}
+
+// removeFile removes the files of dropped segments.
+var removeFile = os.Remove
+
+// dropSegment closes a segment and removes its files.
+func (s *Store) dropSegment(seg *segment) error {
+	seg.f.Close()
+	delete(s.segments, seg.id)
+	for _, ext := range []string{segmentExt, hintExt} {
+		err := removeFile(filepath.Join(s.Dir, segmentName(seg.id, ext)))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// maxID returns the highest segment id in use or in out.
+func (s *Store) maxID(out []*segment) uint32 {
+	var max uint32
+	for id := range s.segments {
+		if id > max {
+			max = id
+		}
+	}
+	for _, seg := range out {
+		if seg.id > max {
+			max = seg.id
+		}
+	}
+	return max
+}
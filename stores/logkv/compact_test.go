@@ -0,0 +1,192 @@
+package logkv_test
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tmstorm/cache/stores/logkv"
+)
+
+// dirSize returns the size of every segment in dir
+func dirSize(t *testing.T, dir string) int64 {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.seg"))
+	assert.NoError(t, err)
+	var size int64
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		assert.NoError(t, err)
+		size += info.Size()
+	}
+	return size
+}
+
+// TestLogkvCompact tests Trim drops overwritten and removed records
+func TestLogkvCompact(t *testing.T) {
+	a := assert.New(t)
+	dir := t.TempDir()
+
+	s := logkv.New(&logkv.Store{Dir: dir, SegmentSize: 512})
+	a.NotNil(s)
+	w := logkv.Get(s)
+	for round := 0; round < 5; round++ {
+		for i := 0; i < 20; i++ {
+			a.NoError(w.Write(fmt.Sprint(i), []byte(fmt.Sprint("value", round)), true))
+		}
+	}
+	for i := 10; i < 20; i++ {
+		a.NoError(w.Remove(fmt.Sprint(i)))
+	}
+
+	before := dirSize(t, dir)
+	s.Trim()
+	after := dirSize(t, dir)
+	a.Less(after, before/4)
+
+	check := func(w interface {
+		Read(string) ([]byte, error)
+		Keys() []string
+	}) {
+		a.Len(w.Keys(), 10)
+		for i := 0; i < 10; i++ {
+			v, err := w.Read(fmt.Sprint(i))
+			a.NoError(err)
+			a.Equal([]byte("value4"), v)
+		}
+	}
+	check(w)
+
+	// Nothing is left to compact
+	s.Trim()
+	a.Equal(after, dirSize(t, dir))
+
+	// Writes after compaction win over the merged records on open
+	a.NoError(w.Write("0", []byte("latest"), true))
+	a.NoError(s.Close())
+
+	s = logkv.New(&logkv.Store{Dir: dir, SegmentSize: 512})
+	a.NotNil(s)
+	defer s.Close()
+	w = logkv.Get(s)
+	v, err := w.Read("0")
+	a.NoError(err)
+	a.Equal([]byte("latest"), v)
+	a.NoError(w.Write("0", []byte("value4"), true))
+	check(w)
+}
+
+// TestLogkvTrimExpired tests Trim removes entries older than MaxAge
+func TestLogkvTrimExpired(t *testing.T) {
+	a := assert.New(t)
+	dir := t.TempDir()
+
+	s := logkv.New(&logkv.Store{Dir: dir, MaxAge: 1})
+	a.NotNil(s)
+	w := logkv.Get(s)
+	a.NoError(w.Write("old", []byte("value"), false))
+	time.Sleep(1100 * time.Millisecond)
+	a.NoError(w.Write("new", []byte("value"), false))
+
+	s.Trim()
+	_, err := w.Read("old")
+	a.Error(err)
+	_, err = w.Read("new")
+	a.NoError(err)
+	a.NoError(s.Close())
+
+	// The expired entry stays gone after reopening
+	s = logkv.New(&logkv.Store{Dir: dir, MaxAge: 1})
+	a.NotNil(s)
+	defer s.Close()
+	a.Equal([]string{"new"}, logkv.Get(s).Keys())
+}
+
+// TestLogkvCompactCrash tests a crash while merged segments are dropped does not bring back removed keys
+func TestLogkvCompactCrash(t *testing.T) {
+	a := assert.New(t)
+	dir := t.TempDir()
+
+	s := logkv.New(&logkv.Store{Dir: dir, SegmentSize: 64})
+	a.NotNil(s)
+	w := logkv.Get(s)
+	a.NoError(w.Write("gone", []byte("value"), false))
+	for i := 0; i < 5; i++ {
+		a.NoError(w.Write(fmt.Sprint("key", i), []byte("value"), true))
+	}
+	a.NoError(w.Remove("gone"))
+	a.NoError(w.Write("last", []byte("value"), false))
+
+	// Crash once the files of the first dropped segment are removed
+	removed := 0
+	restore := logkv.SetRemove(func(path string) error {
+		removed++
+		if removed > 2 {
+			return errors.New("crashed")
+		}
+		return os.Remove(path)
+	})
+	s.Trim()
+	restore()
+	s.Close()
+
+	s = logkv.New(&logkv.Store{Dir: dir, SegmentSize: 64})
+	a.NotNil(s)
+	defer s.Close()
+	w = logkv.Get(s)
+	_, err := w.Read("gone")
+	a.Error(err)
+	for i := 0; i < 5; i++ {
+		_, err = w.Read(fmt.Sprint("key", i))
+		a.NoError(err)
+	}
+}
+
+// TestLogkvCompactFailure tests a failed compaction leaves no merged segments behind
+func TestLogkvCompactFailure(t *testing.T) {
+	a := assert.New(t)
+	dir := t.TempDir()
+
+	s := logkv.New(&logkv.Store{Dir: dir, SegmentSize: 512})
+	a.NotNil(s)
+	w := logkv.Get(s)
+	// Every segment holds live records and an overwritten one
+	for i := 0; i < 40; i++ {
+		a.NoError(w.Write(fmt.Sprint(i), []byte("value"), false))
+		a.NoError(w.Write("dead", []byte(fmt.Sprint(i)), true))
+	}
+	segments, err := filepath.Glob(filepath.Join(dir, "*.seg"))
+	a.NoError(err)
+
+	// The active segment is rolled to the next id and merged segments follow it.
+	// Block the second merged segment so the first one is already written when compaction fails.
+	blocked := filepath.Join(dir, fmt.Sprintf("%010d.seg.tmp", len(segments)+3))
+	a.NoError(os.Mkdir(blocked, 0o700))
+	a.NoError(os.WriteFile(filepath.Join(blocked, "file"), nil, 0o600))
+	s.Trim()
+	a.NoError(os.RemoveAll(blocked))
+
+	tmp, err := filepath.Glob(filepath.Join(dir, "*.tmp"))
+	a.NoError(err)
+	a.Empty(tmp)
+
+	// The store keeps working and later writes win on open
+	a.NoError(w.Write("0", []byte("latest"), true))
+	s.Trim()
+	a.NoError(s.Close())
+
+	s = logkv.New(&logkv.Store{Dir: dir, SegmentSize: 512})
+	a.NotNil(s)
+	defer s.Close()
+	w = logkv.Get(s)
+	a.Len(w.Keys(), 41)
+	v, err := w.Read("0")
+	a.NoError(err)
+	a.Equal([]byte("latest"), v)
+	v, err = w.Read("39")
+	a.NoError(err)
+	a.Equal([]byte("value"), v)
+}
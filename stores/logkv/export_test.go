@@ -0,0 +1,9 @@
+package logkv
+
+// SetRemove replaces how the files of dropped segments are removed
+// and returns a function restoring the original.
+func SetRemove(f func(path string) error) (restore func()) {
+	original := removeFile
+	removeFile = f
+	return func() { removeFile = original }
+}
@@ -0,0 +1,227 @@
+// Package logkv implements an embedded log-structured store in the style of bitcask.
+// Entries are appended to segment files and found through an in-memory index of every key,
+// so each read takes a single disk access regardless of how many entries are saved.
+package logkv
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/tmstorm/cache"
+)
+
+type (
+	// Store implements cache.Store
+	// Only one Store may use a Dir at a time.
+	Store struct {
+		storeType string
+
+		// mtx guards the keydir and segments.
+		// Reads share it while writes, removes, and compaction hold it exclusively.
+		mtx      sync.RWMutex
+		keydir   map[string]entry
+		segments map[uint32]*segment
+		active   *segment
+
+		// Dir is the directory holding the segment and hint files
+		Dir string
+
+		// MaxAge is the implementation of cache.MaxAge for use during trimming old entries
+		MaxAge cache.MaxAge
+
+		// SegmentSize is the size a segment grows to before a new one is started. Defaults to 64 MiB.
+		SegmentSize int64
+
+		// Sync flushes each write to disk before it returns.
+		// Without it writes from the last moments before a crash may be lost.
+		Sync bool
+	}
+
+	// entry is where the latest record of a key is found
+	entry struct {
+		segment   uint32
+		offset    int64
+		size      uint32
+		timestamp int64
+	}
+
+	// writer is used to read, write, and remove entries
+	writer struct {
+		Store *Store
+	}
+)
+
+// New opens the store in Dir, loading the index of every key saved in it.
+// If MaxAge or Dir are not provided they will be set to their default values.
+func New(s *Store) *Store {
+	s.storeType = "logkv"
+
+	if s.Dir == "" {
+		fmt.Println("cache: a directory for the logkv store has not been provided. It will now be set to cache-logkv/ in the applications root directory.")
+		s.Dir = filepath.Clean("./cache-logkv")
+	}
+	if s.MaxAge == 0 {
+		s.MaxAge = cache.DefaultMaxAge
+	}
+	if s.SegmentSize == 0 {
+		s.SegmentSize = 64 << 20
+	}
+
+	err := os.MkdirAll(s.Dir, 0o750)
+	if err != nil {
+		fmt.Printf("cannot make logkv directory: %v\n", err)
+		return nil
+	}
+
+	s.keydir = make(map[string]entry)
+	s.segments = make(map[uint32]*segment)
+	err = s.open()
+	if err != nil {
+		s.closeSegments()
+		fmt.Printf("cannot open logkv store: %v\n", err)
+		return nil
+	}
+	return s
+}
+
+// Type returns the stores type as a string
+func (s *Store) Type() string {
+	return s.storeType
+}
+
+// Get returns a writer for the current logkv store
+func Get(s *Store) *writer {
+	var w writer
+	w.Store = s
+	return &w
+}
+
+// Write appends the value to the active segment.
+// If overwrite = true the value will be overwriten if it already exists
+func (w *writer) Write(key string, value []byte, overwrite bool) error {
+	s := w.Store
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if _, ok := s.keydir[key]; ok && !overwrite {
+		return fmt.Errorf("key already exists in logkv store: %s", key)
+	}
+	return s.append(record{timestamp: time.Now().UnixNano(), key: key, value: value})
+}
+
+// Read returns the value saved under key.
+func (w *writer) Read(key string) ([]byte, error) {
+	s := w.Store
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	e, ok := s.keydir[key]
+	if !ok {
+		return []byte{}, fmt.Errorf("key not found in logkv store: %s", key)
+	}
+	seg := s.segments[e.segment]
+	rec, _, err := readRecord(seg.f, e.offset, seg.size)
+	if err != nil {
+		return []byte{}, fmt.Errorf("unable to read %s from segment %d: %w", key, e.segment, err)
+	}
+	return rec.value, nil
+}
+
+// Remove appends a tombstone for key so it stays removed after the store is reopened.
+// Removing a key that does not exist is not an error.
+func (w *writer) Remove(key string) error {
+	s := w.Store
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if _, ok := s.keydir[key]; !ok {
+		return nil
+	}
+	return s.append(record{timestamp: time.Now().UnixNano(), flags: flagTombstone, key: key})
+}
+
+// append writes a record to the active segment and updates the keydir.
+// A new segment is started first if the record would not fit.
+func (s *Store) append(rec record) error {
+	b := rec.encode()
+	if s.active.size > 0 && s.active.size+int64(len(b)) > s.SegmentSize {
+		err := s.roll()
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err := s.active.f.WriteAt(b, s.active.size)
+	if err != nil {
+		return err
+	}
+	if s.Sync {
+		err = s.active.f.Sync()
+		if err != nil {
+			return err
+		}
+	}
+
+	h := hint{timestamp: rec.timestamp, flags: rec.flags, key: rec.key, size: uint32(len(b)), offset: s.active.size}
+	s.active.hints = append(s.active.hints, h)
+	s.active.size += int64(len(b))
+	s.apply(s.active.id, h)
+	return nil
+}
+
+// Keys returns every key in the store. The order of the returned keys is not defined.
+func (w *writer) Keys() []string {
+	s := w.Store
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	keys := make([]string, 0, len(s.keydir))
+	for key := range s.keydir {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// Purge removes every segment and starts the store empty.
+// This function should only be used when stopping the service.
+// If you need to flush the store without stopping it you can
+// call this method directly.
+func (s *Store) Purge() error {
+	log.Println("Logkv store is being purged...")
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	err := s.closeSegments()
+	if err != nil {
+		return err
+	}
+	for id := range s.segments {
+		for _, ext := range []string{segmentExt, hintExt} {
+			err = os.Remove(filepath.Join(s.Dir, segmentName(id, ext)))
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+
+	s.keydir = make(map[string]entry)
+	s.segments = make(map[uint32]*segment)
+	err = s.newActive(1)
+	if err != nil {
+		return err
+	}
+
+	log.Println("Logkv store purge complete")
+	return nil
+}
+
+// Close closes every segment file. The store cannot be used once it is closed.
+func (s *Store) Close() error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.closeSegments()
+}
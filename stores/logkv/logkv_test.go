@@ -0,0 +1,151 @@
+package logkv_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tmstorm/cache/stores/logkv"
+)
+
+// TestLogkvStore tests writing, reading, and removing entries
+func TestLogkvStore(t *testing.T) {
+	a := assert.New(t)
+
+	s := logkv.New(&logkv.Store{Dir: t.TempDir()})
+	a.NotNil(s)
+	defer s.Close()
+	a.Equal("logkv", s.Type())
+
+	w := logkv.Get(s)
+	a.NoError(w.Write("key", []byte("value"), false))
+	a.Error(w.Write("key", []byte("other"), false))
+
+	v, err := w.Read("key")
+	a.NoError(err)
+	a.Equal([]byte("value"), v)
+
+	a.NoError(w.Write("key", []byte("other"), true))
+	v, err = w.Read("key")
+	a.NoError(err)
+	a.Equal([]byte("other"), v)
+
+	a.NoError(w.Remove("key"))
+	a.NoError(w.Remove("key"))
+	_, err = w.Read("key")
+	a.Error(err)
+
+	a.NoError(w.Write("key", []byte("value"), false))
+	a.NoError(s.Purge())
+	a.Empty(w.Keys())
+}
+
+// TestLogkvReopen tests the keydir is rebuilt from hint files and segments on open
+func TestLogkvReopen(t *testing.T) {
+	a := assert.New(t)
+	dir := t.TempDir()
+
+	s := logkv.New(&logkv.Store{Dir: dir, SegmentSize: 256})
+	a.NotNil(s)
+	w := logkv.Get(s)
+	for i := 0; i < 50; i++ {
+		a.NoError(w.Write(fmt.Sprint(i), []byte(fmt.Sprint("value", i)), true))
+	}
+	a.NoError(w.Write("0", []byte("updated"), true))
+	a.NoError(w.Remove("1"))
+	a.NoError(s.Close())
+
+	hints, _ := filepath.Glob(filepath.Join(dir, "*.hint"))
+	a.NotEmpty(hints)
+
+	s = logkv.New(&logkv.Store{Dir: dir, SegmentSize: 256})
+	a.NotNil(s)
+	defer s.Close()
+	w = logkv.Get(s)
+
+	a.Len(w.Keys(), 49)
+	v, err := w.Read("0")
+	a.NoError(err)
+	a.Equal([]byte("updated"), v)
+	_, err = w.Read("1")
+	a.Error(err)
+	v, err = w.Read("49")
+	a.NoError(err)
+	a.Equal([]byte("value49"), v)
+}
+
+// TestLogkvTornWrite tests a partial record at the end of the active segment is cut off on open
+func TestLogkvTornWrite(t *testing.T) {
+	a := assert.New(t)
+	dir := t.TempDir()
+
+	s := logkv.New(&logkv.Store{Dir: dir})
+	a.NotNil(s)
+	w := logkv.Get(s)
+	a.NoError(w.Write("kept", []byte("value"), false))
+	a.NoError(w.Write("torn", []byte("value"), false))
+	a.NoError(s.Close())
+
+	path := filepath.Join(dir, "0000000001.seg")
+	info, err := os.Stat(path)
+	a.NoError(err)
+	a.NoError(os.Truncate(path, info.Size()-3))
+
+	s = logkv.New(&logkv.Store{Dir: dir})
+	a.NotNil(s)
+	defer s.Close()
+	w = logkv.Get(s)
+
+	v, err := w.Read("kept")
+	a.NoError(err)
+	a.Equal([]byte("value"), v)
+	_, err = w.Read("torn")
+	a.Error(err)
+
+	// Writes continue from the end of the last intact record
+	a.NoError(w.Write("torn", []byte("again"), false))
+	v, err = w.Read("torn")
+	a.NoError(err)
+	a.Equal([]byte("again"), v)
+}
+
+// TestLogkvCorruptLength tests a corrupt record length is not trusted before the record is read
+func TestLogkvCorruptLength(t *testing.T) {
+	a := assert.New(t)
+	dir := t.TempDir()
+
+	s := logkv.New(&logkv.Store{Dir: dir})
+	a.NotNil(s)
+	w := logkv.Get(s)
+	a.NoError(w.Write("kept", []byte("value"), false))
+	a.NoError(w.Write("lost", []byte("value"), false))
+	a.NoError(s.Close())
+
+	// Set the value length of the second record close to 2 GiB
+	path := filepath.Join(dir, "0000000001.seg")
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	a.NoError(err)
+	info, err := f.Stat()
+	a.NoError(err)
+	second := info.Size() / 2
+	_, err = f.WriteAt([]byte{0xf0, 0xff, 0xff, 0x7f}, second+17)
+	a.NoError(err)
+	a.NoError(f.Close())
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	s = logkv.New(&logkv.Store{Dir: dir})
+	runtime.ReadMemStats(&after)
+	a.NotNil(s)
+	defer s.Close()
+	a.Less(after.TotalAlloc-before.TotalAlloc, uint64(1<<20))
+
+	w = logkv.Get(s)
+	_, err = w.Read("kept")
+	a.NoError(err)
+	_, err = w.Read("lost")
+	a.Error(err)
+}
@@ -0,0 +1,144 @@
+package logkv
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"math"
+)
+
+const (
+	// recordHeaderSize is the size of a record before its key and value:
+	// crc u32 | timestamp i64 | flags u8 | key length u32 | value length u32
+	recordHeaderSize = 4 + 8 + 1 + 4 + 4
+
+	// hintHeaderSize is the size of a hint before its key:
+	// crc u32 | timestamp i64 | flags u8 | key length u32 | record size u32 | offset i64
+	hintHeaderSize = 4 + 8 + 1 + 4 + 4 + 8
+
+	// flagTombstone marks a record removing its key
+	flagTombstone = 1 << 0
+)
+
+var (
+	// errCorrupt is returned when a record or hint fails its CRC or is cut off
+	errCorrupt = errors.New("logkv: corrupt record")
+
+	// castagnoli is the CRC-32C table used for records and hints
+	castagnoli = crc32.MakeTable(crc32.Castagnoli)
+)
+
+type (
+	// record is a single write or remove appended to a segment
+	record struct {
+		timestamp int64
+		flags     byte
+		key       string
+		value     []byte
+	}
+
+	// hint describes a record without its value so the keydir can be
+	// loaded without reading the segment
+	hint struct {
+		timestamp int64
+		flags     byte
+		key       string
+		size      uint32
+		offset    int64
+	}
+)
+
+// encode returns the record as it is saved in a segment.
+func (r *record) encode() []byte {
+	b := make([]byte, recordHeaderSize+len(r.key)+len(r.value))
+	binary.LittleEndian.PutUint64(b[4:], uint64(r.timestamp))
+	b[12] = r.flags
+	binary.LittleEndian.PutUint32(b[13:], uint32(len(r.key)))
+	binary.LittleEndian.PutUint32(b[17:], uint32(len(r.value)))
+	copy(b[recordHeaderSize:], r.key)
+	copy(b[recordHeaderSize+len(r.key):], r.value)
+	binary.LittleEndian.PutUint32(b, crc32.Checksum(b[4:], castagnoli))
+	return b
+}
+
+// readRecord reads the record at offset in a segment of length end and returns it with its size.
+// errCorrupt is returned if the record fails its CRC or is cut off, and io.EOF at the end of the segment.
+func readRecord(r io.ReaderAt, offset int64, end int64) (record, uint32, error) {
+	head := make([]byte, recordHeaderSize)
+	n, err := r.ReadAt(head, offset)
+	if n == 0 && err == io.EOF {
+		return record{}, 0, io.EOF
+	}
+	if n < recordHeaderSize {
+		return record{}, 0, errCorrupt
+	}
+
+	keyLen := binary.LittleEndian.Uint32(head[13:])
+	valueLen := binary.LittleEndian.Uint32(head[17:])
+	size := uint64(recordHeaderSize) + uint64(keyLen) + uint64(valueLen)
+	// The lengths are not covered by the CRC until the record is read, so a corrupt
+	// length must not cause a large allocation
+	if size > math.MaxUint32 || offset+int64(size) > end {
+		return record{}, 0, errCorrupt
+	}
+
+	b := make([]byte, size)
+	copy(b, head)
+	n, _ = r.ReadAt(b[recordHeaderSize:], offset+recordHeaderSize)
+	if uint64(n) < size-recordHeaderSize {
+		return record{}, 0, errCorrupt
+	}
+	if binary.LittleEndian.Uint32(b) != crc32.Checksum(b[4:], castagnoli) {
+		return record{}, 0, errCorrupt
+	}
+
+	return record{
+		timestamp: int64(binary.LittleEndian.Uint64(b[4:])),
+		flags:     b[12],
+		key:       string(b[recordHeaderSize : recordHeaderSize+keyLen]),
+		value:     b[recordHeaderSize+keyLen:],
+	}, uint32(size), nil
+}
+
+// encode returns the hint as it is saved in a hint file.
+func (h *hint) encode() []byte {
+	b := make([]byte, hintHeaderSize+len(h.key))
+	binary.LittleEndian.PutUint64(b[4:], uint64(h.timestamp))
+	b[12] = h.flags
+	binary.LittleEndian.PutUint32(b[13:], uint32(len(h.key)))
+	binary.LittleEndian.PutUint32(b[17:], h.size)
+	binary.LittleEndian.PutUint64(b[21:], uint64(h.offset))
+	copy(b[hintHeaderSize:], h.key)
+	binary.LittleEndian.PutUint32(b, crc32.Checksum(b[4:], castagnoli))
+	return b
+}
+
+// decodeHints decodes every hint in a hint file.
+// errCorrupt is returned if any hint fails its CRC or is cut off.
+func decodeHints(b []byte) ([]hint, error) {
+	var hints []hint
+	for len(b) > 0 {
+		if len(b) < hintHeaderSize {
+			return nil, errCorrupt
+		}
+		keyLen := int(binary.LittleEndian.Uint32(b[13:]))
+		if len(b)-hintHeaderSize < keyLen {
+			return nil, errCorrupt
+		}
+		size := hintHeaderSize + keyLen
+		if binary.LittleEndian.Uint32(b) != crc32.Checksum(b[4:size], castagnoli) {
+			return nil, errCorrupt
+		}
+
+		hints = append(hints, hint{
+			timestamp: int64(binary.LittleEndian.Uint64(b[4:])),
+			flags:     b[12],
+			size:      binary.LittleEndian.Uint32(b[17:]),
+			offset:    int64(binary.LittleEndian.Uint64(b[21:])),
+			key:       string(b[hintHeaderSize:size]),
+		})
+		b = b[size:]
+	}
+	return hints, nil
+}
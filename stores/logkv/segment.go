@@ -0,0 +1,245 @@
+package logkv
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// segmentExt is the extension of segment files holding records
+	segmentExt = ".seg"
+
+	// hintExt is the extension of hint files describing the records of a segment
+	hintExt = ".hint"
+
+	// tmpExt is the extension of files being written by compaction.
+	// They are removed on open as they were left by a crash.
+	tmpExt = ".tmp"
+)
+
+// segment is an append-only file of records.
+// Only the active segment is written to.
+type segment struct {
+	id   uint32
+	f    *os.File
+	size int64
+
+	// hints describes the records appended to the active segment
+	// so its hint file can be written once it is full
+	hints []hint
+}
+
+// segmentName returns the file name of a segment or its hint file.
+// Ids are zero padded so the files sort in the order they were written.
+func segmentName(id uint32, ext string) string {
+	return fmt.Sprintf("%010d%s", id, ext)
+}
+
+// open loads every segment in Dir into the keydir and opens the active segment.
+// The last segment is scanned and cut off at the first record failing its CRC,
+// which is where a crash interrupted the last write.
+func (s *Store) open() error {
+	entries, err := os.ReadDir(s.Dir)
+	if err != nil {
+		return err
+	}
+
+	var ids []uint32
+	for _, entry := range entries {
+		name := entry.Name()
+		switch {
+		case strings.HasSuffix(name, tmpExt):
+			err = os.Remove(filepath.Join(s.Dir, name))
+			if err != nil {
+				return err
+			}
+		case strings.HasSuffix(name, segmentExt):
+			id, err := strconv.ParseUint(strings.TrimSuffix(name, segmentExt), 10, 32)
+			if err != nil {
+				continue
+			}
+			ids = append(ids, uint32(id))
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	for i, id := range ids {
+		err = s.loadSegment(id, i == len(ids)-1)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(ids) == 0 {
+		return s.newActive(1)
+	}
+	s.active = s.segments[ids[len(ids)-1]]
+	return nil
+}
+
+// loadSegment adds the records of a segment to the keydir.
+// The hint file is used if it is intact, otherwise the segment is scanned.
+func (s *Store) loadSegment(id uint32, active bool) error {
+	f, err := os.OpenFile(filepath.Join(s.Dir, segmentName(id, segmentExt)), os.O_RDWR, 0o600)
+	if err != nil {
+		return err
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	seg := &segment{id: id, f: f, size: stat.Size()}
+	s.segments[id] = seg
+
+	if !active {
+		b, err := os.ReadFile(filepath.Join(s.Dir, segmentName(id, hintExt)))
+		if err == nil {
+			hints, err := decodeHints(b)
+			if err == nil {
+				for _, h := range hints {
+					s.apply(id, h)
+				}
+				return nil
+			}
+			log.Printf("logkv: ignoring corrupt hint file of segment %d: %v", id, err)
+		}
+	}
+
+	var offset int64
+	for {
+		rec, size, err := readRecord(f, offset, seg.size)
+		if err == io.EOF {
+			break
+		}
+		if errors.Is(err, errCorrupt) {
+			if !active {
+				log.Printf("logkv: segment %d is corrupt after offset %d, ignoring the rest of it", id, offset)
+				break
+			}
+			log.Printf("logkv: truncating segment %d at offset %d after an interrupted write", id, offset)
+			err = f.Truncate(offset)
+			if err != nil {
+				return err
+			}
+			seg.size = offset
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		h := hint{timestamp: rec.timestamp, flags: rec.flags, key: rec.key, size: size, offset: offset}
+		s.apply(id, h)
+		seg.hints = append(seg.hints, h)
+		offset += int64(size)
+	}
+
+	// Save a hint file so the segment does not need to be scanned again
+	if !active {
+		err = s.writeHints(seg)
+		seg.hints = nil
+	}
+	return err
+}
+
+// apply updates the keydir with a record in a segment.
+func (s *Store) apply(id uint32, h hint) {
+	if h.flags&flagTombstone != 0 {
+		delete(s.keydir, h.key)
+		return
+	}
+	s.keydir[h.key] = entry{segment: id, offset: h.offset, size: h.size, timestamp: h.timestamp}
+}
+
+// newActive creates an empty segment with the given id and makes it the active segment.
+func (s *Store) newActive(id uint32) error {
+	f, err := os.OpenFile(filepath.Join(s.Dir, segmentName(id, segmentExt)), os.O_RDWR|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	s.active = &segment{id: id, f: f}
+	s.segments[id] = s.active
+	return nil
+}
+
+// roll seals the active segment and starts a new one.
+func (s *Store) roll() error {
+	err := s.seal(s.active)
+	if err != nil {
+		return err
+	}
+	return s.newActive(s.active.id + 1)
+}
+
+// seal flushes a segment that will no longer be written to and saves its hint file.
+func (s *Store) seal(seg *segment) error {
+	err := seg.f.Sync()
+	if err != nil {
+		return err
+	}
+	err = s.writeHints(seg)
+	if err != nil {
+		return err
+	}
+	seg.hints = nil
+	return nil
+}
+
+// writeHints saves the hint file of a segment.
+// It is written to a temporary file first so a crash cannot leave a partial hint file.
+func (s *Store) writeHints(seg *segment) error {
+	var b []byte
+	for _, h := range seg.hints {
+		b = append(b, h.encode()...)
+	}
+	return writeFile(filepath.Join(s.Dir, segmentName(seg.id, hintExt)), b)
+}
+
+// writeFile atomically replaces the file at path with b.
+func writeFile(path string, b []byte) error {
+	f, err := os.OpenFile(path+tmpExt, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(b)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(path+tmpExt, path)
+}
+
+// syncDir makes the files created or renamed in dir durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = d.Sync()
+	if closeErr := d.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// closeSegments closes every segment file.
+func (s *Store) closeSegments() error {
+	var errs []error
+	for _, seg := range s.segments {
+		errs = append(errs, seg.f.Close())
+	}
+	return errors.Join(errs...)
+}
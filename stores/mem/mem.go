@@ -17,6 +17,20 @@ type (
 
 		// MaxAge is the implementation of cache.MaxAge for use during trimming old key-value pairs
 		MaxAge cache.MaxAge
+
+		// Compression enables compression at rest for values at or above the compression threshold.
+		// Values are saved with the name of the compressor used so Read can decompress them,
+		// and values saved without compression can still be read.
+		Compression *cache.Compression
+
+		// TrimBudget limits the work done by each call to Trim.
+		// Each call continues checking the keys left by the previous one.
+		// If nil every key is checked on each call.
+		TrimBudget *cache.TrimBudget
+
+		// trimKeys holds the keys left to check in the current budgeted trim
+		trimKeys []interface{}
+		trimMtx  sync.Mutex
 	}
 
 	// writer is used to read, write, and remove key-value pairs
@@ -26,9 +40,11 @@ type (
 
 	// value is used to save in a key value store.
 	// Using the value passed and setting time saved.
+	// codec is the name of the compressor used on the value or empty if it is not compressed.
 	valueStore struct {
 		value     []byte
 		timeStamp time.Time
+		codec     string
 	}
 )
 
@@ -62,10 +78,16 @@ func Get(s *Store) *writer {
 // Write adds a new key-value pair in memory
 // If overwrite = true data will be overwriten if it alreay exists
 func (w *writer) Write(key string, value []byte, overwrite bool) error {
+	value, codec, err := w.Store.Compression.Compress(value)
+	if err != nil {
+		return err
+	}
+
 	if !overwrite {
 		_, ok := w.Store.data.LoadOrStore(key, &valueStore{
 			value:     value,
 			timeStamp: time.Now(),
+			codec:     codec,
 		})
 		if ok {
 			err := fmt.Errorf("key already exists in memory store: %s", key)
@@ -75,6 +97,7 @@ func (w *writer) Write(key string, value []byte, overwrite bool) error {
 		w.Store.data.Store(key, &valueStore{
 			value:     value,
 			timeStamp: time.Now(),
+			codec:     codec,
 		})
 	}
 	return nil
@@ -87,7 +110,12 @@ func (w *writer) Read(key string) ([]byte, error) {
 		err := fmt.Errorf("key not found in memory store: %s", key)
 		return []byte{}, err
 	}
-	return value.(*valueStore).value, nil
+
+	stored := value.(*valueStore)
+	if stored.codec != "" {
+		return w.Store.Compression.Decompress(stored.codec, stored.value)
+	}
+	return stored.value, nil
 }
 
 // Remove deletes a key-value pair from in memory store
@@ -107,10 +135,15 @@ func (w *writer) Remove(key string) error {
 func (s *Store) Trim() {
 	log.Println("Starting file store trimming...")
 
+	if s.TrimBudget != nil {
+		s.trimBudgeted()
+		log.Println("File store trimming complete")
+		return
+	}
+
 	s.data.Range(func(key interface{}, stored interface{}) bool {
-		age := stored.(*valueStore).timeStamp.Add(time.Second * time.Duration(s.MaxAge))
-		if time.Now().Local().After(age) {
-			s.data.Delete(key)
+		if s.expired(stored.(*valueStore)) {
+			s.data.CompareAndDelete(key, stored)
 		}
 		return true
 	})
@@ -118,6 +151,41 @@ func (s *Store) Trim() {
 	log.Println("File store trimming complete")
 }
 
+// trimBudgeted checks keys until the TrimBudget is used up.
+// The keys are captured when a pass starts and keys added during
+// the pass are checked by the next one.
+func (s *Store) trimBudgeted() {
+	s.trimMtx.Lock()
+	defer s.trimMtx.Unlock()
+
+	if len(s.trimKeys) == 0 {
+		s.data.Range(func(key interface{}, _ interface{}) bool {
+			s.trimKeys = append(s.trimKeys, key)
+			return true
+		})
+	}
+
+	start := time.Now()
+	checked := 0
+	for len(s.trimKeys) > 0 && !s.TrimBudget.Exhausted(start, checked) {
+		key := s.trimKeys[0]
+		s.trimKeys[0] = nil
+		s.trimKeys = s.trimKeys[1:]
+		checked++
+
+		stored, ok := s.data.Load(key)
+		if ok && s.expired(stored.(*valueStore)) {
+			s.data.CompareAndDelete(key, stored)
+		}
+	}
+}
+
+// expired reports whether a value has reached the MaxAge of the store.
+func (s *Store) expired(v *valueStore) bool {
+	age := v.timeStamp.Add(time.Second * time.Duration(s.MaxAge))
+	return time.Now().Local().After(age)
+}
+
 // Purge clears the entire in-memory store.
 // This function should only be used when stopping the service.
 // If you need to flush the store without stopping it you can
@@ -131,6 +199,10 @@ func (s *Store) Purge() error {
 		return true
 	})
 
+	s.trimMtx.Lock()
+	s.trimKeys = nil
+	s.trimMtx.Unlock()
+
 	log.Println("In-memory store purge complete")
 	return nil
 }
@@ -1,8 +1,10 @@
 package mem_test
 
 import (
+	"compress/gzip"
 	"crypto/rand"
 	"encoding/base32"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -122,3 +124,76 @@ func randString(length int) (string, error) {
 	}
 	return base32.StdEncoding.EncodeToString(randBytes)[:length], nil
 }
+
+// TestMemCompression tests compressed values in the in-memory storage
+func TestMemCompression(t *testing.T) {
+	a := assert.New(t)
+
+	memStore := mem.New(&mem.Store{
+		MaxAge: 20,
+	})
+	m := mem.Get(memStore)
+
+	// Values saved before compression was enabled
+	small := []byte("small value")
+	large := []byte(strings.Repeat(`{"name":"value","list":[1,2,3]}`, 100))
+	a.NoError(m.Write("before", large, false))
+
+	memStore.Compression = &cache.Compression{
+		Compressor: cache.Gzip(gzip.BestCompression),
+		Threshold:  64,
+	}
+	a.NoError(m.Write("small", small, false))
+	a.NoError(m.Write("large", large, false))
+
+	for key, want := range map[string][]byte{"before": large, "small": small, "large": large} {
+		v, err := m.Read(key)
+		a.NoError(err)
+		a.Equal(want, v)
+	}
+}
+
+// TestMemTrimBudget tests trimming is spread over several calls
+func TestMemTrimBudget(t *testing.T) {
+	a := assert.New(t)
+
+	memStore := mem.New(&mem.Store{
+		MaxAge:     1,
+		TrimBudget: &cache.TrimBudget{MaxEntries: 4},
+	})
+	m := mem.Get(memStore)
+
+	keys := make([]string, 10)
+	for i := range keys {
+		keys[i] = strings.Repeat("k", i+1)
+		a.NoError(m.Write(keys[i], []byte("value"), false))
+	}
+	time.Sleep(time.Millisecond * 1100)
+
+	// remaining counts the keys that have not been trimmed
+	remaining := func() int {
+		n := 0
+		for _, key := range keys {
+			if _, err := m.Read(key); err == nil {
+				n++
+			}
+		}
+		return n
+	}
+
+	// Each call checks at most four keys and continues where the last one stopped
+	memStore.Trim()
+	a.Equal(6, remaining())
+	memStore.Trim()
+	a.Equal(2, remaining())
+	memStore.Trim()
+	a.Equal(0, remaining())
+
+	// A new pass starts once every key has been checked
+	a.NoError(m.Write("fresh", []byte("value"), false))
+	memStore.Trim()
+	_, err := m.Read("fresh")
+	a.NoError(err)
+
+	a.NoError(memStore.Purge())
+}
@@ -0,0 +1,91 @@
+package mem
+
+import (
+	"errors"
+	"log"
+	"strings"
+)
+
+// namespaceSeparator ends the prefix of every key in a namespace.
+// Namespace names cannot hold it, so no namespace can reach the keys of another.
+const namespaceSeparator = "\x00"
+
+// namespaceWriter is used to read, write, and remove key-value pairs of one namespace.
+// Keys are saved in the store prefixed with the name of the namespace.
+type namespaceWriter struct {
+	Store  *Store
+	prefix string
+}
+
+// GetNamespace returns a writer limited to the keys of the named namespace,
+// so several tenants can share a store without seeing each others keys.
+// The name must not be empty or hold a NUL byte.
+func GetNamespace(s *Store, name string) (*namespaceWriter, error) {
+	if name == "" || strings.Contains(name, namespaceSeparator) {
+		return nil, errors.New("namespace names must not be empty or hold a NUL byte")
+	}
+	return &namespaceWriter{Store: s, prefix: name + namespaceSeparator}, nil
+}
+
+// Name returns the name of the namespace
+func (w *namespaceWriter) Name() string {
+	return strings.TrimSuffix(w.prefix, namespaceSeparator)
+}
+
+// Write adds a new key-value pair to the namespace
+// If overwrite = true data will be overwriten if it alreay exists
+func (w *namespaceWriter) Write(key string, value []byte, overwrite bool) error {
+	return Get(w.Store).Write(w.prefix+key, value, overwrite)
+}
+
+// Read gets a key-value pair from the namespace
+func (w *namespaceWriter) Read(key string) ([]byte, error) {
+	return Get(w.Store).Read(w.prefix + key)
+}
+
+// Remove deletes a key-value pair from the namespace
+func (w *namespaceWriter) Remove(key string) error {
+	return Get(w.Store).Remove(w.prefix + key)
+}
+
+// Keys returns every key in the namespace without its prefix.
+// The order of the returned keys is not defined.
+func (w *namespaceWriter) Keys() []string {
+	var keys []string
+	w.each(func(key string, _ *valueStore) {
+		keys = append(keys, strings.TrimPrefix(key, w.prefix))
+	})
+	return keys
+}
+
+// Trim removes the key-value pairs of the namespace older than the stores MaxAge.
+func (w *namespaceWriter) Trim() {
+	log.Printf("Starting in-memory namespace %s trimming...", w.Name())
+	w.each(func(key string, stored *valueStore) {
+		if w.Store.expired(stored) {
+			w.Store.data.CompareAndDelete(key, stored)
+		}
+	})
+	log.Printf("In-memory namespace %s trimming complete", w.Name())
+}
+
+// Purge removes every key-value pair of the namespace.
+// The rest of the store is left as it is.
+func (w *namespaceWriter) Purge() error {
+	log.Printf("In-memory namespace %s is being purged...", w.Name())
+	w.each(func(key string, _ *valueStore) {
+		w.Store.data.Delete(key)
+	})
+	log.Printf("In-memory namespace %s purge complete", w.Name())
+	return nil
+}
+
+// each calls fn for every key-value pair in the namespace.
+func (w *namespaceWriter) each(fn func(key string, stored *valueStore)) {
+	w.Store.data.Range(func(key interface{}, stored interface{}) bool {
+		if k, ok := key.(string); ok && strings.HasPrefix(k, w.prefix) {
+			fn(k, stored.(*valueStore))
+		}
+		return true
+	})
+}
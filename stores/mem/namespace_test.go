@@ -0,0 +1,55 @@
+package mem_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tmstorm/cache/stores/mem"
+)
+
+// TestMemNamespace tests namespaces keep their keys apart and can be trimmed and purged alone
+func TestMemNamespace(t *testing.T) {
+	a := assert.New(t)
+	memStore := mem.New(&mem.Store{MaxAge: 1})
+
+	_, err := mem.GetNamespace(memStore, "")
+	a.Error(err)
+	_, err = mem.GetNamespace(memStore, "a\x00b")
+	a.Error(err)
+
+	tenantA, err := mem.GetNamespace(memStore, "a")
+	a.NoError(err)
+	a.Equal("a", tenantA.Name())
+	tenantB, err := mem.GetNamespace(memStore, "b")
+	a.NoError(err)
+
+	a.NoError(tenantA.Write("key", []byte("a"), false))
+	a.NoError(tenantB.Write("key", []byte("b"), false))
+
+	v, err := tenantA.Read("key")
+	a.NoError(err)
+	a.Equal([]byte("a"), v)
+	v, err = tenantB.Read("key")
+	a.NoError(err)
+	a.Equal([]byte("b"), v)
+	_, err = mem.Get(memStore).Read("key")
+	a.Error(err)
+
+	// Trimming one namespace leaves the others alone
+	a.NoError(mem.Get(memStore).Write("plain", []byte("value"), false))
+	time.Sleep(1100 * time.Millisecond)
+	a.NoError(tenantA.Write("new", []byte("a"), false))
+	tenantA.Trim()
+	a.Equal([]string{"new"}, tenantA.Keys())
+	a.Equal([]string{"key"}, tenantB.Keys())
+	_, err = mem.Get(memStore).Read("plain")
+	a.NoError(err)
+
+	a.NoError(tenantB.Purge())
+	a.Empty(tenantB.Keys())
+	a.Equal([]string{"new"}, tenantA.Keys())
+
+	a.NoError(tenantA.Remove("new"))
+	a.Empty(tenantA.Keys())
+}
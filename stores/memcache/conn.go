@@ -0,0 +1,101 @@
+package memcache
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+type (
+	// conn is a single connection to a server
+	conn struct {
+		net.Conn
+		r *bufio.Reader
+		w *bufio.Writer
+	}
+
+	// pool keeps idle connections to each server so they can be reused
+	pool struct {
+		mtx    sync.Mutex
+		idle   map[string][]*conn
+		closed bool
+	}
+)
+
+// errClosed is returned once the store has been closed.
+var errClosed = errors.New("memcache: store is closed")
+
+// getConn returns an idle connection to server or dials a new one.
+func (s *Store) getConn(server string) (*conn, error) {
+	s.pool.mtx.Lock()
+	if s.pool.closed {
+		s.pool.mtx.Unlock()
+		return nil, errClosed
+	}
+	if idle := s.pool.idle[server]; len(idle) > 0 {
+		c := idle[len(idle)-1]
+		s.pool.idle[server] = idle[:len(idle)-1]
+		s.pool.mtx.Unlock()
+		return c, nil
+	}
+	s.pool.mtx.Unlock()
+
+	nc, err := net.DialTimeout("tcp", server, s.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	return &conn{Conn: nc, r: bufio.NewReader(nc), w: bufio.NewWriter(nc)}, nil
+}
+
+// putConn returns a connection to the pool.
+// Connections that failed with anything other than a reply are closed
+// as the replies left on them can no longer be matched to their commands.
+func (s *Store) putConn(server string, c *conn, err error) {
+	var reply *Error
+	if err != nil && !errors.As(err, &reply) && !errors.Is(err, errNotStored) {
+		c.Close()
+		return
+	}
+
+	s.pool.mtx.Lock()
+	defer s.pool.mtx.Unlock()
+	if s.pool.closed || len(s.pool.idle[server]) >= s.PoolSize {
+		c.Close()
+		return
+	}
+	s.pool.idle[server] = append(s.pool.idle[server], c)
+}
+
+// withConn runs fn on a pooled connection to server.
+func (s *Store) withConn(server string, fn func(c *conn) error) error {
+	c, err := s.getConn(server)
+	if err != nil {
+		return err
+	}
+	if s.Timeout > 0 {
+		err = c.SetDeadline(time.Now().Add(s.Timeout))
+	}
+	if err == nil {
+		err = fn(c)
+	}
+	s.putConn(server, c, err)
+	return err
+}
+
+// Close closes every idle connection. The store cannot be used once it is closed.
+func (s *Store) Close() error {
+	s.pool.mtx.Lock()
+	defer s.pool.mtx.Unlock()
+
+	s.pool.closed = true
+	var errs []error
+	for _, idle := range s.pool.idle {
+		for _, c := range idle {
+			errs = append(errs, c.Close())
+		}
+	}
+	s.pool.idle = nil
+	return errors.Join(errs...)
+}
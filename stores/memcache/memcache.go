@@ -0,0 +1,196 @@
+// Package memcache implements a store saving entries on one or more memcached servers.
+// Keys are spread across the servers with consistent hashing.
+package memcache
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/tmstorm/cache"
+)
+
+type (
+	// Store implements cache.Store
+	Store struct {
+		storeType string
+		ring      *ring
+		pool      pool
+
+		// Servers are the addresses of the memcached servers, e.g. localhost:11211
+		Servers []string
+
+		// Protocol is the protocol used to talk to the servers. Defaults to Text.
+		Protocol Protocol
+
+		// Replicas is how many points each server has on the hash ring. Defaults to 160.
+		Replicas int
+
+		// PoolSize is the most idle connections kept for reuse per server. Defaults to 4.
+		PoolSize int
+
+		// Timeout limits dialing and each command. Zero does not limit them.
+		Timeout time.Duration
+
+		// Flush lets Purge run flush_all on every server, which also removes items saved by other clients.
+		// Purge does nothing unless it is set, so servers shared with others are never flushed.
+		Flush bool
+
+		// MaxAge is the implementation of cache.MaxAge.
+		// It is set as the expiration time of each entry so the servers expire them.
+		MaxAge cache.MaxAge
+	}
+
+	// writer is used to read, write, and remove entries
+	writer struct {
+		Store *Store
+	}
+)
+
+// New initializes a new store using the given servers.
+// Connections are opened when they are first needed.
+func New(s *Store) *Store {
+	s.storeType = "memcache"
+
+	if len(s.Servers) == 0 {
+		fmt.Println("cache: no servers have been provided for the memcache store.")
+		return nil
+	}
+	if s.Replicas == 0 {
+		s.Replicas = 160
+	}
+	if s.PoolSize == 0 {
+		s.PoolSize = 4
+	}
+	if s.MaxAge == 0 {
+		s.MaxAge = cache.DefaultMaxAge
+	}
+
+	s.ring = newRing(s.Servers, s.Replicas)
+	s.pool.idle = make(map[string][]*conn)
+	return s
+}
+
+// Type returns the stores type as a string
+func (s *Store) Type() string {
+	return s.storeType
+}
+
+// Get returns a writer for the current memcache store
+func Get(s *Store) *writer {
+	var w writer
+	w.Store = s
+	return &w
+}
+
+// Server returns the address of the server the key is saved on.
+func (s *Store) Server(key string) string {
+	return s.ring.server(key)
+}
+
+// Write saves the value under key with an expiration time of MaxAge.
+// If overwrite = true the value will be overwriten if it already exists
+func (w *writer) Write(key string, value []byte, overwrite bool) error {
+	return w.WriteTTL(key, value, time.Second*time.Duration(w.Store.MaxAge), overwrite)
+}
+
+// WriteTTL saves the value under key with its own TTL instead of MaxAge.
+// TTLs are rounded up to whole seconds. A TTL of zero or less saves a value that does not expire.
+// If overwrite = true the value will be overwriten if it already exists
+func (w *writer) WriteTTL(key string, value []byte, ttl time.Duration, overwrite bool) error {
+	s := w.Store
+	err := s.Protocol.checkKey(key)
+	if err != nil {
+		return err
+	}
+
+	err = s.withConn(s.ring.server(key), func(c *conn) error {
+		return s.Protocol.set(c, key, value, expiration(ttl), overwrite)
+	})
+	if errors.Is(err, errNotStored) {
+		return fmt.Errorf("key already exists in memcache store: %s", key)
+	}
+	return err
+}
+
+// Read returns the value saved under key.
+func (w *writer) Read(key string) ([]byte, error) {
+	s := w.Store
+	err := s.Protocol.checkKey(key)
+	if err != nil {
+		return []byte{}, err
+	}
+
+	var value []byte
+	var found bool
+	err = s.withConn(s.ring.server(key), func(c *conn) error {
+		value, found, err = s.Protocol.get(c, key)
+		return err
+	})
+	if err != nil {
+		return []byte{}, err
+	}
+	if !found {
+		return []byte{}, fmt.Errorf("key not found in memcache store: %s", key)
+	}
+	return value, nil
+}
+
+// Remove deletes the value saved under key.
+// Removing a key that does not exist is not an error.
+func (w *writer) Remove(key string) error {
+	s := w.Store
+	err := s.Protocol.checkKey(key)
+	if err != nil {
+		return err
+	}
+
+	return s.withConn(s.ring.server(key), func(c *conn) error {
+		return s.Protocol.delete(c, key)
+	})
+}
+
+// expiration returns the expiration time sent for a TTL.
+// TTLs longer than 30 days are sent as a unix timestamp,
+// and TTLs of zero or less as 0 which memcached never expires.
+func expiration(ttl time.Duration) int64 {
+	if ttl <= 0 {
+		return 0
+	}
+	seconds := int64((ttl + time.Second - 1) / time.Second)
+	if seconds > maxRelativeTTL {
+		return time.Now().Add(ttl).Unix()
+	}
+	return seconds
+}
+
+// Trim does nothing as the servers expire entries by their expiration time.
+// It is called by the caches trim worker.
+func (s *Store) Trim() {}
+
+// Purge removes every item on every server with flush_all if Flush is set.
+// This also removes items saved by other clients of the servers.
+// Memcached cannot list keys, so without Flush nothing is removed.
+// This function should only be used when stopping the service.
+// If you need to flush the store without stopping it you can
+// call this method directly.
+func (s *Store) Purge() error {
+	if !s.Flush {
+		log.Println("Memcache store does not flush its servers, skipping purge")
+		return nil
+	}
+	log.Println("Memcache store is being purged...")
+
+	var errs []error
+	for _, server := range s.Servers {
+		errs = append(errs, s.withConn(server, flushAll))
+	}
+	err := errors.Join(errs...)
+	if err != nil {
+		return err
+	}
+
+	log.Println("Memcache store purge complete")
+	return nil
+}
@@ -0,0 +1,143 @@
+package memcache_test
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tmstorm/cache"
+	"github.com/tmstorm/cache/stores/memcache"
+)
+
+// TestMemcacheStore tests the store against fake servers with both protocols
+func TestMemcacheStore(t *testing.T) {
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(io.Discard)
+
+	for _, protocol := range []memcache.Protocol{memcache.Text, memcache.Meta} {
+		a := assert.New(t)
+		servers := map[string]*fakeServer{}
+		var addrs []string
+		for range 3 {
+			srv := newFakeServer(t)
+			servers[srv.Addr()] = srv
+			addrs = append(addrs, srv.Addr())
+		}
+
+		mcStore := memcache.New(&memcache.Store{
+			Servers:  addrs,
+			Protocol: protocol,
+			MaxAge:   20,
+		})
+		a.NotNil(mcStore)
+		a.Equal("memcache", mcStore.Type())
+		a.NotZero(len(cache.MakeStores(mcStore)))
+		m := memcache.Get(mcStore)
+
+		a.NoError(m.Write("logo", []byte("<svg></svg>"), false))
+		b, err := m.Read("logo")
+		a.NoError(err)
+		a.Equal("<svg></svg>", string(b))
+
+		// Existing keys are only replaced when overwriting
+		a.Error(m.Write("logo", []byte("other"), false))
+		a.NoError(m.Write("logo", []byte("<svg>new</svg>"), true))
+		b, err = m.Read("logo")
+		a.NoError(err)
+		a.Equal("<svg>new</svg>", string(b))
+
+		// Entries are saved with their expiration time
+		item, ok := servers[mcStore.Server("logo")].Item("logo")
+		a.True(ok)
+		a.Equal(int64(20), item.exp)
+		a.NoError(m.WriteTTL("short", []byte("short"), time.Millisecond*1500, true))
+		item, _ = servers[mcStore.Server("short")].Item("short")
+		a.Equal(int64(2), item.exp)
+		a.NoError(m.WriteTTL("long", []byte("long"), time.Hour*24*60, true))
+		item, _ = servers[mcStore.Server("long")].Item("long")
+		a.Greater(item.exp, time.Now().Unix())
+		a.NoError(m.WriteTTL("forever", []byte("forever"), 0, true))
+		item, _ = servers[mcStore.Server("forever")].Item("forever")
+		a.Zero(item.exp)
+		a.NoError(m.WriteTTL("forever", []byte("forever"), -time.Second, true))
+		item, _ = servers[mcStore.Server("forever")].Item("forever")
+		a.Zero(item.exp)
+		a.NoError(m.WriteTTL("tiny", []byte("tiny"), time.Nanosecond, true))
+		item, _ = servers[mcStore.Server("tiny")].Item("tiny")
+		a.Equal(int64(1), item.exp)
+
+		a.NoError(m.Remove("logo"))
+		_, err = m.Read("logo")
+		a.Error(err)
+		a.NoError(m.Remove("logo"))
+
+		// Only the meta protocol can send keys with spaces
+		err = m.Write("has space", []byte("value"), true)
+		if protocol == memcache.Meta {
+			a.NoError(err)
+			b, err = m.Read("has space")
+			a.NoError(err)
+			a.Equal("value", string(b))
+		} else {
+			a.Error(err)
+		}
+
+		// Empty keys are rejected by both protocols
+		a.Error(m.Write("", []byte("value"), true))
+		_, err = m.Read("")
+		a.Error(err)
+
+		// Keys are spread across every server and saved only on their own
+		for i := range 300 {
+			key := fmt.Sprintf("key-%d", i)
+			a.NoError(m.Write(key, []byte(key), true))
+			_, ok := servers[mcStore.Server(key)].Item(key)
+			a.True(ok, key)
+		}
+		for _, srv := range servers {
+			a.Greater(srv.Len(), 50)
+			a.Equal(int32(1), srv.conns.Load())
+		}
+
+		// Purge only flushes the servers when allowed to
+		a.NoError(mcStore.Purge())
+		for _, srv := range servers {
+			a.NotZero(srv.Len())
+		}
+		mcStore.Flush = true
+		a.NoError(mcStore.Purge())
+		for _, srv := range servers {
+			a.Zero(srv.Len())
+		}
+
+		a.NoError(mcStore.Close())
+		a.Error(m.Write("closed", []byte("closed"), true))
+	}
+}
+
+// TestConsistentHashing tests removing a server only moves the keys it owned
+func TestConsistentHashing(t *testing.T) {
+	a := assert.New(t)
+	servers := []string{"10.0.0.1:11211", "10.0.0.2:11211", "10.0.0.3:11211", "10.0.0.4:11211"}
+	all := memcache.New(&memcache.Store{Servers: servers})
+	fewer := memcache.New(&memcache.Store{Servers: servers[:3]})
+
+	moved := 0
+	for i := range 10000 {
+		key := fmt.Sprintf("key-%d", i)
+		before, after := all.Server(key), fewer.Server(key)
+		if before != servers[3] {
+			a.Equal(before, after, key)
+		} else {
+			moved++
+		}
+	}
+
+	// Roughly a quarter of the keys were owned by the removed server
+	a.InDelta(2500, moved, 500)
+
+	a.Nil(memcache.New(&memcache.Store{}))
+}
@@ -0,0 +1,229 @@
+package memcache
+
+import (
+	"bufio"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Protocol selects the memcached protocol used to talk to the servers.
+type Protocol int
+
+const (
+	// Text uses the classic text protocol supported by every memcached version.
+	Text Protocol = iota
+
+	// Meta uses the meta protocol added in memcached 1.6.
+	// Keys are sent base64 encoded so they may hold any bytes.
+	Meta
+)
+
+// maxKeyLen is the longest key memcached accepts.
+const maxKeyLen = 250
+
+// maxRelativeTTL is the longest TTL sent as seconds. memcached treats
+// larger values as a unix timestamp.
+const maxRelativeTTL = 60 * 60 * 24 * 30
+
+// Error is an error reply sent by the server, e.g. "SERVER_ERROR out of memory".
+// The connection can still be used after an error reply.
+type Error struct {
+	Reply string
+}
+
+func (e *Error) Error() string {
+	return "memcache: " + e.Reply
+}
+
+// errNotStored is returned by set when the key exists and overwrite is false.
+var errNotStored = errors.New("not stored")
+
+// checkKey returns an error if the key cannot be sent with the protocol.
+func (p Protocol) checkKey(key string) error {
+	if len(key) == 0 {
+		return errors.New("key must not be empty for memcache store")
+	}
+	if p == Meta {
+		if base64.StdEncoding.EncodedLen(len(key)) > maxKeyLen {
+			return fmt.Errorf("key is too long for memcache store: %d bytes", len(key))
+		}
+		return nil
+	}
+
+	if len(key) > maxKeyLen {
+		return fmt.Errorf("key must be between 1 and %d bytes for memcache store: %d bytes", maxKeyLen, len(key))
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] <= ' ' || key[i] == 0x7f {
+			return fmt.Errorf("key contains spaces or control characters: %q", key)
+		}
+	}
+	return nil
+}
+
+// set saves value under key. It returns errNotStored if overwrite is false and the key exists.
+func (p Protocol) set(c *conn, key string, value []byte, ttl int64, overwrite bool) error {
+	if p == Meta {
+		mode := "S"
+		if !overwrite {
+			mode = "E"
+		}
+		fmt.Fprintf(c.w, "ms %s %d b T%d M%s\r\n", encodeKey(key), len(value), ttl, mode)
+	} else {
+		cmd := "set"
+		if !overwrite {
+			cmd = "add"
+		}
+		fmt.Fprintf(c.w, "%s %s 0 %d %d\r\n", cmd, key, ttl, len(value))
+	}
+	c.w.Write(value)
+	c.w.WriteString("\r\n")
+	err := c.w.Flush()
+	if err != nil {
+		return err
+	}
+
+	line, err := readLine(c.r)
+	if err != nil {
+		return err
+	}
+	switch line {
+	case "STORED", "HD":
+		return nil
+	case "NOT_STORED", "NS":
+		return errNotStored
+	}
+	return replyError(line)
+}
+
+// get returns the value saved under key and whether it was found.
+func (p Protocol) get(c *conn, key string) ([]byte, bool, error) {
+	if p == Meta {
+		fmt.Fprintf(c.w, "mg %s b v\r\n", encodeKey(key))
+	} else {
+		fmt.Fprintf(c.w, "get %s\r\n", key)
+	}
+	err := c.w.Flush()
+	if err != nil {
+		return nil, false, err
+	}
+
+	var value []byte
+	found := false
+	for {
+		line, err := readLine(c.r)
+		if err != nil {
+			return nil, false, err
+		}
+
+		fields := strings.Fields(line)
+		switch {
+		case line == "END" || line == "EN":
+			return value, found, nil
+		case len(fields) >= 4 && fields[0] == "VALUE":
+			// VALUE <key> <flags> <bytes> is followed by more values and END
+			value, err = readData(c.r, fields[3])
+			found = true
+		case len(fields) >= 2 && fields[0] == "VA":
+			// VA <bytes> <flags> is the only reply to mg
+			value, err = readData(c.r, fields[1])
+			return value, true, err
+		default:
+			return nil, false, replyError(line)
+		}
+		if err != nil {
+			return nil, false, err
+		}
+	}
+}
+
+// delete removes key. Removing a key that does not exist is not an error.
+func (p Protocol) delete(c *conn, key string) error {
+	if p == Meta {
+		fmt.Fprintf(c.w, "md %s b\r\n", encodeKey(key))
+	} else {
+		fmt.Fprintf(c.w, "delete %s\r\n", key)
+	}
+	err := c.w.Flush()
+	if err != nil {
+		return err
+	}
+
+	line, err := readLine(c.r)
+	if err != nil {
+		return err
+	}
+	switch line {
+	case "DELETED", "NOT_FOUND", "HD", "NF":
+		return nil
+	}
+	return replyError(line)
+}
+
+// flushAll removes every item on the server.
+// Both protocols use flush_all as the meta protocol has no equivalent.
+func flushAll(c *conn) error {
+	_, err := c.w.WriteString("flush_all\r\n")
+	if err == nil {
+		err = c.w.Flush()
+	}
+	if err != nil {
+		return err
+	}
+
+	line, err := readLine(c.r)
+	if err != nil {
+		return err
+	}
+	if line != "OK" {
+		return replyError(line)
+	}
+	return nil
+}
+
+// encodeKey encodes a key for the meta protocols b flag.
+func encodeKey(key string) string {
+	return base64.StdEncoding.EncodeToString([]byte(key))
+}
+
+// readLine reads a line terminated by CRLF without the terminator.
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasSuffix(line, "\r\n") {
+		return "", fmt.Errorf("memcache: malformed reply %q", line)
+	}
+	return line[:len(line)-2], nil
+}
+
+// readData reads a data block of the given size followed by CRLF.
+func readData(r *bufio.Reader, size string) ([]byte, error) {
+	n, err := strconv.Atoi(size)
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("memcache: malformed data size %q", size)
+	}
+	b := make([]byte, n+2)
+	_, err = io.ReadFull(r, b)
+	if err != nil {
+		return nil, err
+	}
+	if string(b[n:]) != "\r\n" {
+		return nil, fmt.Errorf("memcache: malformed data block")
+	}
+	return b[:n], nil
+}
+
+// replyError returns an error for a reply the command did not expect.
+// Error replies leave the connection usable while anything else does not.
+func replyError(line string) error {
+	if line == "ERROR" || strings.HasPrefix(line, "CLIENT_ERROR") || strings.HasPrefix(line, "SERVER_ERROR") {
+		return &Error{Reply: line}
+	}
+	return fmt.Errorf("memcache: unexpected reply %q", line)
+}
@@ -0,0 +1,51 @@
+package memcache
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"sort"
+	"strconv"
+)
+
+// ring maps keys to servers with consistent hashing.
+// Each server is placed on the ring several times so keys spread evenly,
+// and adding or removing a server only moves the keys next to its points.
+// Points and keys are hashed with MD5 as in ketama, which spreads similar
+// names such as addresses differing by port well.
+type ring struct {
+	points  []uint32
+	servers map[uint32]string
+}
+
+// newRing places each server on the ring replicas times.
+func newRing(servers []string, replicas int) *ring {
+	r := &ring{servers: make(map[uint32]string, len(servers)*replicas)}
+	for _, server := range servers {
+		for i := range replicas {
+			point := hash(server + "-" + strconv.Itoa(i))
+			if _, ok := r.servers[point]; ok {
+				continue
+			}
+			r.servers[point] = server
+			r.points = append(r.points, point)
+		}
+	}
+	sort.Slice(r.points, func(i, j int) bool { return r.points[i] < r.points[j] })
+	return r
+}
+
+// server returns the server owning key, which is the first point at or after the keys hash.
+func (r *ring) server(key string) string {
+	h := hash(key)
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= h })
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.servers[r.points[i]]
+}
+
+// hash returns the position of a name on the ring.
+func hash(name string) uint32 {
+	sum := md5.Sum([]byte(name))
+	return binary.LittleEndian.Uint32(sum[:4])
+}
@@ -0,0 +1,197 @@
+package memcache_test
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+type (
+	// fakeServer is an in-process stand-in for memcached implementing
+	// the text and meta commands used by the store.
+	fakeServer struct {
+		ln net.Listener
+
+		// conns counts the connections accepted
+		conns atomic.Int32
+
+		mtx  sync.Mutex
+		data map[string]fakeItem
+	}
+
+	// fakeItem is a value saved on the fake server with the expiration time it was sent with
+	fakeItem struct {
+		value []byte
+		exp   int64
+	}
+)
+
+// newFakeServer starts a fake memcached server.
+func newFakeServer(t *testing.T) *fakeServer {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &fakeServer{ln: ln, data: make(map[string]fakeItem)}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			srv.conns.Add(1)
+			go srv.serve(c)
+		}
+	}()
+	return srv
+}
+
+// Addr returns the address the fake server listens on.
+func (srv *fakeServer) Addr() string {
+	return srv.ln.Addr().String()
+}
+
+// Item returns the item saved under key.
+func (srv *fakeServer) Item(key string) (fakeItem, bool) {
+	srv.mtx.Lock()
+	defer srv.mtx.Unlock()
+	item, ok := srv.data[key]
+	return item, ok
+}
+
+// Len returns the number of items saved on the fake server.
+func (srv *fakeServer) Len() int {
+	srv.mtx.Lock()
+	defer srv.mtx.Unlock()
+	return len(srv.data)
+}
+
+// serve reads commands from c until it is closed.
+func (srv *fakeServer) serve(c net.Conn) {
+	defer c.Close()
+	r := bufio.NewReader(c)
+	w := bufio.NewWriter(c)
+
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			w.WriteString("ERROR\r\n")
+		} else if !srv.handle(r, w, fields) {
+			return
+		}
+		if w.Flush() != nil {
+			return
+		}
+	}
+}
+
+// handle runs a single command and writes its reply.
+// It returns false if the connection should be closed.
+func (srv *fakeServer) handle(r *bufio.Reader, w *bufio.Writer, fields []string) bool {
+	srv.mtx.Lock()
+	defer srv.mtx.Unlock()
+
+	switch fields[0] {
+	case "set", "add":
+		// set <key> <flags> <exptime> <bytes>
+		exp, _ := strconv.ParseInt(fields[3], 10, 64)
+		value, ok := readBlock(r, fields[4])
+		if !ok {
+			return false
+		}
+		if _, exists := srv.data[fields[1]]; exists && fields[0] == "add" {
+			w.WriteString("NOT_STORED\r\n")
+			return true
+		}
+		srv.data[fields[1]] = fakeItem{value: value, exp: exp}
+		w.WriteString("STORED\r\n")
+	case "get":
+		for _, key := range fields[1:] {
+			if item, ok := srv.data[key]; ok {
+				fmt.Fprintf(w, "VALUE %s 0 %d\r\n%s\r\n", key, len(item.value), item.value)
+			}
+		}
+		w.WriteString("END\r\n")
+	case "delete":
+		if _, ok := srv.data[fields[1]]; !ok {
+			w.WriteString("NOT_FOUND\r\n")
+			return true
+		}
+		delete(srv.data, fields[1])
+		w.WriteString("DELETED\r\n")
+	case "flush_all":
+		clear(srv.data)
+		w.WriteString("OK\r\n")
+	case "ms":
+		// ms <key> <datalen> <flags>*
+		key, flags := metaKey(fields[1], fields[3:])
+		value, ok := readBlock(r, fields[2])
+		if !ok {
+			return false
+		}
+		exp, _ := strconv.ParseInt(flags["T"], 10, 64)
+		if _, exists := srv.data[key]; exists && flags["M"] == "E" {
+			w.WriteString("NS\r\n")
+			return true
+		}
+		srv.data[key] = fakeItem{value: value, exp: exp}
+		w.WriteString("HD\r\n")
+	case "mg":
+		key, _ := metaKey(fields[1], fields[2:])
+		item, ok := srv.data[key]
+		if !ok {
+			w.WriteString("EN\r\n")
+			return true
+		}
+		fmt.Fprintf(w, "VA %d\r\n%s\r\n", len(item.value), item.value)
+	case "md":
+		key, _ := metaKey(fields[1], fields[2:])
+		if _, ok := srv.data[key]; !ok {
+			w.WriteString("NF\r\n")
+			return true
+		}
+		delete(srv.data, key)
+		w.WriteString("HD\r\n")
+	default:
+		w.WriteString("ERROR\r\n")
+	}
+	return true
+}
+
+// metaKey decodes the key of a meta command and returns its flags by their letter.
+func metaKey(key string, fields []string) (string, map[string]string) {
+	flags := make(map[string]string)
+	for _, f := range fields {
+		flags[f[:1]] = f[1:]
+	}
+	if _, ok := flags["b"]; ok {
+		b, _ := base64.StdEncoding.DecodeString(key)
+		key = string(b)
+	}
+	return key, flags
+}
+
+// readBlock reads a data block of the given size followed by CRLF.
+func readBlock(r *bufio.Reader, size string) ([]byte, bool) {
+	n, err := strconv.Atoi(size)
+	if err != nil {
+		return nil, false
+	}
+	b := make([]byte, n+2)
+	_, err = io.ReadFull(r, b)
+	return b[:n], err == nil
+}
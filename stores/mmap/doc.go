@@ -0,0 +1,4 @@
+// Package mmap implements a store shared by processes on one host through a memory mapped file.
+// Entries are found through an open-addressing hash table and saved in slab-allocated chunks,
+// so no entry needs its own file. The store is only available on Linux.
+package mmap
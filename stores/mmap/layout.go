@@ -0,0 +1,128 @@
+//go:build linux
+
+package mmap
+
+import (
+	"encoding/binary"
+	"sync/atomic"
+	"unsafe"
+)
+
+// File layout
+//
+// header | page classes | hash table | slab pages
+//
+// The header holds the geometry of the file, the seqlock, counters, and the free list of each size class.
+// Page classes records the size class each slab page was carved into, 0 for unassigned pages.
+// Each slot of the hash table points at the chunk holding the key and value of an entry.
+const (
+	magic   = 0x4d4d4150 // "MMAP"
+	version = 1
+
+	osPage     = 4096
+	headerSize = osPage
+
+	// header fields
+	hdrMagic      = 0
+	hdrVersion    = 4
+	hdrSeq        = 8
+	hdrSize       = 16
+	hdrSlots      = 24
+	hdrNextPage   = 32
+	hdrUsed       = 40
+	hdrTombstones = 48
+	hdrFreeHeads  = 64
+
+	// slot fields
+	slotSize   = 32
+	slotHash   = 0
+	slotOffset = 8
+	slotStamp  = 16
+	slotKeyLen = 24
+	slotClass  = 26
+	slotValLen = 28
+
+	// slot hashes with a special meaning
+	hashEmpty     = 0
+	hashTombstone = 1
+
+	// Chunks are powers of two from minChunk up to a whole slab page
+	minChunk   = 64
+	slabPage   = 256 << 10
+	numClasses = 13
+)
+
+// geometry is where each region of the file starts
+type geometry struct {
+	size    int
+	slots   int
+	pages   int
+	classes int
+	table   int
+	slabs   int
+}
+
+// layout returns the geometry of a file of size bytes holding slots slots.
+// It returns false if there is no room for a single slab page.
+func layout(size, slots int) (geometry, bool) {
+	g := geometry{size: size, slots: slots, classes: headerSize}
+	tableSize := roundUp(slots*slotSize, osPage)
+	avail := size - headerSize - tableSize
+	g.pages = avail / (slabPage + 1)
+	for g.pages > 0 && headerSize+roundUp(g.pages, osPage)+tableSize+g.pages*slabPage > size {
+		g.pages--
+	}
+	g.table = g.classes + roundUp(g.pages, osPage)
+	g.slabs = g.table + tableSize
+	return g, g.pages > 0 && slots > 0
+}
+
+// roundUp rounds n up to a multiple of m.
+func roundUp(n, m int) int {
+	return (n + m - 1) / m * m
+}
+
+// classOf returns the smallest size class holding n bytes.
+func classOf(n int) (int, bool) {
+	for c := 0; c < numClasses; c++ {
+		if n <= chunkSize(c) {
+			return c, true
+		}
+	}
+	return 0, false
+}
+
+// chunkSize returns the size of the chunks in a class.
+func chunkSize(class int) int {
+	return minChunk << class
+}
+
+// u64 returns the uint64 at off in the mapping.
+func (s *Store) u64(off int) uint64 {
+	return binary.LittleEndian.Uint64(s.data[off:])
+}
+
+// setU64 sets the uint64 at off in the mapping.
+func (s *Store) setU64(off int, v uint64) {
+	binary.LittleEndian.PutUint64(s.data[off:], v)
+}
+
+// atomicU64 returns the 8-byte aligned uint64 at off for atomic access.
+func (s *Store) atomicU64(off int) *uint64 {
+	return (*uint64)(unsafe.Pointer(&s.data[off])) //#nosec G103 -- off is within the mapping and aligned
+}
+
+// seq returns the seqlock counter. It is odd while a change is being made.
+func (s *Store) seq() *uint32 {
+	return (*uint32)(unsafe.Pointer(&s.data[hdrSeq])) //#nosec G103 -- the header is within the mapping
+}
+
+// slot returns the offset of slot i.
+func (s *Store) slot(i int) int {
+	return s.geo.table + i*slotSize
+}
+
+// slotHashOf returns the hash of slot i, read atomically as it publishes the slot.
+func (s *Store) slotHashOf(i int) uint64 {
+	return atomic.LoadUint64(s.atomicU64(s.slot(i) + slotHash))
+}
@@ -0,0 +1,90 @@
+//go:build linux
+
+package mmap
+
+import (
+	"runtime"
+	"sync/atomic"
+	"syscall"
+)
+
+// maxSpins is how many times a reader retries while changes are being made
+// before waiting for the lock instead
+const maxSpins = 1000
+
+// lock serialises changes between goroutines with mtx and between processes with an flock on the file.
+// A seqlock left odd means the last writer died during a change, so the table is repaired first.
+func (s *Store) lock() error {
+	s.mtx.Lock()
+	if s.data == nil {
+		s.mtx.Unlock()
+		return errClosed
+	}
+	err := flock(s.f.Fd(), syscall.LOCK_EX)
+	if err != nil {
+		s.mtx.Unlock()
+		return err
+	}
+	if atomic.LoadUint32(s.seq())&1 == 1 {
+		s.repair()
+		atomic.AddUint32(s.seq(), 1)
+	}
+	return nil
+}
+
+// unlock releases the locks taken by lock.
+func (s *Store) unlock() {
+	_ = flock(s.f.Fd(), syscall.LOCK_UN)
+	s.mtx.Unlock()
+}
+
+// change runs fn holding the lock with the seqlock odd,
+// so readers retry any read that overlaps it.
+func (s *Store) change(fn func() error) error {
+	err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer s.unlock()
+
+	atomic.AddUint32(s.seq(), 1)
+	defer atomic.AddUint32(s.seq(), 1)
+	return fn()
+}
+
+// read runs fn without the lock, holding use so the file stays mapped, retrying until no change overlapped it.
+// fn returns false if it saw the table half changed.
+// If changes keep overlapping, fn runs once more holding the lock.
+func (s *Store) read(fn func() bool) error {
+	s.use.RLock()
+	defer s.use.RUnlock()
+	if s.data == nil {
+		return errClosed
+	}
+
+	for spin := 0; spin < maxSpins; spin++ {
+		seq := atomic.LoadUint32(s.seq())
+		if seq&1 == 0 && fn() && atomic.LoadUint32(s.seq()) == seq {
+			return nil
+		}
+		runtime.Gosched()
+	}
+
+	err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer s.unlock()
+	fn()
+	return nil
+}
+
+// flock calls syscall.Flock retrying if interrupted by a signal.
+func flock(fd uintptr, how int) error {
+	for {
+		err := syscall.Flock(int(fd), how) //#nosec G115 -- file descriptors fit in an int
+		if err != syscall.EINTR {
+			return err
+		}
+	}
+}
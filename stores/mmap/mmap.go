@@ -0,0 +1,320 @@
+//go:build linux
+
+package mmap
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/tmstorm/cache"
+)
+
+type (
+	// Store implements cache.Store
+	// Any number of Stores in any number of processes may open the same file.
+	Store struct {
+		storeType string
+
+		// mtx serialises changes within the process, the flock on f serialises them between processes.
+		// Reads take neither and use the seqlock in the header instead.
+		mtx sync.Mutex
+
+		// use is held for reading by reads so Close waits for them before unmapping the file
+		use sync.RWMutex
+
+		f    *os.File
+		data []byte
+		geo  geometry
+
+		// Path is the file shared between processes
+		Path string
+
+		// Size is the size of the file in bytes. Defaults to 64 MiB.
+		// It is only used when the file is created, later opens use the size it was created with.
+		Size int
+
+		// Slots is the number of entries the hash table can hold. Defaults to one per 2 KiB of Size.
+		// It is only used when the file is created.
+		Slots int
+
+		// MaxAge is the implementation of cache.MaxAge for use during trimming old entries
+		MaxAge cache.MaxAge
+	}
+
+	// writer is used to read, write, and remove entries
+	writer struct {
+		Store *Store
+	}
+)
+
+// errClosed is returned when the store is used after Close
+var errClosed = errors.New("mmap store is closed")
+
+// maxKeySize is the longest key a slot can describe
+const maxKeySize = 1<<16 - 1
+
+// New maps the file at Path, creating it if it does not exist.
+// If MaxAge, Path, Size, or Slots are not provided they will be set to their default values.
+func New(s *Store) *Store {
+	s.storeType = "mmap"
+
+	if s.Path == "" {
+		fmt.Println("cache: a file for the mmap store has not been provided. It will now be set to cache-mmap/mmap.db in the applications root directory.")
+		s.Path = filepath.Clean("./cache-mmap/mmap.db")
+	}
+	if s.MaxAge == 0 {
+		s.MaxAge = cache.DefaultMaxAge
+	}
+	if s.Size == 0 {
+		s.Size = 64 << 20
+	}
+	s.Size = roundUp(s.Size, osPage)
+	if s.Slots == 0 {
+		s.Slots = s.Size / 2048
+	}
+
+	if _, ok := layout(s.Size, s.Slots); !ok {
+		fmt.Println("cache: the mmap store size is too small to hold its hash table and a slab page.")
+		return nil
+	}
+
+	err := os.MkdirAll(filepath.Dir(s.Path), 0o750)
+	if err != nil {
+		fmt.Printf("cannot make mmap directory: %v\n", err)
+		return nil
+	}
+	err = s.open()
+	if err != nil {
+		fmt.Printf("cannot open mmap store: %v\n", err)
+		return nil
+	}
+	return s
+}
+
+// open maps the file, holding the lock while a new file is laid out
+// so other processes never see it half made.
+func (s *Store) open() error {
+	f, err := os.OpenFile(s.Path, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	s.f = f
+
+	err = s.mapFile()
+	if err != nil {
+		f.Close()
+	}
+	return err
+}
+
+// mapFile lays out an empty file or reads the geometry of an existing one and maps it.
+func (s *Store) mapFile() error {
+	err := flock(s.f.Fd(), syscall.LOCK_EX)
+	if err != nil {
+		return err
+	}
+	defer flock(s.f.Fd(), syscall.LOCK_UN)
+
+	stat, err := s.f.Stat()
+	if err != nil {
+		return err
+	}
+
+	created := stat.Size() == 0
+	if created {
+		err = s.f.Truncate(int64(s.Size))
+		if err != nil {
+			return err
+		}
+	} else {
+		b := make([]byte, hdrSlots+8)
+		_, err = s.f.ReadAt(b, 0)
+		if err != nil {
+			return err
+		}
+		if binary.LittleEndian.Uint32(b[hdrMagic:]) != magic || binary.LittleEndian.Uint32(b[hdrVersion:]) != version {
+			return fmt.Errorf("mmap: %s is not an mmap store file", s.Path)
+		}
+		s.Size = int(binary.LittleEndian.Uint64(b[hdrSize:]))
+		s.Slots = int(binary.LittleEndian.Uint64(b[hdrSlots:]))
+		if stat.Size() != int64(s.Size) {
+			return fmt.Errorf("mmap: %s is %d bytes but should be %d", s.Path, stat.Size(), s.Size)
+		}
+	}
+
+	geo, ok := layout(s.Size, s.Slots)
+	if !ok {
+		return fmt.Errorf("mmap: %s has an invalid layout", s.Path)
+	}
+	s.geo = geo
+
+	s.data, err = syscall.Mmap(int(s.f.Fd()), 0, s.Size, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED) //#nosec G115 -- file descriptors fit in an int
+	if err != nil {
+		return err
+	}
+
+	if created {
+		binary.LittleEndian.PutUint32(s.data[hdrMagic:], magic)
+		binary.LittleEndian.PutUint32(s.data[hdrVersion:], version)
+		s.setU64(hdrSize, uint64(s.Size))
+		s.setU64(hdrSlots, uint64(s.Slots))
+	}
+	return nil
+}
+
+// Type returns the stores type as a string
+func (s *Store) Type() string {
+	return s.storeType
+}
+
+// Get returns a writer for the current mmap store
+func Get(s *Store) *writer {
+	var w writer
+	w.Store = s
+	return &w
+}
+
+// Write saves the value under key.
+// If the store is full the oldest entry of the same size is evicted to make room.
+// If overwrite = true the value will be overwriten if it already exists
+func (w *writer) Write(key string, value []byte, overwrite bool) error {
+	if len(key) > maxKeySize {
+		return fmt.Errorf("key is longer than %d bytes", maxKeySize)
+	}
+	class, ok := classOf(len(key) + len(value))
+	if !ok {
+		return fmt.Errorf("entry is larger than the %d byte limit of the mmap store: %s", chunkSize(numClasses-1), key)
+	}
+
+	s := w.Store
+	return s.change(func() error {
+		h := hash(key)
+		exists := s.find(key, h) >= 0
+		if exists && !overwrite {
+			return fmt.Errorf("key already exists in mmap store: %s", key)
+		}
+
+		// Keep a tenth of the table empty so probing stays short.
+		// Replacing an entry frees its slot before the new one is inserted.
+		limit := uint64(s.geo.slots) * 9 / 10
+		if !exists && s.u64(hdrUsed)+s.u64(hdrTombstones) >= limit {
+			s.rehash()
+		}
+		if !exists && s.u64(hdrUsed) >= limit && !s.evictOldest(-1) {
+			return fmt.Errorf("mmap store is full: %s", key)
+		}
+
+		// The old entry is only removed once the new one has a chunk, so a failed write keeps it.
+		// It is found again as alloc may have evicted it.
+		off, ok := s.alloc(class)
+		if !ok {
+			return fmt.Errorf("mmap store has no room for entries of %d bytes: %s", chunkSize(class), key)
+		}
+		copy(s.data[off:], key)
+		copy(s.data[off+len(key):], value)
+		if i := s.find(key, h); i >= 0 {
+			s.remove(i)
+		}
+		s.insert(h, off, class, len(key), len(value), time.Now().UnixNano())
+		return nil
+	})
+}
+
+// Read returns a copy of the value saved under key.
+func (w *writer) Read(key string) ([]byte, error) {
+	s := w.Store
+	h := hash(key)
+
+	var value []byte
+	var found bool
+	err := s.read(func() bool {
+		var ok bool
+		value, found, ok = s.lookup(key, h)
+		return ok
+	})
+	if err != nil {
+		return []byte{}, err
+	}
+	if !found {
+		return []byte{}, fmt.Errorf("key not found in mmap store: %s", key)
+	}
+	return value, nil
+}
+
+// Remove deletes the entry saved under key.
+// Removing a key that does not exist is not an error.
+func (w *writer) Remove(key string) error {
+	s := w.Store
+	return s.change(func() error {
+		if i := s.find(key, hash(key)); i >= 0 {
+			s.remove(i)
+		}
+		return nil
+	})
+}
+
+// Trim removes entries older than MaxAge and rebuilds the hash table without tombstones.
+// It is called by the caches trim worker.
+// This can be called directly if needed.
+func (s *Store) Trim() {
+	log.Println("Starting mmap store trimming...")
+	cutoff := time.Now().Add(-time.Second * time.Duration(s.MaxAge)).UnixNano()
+	err := s.change(func() error {
+		s.live(func(i int) {
+			if int64(s.u64(s.slot(i)+slotStamp)) < cutoff {
+				s.remove(i)
+			}
+		})
+		s.rehash()
+		return nil
+	})
+	if err != nil {
+		log.Printf("unable to lock mmap store: %v", err)
+		return
+	}
+	log.Println("Mmap store trimming complete")
+}
+
+// Purge removes every entry from the file, including those written by other processes.
+// This function should only be used when stopping the service.
+// If you need to flush the store without stopping it you can
+// call this method directly.
+func (s *Store) Purge() error {
+	log.Println("Mmap store is being purged...")
+	err := s.change(func() error {
+		clear(s.data[hdrNextPage : hdrFreeHeads+numClasses*8])
+		clear(s.data[s.geo.classes:s.geo.slabs])
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	log.Println("Mmap store purge complete")
+	return nil
+}
+
+// Close unmaps and closes the file once running reads and changes finish.
+// The store cannot be used once it is closed.
+func (s *Store) Close() error {
+	s.use.Lock()
+	defer s.use.Unlock()
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if s.data == nil {
+		return errClosed
+	}
+	err := syscall.Munmap(s.data)
+	if err != nil {
+		return err
+	}
+	s.data = nil
+	return s.f.Close()
+}
@@ -0,0 +1,228 @@
+//go:build linux
+
+package mmap_test
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tmstorm/cache/stores/mmap"
+)
+
+// TestMmapStore tests writing, reading, and removing entries
+func TestMmapStore(t *testing.T) {
+	a := assert.New(t)
+
+	s := mmap.New(&mmap.Store{Path: filepath.Join(t.TempDir(), "mmap.db"), Size: 4 << 20})
+	a.NotNil(s)
+	defer s.Close()
+	a.Equal("mmap", s.Type())
+
+	w := mmap.Get(s)
+	a.NoError(w.Write("key", []byte("value"), false))
+	a.Error(w.Write("key", []byte("other"), false))
+
+	v, err := w.Read("key")
+	a.NoError(err)
+	a.Equal([]byte("value"), v)
+
+	a.NoError(w.Write("key", []byte(strings.Repeat("other", 100)), true))
+	v, err = w.Read("key")
+	a.NoError(err)
+	a.Equal([]byte(strings.Repeat("other", 100)), v)
+
+	a.NoError(w.Remove("key"))
+	a.NoError(w.Remove("key"))
+	_, err = w.Read("key")
+	a.Error(err)
+
+	a.Error(w.Write("large", make([]byte, 1<<20), false))
+
+	a.NoError(w.Write("key", []byte("value"), false))
+	a.NoError(s.Purge())
+	_, err = w.Read("key")
+	a.Error(err)
+	used, _ := mmap.Len(s)
+	a.Zero(used)
+}
+
+// TestMmapShared tests stores opened on the same file see each others changes
+func TestMmapShared(t *testing.T) {
+	a := assert.New(t)
+	path := filepath.Join(t.TempDir(), "mmap.db")
+
+	first := mmap.New(&mmap.Store{Path: path, Size: 4 << 20})
+	a.NotNil(first)
+	defer first.Close()
+
+	// The geometry of an existing file wins over the options
+	second := mmap.New(&mmap.Store{Path: path, Size: 8 << 20})
+	a.NotNil(second)
+	defer second.Close()
+	a.Equal(4<<20, second.Size)
+
+	a.NoError(mmap.Get(first).Write("key", []byte("value"), false))
+	v, err := mmap.Get(second).Read("key")
+	a.NoError(err)
+	a.Equal([]byte("value"), v)
+
+	a.NoError(mmap.Get(second).Remove("key"))
+	_, err = mmap.Get(first).Read("key")
+	a.Error(err)
+
+	// Readers never see a value half written by the other store
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := mmap.Get(first)
+			if g%2 == 1 {
+				w = mmap.Get(second)
+			}
+			for i := 0; i < 500; i++ {
+				key := fmt.Sprint(i % 50)
+				if g < 2 {
+					if err := w.Write(key, bytes.Repeat([]byte{byte(i)}, 100+i), true); err != nil {
+						t.Error(err.Error())
+					}
+					continue
+				}
+				v, err := w.Read(key)
+				if err == nil && len(v) > 0 && !bytes.Equal(v, bytes.Repeat(v[:1], len(v))) {
+					t.Error("read a torn value")
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// TestMmapEvict tests the oldest entries are evicted once the slab pages are used
+func TestMmapEvict(t *testing.T) {
+	a := assert.New(t)
+
+	s := mmap.New(&mmap.Store{Path: filepath.Join(t.TempDir(), "mmap.db"), Size: 1 << 20})
+	a.NotNil(s)
+	defer s.Close()
+	w := mmap.Get(s)
+
+	value := make([]byte, 1000)
+	for i := 0; i < 1000; i++ {
+		a.NoError(w.Write(fmt.Sprint(i), value, false))
+	}
+	_, err := w.Read("0")
+	a.Error(err)
+	_, err = w.Read("999")
+	a.NoError(err)
+}
+
+// TestMmapTrim tests Trim removes entries older than MaxAge
+func TestMmapTrim(t *testing.T) {
+	a := assert.New(t)
+
+	s := mmap.New(&mmap.Store{Path: filepath.Join(t.TempDir(), "mmap.db"), Size: 4 << 20, MaxAge: 1})
+	a.NotNil(s)
+	defer s.Close()
+	w := mmap.Get(s)
+
+	for i := 0; i < 100; i++ {
+		a.NoError(w.Write(fmt.Sprint(i), []byte("value"), false))
+	}
+	a.NoError(w.Remove("0"))
+	time.Sleep(1100 * time.Millisecond)
+	a.NoError(w.Write("new", []byte("value"), false))
+
+	s.Trim()
+	used, tombstones := mmap.Len(s)
+	a.Equal(1, used)
+	a.Zero(tombstones)
+	_, err := w.Read("1")
+	a.Error(err)
+	_, err = w.Read("new")
+	a.NoError(err)
+}
+
+// TestMmapRepair tests the store is repaired when a writer died during a change
+func TestMmapRepair(t *testing.T) {
+	a := assert.New(t)
+
+	s := mmap.New(&mmap.Store{Path: filepath.Join(t.TempDir(), "mmap.db"), Size: 4 << 20})
+	a.NotNil(s)
+	defer s.Close()
+	w := mmap.Get(s)
+
+	for i := 0; i < 100; i++ {
+		a.NoError(w.Write(fmt.Sprint("old", i), []byte(fmt.Sprint("value", i)), false))
+	}
+	mmap.Crash(s)
+
+	// Chunks of existing entries are not handed out again
+	for i := 0; i < 100; i++ {
+		a.NoError(w.Write(fmt.Sprint("new", i), []byte("replacement"), false))
+	}
+	for i := 0; i < 100; i++ {
+		v, err := w.Read(fmt.Sprint("old", i))
+		a.NoError(err)
+		a.Equal([]byte(fmt.Sprint("value", i)), v)
+	}
+}
+
+// TestMmapOverwriteNoRoom tests a failed overwrite keeps the old entry
+func TestMmapOverwriteNoRoom(t *testing.T) {
+	a := assert.New(t)
+
+	s := mmap.New(&mmap.Store{Path: filepath.Join(t.TempDir(), "mmap.db"), Size: 1 << 20})
+	a.NotNil(s)
+	defer s.Close()
+	w := mmap.Get(s)
+
+	// Every slab page is carved for 100 KiB entries
+	value := bytes.Repeat([]byte("v"), 100<<10)
+	for i := 0; i < 50; i++ {
+		a.NoError(w.Write(fmt.Sprint(i), value, false))
+	}
+	a.Error(w.Write("49", []byte("small"), true))
+	v, err := w.Read("49")
+	a.NoError(err)
+	a.True(bytes.Equal(value, v))
+}
+
+// TestMmapClose tests Close waits for running reads and later use fails
+func TestMmapClose(t *testing.T) {
+	a := assert.New(t)
+
+	s := mmap.New(&mmap.Store{Path: filepath.Join(t.TempDir(), "mmap.db"), Size: 4 << 20})
+	a.NotNil(s)
+	w := mmap.Get(s)
+	a.NoError(w.Write("key", []byte("value"), false))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				v, err := w.Read("key")
+				if err != nil {
+					return
+				}
+				a.Equal([]byte("value"), v)
+			}
+		}()
+	}
+	time.Sleep(10 * time.Millisecond)
+	a.NoError(s.Close())
+	wg.Wait()
+
+	_, err := w.Read("key")
+	a.Error(err)
+	a.Error(w.Write("key", []byte("value"), true))
+	a.Error(s.Close())
+}
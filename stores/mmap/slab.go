@@ -0,0 +1,93 @@
+//go:build linux
+
+package mmap
+
+// alloc returns the offset of a free chunk in a size class.
+// Slab pages are carved into chunks the first time a class needs them.
+// Once every page is in use the oldest entry of the class is evicted.
+func (s *Store) alloc(class int) (int, bool) {
+	head := hdrFreeHeads + class*8
+	if off := s.u64(head); off != 0 {
+		s.setU64(head, s.u64(int(off)))
+		return int(off), true
+	}
+
+	if next := int(s.u64(hdrNextPage)); next < s.geo.pages {
+		s.data[s.geo.classes+next] = byte(class + 1)
+		s.setU64(hdrNextPage, uint64(next+1))
+		s.carve(next, class, nil)
+		return s.alloc(class)
+	}
+
+	if s.evictOldest(class) {
+		return s.alloc(class)
+	}
+	return 0, false
+}
+
+// free returns a chunk to the free list of its class.
+func (s *Store) free(off, class int) {
+	head := hdrFreeHeads + class*8
+	s.setU64(off, s.u64(head))
+	s.setU64(head, uint64(off))
+}
+
+// carve adds the chunks of a slab page to the free list of its class, skipping chunks in use.
+func (s *Store) carve(page, class int, used map[int]bool) {
+	start := s.geo.slabs + page*slabPage
+	size := chunkSize(class)
+	for off := start + slabPage - size; off >= start; off -= size {
+		if !used[off] {
+			s.free(off, class)
+		}
+	}
+}
+
+// repair rebuilds the free lists and counters from the table after a writer died during a change.
+// Slots pointing at chunks that do not match their size class are dropped.
+// The caller must hold the lock.
+func (s *Store) repair() {
+	pages := min(int(s.u64(hdrNextPage)), s.geo.pages)
+	used := make(map[int]bool)
+	var count, tombstones uint64
+
+	for i := 0; i < s.geo.slots; i++ {
+		state := s.slotHashOf(i)
+		if state == hashTombstone {
+			tombstones++
+		}
+		if state <= hashTombstone {
+			continue
+		}
+
+		slot := s.slot(i)
+		off := int(s.u64(slot + slotOffset))
+		class := int(s.data[slot+slotClass])
+		page := (off - s.geo.slabs) / slabPage
+		_, _, ok := s.entryAt(i)
+		ok = ok && class < numClasses && off >= s.geo.slabs && page < pages &&
+			int(s.data[s.geo.classes+page]) == class+1 &&
+			(off-s.geo.slabs-page*slabPage)%chunkSize(class) == 0 && !used[off]
+		k, v, _ := s.entryAt(i)
+		ok = ok && len(k)+len(v) <= chunkSize(class)
+		if !ok {
+			s.setU64(slot+slotHash, hashTombstone)
+			tombstones++
+			continue
+		}
+		used[off] = true
+		count++
+	}
+
+	for c := 0; c < numClasses; c++ {
+		s.setU64(hdrFreeHeads+c*8, 0)
+	}
+	for page := 0; page < pages; page++ {
+		if class := int(s.data[s.geo.classes+page]); class > 0 && class <= numClasses {
+			s.carve(page, class-1, used)
+		}
+	}
+	s.setU64(hdrNextPage, uint64(pages))
+	s.setU64(hdrUsed, count)
+	s.setU64(hdrTombstones, tombstones)
+}
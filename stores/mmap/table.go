@@ -0,0 +1,167 @@
+//go:build linux
+
+package mmap
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"sync/atomic"
+)
+
+// hash returns the FNV-1a hash of key, moved clear of the reserved slot hashes.
+func hash(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	v := h.Sum64()
+	if v <= hashTombstone {
+		v += hashTombstone + 1
+	}
+	return v
+}
+
+// entryAt returns the key and value slot i points at, without copying them.
+// It returns false if the slot points outside the slab pages, which readers
+// can see while a change is being made.
+func (s *Store) entryAt(i int) (key, value []byte, ok bool) {
+	slot := s.slot(i)
+	off := s.u64(slot + slotOffset)
+	klen := uint64(binary.LittleEndian.Uint16(s.data[slot+slotKeyLen:]))
+	vlen := uint64(binary.LittleEndian.Uint32(s.data[slot+slotValLen:]))
+	if off < uint64(s.geo.slabs) || off+klen+vlen > uint64(s.geo.size) {
+		return nil, nil, false
+	}
+	return s.data[off : off+klen], s.data[off+klen : off+klen+vlen], true
+}
+
+// find returns the slot holding key, or -1 if it is not in the table.
+func (s *Store) find(key string, h uint64) int {
+	for p := 0; p < s.geo.slots; p++ {
+		i := int((h + uint64(p)) % uint64(s.geo.slots))
+		switch s.slotHashOf(i) {
+		case hashEmpty:
+			return -1
+		case h:
+			k, _, ok := s.entryAt(i)
+			if ok && string(k) == key {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// lookup copies the value of key out of the table.
+// It reports whether the key was found and whether the table was readable,
+// as readers do not hold the lock and may see a change half made.
+func (s *Store) lookup(key string, h uint64) (value []byte, found, ok bool) {
+	for p := 0; p < s.geo.slots; p++ {
+		i := int((h + uint64(p)) % uint64(s.geo.slots))
+		switch s.slotHashOf(i) {
+		case hashEmpty:
+			return nil, false, true
+		case h:
+			k, v, ok := s.entryAt(i)
+			if !ok {
+				return nil, false, false
+			}
+			if string(k) == key {
+				return append([]byte(nil), v...), true, true
+			}
+		}
+	}
+	return nil, false, true
+}
+
+// insert adds an entry for a key that is not in the table.
+// The slot is filled in before its hash is set so readers never find a partial slot.
+func (s *Store) insert(h uint64, off, class, klen, vlen int, stamp int64) {
+	for p := 0; p < s.geo.slots; p++ {
+		i := int((h + uint64(p)) % uint64(s.geo.slots))
+		state := s.slotHashOf(i)
+		if state != hashEmpty && state != hashTombstone {
+			continue
+		}
+
+		slot := s.slot(i)
+		s.setU64(slot+slotOffset, uint64(off))
+		s.setU64(slot+slotStamp, uint64(stamp))
+		binary.LittleEndian.PutUint16(s.data[slot+slotKeyLen:], uint16(klen))
+		s.data[slot+slotClass] = byte(class)
+		binary.LittleEndian.PutUint32(s.data[slot+slotValLen:], uint32(vlen))
+		atomic.StoreUint64(s.atomicU64(slot+slotHash), h)
+
+		if state == hashTombstone {
+			s.setU64(hdrTombstones, s.u64(hdrTombstones)-1)
+		}
+		s.setU64(hdrUsed, s.u64(hdrUsed)+1)
+		return
+	}
+}
+
+// remove frees the chunk of slot i and leaves a tombstone so probing continues past it.
+func (s *Store) remove(i int) {
+	slot := s.slot(i)
+	atomic.StoreUint64(s.atomicU64(slot+slotHash), hashTombstone)
+	s.free(int(s.u64(slot+slotOffset)), int(s.data[slot+slotClass]))
+	s.setU64(hdrUsed, s.u64(hdrUsed)-1)
+	s.setU64(hdrTombstones, s.u64(hdrTombstones)+1)
+}
+
+// live calls fn for every slot holding an entry.
+func (s *Store) live(fn func(i int)) {
+	for i := 0; i < s.geo.slots; i++ {
+		if s.slotHashOf(i) > hashTombstone {
+			fn(i)
+		}
+	}
+}
+
+// rehash rebuilds the table without tombstones.
+// Entries keep their chunks, only the slots pointing at them move.
+func (s *Store) rehash() {
+	type saved struct {
+		hash uint64
+		slot [slotSize]byte
+	}
+	var entries []saved
+	s.live(func(i int) {
+		var e saved
+		e.hash = s.slotHashOf(i)
+		copy(e.slot[:], s.data[s.slot(i):])
+		entries = append(entries, e)
+	})
+
+	clear(s.data[s.geo.table:s.geo.slabs])
+	for _, e := range entries {
+		for p := 0; p < s.geo.slots; p++ {
+			i := int((e.hash + uint64(p)) % uint64(s.geo.slots))
+			if s.slotHashOf(i) == hashEmpty {
+				copy(s.data[s.slot(i):], e.slot[:])
+				break
+			}
+		}
+	}
+	s.setU64(hdrUsed, uint64(len(entries)))
+	s.setU64(hdrTombstones, 0)
+}
+
+// evictOldest removes the oldest entry, limited to a size class unless class is -1.
+// It returns false if there is no entry to remove.
+func (s *Store) evictOldest(class int) bool {
+	oldest := -1
+	var stamp uint64
+	s.live(func(i int) {
+		slot := s.slot(i)
+		if class >= 0 && int(s.data[slot+slotClass]) != class {
+			return
+		}
+		if t := s.u64(slot + slotStamp); oldest < 0 || int64(t) < int64(stamp) {
+			oldest, stamp = i, t
+		}
+	})
+	if oldest < 0 {
+		return false
+	}
+	s.remove(oldest)
+	return true
+}
@@ -0,0 +1,46 @@
+package peer
+
+import "sync"
+
+type (
+	// flight runs a function once for each key at a time,
+	// sharing its result with every caller that asked while it ran
+	flight struct {
+		mtx   sync.Mutex
+		calls map[string]*call
+	}
+
+	call struct {
+		wg    sync.WaitGroup
+		value []byte
+		err   error
+	}
+)
+
+// do calls fn for key unless a call for key is already running, in which case it waits for its result.
+func (f *flight) do(key string, fn func() ([]byte, error)) ([]byte, error) {
+	f.mtx.Lock()
+	if f.calls == nil {
+		f.calls = make(map[string]*call)
+	}
+	if c, ok := f.calls[key]; ok {
+		f.mtx.Unlock()
+		c.wg.Wait()
+		return c.value, c.err
+	}
+	c := &call{}
+	c.wg.Add(1)
+	f.calls[key] = c
+	f.mtx.Unlock()
+
+	// Waiting callers are released even if fn panics
+	defer func() {
+		f.mtx.Lock()
+		delete(f.calls, key)
+		f.mtx.Unlock()
+		c.wg.Done()
+	}()
+
+	c.value, c.err = fn()
+	return c.value, c.err
+}
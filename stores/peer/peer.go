@@ -0,0 +1,253 @@
+// Package peer implements a cache shared by a group of peers in the style of groupcache.
+// Each key is owned by one peer chosen with consistent hashing. The owner loads missing
+// values with a Loader and keeps them in its local mem store, while other peers fetch
+// the value from the owner over HTTP, so each value is loaded and kept once per group.
+package peer
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tmstorm/cache/stores/mem"
+)
+
+// ErrNotFound is returned when a key has no value.
+// Loaders should return it, or wrap it, for keys that do not exist
+// so peers can tell a missing key from a failure.
+var ErrNotFound = errors.New("peer: key not found")
+
+type (
+	// Loader returns the value of a key on a miss in the owning peer
+	Loader func(key string) ([]byte, error)
+
+	// PeerList provides the base URLs of every peer in the group, including this one.
+	// It is called on each lookup so the group can change while running.
+	PeerList interface {
+		Peers() []string
+	}
+
+	// StaticPeers is a PeerList that does not change
+	StaticPeers []string
+
+	// Store implements cache.Store and http.Handler.
+	// The handler must be served at BasePath on Self for other peers to reach it.
+	Store struct {
+		storeType string
+		flight    flight
+
+		ringMtx   sync.Mutex
+		ring      *ring
+		ringPeers []string
+
+		// Self is the base URL of this peer as it appears in Peers, e.g. http://10.0.0.1:8080
+		Self string
+
+		// Peers lists the peers in the group
+		Peers PeerList
+
+		// Loader fills keys owned by this peer that are not in Local
+		Loader Loader
+
+		// Local keeps the values owned by this peer. Defaults to a new mem store.
+		// Its MaxAge limits how long values are kept.
+		Local *mem.Store
+
+		// Replicas is the number of points each peer has on the hash ring. Defaults to 50.
+		Replicas int
+
+		// BasePath is the path the handler is served at. Defaults to /_cache/.
+		BasePath string
+
+		// Client is used to fetch values from other peers. Defaults to a client with a 10 second timeout.
+		Client *http.Client
+	}
+
+	// writer is used to read values through the group
+	writer struct {
+		Store *Store
+	}
+)
+
+// Peers returns the list of peers
+func (p StaticPeers) Peers() []string {
+	return p
+}
+
+// New initializes a new peer.
+// If Local, Replicas, BasePath, or Client are not provided they will be set to their default values.
+func New(s *Store) *Store {
+	s.storeType = "peer"
+
+	if s.Self == "" || s.Peers == nil || s.Loader == nil {
+		fmt.Println("cache: the peer store requires Self, Peers, and a Loader.")
+		return nil
+	}
+	s.Self = strings.TrimSuffix(s.Self, "/")
+	if s.Local == nil {
+		s.Local = mem.New(&mem.Store{})
+	}
+	if s.Replicas == 0 {
+		s.Replicas = 50
+	}
+	if s.BasePath == "" {
+		s.BasePath = "/_cache/"
+	}
+	if !strings.HasSuffix(s.BasePath, "/") {
+		s.BasePath += "/"
+	}
+	if s.Client == nil {
+		s.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return s
+}
+
+// Type returns the stores type as a string
+func (s *Store) Type() string {
+	return s.storeType
+}
+
+// Get returns a writer for the current peer store
+func Get(s *Store) *writer {
+	var w writer
+	w.Store = s
+	return &w
+}
+
+// Owner returns the base URL of the peer owning key.
+func (s *Store) Owner(key string) string {
+	s.ringMtx.Lock()
+	defer s.ringMtx.Unlock()
+
+	peers := slices.Clone(s.Peers.Peers())
+	for i := range peers {
+		peers[i] = strings.TrimSuffix(peers[i], "/")
+	}
+	slices.Sort(peers)
+	if s.ring == nil || !slices.Equal(peers, s.ringPeers) {
+		s.ring = newRing(peers, s.Replicas)
+		s.ringPeers = peers
+	}
+	return s.ring.owner(key)
+}
+
+// Read returns the value of key from the peer owning it.
+// If the owner cannot be reached the value is loaded by this peer instead.
+func (w *writer) Read(key string) ([]byte, error) {
+	s := w.Store
+	owner := s.Owner(key)
+	if owner == "" || owner == s.Self {
+		return s.load(key)
+	}
+
+	// Only a peer that cannot be reached is worked around, an error from the owner is returned
+	value, err := s.fetch(owner, key)
+	var status *statusError
+	if err == nil || errors.Is(err, ErrNotFound) || errors.As(err, &status) {
+		return value, err
+	}
+	log.Printf("unable to fetch %s from peer %s, loading it locally: %v", key, owner, err)
+	return s.load(key)
+}
+
+// load returns the value of key from Local, filling it with the Loader on a miss.
+// Concurrent misses for the same key share a single call to the Loader.
+func (s *Store) load(key string) ([]byte, error) {
+	local := mem.Get(s.Local)
+	if value, err := local.Read(key); err == nil {
+		return value, nil
+	}
+
+	return s.flight.do(key, func() ([]byte, error) {
+		if value, err := local.Read(key); err == nil {
+			return value, nil
+		}
+		value, err := s.Loader(key)
+		if err != nil {
+			return nil, err
+		}
+		err = local.Write(key, value, true)
+		return value, err
+	})
+}
+
+// fetch asks a peer for the value of key.
+func (s *Store) fetch(peer string, key string) ([]byte, error) {
+	res, err := s.Client.Get(peer + s.BasePath + url.PathEscape(key))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK:
+		return io.ReadAll(res.Body)
+	case http.StatusNotFound:
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, key)
+	default:
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+		return nil, &statusError{peer: peer, status: res.Status, msg: strings.TrimSpace(string(msg))}
+	}
+}
+
+// statusError is returned when a peer answers with an error
+type statusError struct {
+	peer   string
+	status string
+	msg    string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("peer %s returned %s: %s", e.peer, e.status, e.msg)
+}
+
+// ServeHTTP answers requests from other peers for keys owned by this peer.
+func (s *Store) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	escaped, ok := strings.CutPrefix(r.URL.EscapedPath(), s.BasePath)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	key, err := url.PathUnescape(escaped)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	value, err := s.load(key)
+	if errors.Is(err, ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	_, _ = w.Write(value)
+}
+
+// Trim trims the values kept in Local.
+// It is called by the caches trim worker.
+func (s *Store) Trim() {
+	s.Local.Trim()
+}
+
+// Purge clears the values kept in Local. Other peers are not affected.
+// This function should only be used when stopping the service.
+// If you need to flush the store without stopping it you can
+// call this method directly.
+func (s *Store) Purge() error {
+	return s.Local.Purge()
+}
@@ -0,0 +1,184 @@
+package peer_test
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tmstorm/cache/stores/peer"
+)
+
+// group is a set of in-process peers serving each other on loopback
+type group struct {
+	servers []*httptest.Server
+	stores  []*peer.Store
+	loads   []atomic.Int64
+}
+
+// newGroup starts n peers whose loader returns the key with the index of the peer that loaded it.
+func newGroup(t *testing.T, n int) *group {
+	g := &group{loads: make([]atomic.Int64, n)}
+	var urls peer.StaticPeers
+	handlers := make([]http.Handler, n)
+	for i := 0; i < n; i++ {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			handlers[i].ServeHTTP(w, r)
+		}))
+		t.Cleanup(srv.Close)
+		g.servers = append(g.servers, srv)
+		urls = append(urls, srv.URL)
+	}
+
+	for i := 0; i < n; i++ {
+		s := peer.New(&peer.Store{
+			Self:  urls[i],
+			Peers: urls,
+			Loader: func(key string) ([]byte, error) {
+				g.loads[i].Add(1)
+				if key == "missing" {
+					return nil, peer.ErrNotFound
+				}
+				if key == "broken" {
+					return nil, errors.New("database is down")
+				}
+				return []byte(fmt.Sprint(key, "@", i)), nil
+			},
+		})
+		handlers[i] = s
+		g.stores = append(g.stores, s)
+	}
+	return g
+}
+
+// index returns the index of the peer with the given URL.
+func (g *group) index(url string) int {
+	for i, srv := range g.servers {
+		if srv.URL == url {
+			return i
+		}
+	}
+	return -1
+}
+
+// TestPeerGroup tests each key is loaded once by its owner and read by every peer
+func TestPeerGroup(t *testing.T) {
+	a := assert.New(t)
+	g := newGroup(t, 3)
+
+	owners := make(map[int]int)
+	for k := 0; k < 30; k++ {
+		key := fmt.Sprint("key", k)
+		owner := g.index(g.stores[0].Owner(key))
+		a.NotEqual(-1, owner)
+		owners[owner]++
+
+		// Every peer agrees on the owner and gets the value it loaded
+		for _, s := range g.stores {
+			a.Equal(g.servers[owner].URL, s.Owner(key))
+			v, err := peer.Get(s).Read(key)
+			a.NoError(err)
+			a.Equal(fmt.Sprint(key, "@", owner), string(v))
+		}
+	}
+	a.Len(owners, 3)
+
+	var total int64
+	for i := range g.loads {
+		total += g.loads[i].Load()
+	}
+	a.Equal(int64(30), total)
+
+	// Missing keys and loader failures are returned to the peer asking
+	for _, s := range g.stores {
+		_, err := peer.Get(s).Read("missing")
+		a.ErrorIs(err, peer.ErrNotFound)
+	}
+	for _, s := range g.stores {
+		_, err := peer.Get(s).Read("broken")
+		a.Error(err)
+	}
+}
+
+// TestPeerConcurrentMiss tests concurrent misses for a key share a single load
+func TestPeerConcurrentMiss(t *testing.T) {
+	a := assert.New(t)
+	g := newGroup(t, 2)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := peer.Get(g.stores[i%2]).Read("shared"); err != nil {
+				t.Error(err.Error())
+			}
+		}()
+	}
+	wg.Wait()
+	a.Equal(int64(1), g.loads[0].Load()+g.loads[1].Load())
+}
+
+// TestPeerDown tests a value is loaded locally when its owner cannot be reached
+func TestPeerDown(t *testing.T) {
+	a := assert.New(t)
+	g := newGroup(t, 2)
+
+	var key string
+	for k := 0; ; k++ {
+		key = fmt.Sprint("key", k)
+		if g.stores[0].Owner(key) == g.servers[1].URL {
+			break
+		}
+	}
+	g.servers[1].Close()
+
+	v, err := peer.Get(g.stores[0]).Read(key)
+	a.NoError(err)
+	a.Equal(key+"@0", string(v))
+
+	// The peer list can change while running
+	g.stores[0].Peers = peer.StaticPeers{g.servers[0].URL}
+	a.Equal(g.servers[0].URL, g.stores[0].Owner(key))
+	a.NoError(g.stores[0].Purge())
+}
+
+// TestPeerOwnerError tests an error from the owner is returned rather than loaded locally
+func TestPeerOwnerError(t *testing.T) {
+	a := assert.New(t)
+	g := newGroup(t, 2)
+
+	owner := g.index(g.stores[0].Owner("broken"))
+	other := 1 - owner
+	_, err := peer.Get(g.stores[other]).Read("broken")
+	a.Error(err)
+	a.Equal(int64(1), g.loads[owner].Load())
+	a.Zero(g.loads[other].Load())
+}
+
+// TestPeerLoaderPanic tests a panicking Loader does not block later reads of the key
+func TestPeerLoaderPanic(t *testing.T) {
+	a := assert.New(t)
+
+	var calls atomic.Int64
+	s := peer.New(&peer.Store{
+		Self:  "http://self",
+		Peers: peer.StaticPeers{"http://self"},
+		Loader: func(key string) ([]byte, error) {
+			if calls.Add(1) == 1 {
+				panic("loader failed")
+			}
+			return []byte("value"), nil
+		},
+	})
+	a.NotNil(s)
+
+	a.Panics(func() { _, _ = peer.Get(s).Read("key") })
+	v, err := peer.Get(s).Read("key")
+	a.NoError(err)
+	a.Equal([]byte("value"), v)
+}
@@ -0,0 +1,53 @@
+package peer
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"sort"
+	"strconv"
+)
+
+// ring maps keys to their owning peer with consistent hashing.
+// Each peer is placed on the ring several times so keys spread evenly,
+// and a peer joining or leaving only moves the keys next to its points.
+type ring struct {
+	points []uint32
+	peers  map[uint32]string
+}
+
+// newRing places each peer on the ring replicas times.
+func newRing(peers []string, replicas int) *ring {
+	r := &ring{peers: make(map[uint32]string, len(peers)*replicas)}
+	for _, peer := range peers {
+		for i := range replicas {
+			point := hash(peer + "-" + strconv.Itoa(i))
+			if _, ok := r.peers[point]; ok {
+				continue
+			}
+			r.peers[point] = peer
+			r.points = append(r.points, point)
+		}
+	}
+	sort.Slice(r.points, func(i, j int) bool { return r.points[i] < r.points[j] })
+	return r
+}
+
+// owner returns the peer owning key, which is the first point at or after the keys hash.
+// It returns an empty string if the ring has no peers.
+func (r *ring) owner(key string) string {
+	if len(r.points) == 0 {
+		return ""
+	}
+	h := hash(key)
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= h })
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.peers[r.points[i]]
+}
+
+// hash returns the position of a name on the ring.
+func hash(name string) uint32 {
+	sum := md5.Sum([]byte(name))
+	return binary.LittleEndian.Uint32(sum[:4])
+}
@@ -0,0 +1,181 @@
+package resp
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type (
+	// conn is a single connection to the server
+	conn struct {
+		net.Conn
+		r *bufio.Reader
+		w *bufio.Writer
+	}
+
+	// pool keeps idle connections to the server so they can be reused
+	pool struct {
+		mtx    sync.Mutex
+		idle   []*conn
+		closed bool
+	}
+)
+
+// getConn returns an idle connection or dials a new one.
+func (s *Store) getConn() (*conn, error) {
+	s.pool.mtx.Lock()
+	if s.pool.closed {
+		s.pool.mtx.Unlock()
+		return nil, errors.New("resp: store is closed")
+	}
+	if n := len(s.pool.idle); n > 0 {
+		c := s.pool.idle[n-1]
+		s.pool.idle = s.pool.idle[:n-1]
+		s.pool.mtx.Unlock()
+		return c, nil
+	}
+	s.pool.mtx.Unlock()
+
+	return s.dial()
+}
+
+// putConn returns a connection to the pool.
+// Connections that failed with anything other than an error reply are closed
+// as the replies left on them can no longer be matched to their commands.
+func (s *Store) putConn(c *conn, err error) {
+	var reply Error
+	if err != nil && !errors.As(err, &reply) {
+		c.Close()
+		return
+	}
+
+	s.pool.mtx.Lock()
+	defer s.pool.mtx.Unlock()
+	if s.pool.closed || len(s.pool.idle) >= s.PoolSize {
+		c.Close()
+		return
+	}
+	s.pool.idle = append(s.pool.idle, c)
+}
+
+// dial opens a new connection and prepares it with the stores protocol, credentials, and database.
+func (s *Store) dial() (*conn, error) {
+	nc, err := net.DialTimeout(s.Network, s.Addr, s.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	c := &conn{
+		Conn: nc,
+		r:    bufio.NewReader(nc),
+		w:    bufio.NewWriter(nc),
+	}
+
+	var cmds [][][]byte
+	switch {
+	case s.Protocol == 3:
+		hello := [][]byte{[]byte("HELLO"), []byte("3")}
+		if s.Password != "" {
+			hello = append(hello, []byte("AUTH"), []byte(s.username()), []byte(s.Password))
+		}
+		cmds = append(cmds, hello)
+	case s.Password != "" && s.Username != "":
+		cmds = append(cmds, [][]byte{[]byte("AUTH"), []byte(s.Username), []byte(s.Password)})
+	case s.Password != "":
+		cmds = append(cmds, [][]byte{[]byte("AUTH"), []byte(s.Password)})
+	}
+	if s.DB != 0 {
+		cmds = append(cmds, [][]byte{[]byte("SELECT"), []byte(strconv.Itoa(s.DB))})
+	}
+
+	replies, err := s.do(c, cmds...)
+	if err == nil {
+		err = firstError(replies)
+	}
+	if err != nil {
+		c.Close()
+		return nil, fmt.Errorf("resp: unable to prepare connection: %w", err)
+	}
+	return c, nil
+}
+
+// username returns the user to authenticate as, which is "default" if none is set.
+func (s *Store) username() string {
+	if s.Username == "" {
+		return "default"
+	}
+	return s.Username
+}
+
+// do pipelines the commands on c and returns a reply for each of them.
+// Error replies are returned as values so one failed command does not hide the others.
+func (s *Store) do(c *conn, cmds ...[][]byte) ([]any, error) {
+	if len(cmds) == 0 {
+		return nil, nil
+	}
+	if s.Timeout > 0 {
+		err := c.SetDeadline(time.Now().Add(s.Timeout))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, cmd := range cmds {
+		err := writeCommand(c.w, cmd...)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err := c.w.Flush()
+	if err != nil {
+		return nil, err
+	}
+
+	replies := make([]any, len(cmds))
+	for i := range replies {
+		replies[i], err = readReply(c.r)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return replies, nil
+}
+
+// exec runs the commands on a pooled connection.
+func (s *Store) exec(cmds ...[][]byte) ([]any, error) {
+	c, err := s.getConn()
+	if err != nil {
+		return nil, err
+	}
+	replies, err := s.do(c, cmds...)
+	s.putConn(c, err)
+	return replies, err
+}
+
+// firstError returns the first error reply in replies.
+func firstError(replies []any) error {
+	for _, reply := range replies {
+		if err, ok := reply.(Error); ok {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes every idle connection. The store cannot be used once it is closed.
+func (s *Store) Close() error {
+	s.pool.mtx.Lock()
+	defer s.pool.mtx.Unlock()
+
+	s.pool.closed = true
+	var errs []error
+	for _, c := range s.pool.idle {
+		errs = append(errs, c.Close())
+	}
+	s.pool.idle = nil
+	return errors.Join(errs...)
+}
@@ -0,0 +1,4 @@
+package resp
+
+// ReadReply exposes readReply for testing.
+var ReadReply = readReply
@@ -0,0 +1,77 @@
+package resp
+
+import "time"
+
+type (
+	// Pipeline queues commands and sends them to the server in a single round trip.
+	Pipeline struct {
+		w    *writer
+		cmds [][][]byte
+
+		// results converts each reply into a Result
+		results []func(any) Result
+	}
+
+	// Result is the outcome of a queued command.
+	// Value is only set for reads.
+	Result struct {
+		Key   string
+		Value []byte
+		Err   error
+	}
+)
+
+// Pipeline returns a new pipeline for the store.
+func (w *writer) Pipeline() *Pipeline {
+	return &Pipeline{w: w}
+}
+
+// Write queues a write with a TTL of MaxAge.
+func (p *Pipeline) Write(key string, value []byte, overwrite bool) {
+	p.WriteTTL(key, value, time.Second*time.Duration(p.w.Store.MaxAge), overwrite)
+}
+
+// WriteTTL queues a write with its own TTL.
+func (p *Pipeline) WriteTTL(key string, value []byte, ttl time.Duration, overwrite bool) {
+	p.cmds = append(p.cmds, p.w.Store.set(key, value, ttl, overwrite))
+	p.results = append(p.results, func(reply any) Result {
+		return Result{Key: key, Err: setResult(key, reply)}
+	})
+}
+
+// Read queues a read.
+func (p *Pipeline) Read(key string) {
+	p.cmds = append(p.cmds, p.w.Store.get(key))
+	p.results = append(p.results, func(reply any) Result {
+		value, err := getResult(key, reply)
+		return Result{Key: key, Value: value, Err: err}
+	})
+}
+
+// Remove queues a remove.
+func (p *Pipeline) Remove(key string) {
+	p.cmds = append(p.cmds, p.w.Store.del(key))
+	p.results = append(p.results, func(reply any) Result {
+		return Result{Key: key, Err: firstError([]any{reply})}
+	})
+}
+
+// Exec sends the queued commands and returns their results in the order they were queued.
+// The returned error is only set if the commands could not be sent or their replies read,
+// while errors of individual commands are set in their Result.
+// The pipeline is empty once Exec returns and can be reused.
+func (p *Pipeline) Exec() ([]Result, error) {
+	cmds, results := p.cmds, p.results
+	p.cmds, p.results = nil, nil
+
+	replies, err := p.w.Store.exec(cmds...)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]Result, len(replies))
+	for i, reply := range replies {
+		out[i] = results[i](reply)
+	}
+	return out, nil
+}
@@ -0,0 +1,61 @@
+package resp_test
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tmstorm/cache/stores/resp"
+)
+
+// TestPipeline tests queued commands are sent together and their results kept in order
+func TestPipeline(t *testing.T) {
+	a := assert.New(t)
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(io.Discard)
+	srv := newFakeServer(t, "")
+
+	respStore := resp.New(&resp.Store{Addr: srv.Addr(), Protocol: 3, Prefix: "pipeline:"})
+	a.NotNil(respStore)
+	r := resp.Get(respStore)
+	a.NoError(r.Write("existing", []byte("existing"), false))
+
+	p := r.Pipeline()
+	p.Write("a", []byte("1"), false)
+	p.Write("existing", []byte("other"), false)
+	p.Read("a")
+	p.Read("missing")
+	p.Remove("a")
+	p.Read("a")
+	results, err := p.Exec()
+	a.NoError(err)
+	a.Len(results, 6)
+
+	a.NoError(results[0].Err)
+	a.Error(results[1].Err)
+	a.NoError(results[2].Err)
+	a.Equal("1", string(results[2].Value))
+	a.Equal("missing", results[3].Key)
+	a.Error(results[3].Err)
+	a.NoError(results[4].Err)
+	a.Error(results[5].Err)
+
+	// Pipelines are empty after Exec and can be reused for large batches
+	results, err = p.Exec()
+	a.NoError(err)
+	a.Empty(results)
+	for i := range 2500 {
+		p.Write(fmt.Sprintf("batch:%d", i), []byte("value"), true)
+	}
+	results, err = p.Exec()
+	a.NoError(err)
+	a.Len(results, 2500)
+	a.Equal(2501, srv.Len())
+
+	// Purge follows the SCAN cursor through every batch
+	a.NoError(respStore.Purge())
+	a.Equal(0, srv.Len())
+	a.Equal(int32(1), srv.conns.Load())
+}
@@ -0,0 +1,221 @@
+package resp
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+)
+
+// maxBulkLen is the largest bulk string or aggregate accepted from the server.
+// It matches the default proto-max-bulk-len of Redis.
+const maxBulkLen = 512 << 20
+
+// Error is an error reply sent by the server, e.g. "ERR unknown command".
+type Error string
+
+func (e Error) Error() string {
+	return string(e)
+}
+
+// errProtocol is returned when the server sends a reply that cannot be parsed.
+var errProtocol = errors.New("resp: protocol error")
+
+// writeCommand writes a command to w as a RESP array of bulk strings.
+// The command is buffered until w is flushed so commands can be pipelined.
+func writeCommand(w *bufio.Writer, args ...[]byte) error {
+	_, err := fmt.Fprintf(w, "*%d\r\n", len(args))
+	if err != nil {
+		return err
+	}
+	for _, arg := range args {
+		_, err = fmt.Fprintf(w, "$%d\r\n", len(arg))
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(arg)
+		if err != nil {
+			return err
+		}
+		_, err = w.WriteString("\r\n")
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readReply reads the reply to a single command.
+// RESP3 push messages and attributes sent alongside replies are skipped.
+//
+// Replies are returned as:
+//   - string for simple strings and big numbers
+//   - []byte for bulk and verbatim strings
+//   - int64, float64, and bool for numbers and booleans
+//   - nil for null replies
+//   - []any for arrays and sets, and maps as alternating keys and values
+//   - Error for error replies
+func readReply(r *bufio.Reader) (any, error) {
+	for {
+		kind, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		switch kind {
+		case '>':
+			// Push messages are not replies to a command
+			_, err = readAggregate(r, 1)
+		case '|':
+			// Attributes describe the reply that follows them
+			_, err = readAggregate(r, 2)
+		default:
+			return readValue(r, kind)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// readValue reads a value of the given kind after its type byte has been read.
+func readValue(r *bufio.Reader, kind byte) (any, error) {
+	switch kind {
+	case '+':
+		return readLine(r)
+	case '-':
+		line, err := readLine(r)
+		return Error(line), err
+	case ':':
+		line, err := readLine(r)
+		if err != nil {
+			return nil, err
+		}
+		return parseInt(line)
+	case '$', '=', '!':
+		b, err := readBulk(r)
+		if err != nil || b == nil {
+			return nil, err
+		}
+		switch kind {
+		case '=':
+			// Verbatim strings start with their format, e.g. "txt:"
+			if len(b) < 4 {
+				return nil, errProtocol
+			}
+			return b[4:], nil
+		case '!':
+			return Error(b), nil
+		}
+		return b, nil
+	case '*', '~':
+		return readAggregate(r, 1)
+	case '%':
+		return readAggregate(r, 2)
+	case '_':
+		_, err := readLine(r)
+		return nil, err
+	case '#':
+		line, err := readLine(r)
+		if err != nil {
+			return nil, err
+		}
+		switch line {
+		case "t":
+			return true, nil
+		case "f":
+			return false, nil
+		}
+		return nil, errProtocol
+	case ',':
+		line, err := readLine(r)
+		if err != nil {
+			return nil, err
+		}
+		switch line {
+		case "inf":
+			return math.Inf(1), nil
+		case "-inf":
+			return math.Inf(-1), nil
+		}
+		f, err := strconv.ParseFloat(line, 64)
+		if err != nil {
+			return nil, errProtocol
+		}
+		return f, nil
+	case '(':
+		return readLine(r)
+	}
+	return nil, fmt.Errorf("%w: unknown reply type %q", errProtocol, kind)
+}
+
+// readLine reads a line terminated by CRLF without the terminator.
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return "", errProtocol
+	}
+	return line[:len(line)-2], nil
+}
+
+// readLength reads the length of a bulk string or aggregate.
+// A length of -1 is a RESP2 null and returned as -1.
+func readLength(r *bufio.Reader) (int, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return 0, err
+	}
+	n, err := strconv.Atoi(line)
+	if err != nil || n < -1 || n > maxBulkLen {
+		return 0, errProtocol
+	}
+	return n, nil
+}
+
+// readBulk reads a bulk string after its type byte. Null bulk strings are returned as nil.
+func readBulk(r *bufio.Reader) ([]byte, error) {
+	n, err := readLength(r)
+	if err != nil || n < 0 {
+		return nil, err
+	}
+	b := make([]byte, n+2)
+	_, err = io.ReadFull(r, b)
+	if err != nil {
+		return nil, err
+	}
+	if b[n] != '\r' || b[n+1] != '\n' {
+		return nil, errProtocol
+	}
+	return b[:n], nil
+}
+
+// readAggregate reads an array, set, map, push, or attribute after its type byte.
+// Maps and attributes hold two values for each element.
+func readAggregate(r *bufio.Reader, per int) (any, error) {
+	n, err := readLength(r)
+	if err != nil || n < 0 {
+		return nil, err
+	}
+	values := make([]any, 0, min(n*per, 1024))
+	for range n * per {
+		v, err := readReply(r)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
+
+// parseInt parses an integer reply.
+func parseInt(line string) (int64, error) {
+	n, err := strconv.ParseInt(line, 10, 64)
+	if err != nil {
+		return 0, errProtocol
+	}
+	return n, nil
+}
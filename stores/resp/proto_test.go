@@ -0,0 +1,50 @@
+package resp_test
+
+import (
+	"bufio"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tmstorm/cache/stores/resp"
+)
+
+// Test RESP2 and RESP3 replies are decoded
+func TestReadReply(t *testing.T) {
+	a := assert.New(t)
+
+	replies := map[string]any{
+		"+OK\r\n":                 "OK",
+		"-ERR unknown\r\n":        resp.Error("ERR unknown"),
+		":42\r\n":                 int64(42),
+		"$5\r\nhello\r\n":         []byte("hello"),
+		"$0\r\n\r\n":              []byte{},
+		"$-1\r\n":                 nil,
+		"*-1\r\n":                 nil,
+		"*2\r\n$1\r\na\r\n:1\r\n": []any{[]byte("a"), int64(1)},
+		"_\r\n":                   nil,
+		"#t\r\n":                  true,
+		"#f\r\n":                  false,
+		",1.5\r\n":                1.5,
+		",inf\r\n":                math.Inf(1),
+		"(3492890328409238509324850943850943825024385\r\n": "3492890328409238509324850943850943825024385",
+		"!11\r\nSYNTAX oops\r\n":                           resp.Error("SYNTAX oops"),
+		"=7\r\ntxt:abc\r\n":                                []byte("abc"),
+		"~2\r\n+a\r\n+b\r\n":                               []any{"a", "b"},
+		"%1\r\n+key\r\n:1\r\n":                             []any{"key", int64(1)},
+		">2\r\n+pubsub\r\n+msg\r\n:7\r\n":                  int64(7),
+		"|1\r\n+ttl\r\n:3600\r\n$2\r\nhi\r\n":              []byte("hi"),
+	}
+	for raw, want := range replies {
+		got, err := resp.ReadReply(bufio.NewReader(strings.NewReader(raw)))
+		a.NoError(err, raw)
+		a.Equal(want, got, raw)
+	}
+
+	// Malformed replies are rejected
+	for _, raw := range []string{"?\r\n", ":abc\r\n", "$5\r\nhi\r\n", "$-2\r\n", "+OK\n", "#x\r\n"} {
+		_, err := resp.ReadReply(bufio.NewReader(strings.NewReader(raw)))
+		a.Error(err, raw)
+	}
+}
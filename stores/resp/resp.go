@@ -0,0 +1,267 @@
+// Package resp implements a store saving entries on a server speaking
+// the Redis serialization protocol (RESP2 or RESP3), such as Redis or Valkey.
+package resp
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/tmstorm/cache"
+)
+
+type (
+	// Store implements cache.Store
+	Store struct {
+		storeType string
+		pool      pool
+
+		// Addr is the address of the server, e.g. localhost:6379
+		Addr string
+
+		// Network is the network used to connect to Addr. Defaults to tcp.
+		Network string
+
+		// Username and Password authenticate each connection if Password is set
+		Username string
+		Password string
+
+		// DB is the database selected on each connection
+		DB int
+
+		// Protocol is the RESP version used, either 2 or 3. Defaults to 2.
+		Protocol int
+
+		// PoolSize is the most idle connections kept for reuse. Defaults to 8.
+		PoolSize int
+
+		// Timeout limits dialing and each round trip to the server. Zero does not limit them.
+		Timeout time.Duration
+
+		// Prefix is added to every key so several caches can share a database.
+		// Purge only removes keys with the prefix and does nothing without one.
+		Prefix string
+
+		// MaxAge is the implementation of cache.MaxAge.
+		// It is set as the TTL of each entry so the server expires them.
+		MaxAge cache.MaxAge
+	}
+
+	// writer is used to read, write, and remove entries
+	writer struct {
+		Store *Store
+	}
+)
+
+// New initializes a new store using the server at Addr.
+// Connections are opened when they are first needed.
+func New(s *Store) *Store {
+	s.storeType = "resp"
+
+	if s.Addr == "" {
+		fmt.Println("cache: an address for the resp store has not been provided.")
+		return nil
+	}
+	if s.Network == "" {
+		s.Network = "tcp"
+	}
+	if s.Protocol == 0 {
+		s.Protocol = 2
+	}
+	if s.Protocol != 2 && s.Protocol != 3 {
+		fmt.Println("cache: the resp store only supports protocol 2 or 3.")
+		return nil
+	}
+	if s.PoolSize == 0 {
+		s.PoolSize = 8
+	}
+	if s.MaxAge == 0 {
+		s.MaxAge = cache.DefaultMaxAge
+	}
+	return s
+}
+
+// Type returns the stores type as a string
+func (s *Store) Type() string {
+	return s.storeType
+}
+
+// Get returns a writer for the current resp store
+func Get(s *Store) *writer {
+	var w writer
+	w.Store = s
+	return &w
+}
+
+// Write saves the value under key with a TTL of MaxAge.
+// If overwrite = true the value will be overwriten if it already exists
+func (w *writer) Write(key string, value []byte, overwrite bool) error {
+	return w.WriteTTL(key, value, time.Second*time.Duration(w.Store.MaxAge), overwrite)
+}
+
+// WriteTTL saves the value under key with its own TTL instead of MaxAge.
+// A TTL of zero saves the value without expiry, as in the memcache store.
+// If overwrite = true the value will be overwriten if it already exists
+func (w *writer) WriteTTL(key string, value []byte, ttl time.Duration, overwrite bool) error {
+	replies, err := w.Store.exec(w.Store.set(key, value, ttl, overwrite))
+	if err != nil {
+		return err
+	}
+	return setResult(key, replies[0])
+}
+
+// Read returns the value saved under key.
+func (w *writer) Read(key string) ([]byte, error) {
+	replies, err := w.Store.exec(w.Store.get(key))
+	if err != nil {
+		return []byte{}, err
+	}
+	return getResult(key, replies[0])
+}
+
+// Remove deletes the value saved under key.
+// Removing a key that does not exist is not an error.
+func (w *writer) Remove(key string) error {
+	replies, err := w.Store.exec(w.Store.del(key))
+	if err != nil {
+		return err
+	}
+	return firstError(replies)
+}
+
+// key returns the key saved on the server.
+func (s *Store) key(key string) []byte {
+	return []byte(s.Prefix + key)
+}
+
+// set returns a SET command saving value with the given TTL.
+// TTLs of whole seconds use EX and others use PX. A TTL of zero or less saves the value without expiry.
+func (s *Store) set(key string, value []byte, ttl time.Duration, overwrite bool) [][]byte {
+	cmd := [][]byte{[]byte("SET"), s.key(key), value}
+	switch {
+	case ttl <= 0:
+		// Redis rejects EX 0, so the value is saved without expiry
+	case ttl%time.Second == 0:
+		cmd = append(cmd, []byte("EX"), []byte(strconv.FormatInt(int64(ttl/time.Second), 10)))
+	default:
+		cmd = append(cmd, []byte("PX"), []byte(strconv.FormatInt(max(ttl.Milliseconds(), 1), 10)))
+	}
+	if !overwrite {
+		cmd = append(cmd, []byte("NX"))
+	}
+	return cmd
+}
+
+// get returns a GET command.
+func (s *Store) get(key string) [][]byte {
+	return [][]byte{[]byte("GET"), s.key(key)}
+}
+
+// del returns a DEL command.
+func (s *Store) del(key string) [][]byte {
+	return [][]byte{[]byte("DEL"), s.key(key)}
+}
+
+// setResult returns the error for the reply to a SET command.
+func setResult(key string, reply any) error {
+	switch reply := reply.(type) {
+	case Error:
+		return reply
+	case nil:
+		// NX replies with null if the key exists
+		return fmt.Errorf("key already exists in resp store: %s", key)
+	}
+	return nil
+}
+
+// getResult returns the value or error for the reply to a GET command.
+func getResult(key string, reply any) ([]byte, error) {
+	switch reply := reply.(type) {
+	case Error:
+		return []byte{}, reply
+	case nil:
+		return []byte{}, fmt.Errorf("key not found in resp store: %s", key)
+	case []byte:
+		return reply, nil
+	}
+	return []byte{}, fmt.Errorf("%w: unexpected reply to GET", errProtocol)
+}
+
+// Trim does nothing as the server expires entries by their TTL.
+// It is called by the caches trim worker.
+func (s *Store) Trim() {}
+
+// Purge removes every key with the stores Prefix.
+// Without a Prefix nothing is removed, as the keys of the store cannot be told apart
+// from those of anything else using the database.
+// Keys are found with SCAN so the server is not blocked.
+// This function should only be used when stopping the service.
+// If you need to flush the store without stopping it you can
+// call this method directly.
+func (s *Store) Purge() error {
+	if s.Prefix == "" {
+		log.Println("Resp store has no prefix, skipping purge")
+		return nil
+	}
+	log.Println("Resp store is being purged...")
+
+	pattern := []byte(globEscape(s.Prefix) + "*")
+	cursor := []byte("0")
+	for {
+		replies, err := s.exec([][]byte{[]byte("SCAN"), cursor, []byte("MATCH"), pattern, []byte("COUNT"), []byte("1000")})
+		if err != nil {
+			return err
+		}
+		if err := firstError(replies); err != nil {
+			return err
+		}
+
+		scan, ok := replies[0].([]any)
+		if !ok || len(scan) != 2 {
+			return fmt.Errorf("%w: unexpected reply to SCAN", errProtocol)
+		}
+		cursor, ok = scan[0].([]byte)
+		keys, _ := scan[1].([]any)
+		if !ok {
+			return fmt.Errorf("%w: unexpected reply to SCAN", errProtocol)
+		}
+
+		if len(keys) > 0 {
+			del := [][]byte{[]byte("DEL")}
+			for _, key := range keys {
+				if key, ok := key.([]byte); ok {
+					del = append(del, key)
+				}
+			}
+			replies, err = s.exec(del)
+			if err != nil {
+				return err
+			}
+			if err := firstError(replies); err != nil {
+				return err
+			}
+		}
+
+		if bytes.Equal(cursor, []byte("0")) {
+			break
+		}
+	}
+
+	log.Println("Resp store purge complete")
+	return nil
+}
+
+// globEscape escapes the characters SCAN MATCH treats as a pattern.
+func globEscape(s string) string {
+	var b bytes.Buffer
+	for _, r := range s {
+		switch r {
+		case '*', '?', '[', ']', '\\':
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
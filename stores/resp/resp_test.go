@@ -0,0 +1,138 @@
+package resp_test
+
+import (
+	"io"
+	"log"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tmstorm/cache"
+	"github.com/tmstorm/cache/stores/resp"
+)
+
+// TestRespStore tests the store against the fake server with both protocol versions
+func TestRespStore(t *testing.T) {
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(io.Discard)
+
+	for _, protocol := range []int{2, 3} {
+		a := assert.New(t)
+		srv := newFakeServer(t, "")
+
+		respStore := resp.New(&resp.Store{
+			Addr:     srv.Addr(),
+			Protocol: protocol,
+			Prefix:   "app:",
+			MaxAge:   20,
+		})
+		a.NotNil(respStore)
+		a.Equal("resp", respStore.Type())
+		a.NotZero(len(cache.MakeStores(respStore)))
+		r := resp.Get(respStore)
+
+		a.NoError(r.Write("logo", []byte("<svg></svg>"), false))
+		b, err := r.Read("logo")
+		a.NoError(err)
+		a.Equal("<svg></svg>", string(b))
+
+		// SET NX keeps existing keys
+		a.Error(r.Write("logo", []byte("other"), false))
+		a.NoError(r.Write("logo", []byte("<svg>new</svg>"), true))
+		b, err = r.Read("logo")
+		a.NoError(err)
+		a.Equal("<svg>new</svg>", string(b))
+
+		// Entries expire by their TTL
+		a.NoError(r.WriteTTL("short", []byte("short lived"), time.Millisecond*50, false))
+		_, err = r.Read("short")
+		a.NoError(err)
+		time.Sleep(time.Millisecond * 100)
+		_, err = r.Read("short")
+		a.Error(err)
+
+		// A zero TTL saves the entry without expiry
+		a.NoError(r.WriteTTL("forever", []byte("kept"), 0, false))
+		b, err = r.Read("forever")
+		a.NoError(err)
+		a.Equal("kept", string(b))
+		a.NoError(r.Remove("forever"))
+
+		a.NoError(r.Remove("logo"))
+		_, err = r.Read("logo")
+		a.Error(err)
+		a.NoError(r.Remove("logo"))
+
+		// Sequential commands reuse a single connection
+		a.Equal(int32(1), srv.conns.Load(), protocol)
+
+		// Purge only removes keys with the prefix
+		other := resp.Get(resp.New(&resp.Store{Addr: srv.Addr()}))
+		a.NoError(other.Write("unrelated", []byte("kept"), false))
+		for _, key := range []string{"a", "b", "c"} {
+			a.NoError(r.Write(key, []byte(key), false))
+		}
+		a.Equal(4, srv.Len())
+		a.NoError(respStore.Purge())
+		a.Equal(1, srv.Len())
+		_, err = other.Read("unrelated")
+		a.NoError(err)
+
+		// Without a prefix nothing is removed
+		a.NoError(r.Write("a", []byte("a"), false))
+		a.NoError(other.Store.Purge())
+		a.Equal(2, srv.Len())
+
+		a.NoError(respStore.Close())
+		a.Error(r.Write("closed", []byte("closed"), true))
+	}
+}
+
+// TestRespConcurrency tests the pool under concurrent use
+func TestRespConcurrency(t *testing.T) {
+	a := assert.New(t)
+	srv := newFakeServer(t, "")
+
+	respStore := resp.New(&resp.Store{Addr: srv.Addr(), PoolSize: 4})
+	a.NotNil(respStore)
+	r := resp.Get(respStore)
+
+	var wg sync.WaitGroup
+	for range 16 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 50 {
+				a.NoError(r.Write("key", []byte("value"), true))
+				b, err := r.Read("key")
+				a.NoError(err)
+				a.Equal("value", string(b))
+			}
+		}()
+	}
+	wg.Wait()
+	a.NoError(respStore.Close())
+}
+
+// TestRespAuth tests connections are authenticated and select their database
+func TestRespAuth(t *testing.T) {
+	a := assert.New(t)
+	srv := newFakeServer(t, "secret")
+
+	for _, protocol := range []int{2, 3} {
+		r := resp.Get(resp.New(&resp.Store{Addr: srv.Addr(), Protocol: protocol, Password: "wrong"}))
+		err := r.Write("key", []byte("value"), true)
+		var reply resp.Error
+		a.ErrorAs(err, &reply)
+
+		r = resp.Get(resp.New(&resp.Store{Addr: srv.Addr(), Protocol: protocol, Password: "secret", DB: 2}))
+		a.NoError(r.Write("key", []byte("value"), true))
+		b, err := r.Read("key")
+		a.NoError(err)
+		a.Equal("value", string(b))
+	}
+
+	a.Nil(resp.New(&resp.Store{}))
+	a.Nil(resp.New(&resp.Store{Addr: srv.Addr(), Protocol: 4}))
+}
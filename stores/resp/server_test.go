@@ -0,0 +1,312 @@
+package resp_test
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type (
+	// fakeServer is an in-process stand-in for a RESP server implementing
+	// the commands used by the store.
+	fakeServer struct {
+		ln       net.Listener
+		password string
+
+		// conns counts the connections accepted
+		conns atomic.Int32
+
+		mtx  sync.Mutex
+		data map[string]fakeEntry
+	}
+
+	// fakeEntry is a value saved on the fake server
+	fakeEntry struct {
+		value   []byte
+		expires time.Time
+	}
+
+	// fakeConn is the state of a connection to the fake server
+	fakeConn struct {
+		w      *bufio.Writer
+		proto  int
+		authed bool
+	}
+)
+
+// newFakeServer starts a fake server that requires password if it is not empty.
+func newFakeServer(t *testing.T, password string) *fakeServer {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &fakeServer{
+		ln:       ln,
+		password: password,
+		data:     make(map[string]fakeEntry),
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			srv.conns.Add(1)
+			go srv.serve(c)
+		}
+	}()
+	return srv
+}
+
+// Addr returns the address the fake server listens on.
+func (srv *fakeServer) Addr() string {
+	return srv.ln.Addr().String()
+}
+
+// Len returns the number of keys saved on the fake server that have not expired.
+func (srv *fakeServer) Len() int {
+	srv.mtx.Lock()
+	defer srv.mtx.Unlock()
+	n := 0
+	for key := range srv.data {
+		if _, ok := srv.lookup(key); ok {
+			n++
+		}
+	}
+	return n
+}
+
+// serve reads commands from c until it is closed.
+func (srv *fakeServer) serve(c net.Conn) {
+	defer c.Close()
+	r := bufio.NewReader(c)
+	fc := &fakeConn{w: bufio.NewWriter(c), proto: 2, authed: srv.password == ""}
+
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			return
+		}
+		srv.handle(fc, args)
+
+		// Flush once every pipelined command has been handled
+		if r.Buffered() == 0 {
+			if fc.w.Flush() != nil {
+				return
+			}
+		}
+	}
+}
+
+// readCommand reads a command sent as an array of bulk strings.
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "*") {
+		return nil, fmt.Errorf("unexpected command %q", line)
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+	if err != nil {
+		return nil, err
+	}
+
+	args := make([]string, n)
+	for i := range args {
+		line, err = r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+		if err != nil {
+			return nil, err
+		}
+		b := make([]byte, size+2)
+		_, err = io.ReadFull(r, b)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = string(b[:size])
+	}
+	return args, nil
+}
+
+// handle runs a single command and writes its reply.
+func (srv *fakeServer) handle(c *fakeConn, args []string) {
+	cmd := strings.ToUpper(args[0])
+	switch {
+	case cmd == "HELLO":
+		if len(args) >= 5 && strings.ToUpper(args[2]) == "AUTH" {
+			if args[4] != srv.password {
+				c.error("WRONGPASS invalid username-password pair")
+				return
+			}
+			c.authed = true
+		}
+		if !c.authed {
+			c.error("NOAUTH HELLO must be called with the client already authenticated")
+			return
+		}
+		if len(args) >= 2 {
+			c.proto, _ = strconv.Atoi(args[1])
+		}
+		if c.proto == 3 {
+			fmt.Fprintf(c.w, "%%2\r\n+server\r\n+fake\r\n+proto\r\n:%d\r\n", c.proto)
+		} else {
+			fmt.Fprintf(c.w, "*4\r\n$6\r\nserver\r\n$4\r\nfake\r\n$5\r\nproto\r\n:%d\r\n", c.proto)
+		}
+		return
+	case cmd == "AUTH":
+		if args[len(args)-1] != srv.password {
+			c.error("WRONGPASS invalid username-password pair")
+			return
+		}
+		c.authed = true
+		c.w.WriteString("+OK\r\n")
+		return
+	case !c.authed:
+		c.error("NOAUTH Authentication required.")
+		return
+	}
+
+	srv.mtx.Lock()
+	defer srv.mtx.Unlock()
+
+	switch cmd {
+	case "SELECT":
+		c.w.WriteString("+OK\r\n")
+	case "SET":
+		entry := fakeEntry{value: []byte(args[2])}
+		nx := false
+		for i := 3; i < len(args); i++ {
+			switch strings.ToUpper(args[i]) {
+			case "EX", "PX":
+				n, _ := strconv.Atoi(args[i+1])
+				if n <= 0 {
+					c.w.WriteString("-ERR invalid expire time in 'set' command\r\n")
+					return
+				}
+				unit := time.Second
+				if strings.ToUpper(args[i]) == "PX" {
+					unit = time.Millisecond
+				}
+				entry.expires = time.Now().Add(time.Duration(n) * unit)
+				i++
+			case "NX":
+				nx = true
+			}
+		}
+		if _, ok := srv.lookup(args[1]); ok && nx {
+			c.null()
+			return
+		}
+		srv.data[args[1]] = entry
+		c.w.WriteString("+OK\r\n")
+	case "GET":
+		// Push messages may arrive before any reply on RESP3
+		if c.proto == 3 {
+			c.w.WriteString(">2\r\n$10\r\ninvalidate\r\n*1\r\n$5\r\nother\r\n")
+		}
+		entry, ok := srv.lookup(args[1])
+		if !ok {
+			c.null()
+			return
+		}
+		fmt.Fprintf(c.w, "$%d\r\n%s\r\n", len(entry.value), entry.value)
+	case "DEL":
+		n := 0
+		for _, key := range args[1:] {
+			if _, ok := srv.lookup(key); ok {
+				n++
+			}
+			delete(srv.data, key)
+		}
+		fmt.Fprintf(c.w, ":%d\r\n", n)
+	case "SCAN":
+		srv.scan(c, args)
+	default:
+		c.error("ERR unknown command '" + args[0] + "'")
+	}
+}
+
+// scan replies to SCAN. The cursor is the hex encoded key to continue from
+// so keys removed between calls do not cause others to be skipped.
+func (srv *fakeServer) scan(c *fakeConn, args []string) {
+	var from string
+	if args[1] != "0" {
+		b, _ := hex.DecodeString(args[1])
+		from = string(b)
+	}
+	pattern, count := "*", 10
+	for i := 2; i+1 < len(args); i += 2 {
+		switch strings.ToUpper(args[i]) {
+		case "MATCH":
+			pattern = args[i+1]
+		case "COUNT":
+			count, _ = strconv.Atoi(args[i+1])
+		}
+	}
+
+	keys := make([]string, 0, len(srv.data))
+	for key := range srv.data {
+		if key >= from {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	var found []string
+	next := "0"
+	for i, key := range keys {
+		if i >= count {
+			next = hex.EncodeToString([]byte(key))
+			break
+		}
+		if ok, _ := path.Match(pattern, key); ok {
+			found = append(found, key)
+		}
+	}
+
+	fmt.Fprintf(c.w, "*2\r\n$%d\r\n%s\r\n*%d\r\n", len(next), next, len(found))
+	for _, key := range found {
+		fmt.Fprintf(c.w, "$%d\r\n%s\r\n", len(key), key)
+	}
+}
+
+// lookup returns the entry saved under key if it has not expired.
+func (srv *fakeServer) lookup(key string) (fakeEntry, bool) {
+	entry, ok := srv.data[key]
+	if ok && !entry.expires.IsZero() && time.Now().After(entry.expires) {
+		delete(srv.data, key)
+		return fakeEntry{}, false
+	}
+	return entry, ok
+}
+
+// null writes a null reply in the connections protocol.
+func (c *fakeConn) null() {
+	if c.proto == 3 {
+		c.w.WriteString("_\r\n")
+		return
+	}
+	c.w.WriteString("$-1\r\n")
+}
+
+// error writes an error reply.
+func (c *fakeConn) error(msg string) {
+	c.w.WriteString("-" + msg + "\r\n")
+}
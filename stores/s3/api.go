@@ -0,0 +1,381 @@
+package s3
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+type (
+	// Error is an error response from the object store.
+	Error struct {
+		StatusCode int    `xml:"-"`
+		Code       string `xml:"Code"`
+		Message    string `xml:"Message"`
+	}
+
+	// initiateResult is the response to CreateMultipartUpload
+	initiateResult struct {
+		UploadID string `xml:"UploadId"`
+	}
+
+	// completeUpload is the request body of CompleteMultipartUpload
+	completeUpload struct {
+		XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+		Parts   []completedPart `xml:"Part"`
+	}
+
+	// completedPart is a part of a multipart upload
+	completedPart struct {
+		PartNumber int
+		ETag       string
+	}
+
+	// listResult is the response to ListObjectsV2
+	listResult struct {
+		Contents              []object
+		IsTruncated           bool
+		NextContinuationToken string
+	}
+
+	// object is an object listed by ListObjectsV2
+	object struct {
+		Key          string
+		LastModified time.Time
+		Size         int64
+	}
+
+	// uploadsResult is the response to ListMultipartUploads
+	uploadsResult struct {
+		Uploads            []upload `xml:"Upload"`
+		IsTruncated        bool
+		NextKeyMarker      string
+		NextUploadIDMarker string `xml:"NextUploadIdMarker"`
+	}
+
+	// upload is an upload listed by ListMultipartUploads
+	upload struct {
+		Key       string
+		UploadID  string `xml:"UploadId"`
+		Initiated time.Time
+	}
+
+	// deleteRequest is the request body of DeleteObjects
+	deleteRequest struct {
+		XMLName xml.Name       `xml:"Delete"`
+		Quiet   bool           `xml:"Quiet"`
+		Objects []deleteObject `xml:"Object"`
+	}
+
+	// deleteObject is an object to delete with DeleteObjects
+	deleteObject struct {
+		Key string
+	}
+
+	// deleteResult is the response to DeleteObjects
+	deleteResult struct {
+		Errors []struct {
+			Key     string
+			Code    string
+			Message string
+		} `xml:"Error"`
+	}
+)
+
+// maxDeleteKeys is the most objects DeleteObjects removes in one request.
+const maxDeleteKeys = 1000
+
+const (
+	// maxParts is the most parts a multipart upload can have
+	maxParts = 10000
+
+	// maxPartSize is the largest part S3 accepts
+	maxPartSize int64 = 5 << 30
+)
+
+// minPartSize is the smallest part S3 accepts for every part but the last
+var minPartSize int64 = 5 << 20
+
+// errPreconditionFailed is returned when an object exists and overwrite is false.
+var errPreconditionFailed = errors.New("precondition failed")
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("s3: %d %s: %s", e.StatusCode, e.Code, e.Message)
+}
+
+// objectURL returns the URL of the object key, or of the bucket if key is empty.
+func (s *Store) objectURL(key string, query url.Values) *url.URL {
+	u := *s.endpoint
+	p := "/" + key
+	if s.PathStyle {
+		p = "/" + s.Bucket
+		if key != "" {
+			p += "/" + key
+		}
+	} else {
+		u.Host = s.Bucket + "." + u.Host
+	}
+	u.Path = p
+	u.RawPath = uriEncode(p, false)
+	u.RawQuery = canonicalQuery(query)
+	return &u
+}
+
+// do sends a signed request and returns the response if it succeeded.
+// The caller must close the response body.
+func (s *Store) do(method string, key string, query url.Values, header http.Header, body []byte) (*http.Response, error) {
+	req, err := http.NewRequest(method, s.objectURL(key, query).String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	req.ContentLength = int64(len(body))
+
+	payloadHash := emptyHash
+	if len(body) > 0 {
+		payloadHash = hashHex(body)
+	}
+	sign(req, s.Credentials, s.Region, payloadHash, time.Now())
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 == 2 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusPreconditionFailed {
+		return nil, errPreconditionFailed
+	}
+	return nil, readError(resp)
+}
+
+// readError returns the error described by a failed response.
+func readError(resp *http.Response) error {
+	e := &Error{StatusCode: resp.StatusCode, Code: http.StatusText(resp.StatusCode)}
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<16))
+	if len(b) > 0 {
+		_ = xml.Unmarshal(b, e)
+	}
+	return e
+}
+
+// doXML sends a request and decodes its XML response into v.
+// Some operations report errors in the body of a 200 response, which are returned as well.
+func (s *Store) doXML(method string, key string, query url.Values, header http.Header, body []byte, v any) error {
+	resp, err := s.do(method, key, query, header, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if rootElement(b) == "Error" {
+		e := &Error{StatusCode: resp.StatusCode}
+		_ = xml.Unmarshal(b, e)
+		if e.Code == "PreconditionFailed" {
+			return errPreconditionFailed
+		}
+		return e
+	}
+	if v == nil {
+		return nil
+	}
+	return xml.Unmarshal(b, v)
+}
+
+// rootElement returns the name of the root element of an XML document.
+func rootElement(b []byte) string {
+	d := xml.NewDecoder(bytes.NewReader(b))
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return ""
+		}
+		if start, ok := tok.(xml.StartElement); ok {
+			return start.Name.Local
+		}
+	}
+}
+
+// putObject saves data as key, using a multipart upload if it is larger than PartSize.
+func (s *Store) putObject(key string, data []byte, overwrite bool) error {
+	header := make(http.Header)
+	if !overwrite {
+		header.Set("If-None-Match", "*")
+	}
+
+	if int64(len(data)) > s.PartSize*maxParts {
+		return fmt.Errorf("entry is too large for s3 store: %d bytes is more than %d parts of %d bytes", len(data), maxParts, s.PartSize)
+	}
+	if int64(len(data)) <= s.PartSize {
+		resp, err := s.do(http.MethodPut, key, nil, header, data)
+		if err != nil {
+			return err
+		}
+		return resp.Body.Close()
+	}
+	return s.multipartUpload(key, data, header)
+}
+
+// multipartUpload saves data as key in parts of PartSize.
+// The upload is aborted if any part fails.
+func (s *Store) multipartUpload(key string, data []byte, header http.Header) error {
+	var initiated initiateResult
+	err := s.doXML(http.MethodPost, key, url.Values{"uploads": {""}}, nil, nil, &initiated)
+	if err != nil {
+		return err
+	}
+
+	err = s.uploadParts(key, initiated.UploadID, data, header)
+	if err != nil {
+		abortErr := s.abortUpload(key, initiated.UploadID)
+		return errors.Join(err, abortErr)
+	}
+	return nil
+}
+
+// uploadParts uploads each part of data and completes the upload.
+func (s *Store) uploadParts(key string, uploadID string, data []byte, header http.Header) error {
+	var complete completeUpload
+	for part := 1; len(data) > 0; part++ {
+		n := min(int64(len(data)), s.PartSize)
+		query := url.Values{"partNumber": {strconv.Itoa(part)}, "uploadId": {uploadID}}
+		resp, err := s.do(http.MethodPut, key, query, nil, data[:n])
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+
+		complete.Parts = append(complete.Parts, completedPart{PartNumber: part, ETag: resp.Header.Get("ETag")})
+		data = data[n:]
+	}
+
+	body, err := xml.Marshal(complete)
+	if err != nil {
+		return err
+	}
+	return s.doXML(http.MethodPost, key, url.Values{"uploadId": {uploadID}}, header, body, nil)
+}
+
+// abortUpload aborts a multipart upload and removes its parts.
+func (s *Store) abortUpload(key string, uploadID string) error {
+	resp, err := s.do(http.MethodDelete, key, url.Values{"uploadId": {uploadID}}, nil, nil)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// getObject returns the contents of key. The returned error is nil and found false if it does not exist.
+func (s *Store) getObject(key string) ([]byte, bool, error) {
+	resp, err := s.do(http.MethodGet, key, nil, nil, nil)
+	var e *Error
+	if errors.As(err, &e) && e.StatusCode == http.StatusNotFound {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(resp.Body)
+	return b, err == nil, err
+}
+
+// deleteObject removes key. Removing an object that does not exist is not an error.
+func (s *Store) deleteObject(key string) error {
+	resp, err := s.do(http.MethodDelete, key, nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// listObjects calls fn with each page of objects whose key starts with prefix.
+func (s *Store) listObjects(prefix string, fn func([]object) error) error {
+	query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+	for {
+		var page listResult
+		err := s.doXML(http.MethodGet, "", query, nil, nil, &page)
+		if err != nil {
+			return err
+		}
+		err = fn(page.Contents)
+		if err != nil {
+			return err
+		}
+		if !page.IsTruncated {
+			return nil
+		}
+		query.Set("continuation-token", page.NextContinuationToken)
+	}
+}
+
+// listUploads calls fn with each page of incomplete multipart uploads whose key starts with prefix.
+func (s *Store) listUploads(prefix string, fn func([]upload) error) error {
+	query := url.Values{"uploads": {""}, "prefix": {prefix}}
+	for {
+		var page uploadsResult
+		err := s.doXML(http.MethodGet, "", query, nil, nil, &page)
+		if err != nil {
+			return err
+		}
+		err = fn(page.Uploads)
+		if err != nil {
+			return err
+		}
+		if !page.IsTruncated {
+			return nil
+		}
+		query.Set("key-marker", page.NextKeyMarker)
+		query.Set("upload-id-marker", page.NextUploadIDMarker)
+	}
+}
+
+// deleteObjects removes the given keys in batches of up to 1000.
+func (s *Store) deleteObjects(keys []string) error {
+	for len(keys) > 0 {
+		n := min(len(keys), maxDeleteKeys)
+		req := deleteRequest{Quiet: true}
+		for _, key := range keys[:n] {
+			req.Objects = append(req.Objects, deleteObject{Key: key})
+		}
+		keys = keys[n:]
+
+		body, err := xml.Marshal(req)
+		if err != nil {
+			return err
+		}
+		sum := md5.Sum(body)
+		header := make(http.Header)
+		header.Set("Content-MD5", base64.StdEncoding.EncodeToString(sum[:]))
+		header.Set("Content-Type", "application/xml")
+
+		var result deleteResult
+		err = s.doXML(http.MethodPost, "", url.Values{"delete": {""}}, header, body, &result)
+		if err != nil {
+			return err
+		}
+		if len(result.Errors) > 0 {
+			e := result.Errors[0]
+			return fmt.Errorf("s3: unable to delete %d objects, %s: %s %s", len(result.Errors), e.Key, e.Code, e.Message)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,12 @@
+package s3
+
+// Sign exposes sign for testing.
+var Sign = sign
+
+// SetMinPartSize replaces the smallest part size New accepts
+// and returns a function restoring the original.
+func SetMinPartSize(n int64) (restore func()) {
+	original := minPartSize
+	minPartSize = n
+	return func() { minPartSize = original }
+}
@@ -0,0 +1,244 @@
+// Package s3 implements a store saving entries as objects in an S3 compatible object store.
+// Requests are signed with AWS Signature Version 4.
+package s3
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/tmstorm/cache"
+)
+
+type (
+	// Store implements cache.Store
+	Store struct {
+		storeType string
+		endpoint  *url.URL
+
+		// Endpoint is the URL of the object store, e.g. https://s3.us-east-1.amazonaws.com
+		Endpoint string
+
+		// Region is the region requests are signed for. Defaults to us-east-1.
+		Region string
+
+		// Bucket is the bucket entries are saved in
+		Bucket string
+
+		// PathStyle addresses the bucket in the path instead of the host name,
+		// which most self-hosted object stores require.
+		PathStyle bool
+
+		// Credentials sign each request
+		Credentials Credentials
+
+		// Prefix is added to the key of every object so several caches can share a bucket.
+		// Trim and Purge only remove objects with the prefix, and Purge does nothing without one.
+		Prefix string
+
+		// PartSize is the size of each part of a multipart upload.
+		// Entries larger than PartSize are uploaded in parts. Defaults to 8 MiB.
+		// S3 requires every part but the last to be between 5 MiB and 5 GiB,
+		// and an upload can have at most 10000 parts, which limits the size of an entry.
+		PartSize int64
+
+		// Client sends the requests. Defaults to http.DefaultClient.
+		Client *http.Client
+
+		// MaxAge is the implementation of cache.MaxAge for use during trimming old objects
+		MaxAge cache.MaxAge
+	}
+
+	// writer is used to implement the store for read, write, and remove
+	writer struct {
+		Store *Store
+	}
+)
+
+// New initializes a new store saving entries in Bucket.
+func New(s *Store) *Store {
+	s.storeType = "s3"
+
+	if s.Endpoint == "" || s.Bucket == "" {
+		fmt.Println("cache: an endpoint and bucket for the s3 store have not been provided.")
+		return nil
+	}
+	endpoint, err := url.Parse(s.Endpoint)
+	if err != nil || endpoint.Host == "" {
+		fmt.Printf("cache: the s3 endpoint is not a valid URL: %s\n", s.Endpoint)
+		return nil
+	}
+	s.endpoint = endpoint
+
+	if s.Region == "" {
+		s.Region = "us-east-1"
+	}
+	if s.PartSize == 0 {
+		s.PartSize = 8 << 20
+	}
+	if s.PartSize < minPartSize || s.PartSize > maxPartSize {
+		fmt.Printf("cache: the s3 part size must be between %d and %d bytes: %d\n", minPartSize, maxPartSize, s.PartSize)
+		return nil
+	}
+	if s.Client == nil {
+		s.Client = http.DefaultClient
+	}
+	if s.MaxAge == 0 {
+		s.MaxAge = cache.DefaultMaxAge
+	}
+	return s
+}
+
+// Type returns the stores type as a string
+func (s *Store) Type() string {
+	return s.storeType
+}
+
+// Get returns a writer for the current s3 store
+func Get(s *Store) *writer {
+	var w writer
+	w.Store = s
+	return &w
+}
+
+// objectKey returns the key of the object saving fileName under path.
+// Paths may not leave the stores prefix.
+func (s *Store) objectKey(dir string, fileName string) (string, error) {
+	name := path.Join(dir, fileName)
+	if !fs.ValidPath(name) || name == "." {
+		return "", fmt.Errorf("invalid path for s3 store: %s", path.Join(dir, fileName))
+	}
+	return s.Prefix + name, nil
+}
+
+// Write saves the data passed with the fileName given to the given path.
+// If overwrite = true the object will be overwriten if it already exists
+func (w *writer) Write(path string, fileName string, data []byte, overwrite bool) error {
+	key, err := w.Store.objectKey(path, fileName)
+	if err != nil {
+		return err
+	}
+
+	err = w.Store.putObject(key, data, overwrite)
+	if errors.Is(err, errPreconditionFailed) {
+		return fmt.Errorf("file already exists in store: %s", key)
+	}
+	return err
+}
+
+// Read returns the data saved with fileName under path.
+func (w *writer) Read(path string, fileName string) ([]byte, error) {
+	key, err := w.Store.objectKey(path, fileName)
+	if err != nil {
+		return []byte{}, err
+	}
+
+	data, found, err := w.Store.getObject(key)
+	if err != nil {
+		return []byte{}, err
+	}
+	if !found {
+		return []byte{}, fmt.Errorf("file not found in s3 store: %s", key)
+	}
+	return data, nil
+}
+
+// Remove deletes the object saved with fileName under path.
+// Removing an object that does not exist is not an error.
+func (w *writer) Remove(path string, fileName string) error {
+	key, err := w.Store.objectKey(path, fileName)
+	if err != nil {
+		return err
+	}
+	return w.Store.deleteObject(key)
+}
+
+// Trim removes objects whose LastModified is older than MaxAge and aborts
+// multipart uploads started before MaxAge so their parts do not linger.
+// It is called by the caches trim worker.
+// This can be called directly if needed.
+func (s *Store) Trim() {
+	log.Println("Starting s3 store trimming...")
+
+	cutoff := time.Now().Add(-time.Second * time.Duration(s.MaxAge))
+	err := s.listObjects(s.Prefix, func(objects []object) error {
+		var expired []string
+		for _, obj := range objects {
+			if obj.LastModified.Before(cutoff) {
+				expired = append(expired, obj.Key)
+			}
+		}
+		return s.deleteObjects(expired)
+	})
+	if err != nil {
+		log.Printf("unable to trim s3 store: %v", err)
+	}
+
+	err = s.abortUploads(func(u upload) bool { return u.Initiated.Before(cutoff) })
+	if err != nil {
+		log.Printf("unable to abort s3 uploads: %v", err)
+	}
+
+	log.Println("S3 store trimming complete")
+}
+
+// Purge removes every object and multipart upload with the stores Prefix.
+// Without a Prefix nothing is removed, as the objects of the store cannot be told apart
+// from those of anything else using the bucket.
+// This function should only be used when stopping the service.
+// If you need to flush the store without stopping it you can
+// call this method directly.
+func (s *Store) Purge() error {
+	if s.Prefix == "" {
+		log.Println("S3 store has no prefix, skipping purge")
+		return nil
+	}
+	log.Println("S3 store is being purged...")
+
+	err := s.listObjects(s.Prefix, func(objects []object) error {
+		keys := make([]string, len(objects))
+		for i, obj := range objects {
+			keys[i] = obj.Key
+		}
+		return s.deleteObjects(keys)
+	})
+	if err != nil {
+		return err
+	}
+
+	err = s.abortUploads(func(upload) bool { return true })
+	if err != nil {
+		return err
+	}
+
+	log.Println("S3 store purge complete")
+	return nil
+}
+
+// abortUploads aborts the multipart uploads with the stores Prefix that match.
+func (s *Store) abortUploads(match func(upload) bool) error {
+	var stale []upload
+	err := s.listUploads(s.Prefix, func(uploads []upload) error {
+		for _, u := range uploads {
+			if match(u) && strings.HasPrefix(u.Key, s.Prefix) {
+				stale = append(stale, u)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, u := range stale {
+		errs = append(errs, s.abortUpload(u.Key, u.UploadID))
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,159 @@
+package s3_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tmstorm/cache"
+	"github.com/tmstorm/cache/stores/s3"
+)
+
+// newStore returns a store using the fake server.
+func newStore(t *testing.T, srv *fakeServer, prefix string) *s3.Store {
+	t.Helper()
+	defer s3.SetMinPartSize(16)()
+	s := s3.New(&s3.Store{
+		Endpoint:  srv.URL,
+		Bucket:    fakeBucket,
+		PathStyle: true,
+		Prefix:    prefix,
+		PartSize:  16,
+		MaxAge:    20,
+		Credentials: s3.Credentials{
+			AccessKeyID:     fakeAccessKey,
+			SecretAccessKey: "secret",
+		},
+	})
+	if s == nil {
+		t.Fatal("s3 store could not be created")
+	}
+	return s
+}
+
+// TestS3Store tests writing, reading, and removing objects
+func TestS3Store(t *testing.T) {
+	a := assert.New(t)
+	srv := newFakeServer(t)
+	s3Store := newStore(t, srv, "app/")
+	a.Equal("s3", s3Store.Type())
+	a.NotZero(len(cache.MakeStores(s3Store)))
+	w := s3.Get(s3Store)
+
+	a.NoError(w.Write("images", "logo.svg", []byte("<svg></svg>"), false))
+	data, ok := srv.Object("app/images/logo.svg")
+	a.True(ok)
+	a.Equal("<svg></svg>", string(data))
+	b, err := w.Read("images", "logo.svg")
+	a.NoError(err)
+	a.Equal("<svg></svg>", string(b))
+
+	// Existing objects are only replaced when overwriting
+	a.Error(w.Write("images", "logo.svg", []byte("other"), false))
+	a.NoError(w.Write("images", "logo.svg", []byte("<svg>new</svg>"), true))
+	b, err = w.Read("images", "logo.svg")
+	a.NoError(err)
+	a.Equal("<svg>new</svg>", string(b))
+
+	// Names are escaped in the request path
+	a.NoError(w.Write("with spaces", "100% (final).txt", []byte("escaped"), true))
+	b, err = w.Read("with spaces", "100% (final).txt")
+	a.NoError(err)
+	a.Equal("escaped", string(b))
+
+	a.NoError(w.Remove("images", "logo.svg"))
+	_, err = w.Read("images", "logo.svg")
+	a.Error(err)
+	a.NoError(w.Remove("images", "logo.svg"))
+
+	// Paths cannot leave the prefix
+	a.Error(w.Write("..", "escape.txt", []byte("escape"), true))
+	a.Error(w.Write("/", "", []byte("root"), true))
+
+	// Requests signed with other credentials are rejected
+	other := newStore(t, srv, "app/")
+	other.Credentials.AccessKeyID = "AKIDOTHER"
+	_, err = s3.Get(other).Read("with spaces", "100% (final).txt")
+	var e *s3.Error
+	a.True(errors.As(err, &e))
+	a.Equal(http.StatusForbidden, e.StatusCode)
+	a.Equal("SignatureDoesNotMatch", e.Code)
+
+	a.Nil(s3.New(&s3.Store{Endpoint: srv.URL}))
+	a.Nil(s3.New(&s3.Store{Endpoint: "not a url", Bucket: fakeBucket}))
+	// Parts must be sizes S3 accepts
+	a.Nil(s3.New(&s3.Store{Endpoint: srv.URL, Bucket: fakeBucket, PartSize: 1 << 20}))
+	a.Nil(s3.New(&s3.Store{Endpoint: srv.URL, Bucket: fakeBucket, PartSize: 6 << 30}))
+	a.NotNil(s3.New(&s3.Store{Endpoint: srv.URL, Bucket: fakeBucket, PartSize: 5 << 20}))
+}
+
+// TestMultipartUpload tests large objects are uploaded in parts
+func TestMultipartUpload(t *testing.T) {
+	a := assert.New(t)
+	srv := newFakeServer(t)
+	w := s3.Get(newStore(t, srv, ""))
+
+	large := bytes.Repeat([]byte("0123456789"), 5)
+	a.NoError(w.Write("artifacts", "build.tar", large, false))
+	data, ok := srv.Object("artifacts/build.tar")
+	a.True(ok)
+	a.Equal(large, data)
+	b, err := w.Read("artifacts", "build.tar")
+	a.NoError(err)
+	a.Equal(large, b)
+
+	// A failed upload is aborted
+	a.Error(w.Write("artifacts", "build.tar", large, false))
+	a.Zero(srv.Uploads())
+	a.NoError(w.Write("artifacts", "build.tar", append(large, '!'), true))
+	data, _ = srv.Object("artifacts/build.tar")
+	a.Equal(append(large, '!'), data)
+
+	// Entries needing more parts than an upload can have are refused
+	a.Error(w.Write("artifacts", "huge.tar", make([]byte, 16*10000+1), true))
+	_, ok = srv.Object("artifacts/huge.tar")
+	a.False(ok)
+	a.Zero(srv.Uploads())
+}
+
+// TestS3Trim tests expired objects and stale uploads are trimmed and Purge only removes the stores objects
+func TestS3Trim(t *testing.T) {
+	a := assert.New(t)
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(io.Discard)
+
+	srv := newFakeServer(t)
+	s3Store := newStore(t, srv, "app/")
+	w := s3.Get(s3Store)
+
+	// Enough objects to need several list pages
+	for i := range 8 {
+		a.NoError(w.Write("entries", fmt.Sprintf("%d.txt", i), []byte("entry"), true))
+		if i%2 == 0 {
+			srv.Age(fmt.Sprintf("app/entries/%d.txt", i), time.Hour)
+		}
+	}
+	a.NoError(s3.Get(newStore(t, srv, "other/")).Write("entries", "0.txt", []byte("other"), true))
+	srv.Age("other/entries/0.txt", time.Hour)
+	srv.StartUpload("app/stale.tar")
+	srv.Age("app/stale.tar", time.Hour)
+	srv.StartUpload("app/active.tar")
+
+	s3Store.Trim()
+	a.Equal([]string{"app/entries/1.txt", "app/entries/3.txt", "app/entries/5.txt", "app/entries/7.txt", "other/entries/0.txt"}, srv.Keys())
+	a.Equal(1, srv.Uploads())
+
+	a.NoError(s3Store.Purge())
+	a.Equal([]string{"other/entries/0.txt"}, srv.Keys())
+	a.Zero(srv.Uploads())
+
+	// Without a prefix nothing is removed
+	a.NoError(newStore(t, srv, "").Purge())
+	a.Equal([]string{"other/entries/0.txt"}, srv.Keys())
+}
@@ -0,0 +1,303 @@
+package s3_test
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	// fakeBucket is the only bucket of the fake server
+	fakeBucket = "cache"
+
+	// fakeAccessKey is the access key the fake server accepts
+	fakeAccessKey = "AKIDEXAMPLE"
+
+	// fakePageSize is the most objects listed in a single page
+	fakePageSize = 3
+)
+
+type (
+	// fakeServer is an httptest stand-in for an S3 compatible object store
+	// implementing the operations used by the store with path style addressing.
+	fakeServer struct {
+		*httptest.Server
+
+		mtx     sync.Mutex
+		objects map[string]fakeObject
+		uploads map[string]*fakeUpload
+		nextID  int
+	}
+
+	// fakeObject is an object saved on the fake server
+	fakeObject struct {
+		data     []byte
+		modified time.Time
+	}
+
+	// fakeUpload is an incomplete multipart upload
+	fakeUpload struct {
+		key       string
+		parts     map[int][]byte
+		initiated time.Time
+	}
+)
+
+// newFakeServer starts a fake object store.
+func newFakeServer(t *testing.T) *fakeServer {
+	t.Helper()
+	srv := &fakeServer{
+		objects: make(map[string]fakeObject),
+		uploads: make(map[string]*fakeUpload),
+	}
+	srv.Server = httptest.NewServer(http.HandlerFunc(srv.handle))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// Object returns the contents of the object saved as key.
+func (srv *fakeServer) Object(key string) ([]byte, bool) {
+	srv.mtx.Lock()
+	defer srv.mtx.Unlock()
+	obj, ok := srv.objects[key]
+	return obj.data, ok
+}
+
+// Keys returns the keys of every object saved on the fake server.
+func (srv *fakeServer) Keys() []string {
+	srv.mtx.Lock()
+	defer srv.mtx.Unlock()
+	keys := make([]string, 0, len(srv.objects))
+	for key := range srv.objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Uploads returns the number of incomplete multipart uploads.
+func (srv *fakeServer) Uploads() int {
+	srv.mtx.Lock()
+	defer srv.mtx.Unlock()
+	return len(srv.uploads)
+}
+
+// Age moves the LastModified time of key, or the initiated time of its uploads, back by d.
+func (srv *fakeServer) Age(key string, d time.Duration) {
+	srv.mtx.Lock()
+	defer srv.mtx.Unlock()
+	if obj, ok := srv.objects[key]; ok {
+		obj.modified = obj.modified.Add(-d)
+		srv.objects[key] = obj
+	}
+	for _, u := range srv.uploads {
+		if u.key == key {
+			u.initiated = u.initiated.Add(-d)
+		}
+	}
+}
+
+// StartUpload starts a multipart upload that is never completed.
+func (srv *fakeServer) StartUpload(key string) {
+	srv.mtx.Lock()
+	defer srv.mtx.Unlock()
+	srv.startUpload(key)
+}
+
+func (srv *fakeServer) startUpload(key string) string {
+	srv.nextID++
+	id := strconv.Itoa(srv.nextID)
+	srv.uploads[id] = &fakeUpload{key: key, parts: make(map[int][]byte), initiated: time.Now()}
+	return id
+}
+
+// handle serves a single request.
+func (srv *fakeServer) handle(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return
+	}
+
+	// Check the request was signed for the payload that was sent
+	sum := sha256.Sum256(body)
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential="+fakeAccessKey+"/") ||
+		r.Header.Get("X-Amz-Content-Sha256") != hex.EncodeToString(sum[:]) {
+		writeError(w, http.StatusForbidden, "SignatureDoesNotMatch")
+		return
+	}
+
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if bucket != fakeBucket {
+		writeError(w, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+
+	srv.mtx.Lock()
+	defer srv.mtx.Unlock()
+
+	query := r.URL.Query()
+	switch {
+	case key == "" && r.Method == http.MethodGet && query.Has("uploads"):
+		srv.listUploads(w, query.Get("prefix"))
+	case key == "" && r.Method == http.MethodGet:
+		srv.listObjects(w, query.Get("prefix"), query.Get("continuation-token"))
+	case key == "" && r.Method == http.MethodPost && query.Has("delete"):
+		srv.deleteObjects(w, r, body)
+	case r.Method == http.MethodPut && query.Has("uploadId"):
+		u, ok := srv.uploads[query.Get("uploadId")]
+		if !ok {
+			writeError(w, http.StatusNotFound, "NoSuchUpload")
+			return
+		}
+		part, _ := strconv.Atoi(query.Get("partNumber"))
+		u.parts[part] = body
+		w.Header().Set("ETag", etag(body))
+	case r.Method == http.MethodPut:
+		if _, exists := srv.objects[key]; exists && r.Header.Get("If-None-Match") == "*" {
+			writeError(w, http.StatusPreconditionFailed, "PreconditionFailed")
+			return
+		}
+		srv.objects[key] = fakeObject{data: body, modified: time.Now()}
+		w.Header().Set("ETag", etag(body))
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		fmt.Fprintf(w, "<InitiateMultipartUploadResult><UploadId>%s</UploadId></InitiateMultipartUploadResult>", srv.startUpload(key))
+	case r.Method == http.MethodPost && query.Has("uploadId"):
+		srv.completeUpload(w, r, key, query.Get("uploadId"), body)
+	case r.Method == http.MethodDelete && query.Has("uploadId"):
+		delete(srv.uploads, query.Get("uploadId"))
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodDelete:
+		delete(srv.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodGet:
+		obj, ok := srv.objects[key]
+		if !ok {
+			writeError(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		w.Write(obj.data)
+	default:
+		writeError(w, http.StatusNotImplemented, "NotImplemented")
+	}
+}
+
+// listObjects lists objects a page at a time using the last key of a page as the continuation token.
+func (srv *fakeServer) listObjects(w http.ResponseWriter, prefix string, token string) {
+	var keys []string
+	for key := range srv.objects {
+		if strings.HasPrefix(key, prefix) && key > token {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	truncated := len(keys) > fakePageSize
+	if truncated {
+		keys = keys[:fakePageSize]
+	}
+	fmt.Fprint(w, `<ListBucketResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/">`)
+	for _, key := range keys {
+		obj := srv.objects[key]
+		fmt.Fprintf(w, "<Contents><Key>%s</Key><LastModified>%s</LastModified><Size>%d</Size></Contents>",
+			key, obj.modified.UTC().Format(time.RFC3339Nano), len(obj.data))
+	}
+	fmt.Fprintf(w, "<IsTruncated>%t</IsTruncated>", truncated)
+	if truncated {
+		fmt.Fprintf(w, "<NextContinuationToken>%s</NextContinuationToken>", keys[len(keys)-1])
+	}
+	fmt.Fprint(w, "</ListBucketResult>")
+}
+
+// listUploads lists every incomplete upload in a single page.
+func (srv *fakeServer) listUploads(w http.ResponseWriter, prefix string) {
+	fmt.Fprint(w, "<ListMultipartUploadsResult>")
+	for id, u := range srv.uploads {
+		if strings.HasPrefix(u.key, prefix) {
+			fmt.Fprintf(w, "<Upload><Key>%s</Key><UploadId>%s</UploadId><Initiated>%s</Initiated></Upload>",
+				u.key, id, u.initiated.UTC().Format(time.RFC3339Nano))
+		}
+	}
+	fmt.Fprint(w, "<IsTruncated>false</IsTruncated></ListMultipartUploadsResult>")
+}
+
+// deleteObjects removes every object in a DeleteObjects request.
+func (srv *fakeServer) deleteObjects(w http.ResponseWriter, r *http.Request, body []byte) {
+	sum := md5.Sum(body)
+	if r.Header.Get("Content-MD5") != base64.StdEncoding.EncodeToString(sum[:]) {
+		writeError(w, http.StatusBadRequest, "InvalidDigest")
+		return
+	}
+
+	var req struct {
+		Objects []struct{ Key string } `xml:"Object"`
+	}
+	if xml.Unmarshal(body, &req) != nil || len(req.Objects) > 1000 {
+		writeError(w, http.StatusBadRequest, "MalformedXML")
+		return
+	}
+	for _, obj := range req.Objects {
+		delete(srv.objects, obj.Key)
+	}
+	fmt.Fprint(w, "<DeleteResult></DeleteResult>")
+}
+
+// completeUpload joins the parts of an upload into an object.
+func (srv *fakeServer) completeUpload(w http.ResponseWriter, r *http.Request, key string, id string, body []byte) {
+	u, ok := srv.uploads[id]
+	if !ok {
+		writeError(w, http.StatusNotFound, "NoSuchUpload")
+		return
+	}
+	if _, exists := srv.objects[key]; exists && r.Header.Get("If-None-Match") == "*" {
+		writeError(w, http.StatusPreconditionFailed, "PreconditionFailed")
+		return
+	}
+
+	var req struct {
+		Parts []struct {
+			PartNumber int
+			ETag       string
+		} `xml:"Part"`
+	}
+	if xml.Unmarshal(body, &req) != nil {
+		writeError(w, http.StatusBadRequest, "MalformedXML")
+		return
+	}
+
+	var data []byte
+	for i, part := range req.Parts {
+		b, ok := u.parts[part.PartNumber]
+		if !ok || part.PartNumber != i+1 || part.ETag != etag(b) {
+			writeError(w, http.StatusBadRequest, "InvalidPart")
+			return
+		}
+		data = append(data, b...)
+	}
+	srv.objects[key] = fakeObject{data: data, modified: time.Now()}
+	delete(srv.uploads, id)
+	fmt.Fprint(w, "<CompleteMultipartUploadResult></CompleteMultipartUploadResult>")
+}
+
+// etag returns the quoted MD5 of data as S3 does for single part objects.
+func etag(data []byte) string {
+	sum := md5.Sum(data)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+// writeError writes an S3 error response.
+func writeError(w http.ResponseWriter, status int, code string) {
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "<Error><Code>%s</Code><Message>%s</Message></Error>", code, http.StatusText(status))
+}
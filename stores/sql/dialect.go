@@ -0,0 +1,142 @@
+package sql
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Dialect writes the statements that differ between databases.
+// Every table has a cache_key primary key, a value column, and an expires_at column
+// holding the unix time in seconds the entry expires at.
+type Dialect interface {
+	// Placeholder returns the placeholder of argument n, counting from 1
+	Placeholder(n int) string
+
+	// Create returns the statements creating the table and its expires_at index if they do not exist
+	Create(table string) []string
+
+	// Upsert returns a statement inserting cache_key, value, and expires_at
+	// that replaces the value and expires_at of an existing row
+	Upsert(table string) string
+
+	// Insert returns a statement inserting cache_key, value, and expires_at
+	// that affects no rows if the key exists
+	Insert(table string) string
+}
+
+var (
+	// SQLite is the dialect of SQLite 3.24 or later
+	SQLite Dialect = sqlite{}
+
+	// Postgres is the dialect of PostgreSQL 9.5 or later
+	Postgres Dialect = postgres{}
+
+	// MySQL is the dialect of MySQL and MariaDB
+	MySQL Dialect = mysql{}
+)
+
+type (
+	sqlite   struct{}
+	postgres struct{}
+	mysql    struct{}
+)
+
+// insert returns the INSERT statement shared by every dialect.
+func insert(d Dialect, verb, table string) string {
+	return fmt.Sprintf("%s INTO %s (cache_key, value, expires_at) VALUES (%s, %s, %s)",
+		verb, table, d.Placeholder(1), d.Placeholder(2), d.Placeholder(3))
+}
+
+func (sqlite) Placeholder(int) string {
+	return "?"
+}
+
+func (sqlite) Create(table string) []string {
+	return []string{
+		"CREATE TABLE IF NOT EXISTS " + table + " (cache_key TEXT PRIMARY KEY, value BLOB NOT NULL, expires_at INTEGER NOT NULL)",
+		"CREATE INDEX IF NOT EXISTS " + table + "_expires_at ON " + table + " (expires_at)",
+	}
+}
+
+func (d sqlite) Upsert(table string) string {
+	return insert(d, "INSERT", table) + " ON CONFLICT (cache_key) DO UPDATE SET value = excluded.value, expires_at = excluded.expires_at"
+}
+
+func (d sqlite) Insert(table string) string {
+	return insert(d, "INSERT", table) + " ON CONFLICT (cache_key) DO NOTHING"
+}
+
+func (postgres) Placeholder(n int) string {
+	return "$" + strconv.Itoa(n)
+}
+
+// Create saves keys as BYTEA so keys that are not valid UTF-8 can be stored.
+func (postgres) Create(table string) []string {
+	return []string{
+		"CREATE TABLE IF NOT EXISTS " + table + " (cache_key BYTEA PRIMARY KEY, value BYTEA NOT NULL, expires_at BIGINT NOT NULL)",
+		"CREATE INDEX IF NOT EXISTS " + table + "_expires_at ON " + table + " (expires_at)",
+	}
+}
+
+func (d postgres) Upsert(table string) string {
+	return insert(d, "INSERT", table) + " ON CONFLICT (cache_key) DO UPDATE SET value = EXCLUDED.value, expires_at = EXCLUDED.expires_at"
+}
+
+func (d postgres) Insert(table string) string {
+	return insert(d, "INSERT", table) + " ON CONFLICT (cache_key) DO NOTHING"
+}
+
+func (postgres) binaryKeys() {}
+
+func (mysql) Placeholder(int) string {
+	return "?"
+}
+
+// mysqlKeyLen is the length in bytes of the MySQL cache_key column.
+const mysqlKeyLen = 255
+
+// Create keeps the index in the table definition as MySQL has no CREATE INDEX IF NOT EXISTS.
+// Keys are saved as VARBINARY so they are compared byte for byte rather than by a case insensitive collation.
+func (mysql) Create(table string) []string {
+	return []string{
+		"CREATE TABLE IF NOT EXISTS " + table + " (cache_key VARBINARY(" + strconv.Itoa(mysqlKeyLen) + ") NOT NULL PRIMARY KEY, value LONGBLOB NOT NULL, expires_at BIGINT NOT NULL, INDEX " + table + "_expires_at (expires_at))",
+	}
+}
+
+func (d mysql) Upsert(table string) string {
+	return insert(d, "INSERT", table) + " ON DUPLICATE KEY UPDATE value = VALUES(value), expires_at = VALUES(expires_at)"
+}
+
+// Insert uses INSERT IGNORE. It also turns other errors into warnings, such as a key longer
+// than the column being truncated, so keys are checked against maxKeyLen before they are written.
+func (d mysql) Insert(table string) string {
+	return insert(d, "INSERT IGNORE", table)
+}
+
+func (mysql) maxKeyLen() int {
+	return mysqlKeyLen
+}
+
+func (mysql) binaryKeys() {}
+
+// keyLimit is implemented by dialects whose cache_key column has a maximum length in bytes.
+type keyLimit interface {
+	maxKeyLen() int
+}
+
+// binaryKeyer is implemented by dialects whose cache_key column is binary,
+// so keys are bound as []byte rather than as text.
+type binaryKeyer interface {
+	binaryKeys()
+}
+
+// validTable reports whether table is a plain identifier that is safe to put in a statement.
+func validTable(table string) bool {
+	if table == "" || strings.IndexFunc(table, func(r rune) bool {
+		return !(r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9')
+	}) >= 0 {
+		return false
+	}
+	return table[0] < '0' || table[0] > '9'
+}
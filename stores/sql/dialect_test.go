@@ -0,0 +1,109 @@
+package sql_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	sqlstore "github.com/tmstorm/cache/stores/sql"
+)
+
+// TestDialects tests each dialect writes statements with its own placeholders and upsert syntax
+func TestDialects(t *testing.T) {
+	tests := []struct {
+		dialect sqlstore.Dialect
+		upsert  string
+		insert  string
+		read    string
+	}{
+		{
+			dialect: sqlstore.SQLite,
+			upsert:  "INSERT INTO entries (cache_key, value, expires_at) VALUES (?, ?, ?) ON CONFLICT (cache_key) DO UPDATE SET value = excluded.value, expires_at = excluded.expires_at",
+			insert:  "INSERT INTO entries (cache_key, value, expires_at) VALUES (?, ?, ?) ON CONFLICT (cache_key) DO NOTHING",
+			read:    "SELECT value FROM entries WHERE cache_key = ? AND expires_at > ?",
+		},
+		{
+			dialect: sqlstore.Postgres,
+			upsert:  "INSERT INTO entries (cache_key, value, expires_at) VALUES ($1, $2, $3) ON CONFLICT (cache_key) DO UPDATE SET value = EXCLUDED.value, expires_at = EXCLUDED.expires_at",
+			insert:  "INSERT INTO entries (cache_key, value, expires_at) VALUES ($1, $2, $3) ON CONFLICT (cache_key) DO NOTHING",
+			read:    "SELECT value FROM entries WHERE cache_key = $1 AND expires_at > $2",
+		},
+		{
+			dialect: sqlstore.MySQL,
+			upsert:  "INSERT INTO entries (cache_key, value, expires_at) VALUES (?, ?, ?) ON DUPLICATE KEY UPDATE value = VALUES(value), expires_at = VALUES(expires_at)",
+			insert:  "INSERT IGNORE INTO entries (cache_key, value, expires_at) VALUES (?, ?, ?)",
+			read:    "SELECT value FROM entries WHERE cache_key = ? AND expires_at > ?",
+		},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			a := assert.New(t)
+			db, fdb := openFake(t.Name())
+			defer db.Close()
+
+			s := sqlstore.New(&sqlstore.Store{DB: db, Dialect: test.dialect, Table: "entries"})
+			a.NotNil(s)
+			w := sqlstore.Get(s)
+			a.NoError(w.Write("key", []byte("value"), true))
+			a.NoError(w.Write("other", []byte("value"), false))
+			_, err := w.Read("key")
+			a.NoError(err)
+
+			stmts := fdb.statements()
+			create := test.dialect.Create("entries")
+			a.Equal(create, stmts[:len(create)])
+			a.Contains(stmts, test.upsert)
+			a.Contains(stmts, test.insert)
+			a.Contains(stmts, test.read)
+		})
+	}
+}
+
+// TestMySQLKeys tests MySQL keys are compared byte for byte and limited to the column length
+func TestMySQLKeys(t *testing.T) {
+	a := assert.New(t)
+	db, _ := openFake(t.Name())
+	defer db.Close()
+
+	a.Contains(sqlstore.MySQL.Create("entries")[0], "cache_key VARBINARY(255)")
+
+	s := sqlstore.New(&sqlstore.Store{DB: db, Dialect: sqlstore.MySQL})
+	a.NotNil(s)
+	w := sqlstore.Get(s)
+	a.NoError(w.Write(strings.Repeat("k", 255), []byte("value"), false))
+	a.Error(w.Write(strings.Repeat("k", 256), []byte("value"), false))
+	a.Error(w.Write(strings.Repeat("k", 256), []byte("value"), true))
+
+	// Other dialects have no limit
+	s = sqlstore.New(&sqlstore.Store{DB: db, Dialect: sqlstore.SQLite, Table: "other"})
+	a.NotNil(s)
+	a.NoError(sqlstore.Get(s).Write(strings.Repeat("k", 256), []byte("value"), false))
+}
+
+// TestPostgresKeys tests Postgres keys are saved as BYTEA and bound as bytes
+func TestPostgresKeys(t *testing.T) {
+	a := assert.New(t)
+	db, fdb := openFake(t.Name())
+	defer db.Close()
+
+	a.Contains(sqlstore.Postgres.Create("entries")[0], "cache_key BYTEA")
+
+	s := sqlstore.New(&sqlstore.Store{DB: db, Dialect: sqlstore.Postgres})
+	a.NotNil(s)
+	w := sqlstore.Get(s)
+	key := "key\xff"
+	a.NoError(w.Write(key, []byte("value"), false))
+	v, err := w.Read(key)
+	a.NoError(err)
+	a.Equal([]byte("value"), v)
+	a.NoError(w.Remove(key))
+	a.Equal(4, fdb.binaryKeys)
+
+	// SQLite keys are still bound as text
+	s = sqlstore.New(&sqlstore.Store{DB: db, Dialect: sqlstore.SQLite, Table: "other"})
+	a.NotNil(s)
+	a.NoError(sqlstore.Get(s).Write("key", []byte("value"), true))
+	a.Equal(4, fdb.binaryKeys)
+}
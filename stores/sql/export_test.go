@@ -0,0 +1,9 @@
+package sql
+
+import "time"
+
+// SetNow replaces the clock used for expiry and returns a function restoring it.
+func SetNow(f func() time.Time) (restore func()) {
+	now = f
+	return func() { now = time.Now }
+}
@@ -0,0 +1,214 @@
+package sql_test
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// fakeDriver is an in-memory database/sql driver understanding only the statements the store runs.
+// Each DSN is a separate database.
+type fakeDriver struct {
+	mtx sync.Mutex
+	dbs map[string]*fakeDB
+}
+
+// fakeDB holds the rows of every table and the statements run against it
+type fakeDB struct {
+	mtx   sync.Mutex
+	rows  map[string]fakeRow
+	stmts []string
+
+	// binaryKeys counts the keys bound as []byte
+	binaryKeys int
+}
+
+type fakeRow struct {
+	value   []byte
+	expires int64
+}
+
+var fake = &fakeDriver{dbs: make(map[string]*fakeDB)}
+
+func init() {
+	sql.Register("cachefake", fake)
+}
+
+// openFake returns a database backed by the fake driver and its state.
+func openFake(name string) (*sql.DB, *fakeDB) {
+	db, _ := sql.Open("cachefake", name)
+	fake.mtx.Lock()
+	defer fake.mtx.Unlock()
+	if fake.dbs[name] == nil {
+		fake.dbs[name] = &fakeDB{rows: make(map[string]fakeRow)}
+	}
+	return db, fake.dbs[name]
+}
+
+func (d *fakeDriver) Open(name string) (driver.Conn, error) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	db := d.dbs[name]
+	if db == nil {
+		return nil, errors.New("fake: unknown database")
+	}
+	return &fakeConn{db: db}, nil
+}
+
+// statements returns the statements run so far.
+func (db *fakeDB) statements() []string {
+	db.mtx.Lock()
+	defer db.mtx.Unlock()
+	return append([]string(nil), db.stmts...)
+}
+
+type fakeConn struct {
+	db *fakeDB
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return &fakeStmt{db: c.db, query: query}, nil
+}
+
+func (c *fakeConn) Close() error {
+	return nil
+}
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("fake: transactions are not supported")
+}
+
+type fakeStmt struct {
+	db    *fakeDB
+	query string
+}
+
+var placeholders = regexp.MustCompile(`\?|\$\d+`)
+
+func (s *fakeStmt) Close() error {
+	return nil
+}
+
+func (s *fakeStmt) NumInput() int {
+	return len(placeholders.FindAllString(s.query, -1))
+}
+
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	db := s.db
+	db.mtx.Lock()
+	defer db.mtx.Unlock()
+	db.stmts = append(db.stmts, s.query)
+
+	q := s.query
+	switch {
+	case strings.HasPrefix(q, "CREATE"):
+		return driver.RowsAffected(0), nil
+
+	case strings.HasPrefix(q, "INSERT"):
+		key := db.key(args[0])
+		row := fakeRow{value: args[1].([]byte), expires: args[2].(int64)}
+		if _, ok := db.rows[key]; ok && !strings.Contains(q, "UPDATE") {
+			return driver.RowsAffected(0), nil
+		}
+		db.rows[key] = row
+		return driver.RowsAffected(1), nil
+
+	case strings.HasPrefix(q, "DELETE"):
+		var n int64
+		var match string
+		if strings.Contains(q, "cache_key") {
+			match = db.key(args[0])
+		}
+		for key, row := range db.rows {
+			switch {
+			case strings.Contains(q, "cache_key") && strings.Contains(q, "expires_at"):
+				if key != match || row.expires > args[1].(int64) {
+					continue
+				}
+			case strings.Contains(q, "cache_key"):
+				if key != match {
+					continue
+				}
+			case strings.Contains(q, "expires_at"):
+				if row.expires > args[0].(int64) {
+					continue
+				}
+			}
+			delete(db.rows, key)
+			n++
+		}
+		return driver.RowsAffected(n), nil
+	}
+	return nil, fmt.Errorf("fake: unsupported statement %q", q)
+}
+
+// key returns a key bound as a string or as []byte.
+func (db *fakeDB) key(v driver.Value) string {
+	if b, ok := v.([]byte); ok {
+		db.binaryKeys++
+		return string(b)
+	}
+	return v.(string)
+}
+
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	db := s.db
+	db.mtx.Lock()
+	defer db.mtx.Unlock()
+	db.stmts = append(db.stmts, s.query)
+
+	if !strings.HasPrefix(s.query, "SELECT value") {
+		return nil, fmt.Errorf("fake: unsupported query %q", s.query)
+	}
+	rows := &fakeRows{}
+	if row, ok := db.rows[db.key(args[0])]; ok && row.expires > args[1].(int64) {
+		rows.values = append(rows.values, row.value)
+	}
+	return rows, nil
+}
+
+type fakeRows struct {
+	values [][]byte
+}
+
+func (r *fakeRows) Columns() []string {
+	return []string{"value"}
+}
+
+func (r *fakeRows) Close() error {
+	return nil
+}
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	dest[0] = r.values[0]
+	r.values = r.values[1:]
+	return nil
+}
+
+// errDriver fails every statement, to test errors are returned
+type errDriver struct{}
+
+func (errDriver) OpenConnector(string) (driver.Connector, error) {
+	return errDriver{}, nil
+}
+
+func (errDriver) Open(string) (driver.Conn, error) {
+	return nil, errors.New("fake: database is down")
+}
+
+func (d errDriver) Connect(context.Context) (driver.Conn, error) {
+	return d.Open("")
+}
+
+func (d errDriver) Driver() driver.Driver {
+	return d
+}
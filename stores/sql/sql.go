@@ -0,0 +1,228 @@
+// Package sql implements a store saving entries in a table of a relational database through database/sql.
+// The statements that differ between databases are written by a Dialect.
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/tmstorm/cache"
+)
+
+type (
+	// Store implements cache.Store
+	Store struct {
+		storeType string
+		stmts     statements
+
+		// DB is the database holding the table. It is not closed by the store.
+		DB *sql.DB
+
+		// Dialect writes the statements for the database behind DB
+		Dialect Dialect
+
+		// Table is the name of the table holding entries. Defaults to cache.
+		// It is created if it does not exist.
+		Table string
+
+		// Timeout limits each statement. Zero does not limit them.
+		Timeout time.Duration
+
+		// MaxAge is the implementation of cache.MaxAge.
+		// Entries expire MaxAge after they are written and are removed by Trim.
+		MaxAge cache.MaxAge
+	}
+
+	// statements are the statements used by the store, written once by its Dialect
+	statements struct {
+		upsert        string
+		insert        string
+		read          string
+		remove        string
+		removeExpired string
+		trim          string
+		purge         string
+	}
+
+	// writer is used to read, write, and remove entries
+	writer struct {
+		Store *Store
+	}
+)
+
+// now returns the current time. It is replaced in tests.
+var now = time.Now
+
+// New initializes a new store using DB, creating Table if it does not exist.
+// If MaxAge or Table are not provided they will be set to their default values.
+func New(s *Store) *Store {
+	s.storeType = "sql"
+
+	if s.DB == nil || s.Dialect == nil {
+		fmt.Println("cache: the sql store requires a database and a dialect.")
+		return nil
+	}
+	if s.Table == "" {
+		s.Table = "cache"
+	}
+	if !validTable(s.Table) {
+		fmt.Println("cache: the sql store table name may only hold letters, digits, and underscores.")
+		return nil
+	}
+	if s.MaxAge == 0 {
+		s.MaxAge = cache.DefaultMaxAge
+	}
+
+	for _, stmt := range s.Dialect.Create(s.Table) {
+		_, err := s.exec(stmt)
+		if err != nil {
+			fmt.Printf("cannot create sql store table: %v\n", err)
+			return nil
+		}
+	}
+
+	d, t := s.Dialect, s.Table
+	s.stmts = statements{
+		upsert:        d.Upsert(t),
+		insert:        d.Insert(t),
+		read:          fmt.Sprintf("SELECT value FROM %s WHERE cache_key = %s AND expires_at > %s", t, d.Placeholder(1), d.Placeholder(2)),
+		remove:        fmt.Sprintf("DELETE FROM %s WHERE cache_key = %s", t, d.Placeholder(1)),
+		removeExpired: fmt.Sprintf("DELETE FROM %s WHERE cache_key = %s AND expires_at <= %s", t, d.Placeholder(1), d.Placeholder(2)),
+		trim:          fmt.Sprintf("DELETE FROM %s WHERE expires_at <= %s", t, d.Placeholder(1)),
+		purge:         "DELETE FROM " + t,
+	}
+	return s
+}
+
+// Type returns the stores type as a string
+func (s *Store) Type() string {
+	return s.storeType
+}
+
+// Get returns a writer for the current sql store
+func Get(s *Store) *writer {
+	var w writer
+	w.Store = s
+	return &w
+}
+
+// withTimeout returns the context for a statement, limited by Timeout.
+func (s *Store) withTimeout() (context.Context, context.CancelFunc) {
+	if s.Timeout > 0 {
+		return context.WithTimeout(context.Background(), s.Timeout)
+	}
+	return context.WithCancel(context.Background())
+}
+
+// exec runs a statement that returns no rows.
+func (s *Store) exec(stmt string, args ...any) (sql.Result, error) {
+	ctx, cancel := s.withTimeout()
+	defer cancel()
+	return s.DB.ExecContext(ctx, stmt, args...)
+}
+
+// Write saves the value under key, expiring after MaxAge.
+// If overwrite = true the value will be overwriten if it already exists
+func (w *writer) Write(key string, value []byte, overwrite bool) error {
+	return w.WriteTTL(key, value, time.Second*time.Duration(w.Store.MaxAge), overwrite)
+}
+
+// WriteTTL saves the value under key, expiring after ttl instead of MaxAge.
+// If overwrite = true the value will be overwriten if it already exists
+func (w *writer) WriteTTL(key string, value []byte, ttl time.Duration, overwrite bool) error {
+	s := w.Store
+	if limit, ok := s.Dialect.(keyLimit); ok && len(key) > limit.maxKeyLen() {
+		return fmt.Errorf("key is too long for sql store: %d bytes", len(key))
+	}
+	t := now()
+	expires := t.Add(ttl).Unix()
+	if value == nil {
+		value = []byte{}
+	}
+
+	if overwrite {
+		_, err := s.exec(s.stmts.upsert, s.keyArg(key), value, expires)
+		return err
+	}
+
+	// An expired row that has not been trimmed yet does not count as existing
+	_, err := s.exec(s.stmts.removeExpired, s.keyArg(key), t.Unix())
+	if err != nil {
+		return err
+	}
+	res, err := s.exec(s.stmts.insert, s.keyArg(key), value, expires)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("key already exists in sql store: %s", key)
+	}
+	return nil
+}
+
+// Read returns the value saved under key if it has not expired.
+func (w *writer) Read(key string) ([]byte, error) {
+	s := w.Store
+	ctx, cancel := s.withTimeout()
+	defer cancel()
+
+	var value []byte
+	err := s.DB.QueryRowContext(ctx, s.stmts.read, s.keyArg(key), now().Unix()).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return []byte{}, fmt.Errorf("key not found in sql store: %s", key)
+	}
+	if err != nil {
+		return []byte{}, err
+	}
+	return value, nil
+}
+
+// Remove deletes the value saved under key.
+// Removing a key that does not exist is not an error.
+func (w *writer) Remove(key string) error {
+	_, err := w.Store.exec(w.Store.stmts.remove, w.Store.keyArg(key))
+	return err
+}
+
+// keyArg returns key as the argument type of the dialect's cache_key column.
+func (s *Store) keyArg(key string) any {
+	if _, ok := s.Dialect.(binaryKeyer); ok {
+		return []byte(key)
+	}
+	return key
+}
+
+// Trim removes every row that has expired.
+// It is called by the caches trim worker.
+// This can be called directly if needed.
+func (s *Store) Trim() {
+	log.Println("Starting sql store trimming...")
+	_, err := s.exec(s.stmts.trim, now().Unix())
+	if err != nil {
+		log.Printf("unable to trim sql store: %v", err)
+		return
+	}
+	log.Println("Sql store trimming complete")
+}
+
+// Purge removes every row from Table.
+// This function should only be used when stopping the service.
+// If you need to flush the store without stopping it you can
+// call this method directly.
+func (s *Store) Purge() error {
+	log.Println("Sql store is being purged...")
+	_, err := s.exec(s.stmts.purge)
+	if err != nil {
+		return err
+	}
+	log.Println("Sql store purge complete")
+	return nil
+}
@@ -0,0 +1,96 @@
+package sql_test
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	sqlstore "github.com/tmstorm/cache/stores/sql"
+)
+
+// TestSQLStore tests writing, reading, and removing entries
+func TestSQLStore(t *testing.T) {
+	a := assert.New(t)
+	db, _ := openFake(t.Name())
+	defer db.Close()
+
+	s := sqlstore.New(&sqlstore.Store{DB: db, Dialect: sqlstore.SQLite})
+	a.NotNil(s)
+	a.Equal("sql", s.Type())
+	a.Equal("cache", s.Table)
+
+	w := sqlstore.Get(s)
+	a.NoError(w.Write("key", []byte("value"), false))
+	a.Error(w.Write("key", []byte("other"), false))
+
+	v, err := w.Read("key")
+	a.NoError(err)
+	a.Equal([]byte("value"), v)
+
+	a.NoError(w.Write("key", []byte("other"), true))
+	v, err = w.Read("key")
+	a.NoError(err)
+	a.Equal([]byte("other"), v)
+
+	a.NoError(w.Remove("key"))
+	a.NoError(w.Remove("key"))
+	_, err = w.Read("key")
+	a.Error(err)
+
+	a.NoError(w.Write("key", []byte("value"), false))
+	a.NoError(s.Purge())
+	_, err = w.Read("key")
+	a.Error(err)
+}
+
+// TestSQLExpiry tests expired rows are not read, can be written over, and are trimmed
+func TestSQLExpiry(t *testing.T) {
+	a := assert.New(t)
+	db, fdb := openFake(t.Name())
+	defer db.Close()
+
+	clock := time.Unix(1_700_000_000, 0)
+	restore := sqlstore.SetNow(func() time.Time { return clock })
+	defer restore()
+
+	s := sqlstore.New(&sqlstore.Store{DB: db, Dialect: sqlstore.Postgres, MaxAge: 60})
+	a.NotNil(s)
+	w := sqlstore.Get(s)
+
+	a.NoError(w.Write("old", []byte("value"), false))
+	a.NoError(w.WriteTTL("new", []byte("value"), time.Hour, false))
+
+	clock = clock.Add(2 * time.Minute)
+	_, err := w.Read("old")
+	a.Error(err)
+	_, err = w.Read("new")
+	a.NoError(err)
+
+	// The expired row no longer blocks a write without overwrite
+	a.NoError(w.Write("old", []byte("again"), false))
+	v, err := w.Read("old")
+	a.NoError(err)
+	a.Equal([]byte("again"), v)
+
+	clock = clock.Add(2 * time.Minute)
+	s.Trim()
+	a.Len(fdb.rows, 1)
+	a.Contains(fdb.rows, "new")
+}
+
+// TestSQLErrors tests invalid options and database errors
+func TestSQLErrors(t *testing.T) {
+	a := assert.New(t)
+	db, _ := openFake(t.Name())
+	defer db.Close()
+
+	a.Nil(sqlstore.New(&sqlstore.Store{Dialect: sqlstore.SQLite}))
+	a.Nil(sqlstore.New(&sqlstore.Store{DB: db}))
+	a.Nil(sqlstore.New(&sqlstore.Store{DB: db, Dialect: sqlstore.SQLite, Table: "cache; DROP TABLE users"}))
+	a.Nil(sqlstore.New(&sqlstore.Store{DB: db, Dialect: sqlstore.SQLite, Table: "1cache"}))
+
+	down := sql.OpenDB(errDriver{})
+	defer down.Close()
+	a.Nil(sqlstore.New(&sqlstore.Store{DB: down, Dialect: sqlstore.SQLite}))
+}
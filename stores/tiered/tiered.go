@@ -0,0 +1,258 @@
+// Package tiered implements a store keeping recently used entries of a disk store in memory.
+package tiered
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io/fs"
+	"log"
+	"sync"
+
+	"github.com/tmstorm/cache/stores/disk"
+	"github.com/tmstorm/cache/stores/mem"
+)
+
+// stripeCount is the number of locks keys are spread across.
+const stripeCount = 64
+
+// Mode sets when writes are saved to the disk store.
+type Mode int
+
+const (
+	// WriteThrough saves each write to the disk store before it is saved in memory.
+	WriteThrough Mode = iota
+
+	// WriteBack saves writes in memory and flushes them to the disk store
+	// on Trim or Flush. Writes not yet flushed are lost if the process exits.
+	WriteBack
+)
+
+type (
+	// Store implements cache.Store
+	// L1 should use a MaxAge no longer than L2 so promoted entries do not outlive the disk store.
+	// Only the tiered store should be added to a cache as it trims and purges both tiers.
+	Store struct {
+		storeType string
+
+		// stripes lock keys so promotions and flushes do not race writes and removes
+		stripes [stripeCount]sync.RWMutex
+
+		// dirty holds the keys written in WriteBack mode that have not been flushed
+		dirty    map[string]struct{}
+		dirtyMtx sync.Mutex
+
+		// L1 is the in-memory store checked first
+		L1 *mem.Store
+
+		// L2 is the disk store holding every entry, saved by key
+		L2 *disk.Store
+
+		// Mode sets when writes are saved to L2. WriteThrough is the default.
+		Mode Mode
+	}
+
+	// writer is used to read, write, and remove entries across both tiers
+	writer struct {
+		Store *Store
+	}
+)
+
+// New initializes a new tiered store over the given tiers.
+// Both tiers must already be initialized with their own New.
+func New(s *Store) *Store {
+	s.storeType = "tiered"
+	s.dirty = make(map[string]struct{})
+
+	if s.L1 == nil || s.L2 == nil {
+		fmt.Println("cache: the tiered store requires both an L1 and an L2 store.")
+		return nil
+	}
+	return s
+}
+
+// Type returns the stores type as a string
+func (s *Store) Type() string {
+	return s.storeType
+}
+
+// Get returns a writer for the current tiered store
+func Get(s *Store) *writer {
+	var w writer
+	w.Store = s
+	return &w
+}
+
+// stripe returns the lock for the given key.
+func (s *Store) stripe(key string) *sync.RWMutex {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return &s.stripes[h.Sum32()%stripeCount]
+}
+
+// Write saves the data under the given key.
+// In WriteThrough mode it is saved to L2 and then L1.
+// In WriteBack mode it is saved to L1 and flushed to L2 later.
+// If overwrite = true the entry will be overwriten if it already exists in either tier
+func (w *writer) Write(key string, data []byte, overwrite bool) error {
+	stripe := w.Store.stripe(key)
+	stripe.Lock()
+	defer stripe.Unlock()
+
+	if w.Store.Mode == WriteBack {
+		if !overwrite && w.Store.exists(key) {
+			return fmt.Errorf("key already exists in tiered store: %s", key)
+		}
+		err := mem.Get(w.Store.L1).Write(key, data, true)
+		if err != nil {
+			return err
+		}
+		w.Store.dirtyMtx.Lock()
+		w.Store.dirty[key] = struct{}{}
+		w.Store.dirtyMtx.Unlock()
+		return nil
+	}
+
+	err := disk.GetKeyed(w.Store.L2).Write(key, data, overwrite)
+	if err != nil {
+		return err
+	}
+	return mem.Get(w.Store.L1).Write(key, data, true)
+}
+
+// Read returns the data saved under the given key.
+// Entries found in L2 are promoted to L1 so later reads are served from memory.
+func (w *writer) Read(key string) ([]byte, error) {
+	data, err := mem.Get(w.Store.L1).Read(key)
+	if err == nil {
+		return data, nil
+	}
+
+	stripe := w.Store.stripe(key)
+	stripe.RLock()
+	defer stripe.RUnlock()
+
+	// Check L1 again as it may have been written while waiting for the lock
+	data, err = mem.Get(w.Store.L1).Read(key)
+	if err == nil {
+		return data, nil
+	}
+
+	data, err = disk.GetKeyed(w.Store.L2).Read(key)
+	if err != nil {
+		return []byte{}, err
+	}
+
+	// Another reader may promote the same entry, which is fine as the data is the same
+	err = mem.Get(w.Store.L1).Write(key, data, false)
+	if err != nil {
+		log.Printf("unable to promote %s to memory: %v", key, err)
+	}
+	return data, nil
+}
+
+// Remove deletes the entry saved under the given key from both tiers.
+// Removing a key that does not exist is not an error.
+func (w *writer) Remove(key string) error {
+	stripe := w.Store.stripe(key)
+	stripe.Lock()
+	defer stripe.Unlock()
+
+	w.Store.dirtyMtx.Lock()
+	delete(w.Store.dirty, key)
+	w.Store.dirtyMtx.Unlock()
+
+	err := disk.GetKeyed(w.Store.L2).Remove(key)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return mem.Get(w.Store.L1).Remove(key)
+}
+
+// exists reports whether the key is saved in either tier.
+func (s *Store) exists(key string) bool {
+	if _, err := mem.Get(s.L1).Read(key); err == nil {
+		return true
+	}
+	_, err := disk.GetKeyed(s.L2).Read(key)
+	return err == nil
+}
+
+// Flush saves every entry written in WriteBack mode to L2.
+// Entries that cannot be saved are kept and retried on the next flush.
+// It is called by Trim and can be called directly, e.g. before the process exits.
+func (s *Store) Flush() error {
+	s.dirtyMtx.Lock()
+	keys := make([]string, 0, len(s.dirty))
+	for key := range s.dirty {
+		keys = append(keys, key)
+	}
+	s.dirtyMtx.Unlock()
+
+	var errs []error
+	for _, key := range keys {
+		errs = append(errs, s.flush(key))
+	}
+	return errors.Join(errs...)
+}
+
+// flush saves a single dirty key to L2.
+func (s *Store) flush(key string) error {
+	stripe := s.stripe(key)
+	stripe.Lock()
+	defer stripe.Unlock()
+
+	// The key may have been removed or flushed while waiting for the lock
+	s.dirtyMtx.Lock()
+	_, ok := s.dirty[key]
+	s.dirtyMtx.Unlock()
+	if !ok {
+		return nil
+	}
+
+	data, err := mem.Get(s.L1).Read(key)
+	if err == nil {
+		err = disk.GetKeyed(s.L2).Write(key, data, true)
+		if err != nil {
+			return fmt.Errorf("unable to flush %s: %w", key, err)
+		}
+	}
+
+	// An entry trimmed from L1 before it was flushed cannot be saved
+	s.dirtyMtx.Lock()
+	delete(s.dirty, key)
+	s.dirtyMtx.Unlock()
+	if err != nil {
+		return fmt.Errorf("entry %s was trimmed before it was flushed: %w", key, err)
+	}
+	return nil
+}
+
+// Trim flushes entries written in WriteBack mode and then trims both tiers.
+// It is called by the caches trim worker.
+// This can be called directly if needed.
+func (s *Store) Trim() {
+	err := s.Flush()
+	if err != nil {
+		log.Printf("unable to flush tiered store: %v", err)
+	}
+
+	s.L1.Trim()
+	s.L2.Trim()
+}
+
+// Purge clears both tiers, including entries that have not been flushed.
+// This function should only be used when stopping the service.
+// If you need to flush the store without stopping it you can
+// call this method directly.
+func (s *Store) Purge() error {
+	s.dirtyMtx.Lock()
+	clear(s.dirty)
+	s.dirtyMtx.Unlock()
+
+	err := s.L1.Purge()
+	if err != nil {
+		return err
+	}
+	return s.L2.Purge()
+}
@@ -0,0 +1,129 @@
+package tiered_test
+
+import (
+	"io"
+	"log"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tmstorm/cache"
+	"github.com/tmstorm/cache/stores/disk"
+	"github.com/tmstorm/cache/stores/mem"
+	"github.com/tmstorm/cache/stores/tiered"
+)
+
+// newTiers returns new L1 and L2 stores for a tiered store.
+func newTiers(t *testing.T) (*mem.Store, *disk.Store) {
+	t.Helper()
+	l1 := mem.New(&mem.Store{MaxAge: 20})
+	l2 := disk.New(&disk.Store{RootDir: t.TempDir(), MaxAge: 20})
+	if l2 == nil {
+		t.Fatal("disk store could not be created")
+	}
+	return l1, l2
+}
+
+// TestWriteThrough tests writes are saved to both tiers and reads promote entries
+func TestWriteThrough(t *testing.T) {
+	a := assert.New(t)
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(io.Discard)
+
+	l1, l2 := newTiers(t)
+	tieredStore := tiered.New(&tiered.Store{L1: l1, L2: l2})
+	a.NotNil(tieredStore)
+	a.Equal("tiered", tieredStore.Type())
+	a.NotZero(len(cache.MakeStores(tieredStore)))
+	w := tiered.Get(tieredStore)
+
+	// Writes are saved to both tiers
+	a.NoError(w.Write("logo", []byte("<svg></svg>"), false))
+	b, err := mem.Get(l1).Read("logo")
+	a.NoError(err)
+	a.Equal("<svg></svg>", string(b))
+	b, err = disk.GetKeyed(l2).Read("logo")
+	a.NoError(err)
+	a.Equal("<svg></svg>", string(b))
+
+	// Existing keys are not overwritten unless asked to
+	a.Error(w.Write("logo", []byte("other"), false))
+	a.NoError(w.Write("logo", []byte("<svg>new</svg>"), true))
+	b, err = w.Read("logo")
+	a.NoError(err)
+	a.Equal("<svg>new</svg>", string(b))
+
+	// Entries only on disk are promoted to memory when read
+	a.NoError(disk.GetKeyed(l2).Write("icon", []byte("<svg>icon</svg>"), false))
+	_, err = mem.Get(l1).Read("icon")
+	a.Error(err)
+	b, err = w.Read("icon")
+	a.NoError(err)
+	a.Equal("<svg>icon</svg>", string(b))
+	b, err = mem.Get(l1).Read("icon")
+	a.NoError(err)
+	a.Equal("<svg>icon</svg>", string(b))
+
+	// Keys on disk are not overwritten through a write to memory
+	a.NoError(mem.Get(l1).Remove("icon"))
+	a.Error(w.Write("icon", []byte("other"), false))
+
+	// Removing a key removes it from both tiers
+	a.NoError(w.Remove("logo"))
+	_, err = mem.Get(l1).Read("logo")
+	a.Error(err)
+	_, err = disk.GetKeyed(l2).Read("logo")
+	a.Error(err)
+	_, err = w.Read("logo")
+	a.Error(err)
+	a.NoError(w.Remove("logo"))
+
+	a.NoError(tieredStore.Purge())
+	_, err = w.Read("icon")
+	a.Error(err)
+}
+
+// TestWriteBack tests writes are kept in memory until they are flushed
+func TestWriteBack(t *testing.T) {
+	a := assert.New(t)
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(io.Discard)
+
+	l1, l2 := newTiers(t)
+	tieredStore := tiered.New(&tiered.Store{L1: l1, L2: l2, Mode: tiered.WriteBack})
+	a.NotNil(tieredStore)
+	w := tiered.Get(tieredStore)
+
+	a.NoError(w.Write("logo", []byte("<svg></svg>"), false))
+	a.NoError(w.Write("removed", []byte("gone"), false))
+	_, err := disk.GetKeyed(l2).Read("logo")
+	a.Error(err)
+	b, err := w.Read("logo")
+	a.NoError(err)
+	a.Equal("<svg></svg>", string(b))
+	a.Error(w.Write("logo", []byte("other"), false))
+
+	// Removed keys are not flushed
+	a.NoError(w.Remove("removed"))
+
+	// Trim flushes written entries to disk
+	tieredStore.Trim()
+	b, err = disk.GetKeyed(l2).Read("logo")
+	a.NoError(err)
+	a.Equal("<svg></svg>", string(b))
+	_, err = disk.GetKeyed(l2).Read("removed")
+	a.Error(err)
+
+	// Entries trimmed from memory before they are flushed are reported
+	a.NoError(w.Write("lost", []byte("lost"), false))
+	a.NoError(mem.Get(l1).Remove("lost"))
+	a.Error(tieredStore.Flush())
+	a.NoError(tieredStore.Flush())
+
+	a.NoError(tieredStore.Purge())
+}
+
+// TestMissingTier tests both tiers are required
+func TestMissingTier(t *testing.T) {
+	a := assert.New(t)
+	a.Nil(tiered.New(&tiered.Store{L1: mem.New(&mem.Store{})}))
+}